@@ -0,0 +1,217 @@
+package image_formula_find
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// rowScratch holds the vector stack used by EvaluateRow. Each stack slot is
+// either uniform, holding a single value shared by the whole row, or a vector
+// with one value per X coordinate. The registers come after the stack slots
+// and are stored the same way.
+type rowScratch struct {
+	vec     []float64
+	uniform []bool
+	scalar  []float64
+}
+
+var rowScratchPool = sync.Pool{
+	New: func() interface{} {
+		return &rowScratch{}
+	},
+}
+
+func (s *rowScratch) reset(depth, n int) {
+	if cap(s.vec) < depth*n {
+		s.vec = make([]float64, depth*n)
+	}
+	s.vec = s.vec[:depth*n]
+	if cap(s.uniform) < depth {
+		s.uniform = make([]bool, depth)
+		s.scalar = make([]float64, depth)
+	}
+	s.uniform = s.uniform[:depth]
+	s.scalar = s.scalar[:depth]
+}
+
+// EvaluateRow evaluates the program at (xs[i], Y) for every i and stores the
+// results in out, which must be at least as long as xs. Subtrees that do not
+// depend on X are evaluated once for the whole row, and constant subtrees
+// were already folded by Compile, so only the X dependent part of the formula
+// is interpreted per pixel. The results are identical to calling Evaluate for
+// each point.
+func (p *Program) EvaluateRow(xs []float64, Y, T float64, out []float64) {
+	n := len(xs)
+	out = out[:n]
+	if len(p.Code) == 0 {
+		for i := range out {
+			out[i] = 0
+		}
+		return
+	}
+	s := rowScratchPool.Get().(*rowScratch)
+	defer rowScratchPool.Put(s)
+	s.reset(p.MaxStack+p.Registers, n)
+	slot := func(i int) []float64 {
+		return s.vec[i*n : (i+1)*n]
+	}
+	// broadcast turns a uniform slot into a vector so it can be combined
+	// element wise with another vector.
+	broadcast := func(i int) {
+		if !s.uniform[i] {
+			return
+		}
+		v := slot(i)
+		for j := range v {
+			v[j] = s.scalar[i]
+		}
+		s.uniform[i] = false
+	}
+	// state is taken from statePool by the first OpEval and shared by the
+	// rest of the row.
+	var state *State
+	sp := 0
+	for pc := range p.Code {
+		in := &p.Code[pc]
+		switch in.Op {
+		case OpConst:
+			s.uniform[sp], s.scalar[sp] = true, in.Value
+			sp++
+		case OpX:
+			s.uniform[sp] = false
+			copy(slot(sp), xs)
+			sp++
+		case OpY:
+			s.uniform[sp], s.scalar[sp] = true, Y
+			sp++
+		case OpT:
+			s.uniform[sp], s.scalar[sp] = true, T
+			sp++
+		case OpNeg, OpCall1:
+			a := sp - 1
+			if s.uniform[a] {
+				s.scalar[a] = apply1(*in, s.scalar[a])
+				continue
+			}
+			va := slot(a)
+			if in.Op == OpNeg {
+				for j := range va {
+					va[j] = -va[j]
+				}
+			} else {
+				for j := range va {
+					va[j] = in.Fn1(va[j])
+				}
+			}
+		case OpEval:
+			if state == nil {
+				state = p.state(0, Y, T)
+				defer statePool.Put(state)
+			}
+			s.uniform[sp] = false
+			v := slot(sp)
+			for j := range v {
+				state.X = xs[j]
+				v[j] = in.Expr.Evaluate(state)
+			}
+			sp++
+		case OpCall3:
+			sp -= 2
+			a, b, c := sp-1, sp, sp+1
+			if s.uniform[a] && s.uniform[b] && s.uniform[c] {
+				s.scalar[a] = in.Fn3(s.scalar[a], s.scalar[b], s.scalar[c])
+				continue
+			}
+			broadcast(a)
+			broadcast(b)
+			broadcast(c)
+			va, vb, vc := slot(a), slot(b), slot(c)
+			for j := range va {
+				va[j] = in.Fn3(va[j], vb[j], vc[j])
+			}
+		case OpStore, OpLoad:
+			from, to := sp-1, p.MaxStack+in.Slot
+			if in.Op == OpLoad {
+				from, to = to, sp
+				sp++
+			}
+			s.uniform[to], s.scalar[to] = s.uniform[from], s.scalar[from]
+			if !s.uniform[from] {
+				copy(slot(to), slot(from))
+			}
+		default:
+			sp--
+			a, b := sp-1, sp
+			if s.uniform[a] && s.uniform[b] {
+				s.scalar[a] = apply2(*in, s.scalar[a], s.scalar[b])
+				continue
+			}
+			broadcast(a)
+			broadcast(b)
+			va, vb := slot(a), slot(b)
+			switch in.Op {
+			case OpAdd:
+				for j := range va {
+					va[j] += vb[j]
+				}
+			case OpSub:
+				for j := range va {
+					va[j] -= vb[j]
+				}
+			case OpMul:
+				for j := range va {
+					va[j] *= vb[j]
+				}
+			case OpDiv:
+				for j := range va {
+					va[j] /= vb[j]
+				}
+			case OpPow:
+				for j := range va {
+					va[j] = math.Pow(va[j], vb[j])
+				}
+			case OpMod:
+				for j := range va {
+					va[j] = math.Mod(va[j], vb[j])
+				}
+			case OpCall2:
+				for j := range va {
+					va[j] = in.Fn2(va[j], vb[j])
+				}
+			}
+		}
+	}
+	if s.uniform[0] {
+		for i := range out {
+			out[i] = protectResult(p.Policy, s.scalar[0])
+		}
+		return
+	}
+	copy(out, slot(0))
+	if p.Policy == Protected {
+		for i, v := range out {
+			out[i] = protectResult(p.Policy, v)
+		}
+	}
+}
+
+// EvaluateTile evaluates the program over the grid xs × ys, storing the
+// result for (xs[i], ys[j]) at out[j*len(xs)+i].
+func (p *Program) EvaluateTile(xs, ys []float64, T float64, out []float64) {
+	for j, y := range ys {
+		p.EvaluateRow(xs, y, T, out[j*len(xs):(j+1)*len(xs)])
+	}
+}
+
+// EvaluateRow is the row wise equivalent of Evaluate. It compiles the formula
+// on every call, callers evaluating many rows should Compile once and use
+// Program.EvaluateRow instead.
+func (v Function) EvaluateRow(xs []float64, Y, T float64, out []float64) error {
+	if v.Equals == nil {
+		return errors.New("no such formula")
+	}
+	p := Compile(&v)
+	p.EvaluateRow(xs, Y, T, out)
+	return nil
+}
@@ -0,0 +1,107 @@
+package image_formula_find
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestEvaluateRowMatchesEvaluate(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	xs := []float64{-10, -3.5, 0, 0.25, 1, 9.75}
+	out := make([]float64, len(xs))
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 6)
+		p := Compile(f)
+		for _, y := range []float64{-10, 0, 2.5} {
+			p.EvaluateRow(xs, y, 1, out)
+			for j, x := range xs {
+				if want := p.Evaluate(x, y, 1); !sameFloat(out[j], want) {
+					t.Fatalf("%s at (%v, %v): row %v, point %v", f, x, y, out[j], want)
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateRowFallback(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	env := &State{
+		Width:  40,
+		Height: 30,
+		Frame:  Frame{XU: 8, XV: 3, X0: -5, YU: -2, YV: 12, Y0: -4},
+		Params: map[string]float64{"SCALE": 2.5},
+	}
+	xs := []float64{-10, -3.5, 0, 0.25, 9.75}
+	out := make([]float64, len(xs))
+	for i := 0; i < 500; i++ {
+		f := randomFunction(r, 5)
+		// The opaque right hand side is left to the tree walker.
+		p := CompileWith(&Function{Equals: &Equals{LHS: f.Equals.LHS, RHS: opaqueExpression{f.Equals.RHS}}}, env)
+		for _, y := range []float64{-10, 2.5} {
+			p.EvaluateRow(xs, y, 1, out)
+			for j, x := range xs {
+				state := *env
+				state.X, state.Y, state.T = x, y, 1
+				if want := f.Equals.Evaluate(&state); !sameFloat(out[j], want) {
+					t.Fatalf("%s at (%v, %v): row %v, evaluated %v", f, x, y, out[j], want)
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateTile(t *testing.T) {
+	f, err := ParseFunction("y = x * 2 + sin(y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Compile(f)
+	xs := []float64{-1, 0, 1}
+	ys := []float64{-2, 3}
+	out := make([]float64, len(xs)*len(ys))
+	p.EvaluateTile(xs, ys, 0, out)
+	for j, y := range ys {
+		for i, x := range xs {
+			if want := p.Evaluate(x, y, 0); out[j*len(xs)+i] != want {
+				t.Errorf("(%v, %v): got %v, want %v", x, y, out[j*len(xs)+i], want)
+			}
+		}
+	}
+}
+
+func TestFunctionEvaluateRow(t *testing.T) {
+	out := make([]float64, 2)
+	if err := (Function{}).EvaluateRow([]float64{1, 2}, 0, 0, out); err == nil {
+		t.Error("Expected error for empty formula")
+	}
+	f, err := ParseFunction("y = x + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.EvaluateRow([]float64{1, 2}, 0, 0, out); err != nil {
+		t.Fatal(err)
+	}
+	if out[0] != 2 || out[1] != 3 {
+		t.Errorf("Expected [2 3], got %v", out)
+	}
+}
+
+func BenchmarkProgramEvaluateRow(b *testing.B) {
+	f, err := ParseFunction("y / 4 = x * x + sin(y) * 2")
+	if err != nil {
+		b.Fatalf("Failed to parse function: %v", err)
+	}
+	p := Compile(f)
+	xs := make([]float64, 256)
+	for i := range xs {
+		xs[i] = float64(i)/12.8 - 10
+	}
+	out := make([]float64, len(xs))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.EvaluateRow(xs, float64(i%20)-10, 0, out)
+	}
+}
@@ -0,0 +1,145 @@
+package image_formula_find
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Bloat configures the control of bloat, formulas growing through evolution
+// without their score improving. The zero value controls nothing.
+type Bloat struct {
+	// SizePenalty is added to the score of an individual for each node of
+	// its formulas, and DepthPenalty for each level of its deepest formula.
+	SizePenalty, DepthPenalty float64
+	// MaxDepth drops new individuals with a formula deeper than this before
+	// they are scored. Zero is no limit.
+	MaxDepth int
+	// Tarpeian is the chance a new individual larger than the average of
+	// its generation is dropped before it is scored, the Tarpeian method.
+	Tarpeian float64
+	// Tournament is the number of individuals a tournament for a parent to
+	// breed draws from. Below 2 parents are drawn at random.
+	Tournament int
+	// Lexicographic is the relative width of the bins of scores that count
+	// as equal when ranking individuals, so the smaller of two individuals
+	// with nearly the same score ranks first: lexicographic parsimony
+	// pressure. Bins grow geometrically, 0.01 takes scores within about one
+	// percent as equal. Zero ranks by score alone.
+	Lexicographic float64
+}
+
+// bloatFields are the names of the fields of a Bloat in ParseBloat and
+// String.
+var bloatFields = []string{"size", "depth", "maxdepth", "tarpeian", "tournament", "lexicographic"}
+
+// ParseBloat reads a bloat control from a comma separated list of name=value
+// settings, such as "size=2,maxdepth=12,tournament=4". The names are size and
+// depth for the penalties, maxdepth, tarpeian, tournament and lexicographic.
+// An empty string controls nothing.
+func ParseBloat(text string) (Bloat, error) {
+	var b Bloat
+	for _, each := range strings.Split(text, ",") {
+		if strings.TrimSpace(each) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(each, "=")
+		if !ok {
+			return Bloat{}, fmt.Errorf("invalid bloat control %q, expected name=value", each)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "size":
+			b.SizePenalty, err = strconv.ParseFloat(value, 64)
+		case "depth":
+			b.DepthPenalty, err = strconv.ParseFloat(value, 64)
+		case "maxdepth":
+			b.MaxDepth, err = strconv.Atoi(value)
+		case "tarpeian":
+			b.Tarpeian, err = strconv.ParseFloat(value, 64)
+		case "tournament":
+			b.Tournament, err = strconv.Atoi(value)
+		case "lexicographic":
+			b.Lexicographic, err = strconv.ParseFloat(value, 64)
+		default:
+			return Bloat{}, fmt.Errorf("unknown bloat control %q, expected one of %s", name, strings.Join(bloatFields, ", "))
+		}
+		if err != nil {
+			return Bloat{}, fmt.Errorf("invalid bloat control %s: %w", name, err)
+		}
+	}
+	return b, nil
+}
+
+// String returns the settings that are not zero as ParseBloat reads them.
+func (b Bloat) String() string {
+	values := []string{
+		strconv.FormatFloat(b.SizePenalty, 'g', -1, 64),
+		strconv.FormatFloat(b.DepthPenalty, 'g', -1, 64),
+		strconv.Itoa(b.MaxDepth),
+		strconv.FormatFloat(b.Tarpeian, 'g', -1, 64),
+		strconv.Itoa(b.Tournament),
+		strconv.FormatFloat(b.Lexicographic, 'g', -1, 64),
+	}
+	var settings []string
+	for i, each := range values {
+		if each != "0" {
+			settings = append(settings, bloatFields[i]+"="+each)
+		}
+	}
+	return strings.Join(settings, ",")
+}
+
+// Penalty returns the amount added to the score of an individual whose
+// formulas have size nodes and are depth deep.
+func (b Bloat) Penalty(size, depth int) float64 {
+	return b.SizePenalty*float64(size) + b.DepthPenalty*float64(depth)
+}
+
+// TooDeep reports whether formulas depth deep exceed MaxDepth.
+func (b Bloat) TooDeep(depth int) bool {
+	return b.MaxDepth > 0 && depth > b.MaxDepth
+}
+
+// Doomed reports whether the Tarpeian method drops an individual of the size
+// from a generation of the mean size.
+func (b Bloat) Doomed(size int, mean float64) bool {
+	return b.Tarpeian > 0 && float64(size) > mean && rand.Float64() < b.Tarpeian
+}
+
+// Less reports whether an individual with score1 and size1 ranks before
+// one with score2 and size2: by score or, for scores in the same
+// Lexicographic bin, by size.
+func (b Bloat) Less(score1 float64, size1 int, score2 float64, size2 int) bool {
+	if b.Lexicographic > 0 {
+		if bin1, bin2 := b.bin(score1), b.bin(score2); bin1 != bin2 {
+			return bin1 < bin2
+		}
+		if size1 != size2 {
+			return size1 < size2
+		}
+	}
+	return score1 < score2
+}
+
+// bin returns the Lexicographic bin of a score.
+func (b Bloat) bin(score float64) float64 {
+	return math.Floor(math.Log1p(math.Max(score, 0)) / math.Log1p(b.Lexicographic))
+}
+
+// FormulaSize returns the number of nodes of the formulas, not counting the
+// Equals of each or Brackets, and the depth of the deepest. Missing formulas
+// count for nothing.
+func FormulaSize(fs ...*Function) (size, depth int) {
+	for _, f := range fs {
+		if f == nil || f.Equals == nil {
+			continue
+		}
+		size += f.Metrics().Nodes - 1
+		depth = max(depth, f.Equals.Depth())
+	}
+	return size, depth
+}
@@ -0,0 +1,106 @@
+package image_formula_find
+
+import (
+	"testing"
+)
+
+func TestParseBloat(t *testing.T) {
+	b, err := ParseBloat("size=2, depth=0.5,MaxDepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Bloat{SizePenalty: 2, DepthPenalty: 0.5, MaxDepth: 12, Tarpeian: 0.3, Tournament: 4, Lexicographic: 0.01}
+	if b != want {
+		t.Errorf("ParseBloat = %+v, want %+v", b, want)
+	}
+	if s := b.String(); s != "size=2,depth=0.5,maxdepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01" {
+		t.Errorf("String() = %q", s)
+	}
+	if again, err := ParseBloat(b.String()); err != nil || again != b {
+		t.Errorf("%q reads back as %+v, %v", b, again, err)
+	}
+	if b, err := ParseBloat(""); err != nil || b != (Bloat{}) || b.String() != "" {
+		t.Errorf("ParseBloat(\"\") = %+v, %v", b, err)
+	}
+	for _, text := range []string{"size", "width=2", "maxdepth=1.5", "tarpeian=often"} {
+		if _, err := ParseBloat(text); err == nil {
+			t.Errorf("ParseBloat(%q) did not fail", text)
+		}
+	}
+}
+
+func TestBloatPenalty(t *testing.T) {
+	b := Bloat{SizePenalty: 2, DepthPenalty: 0.5, MaxDepth: 4}
+	if p := b.Penalty(10, 3); p != 21.5 {
+		t.Errorf("Penalty(10, 3) = %v, want 21.5", p)
+	}
+	if b.TooDeep(4) || !b.TooDeep(5) {
+		t.Errorf("TooDeep wrong around MaxDepth 4")
+	}
+	if (Bloat{}).TooDeep(1000) {
+		t.Errorf("No MaxDepth is too deep")
+	}
+}
+
+func TestBloatDoomed(t *testing.T) {
+	always, never := Bloat{Tarpeian: 1}, Bloat{}
+	for i := 0; i < 100; i++ {
+		if !always.Doomed(11, 10) {
+			t.Fatalf("Larger than the mean survived a certain Tarpeian")
+		}
+		if always.Doomed(10, 10) || never.Doomed(100, 10) {
+			t.Fatalf("Doomed without reason")
+		}
+	}
+	half, doomed := Bloat{Tarpeian: 0.5}, 0
+	for i := 0; i < 1000; i++ {
+		if half.Doomed(20, 10) {
+			doomed++
+		}
+	}
+	if doomed < 400 || doomed > 600 {
+		t.Errorf("%d of 1000 doomed, want about 500", doomed)
+	}
+}
+
+func TestBloatLess(t *testing.T) {
+	plain, lexicographic := Bloat{}, Bloat{Lexicographic: 0.01}
+	tests := []struct {
+		score1       float64
+		size1        int
+		score2       float64
+		size2        int
+		plain, lexic bool
+	}{
+		{1000, 20, 1001, 5, true, false},
+		{1001, 5, 1000, 20, false, true},
+		{1000, 20, 2000, 5, true, true},
+		{1000, 5, 1000, 5, false, false},
+		{1000, 5, 1000.5, 5, true, true},
+		{0, 5, 0, 3, false, false},
+	}
+	for _, test := range tests {
+		if got := plain.Less(test.score1, test.size1, test.score2, test.size2); got != test.plain {
+			t.Errorf("Less(%v, %d, %v, %d) = %v", test.score1, test.size1, test.score2, test.size2, got)
+		}
+		if got := lexicographic.Less(test.score1, test.size1, test.score2, test.size2); got != test.lexic {
+			t.Errorf("Lexicographic Less(%v, %d, %v, %d) = %v", test.score1, test.size1, test.score2, test.size2, got)
+		}
+	}
+}
+
+func TestFormulaSize(t *testing.T) {
+	var fs []*Function
+	for _, each := range []string{"y = sin(x) + (2 * x)", "y = x"} {
+		f, err := ParseFunction(each)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs = append(fs, f)
+	}
+	// y, sin, x, +, 2, *, x and then y, x, with the Equals, the sum, the
+	// brackets, the product and x deep.
+	if size, depth := FormulaSize(append(fs, nil)...); size != 9 || depth != 5 {
+		t.Errorf("FormulaSize = %d, %d, want 9, 5", size, depth)
+	}
+}
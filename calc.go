@@ -5,20 +5,30 @@ package image_formula_find
 
 import __yyfmt__ "fmt"
 
-var yyResult *Function
+// The parser keeps its result on the lexer, in the result and statements
+// fields, so parses may run concurrently.
 
-//line calc.y:14
+//line calc.y:18
 type yySymType struct {
-	yys   int
-	float float64
-	s     string
-	expr  Expression
+	yys        int
+	float      float64
+	s          string
+	pos        int
+	expr       Expression
+	statement  Statement
+	statements []Statement
 }
 
 const Highest = 57346
 const FLOAT = 57347
 const VAR = 57348
 const FUNCNAME = 57349
+const LE = 57350
+const GE = 57351
+const EQ = 57352
+const LET = 57353
+const SCRIPT = 57354
+const ROOT = 57355
 
 var yyToknames = [...]string{
 	"$end",
@@ -28,14 +38,23 @@ var yyToknames = [...]string{
 	"FLOAT",
 	"VAR",
 	"FUNCNAME",
+	"LE",
+	"GE",
+	"EQ",
+	"LET",
+	"SCRIPT",
+	"ROOT",
 	"'='",
+	"'<'",
+	"'>'",
 	"'+'",
 	"'-'",
 	"'*'",
 	"'/'",
 	"'%'",
-	"'^'",
 	"','",
+	"'^'",
+	"';'",
 	"'('",
 	"')'",
 }
@@ -46,7 +65,7 @@ const yyEofCode = 1
 const yyErrCode = 2
 const yyInitialStackSize = 16
 
-//line calc.y:46
+//line calc.y:76
 
 //line yacctab:1
 var yyExca = [...]int8{
@@ -57,69 +76,92 @@ var yyExca = [...]int8{
 
 const yyPrivate = 57344
 
-const yyLast = 80
+const yyLast = 186
 
 var yyAct = [...]int8{
-	2, 19, 16, 1, 0, 0, 17, 18, 0, 20,
-	21, 22, 23, 24, 25, 26, 27, 28, 0, 16,
-	29, 10, 11, 12, 13, 14, 15, 32, 0, 31,
-	0, 0, 16, 33, 10, 11, 12, 13, 14, 15,
-	0, 16, 34, 10, 11, 12, 13, 14, 15, 0,
-	0, 30, 3, 4, 7, 16, 5, 6, 0, 12,
-	13, 14, 15, 8, 16, 9, 10, 11, 12, 13,
-	14, 15, 16, 0, 10, 11, 12, 13, 14, 15,
+	2, 28, 49, 54, 51, 23, 50, 25, 26, 27,
+	1, 29, 30, 31, 32, 33, 34, 35, 36, 37,
+	38, 39, 40, 41, 42, 23, 19, 21, 22, 47,
+	23, 24, 44, 18, 20, 12, 13, 14, 15, 16,
+	59, 17, 0, 46, 58, 0, 17, 0, 45, 0,
+	0, 0, 55, 0, 56, 57, 23, 19, 21, 22,
+	60, 43, 0, 0, 18, 20, 12, 13, 14, 15,
+	16, 53, 17, 0, 0, 52, 23, 19, 21, 22,
+	0, 0, 0, 0, 18, 20, 12, 13, 14, 15,
+	16, 0, 17, 0, 0, 61, 23, 19, 21, 22,
+	0, 0, 0, 0, 18, 20, 12, 13, 14, 15,
+	16, 0, 17, 0, 0, 48, 23, 19, 21, 22,
+	0, 0, 0, 11, 18, 20, 12, 13, 14, 15,
+	16, 0, 17, 23, 19, 21, 22, 0, 0, 0,
+	0, 18, 20, 12, 13, 14, 15, 16, 0, 17,
+	4, 5, 9, 4, 5, 9, 0, 3, 8, 0,
+	0, 8, 6, 7, 23, 6, 7, 0, 0, 23,
+	10, 0, 0, 10, 12, 13, 14, 15, 16, 0,
+	17, 14, 15, 16, 0, 17,
 }
 
 var yyPact = [...]int16{
-	47, -1000, 57, -1000, -1000, 47, 47, -15, 47, 47,
-	47, 47, 47, 47, 47, 47, 47, -5, -5, 47,
-	34, 65, 48, 48, -5, -5, -5, -5, -5, 12,
-	-1000, -1000, 47, 25, -1000,
+	145, -1000, 109, -1000, -1000, -1000, 148, 148, 148, -24,
+	148, 148, 148, 148, 148, 148, 148, 148, 148, 148,
+	148, 148, 148, 148, 37, -1000, -1000, -1000, 148, 89,
+	126, 162, 162, 23, 23, 23, 23, 157, 157, 157,
+	157, 157, -2, -1000, -22, 0, -10, 49, -1000, -1000,
+	-11, 148, -1000, 148, 148, 126, 18, 126, -1000, 148,
+	69, -1000,
 }
 
 var yyPgo = [...]int8{
-	0, 0, 3,
+	0, 0, 32, 31, 10,
 }
 
 var yyR1 = [...]int8{
-	0, 2, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 1, 1, 1, 1, 1,
+	0, 4, 4, 4, 3, 3, 3, 2, 2, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
 }
 
 var yyR2 = [...]int8{
-	0, 3, 1, 1, 3, 3, 3, 3, 3, 3,
-	2, 2, 3, 4, 6, 3,
+	0, 3, 1, 2, 0, 2, 3, 4, 3, 1,
+	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 2, 2, 2, 3, 4, 6, 8, 3,
 }
 
 var yyChk = [...]int16{
-	-1000, -2, -1, 5, 6, 9, 10, 7, 16, 8,
-	9, 10, 11, 12, 13, 14, 7, -1, -1, 16,
+	-1000, -4, -1, 12, 5, 6, 17, 18, 13, 7,
+	25, 14, 17, 18, 19, 20, 21, 23, 15, 8,
+	16, 9, 10, 7, -3, -1, -1, -1, 25, -1,
 	-1, -1, -1, -1, -1, -1, -1, -1, -1, -1,
-	17, 17, 15, -1, 17,
+	-1, -1, -1, 24, -2, 11, 6, -1, 26, 24,
+	6, 14, 26, 22, 14, -1, -1, -1, 26, 22,
+	-1, 26,
 }
 
 var yyDef = [...]int8{
-	0, -2, 0, 2, 3, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 10, 11, 0,
-	0, 1, 4, 5, 6, 7, 8, 9, 12, 0,
-	15, 13, 0, 0, 14,
+	0, -2, 2, 4, 9, 10, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 3, 22, 23, 24, 0, 0,
+	1, 11, 12, 13, 14, 15, 16, 17, 18, 19,
+	20, 21, 25, 5, 0, 0, 0, 0, 29, 6,
+	0, 0, 26, 0, 0, 8, 0, 7, 27, 0,
+	0, 28,
 }
 
 var yyTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 13, 3, 3,
-	16, 17, 11, 9, 15, 10, 3, 12, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 21, 3, 3,
+	25, 26, 19, 17, 22, 18, 3, 20, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 24,
+	15, 14, 16, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 8, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 14,
+	3, 3, 3, 3, 23,
 }
 
 var yyTok2 = [...]int8{
-	2, 3, 4, 5, 6, 7,
+	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+	12, 13,
 }
 
 var yyTok3 = [...]int8{
@@ -465,91 +507,179 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:27
+//line calc.y:37
 		{
-			yyResult = &Function{Equals: &Equals{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}}
+			yylex.(*CalcLexer).result = &Function{Equals: &Equals{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}}
 		}
 	case 2:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line calc.y:30
+//line calc.y:38
 		{
-			yyVAL.expr = &Const{Value: yyDollar[1].float}
+			yylex.(*CalcLexer).result = &Function{Equals: &Equals{RHS: yyDollar[1].expr}}
 		}
 	case 3:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line calc.y:39
+		{
+			yylex.(*CalcLexer).statements = yyDollar[2].statements
+		}
+	case 4:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line calc.y:43
+		{
+			yyVAL.statements = nil
+		}
+	case 5:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line calc.y:44
+		{
+			yyVAL.statements = yyDollar[1].statements
+		}
+	case 6:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:45
+		{
+			yyVAL.statements = append(yyDollar[1].statements, yyDollar[2].statement)
+		}
+	case 7:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line calc.y:49
+		{
+			yyVAL.statement = Statement{Let: true, Name: yyDollar[2].s, Expr: yyDollar[4].expr}
+		}
+	case 8:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:50
+		{
+			yyVAL.statement = Statement{Name: yyDollar[1].s, Expr: yyDollar[3].expr}
+		}
+	case 9:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line calc.y:31
+//line calc.y:53
+		{
+			yyVAL.expr = &Const{Value: yyDollar[1].float}
+		}
+	case 10:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line calc.y:54
 		{
 			yyVAL.expr = &Var{Var: yyDollar[1].s}
 		}
-	case 4:
+	case 11:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:32
+//line calc.y:55
 		{
 			yyVAL.expr = &Plus{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 5:
+	case 12:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:33
+//line calc.y:56
 		{
-			yyVAL.expr = &Subtract{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+			yyVAL.expr = &Subtract{LHS: yyDollar[3].expr, RHS: yyDollar[1].expr}
 		}
-	case 6:
+	case 13:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:34
+//line calc.y:57
 		{
 			yyVAL.expr = &Multiply{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 7:
+	case 14:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:35
+//line calc.y:58
 		{
-			yyVAL.expr = &Divide{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+			yyVAL.expr = &Divide{LHS: yyDollar[3].expr, RHS: yyDollar[1].expr}
 		}
-	case 8:
+	case 15:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:36
+//line calc.y:59
 		{
 			yyVAL.expr = &Modulus{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 9:
+	case 16:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:37
+//line calc.y:60
 		{
 			yyVAL.expr = &Power{LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 10:
+	case 17:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:61
+		{
+			yyVAL.expr = &Compare{Op: "<", LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+		}
+	case 18:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:62
+		{
+			yyVAL.expr = &Compare{Op: "<=", LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+		}
+	case 19:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:63
+		{
+			yyVAL.expr = &Compare{Op: ">", LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+		}
+	case 20:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:64
+		{
+			yyVAL.expr = &Compare{Op: ">=", LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+		}
+	case 21:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line calc.y:65
+		{
+			yyVAL.expr = &Compare{Op: "==", LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
+		}
+	case 22:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line calc.y:38
+//line calc.y:66
 		{
 			yyVAL.expr = yyDollar[2].expr
 		}
-	case 11:
+	case 23:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line calc.y:39
+//line calc.y:67
 		{
 			yyVAL.expr = &Negate{Expr: yyDollar[2].expr}
 		}
-	case 12:
+	case 24:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line calc.y:68
+		{
+			yyVAL.expr = NewSingleFunction("Sqrt", yyDollar[2].expr)
+		}
+	case 25:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:40
+//line calc.y:69
 		{
+			yylex.(*CalcLexer).arity(yyDollar[2].s, yyDollar[2].pos, 2)
 			yyVAL.expr = NewDoubleFunction(yyDollar[2].s, yyDollar[1].expr, yyDollar[3].expr, true)
 		}
-	case 13:
+	case 26:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line calc.y:41
+//line calc.y:70
 		{
+			yylex.(*CalcLexer).arity(yyDollar[1].s, yyDollar[1].pos, 1)
 			yyVAL.expr = NewSingleFunction(yyDollar[1].s, yyDollar[3].expr)
 		}
-	case 14:
+	case 27:
 		yyDollar = yyS[yypt-6 : yypt+1]
-//line calc.y:42
+//line calc.y:71
 		{
+			yylex.(*CalcLexer).arity(yyDollar[1].s, yyDollar[1].pos, 2)
 			yyVAL.expr = NewDoubleFunction(yyDollar[1].s, yyDollar[3].expr, yyDollar[5].expr, false)
 		}
-	case 15:
+	case 28:
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line calc.y:72
+		{
+			yylex.(*CalcLexer).arity(yyDollar[1].s, yyDollar[1].pos, 3)
+			yyVAL.expr = NewTripleFunction(yyDollar[1].s, yyDollar[3].expr, yyDollar[5].expr, yyDollar[7].expr)
+		}
+	case 29:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line calc.y:43
+//line calc.y:73
 		{
 			yyVAL.expr = &Brackets{Expr: yyDollar[2].expr}
 		}
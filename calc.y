@@ -3,43 +3,73 @@ package image_formula_find;
 
 import __yyfmt__ "fmt"
 
-var yyResult *Function
+// The parser keeps its result on the lexer, in the result and statements
+// fields, so parses may run concurrently.
 %}
 
 %token<expr> Highest
 %token<float> FLOAT
 %token<s> VAR FUNCNAME
+%token LE GE EQ LET SCRIPT ROOT
 %type<expr> expr
+%type<statement> statement
+%type<statements> statements
 
 %union {
     float float64
     s string
+    pos int
     expr Expression
+    statement Statement
+    statements []Statement
  }
 
 %right '='
+%left '<' '>' LE GE EQ
 %left '+' '-'
-%left '*' '/' '%' '^' ','
-%right Highest FUNCNAME
+%left '*' '/' '%' ','
+%right '^'
+%right FUNCNAME
+%right Highest
 
 %%
 input
-    : expr '=' expr { yyResult = &Function{ Equals: &Equals { LHS: $1, RHS: $3 } } }
+    : expr '=' expr { yylex.(*CalcLexer).result = &Function{ Equals: &Equals { LHS: $1, RHS: $3 } } }
+    | expr          { yylex.(*CalcLexer).result = &Function{ Equals: &Equals { RHS: $1 } } }
+    | SCRIPT statements { yylex.(*CalcLexer).statements = $2 }
+    ;
+
+statements
+    :                          { $$ = nil }
+    | statements ';'           { $$ = $1 }
+    | statements statement ';' { $$ = append($1, $2) }
+    ;
+
+statement
+    : LET VAR '=' expr  { $$ = Statement{ Let: true, Name: $2, Expr: $4 } }
+    | VAR '=' expr      { $$ = Statement{ Name: $1, Expr: $3 } }
     ;
 
 expr: FLOAT             { $$ = &Const{Value: $1} }
     | VAR               { $$ = &Var{ Var: $1 } }
     | expr '+' expr     { $$ = &Plus{ LHS: $1, RHS: $3, } }
-    | expr '-' expr     { $$ = &Subtract{ LHS: $1, RHS: $3, } }
+    | expr '-' expr     { $$ = &Subtract{ LHS: $3, RHS: $1, } }
     | expr '*' expr     { $$ = &Multiply{ LHS: $1, RHS: $3, } }
-    | expr '/' expr     { $$ = &Divide{ LHS: $1, RHS: $3, } }
+    | expr '/' expr     { $$ = &Divide{ LHS: $3, RHS: $1, } }
     | expr '%' expr     { $$ = &Modulus{ LHS: $1, RHS: $3, } }
     | expr '^' expr     { $$ = &Power{ LHS: $1, RHS: $3, } }
+    | expr '<' expr     { $$ = &Compare{ Op: "<", LHS: $1, RHS: $3, } }
+    | expr LE expr      { $$ = &Compare{ Op: "<=", LHS: $1, RHS: $3, } }
+    | expr '>' expr     { $$ = &Compare{ Op: ">", LHS: $1, RHS: $3, } }
+    | expr GE expr      { $$ = &Compare{ Op: ">=", LHS: $1, RHS: $3, } }
+    | expr EQ expr      { $$ = &Compare{ Op: "==", LHS: $1, RHS: $3, } }
     | '+' expr  %prec Highest    { $$ = $2 }
     | '-' expr  %prec Highest    { $$ = &Negate{ Expr: $2 } }
-    | expr FUNCNAME expr     { $$ = NewDoubleFunction($2, $1, $3, true) }
-    | FUNCNAME '(' expr ')'  { $$ = NewSingleFunction($1, $3) }
-    | FUNCNAME '(' expr ',' expr ')' { $$ = NewDoubleFunction($1, $3, $5, false) }
+    | ROOT expr %prec Highest    { $$ = NewSingleFunction("Sqrt", $2) }
+    | expr FUNCNAME expr     { yylex.(*CalcLexer).arity($2, $<pos>2, 2); $$ = NewDoubleFunction($2, $1, $3, true) }
+    | FUNCNAME '(' expr ')'  { yylex.(*CalcLexer).arity($1, $<pos>1, 1); $$ = NewSingleFunction($1, $3) }
+    | FUNCNAME '(' expr ',' expr ')' { yylex.(*CalcLexer).arity($1, $<pos>1, 2); $$ = NewDoubleFunction($1, $3, $5, false) }
+    | FUNCNAME '(' expr ',' expr ',' expr ')' { yylex.(*CalcLexer).arity($1, $<pos>1, 3); $$ = NewTripleFunction($1, $3, $5, $7) }
     | '(' expr ')'            { $$ = &Brackets{ Expr: $2 } }
     ;
 
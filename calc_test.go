@@ -19,17 +19,20 @@ func TestEndToEndParser(t *testing.T) {
 		"y / 4 = x mod 2 * 2",
 		"y / 4 = mod(x, 3) * 2",
 		"y / 4 = abs(x) + 2 * 2",
+		"y = (x < 2) * 3 + (x >= y)",
+		"y = x + 1 <= y == 1",
+		"y = if(x > 0, x, -x) + clamp(x, 0, 1)",
 	} {
 		t.Run(fmt.Sprintf("%d: %s", eachI, each), func(t *testing.T) {
 			parser := yyNewParser()
-			yyResult = nil
-			r := parser.Parse(NewCalcLexer(each))
+			lex := NewCalcLexer(each).(*CalcLexer)
+			r := parser.Parse(lex)
 			t.Logf("Result %d for %#v", r, each)
-			if yyResult == nil {
+			if lex.result == nil {
 				t.Logf("Error; no result returned %#v", parser)
 				t.Fail()
-			} else if yyResult.String() != each {
-				t.Logf("Failed to match %v with %v", yyResult.String(), each)
+			} else if lex.result.String() != each {
+				t.Logf("Failed to match %v with %v", lex.result.String(), each)
 				t.Fail()
 			}
 		})
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"strings"
+
+	"image-formula-find"
+	"image-formula-find/dna1"
+	"image-formula-find/dna3"
+	"image-formula-find/dna4"
+	"image-formula-find/dna5"
+	"image-formula-find/drawer1"
+	"image-formula-find/evolve"
+	"image-formula-find/gp"
+)
+
+// engine runs one generation of an encoding, returning the best score, the
+// mean size of the formulas and the best formulas.
+type engine func(generation int) (float64, float64, string)
+
+// Evolves the same image with each encoding and the tree engine side by
+// side, printing the best score and the mean formula size of each every
+// -every generations.
+func main() {
+	var inputPath string
+	var generations, every int
+	var encodings, bloatControl, view string
+	var evolveView bool
+	flag.StringVar(&inputPath, "input", "flag.png", "Path to input image")
+	flag.IntVar(&generations, "generations", 200, "Number of generations")
+	flag.IntVar(&every, "every", 10, "Generations between reports")
+	flag.StringVar(&encodings, "encodings", "dna1,dna3,dna4,dna5,gp", "Comma separated encodings to compare")
+	flag.StringVar(&bloatControl, "bloat", "", "Bloat control for every encoding, such as size=2,maxdepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01")
+	flag.StringVar(&view, "viewport", "", "View every encoding draws through, such as x=2,y=-1,scale=5,rotation=0.3,aspect=fit")
+	flag.BoolVar(&evolveView, "evolveview", false, "Let the gp trees evolve their viewport, starting from -viewport")
+	flag.Parse()
+	log.SetFlags(log.Flags() | log.Lshortfile)
+
+	control, err := image_formula_find.ParseBloat(bloatControl)
+	if err != nil {
+		log.Fatalf("Invalid bloat control: %v", err)
+	}
+	viewport, err := drawer1.ParseViewport(view)
+	if err != nil {
+		log.Fatalf("Invalid viewport: %v", err)
+	}
+	srcimg := LoadImage(inputPath)
+	engines := map[string]func(image.Image) engine{
+		"dna1": func(img image.Image) engine {
+			worker := &dna1.BasicRequired{R: img.Bounds(), I: img, Bloat: control, Viewport: viewport}
+			newDNA := randomDNA(func() string { return dna1.RndStr(50) }, dna1.Valid)
+			var last []*dna1.Individual
+			return func(generation int) (float64, float64, string) {
+				last = dna1.GenerationProcess(worker, last, generation, newDNA)
+				return last[0].Score, evolve.MeanSize(last), formulas(last[0].CsvRow())
+			}
+		},
+		"dna3": func(img image.Image) engine {
+			worker := &dna3.BasicRequired{R: img.Bounds(), I: img, Bloat: control, Viewport: viewport}
+			newDNA := randomDNA(func() string { return dna3.RndStr(50) }, dna3.Valid)
+			var last []*dna3.Individual
+			return func(generation int) (float64, float64, string) {
+				last = dna3.GenerationProcess(worker, last, generation, newDNA)
+				return last[0].Score, evolve.MeanSize(last), formulas(last[0].CsvRow())
+			}
+		},
+		"dna4": func(img image.Image) engine {
+			worker := &dna4.BasicRequired{R: img.Bounds(), I: img, Bloat: control, Viewport: viewport}
+			newDNA := randomDNA(func() string { return dna4.RndStr(50) }, dna4.Valid)
+			var last []*dna4.Individual
+			return func(generation int) (float64, float64, string) {
+				last = dna4.GenerationProcess(worker, last, generation, newDNA)
+				return last[0].Score, evolve.MeanSize(last), formulas(last[0].CsvRow())
+			}
+		},
+		"dna5": func(img image.Image) engine {
+			worker := &dna5.BasicRequired{R: img.Bounds(), I: img, Bloat: control, Viewport: viewport}
+			newDNA := randomDNA(func() string { return dna5.RndStr(50) }, dna5.Valid)
+			var last []*dna5.Individual
+			return func(generation int) (float64, float64, string) {
+				last = dna5.GenerationProcess(worker, last, generation, newDNA)
+				return last[0].Score, evolve.MeanSize(last), formulas(last[0].CsvRow())
+			}
+		},
+		"gp": func(img image.Image) engine {
+			worker := &gp.BasicRequired{R: img.Bounds(), I: img, Bloat: control, Viewport: viewport, EvolveViewport: evolveView}
+			var last []*gp.Individual
+			return func(generation int) (float64, float64, string) {
+				last = gp.GenerationProcess(worker, last, generation)
+				return last[0].Score, evolve.MeanSize(last), formulas(last[0].CsvRow())
+			}
+		},
+	}
+
+	names := strings.Split(encodings, ",")
+	running := make([]engine, len(names))
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		start, ok := engines[names[i]]
+		if !ok {
+			log.Fatalf("Unknown encoding: %s", name)
+		}
+		running[i] = start(srcimg)
+	}
+	fmt.Printf("%10s", "Generation")
+	for _, name := range names {
+		fmt.Printf(" %16s %6s", name, "size")
+	}
+	fmt.Println()
+	best := make([]string, len(names))
+	for generation := 0; generation < generations; generation++ {
+		report := (generation+1)%every == 0 || generation == generations-1
+		if report {
+			fmt.Printf("%10d", generation+1)
+		}
+		for i, run := range running {
+			score, size, formula := run(generation)
+			best[i] = formula
+			if report {
+				fmt.Printf(" %16.2f %6.1f", score, size)
+			}
+		}
+		if report {
+			fmt.Println()
+		}
+	}
+	for i, name := range names {
+		fmt.Printf("%s: %s\n", name, best[i])
+	}
+}
+
+// randomDNA returns a channel of random valid DNA strings.
+func randomDNA(rnd func() string, valid func(string) bool) chan string {
+	newDNA := make(chan string, 100)
+	go func() {
+		for {
+			if dna := rnd(); valid(dna) {
+				newDNA <- dna
+			}
+		}
+	}()
+	return newDNA
+}
+
+// formulas returns the red, blue and green formulas of a CSV row, with the
+// viewport they are drawn through unless it is the default.
+func formulas(row []string) string {
+	text := strings.Join(row[1:4], "; ")
+	if view := row[len(row)-1]; view != "" {
+		text += " in " + view
+	}
+	return text
+}
+
+func LoadImage(path string) image.Image {
+	fin, err := os.Open(path)
+	if err != nil {
+		log.Panicf("Error opening image %s: %v", path, err)
+	}
+	defer func() {
+		if err := fin.Close(); err != nil {
+			log.Printf("Error closing file: %v", err)
+		}
+	}()
+	i, _, err := image.Decode(fin)
+	if err != nil {
+		log.Panicf("Error decoding image %s: %v", path, err)
+	}
+	return i
+}
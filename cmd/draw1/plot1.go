@@ -1,39 +1,128 @@
 package main
 
 import (
+	"flag"
 	"image"
 	image_formula_find "image-formula-find"
 	"image-formula-find/drawer1"
 	"image/png"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// defaultScript is drawn when no script file is given.
+const defaultScript = `let a = sin(x * y)
+r = a * 255; g = (1 - a) * 128; b = a ^ 2`
+
+// Draws the red, green and blue channels of a script, such as
+//
+//	let a = sin(x * y)
+//	r = a * 255; g = (1 - a) * 128; b = a ^ 2
+//
+// read from the file named by the argument. With -frames above 1 it draws
+// an animation of T from -tstart to -tend instead, written as an animated GIF
+// or, for a .png output, an animated PNG. With -equation it draws that
+// equation as an implicit curve, or with -fill as a shape, instead.
 func main() {
+	var outputPath string
+	var width, height int
+	var policyName string
+	var frames, delay int
+	var tStart, tEnd float64
+	var loop bool
+	var equation string
+	var thickness float64
+	var fill bool
+	var view string
+	flag.StringVar(&outputPath, "output", "out.png", "Output PNG file")
+	flag.IntVar(&width, "width", 100, "Image width")
+	flag.IntVar(&height, "height", 100, "Image height")
+	flag.StringVar(&policyName, "policy", "ieee", "Numeric policy: ieee, protected or poison")
+	flag.IntVar(&frames, "frames", 1, "Number of frames, above 1 for an animation")
+	flag.Float64Var(&tStart, "tstart", 0, "T of the first frame")
+	flag.Float64Var(&tEnd, "tend", 1, "T the last frame leads back to")
+	flag.IntVar(&delay, "delay", 10, "Time each frame is shown, in hundredths of a second")
+	flag.BoolVar(&loop, "loop", false, "Fail unless the formulas repeat from -tstart to -tend")
+	flag.StringVar(&equation, "equation", "", "Equation such as \"x^2 + y^2 = 25\" to draw as an implicit curve")
+	flag.Float64Var(&thickness, "thickness", 1, "Width of the implicit curve in pixels")
+	flag.BoolVar(&fill, "fill", false, "Fill the implicit shape where the left side is less than the right")
+	flag.StringVar(&view, "viewport", "", "View to draw, such as x=2,y=-1,scale=5,rotation=0.3,aspect=fit, as in the Viewport column of out.csv")
+	flag.Parse()
+
 	log.SetFlags(log.Flags() | log.Lshortfile)
-	i := image.NewRGBA(image.Rect(0, 0, 100, 100))
-	rf, err := image_formula_find.ParseFunction("x = y + 1")
+	policy, err := image_formula_find.ParsePolicy(policyName)
 	if err != nil {
-		log.Fatalf("Invalid red formula: %v", err)
+		log.Fatalf("Invalid policy: %v", err)
 	}
-	bf, err := image_formula_find.ParseFunction("x = 2 * y + 3")
+	viewport, err := drawer1.ParseViewport(view)
 	if err != nil {
-		log.Fatalf("Invalid blue formula: %v", err)
+		log.Fatalf("Invalid viewport: %v", err)
+	}
+	if equation != "" {
+		f, err := image_formula_find.ParseFunction(equation)
+		if err != nil {
+			log.Fatalf("Invalid equation: %v", err)
+		}
+		if unbound := image_formula_find.Unbound(nil, f); len(unbound) > 0 {
+			log.Fatalf("Parameters without a value: %s", strings.Join(unbound, ", "))
+		}
+		im := &drawer1.Implicit{Formula: f, Width: width, Height: height, Policy: policy, Thickness: thickness, Fill: fill, Viewport: viewport}
+		i := image.NewRGBA(image.Rect(0, 0, width, height))
+		if invalid := im.Render(i); invalid > 0 {
+			log.Printf("%d of %d pixels invalid", invalid, width*height)
+		}
+		log.Printf("Equation: %s", f.String())
+		save(i, outputPath)
+		return
+	}
+	text := defaultScript
+	if flag.NArg() > 0 {
+		b, err := os.ReadFile(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Error reading script: %v", err)
+		}
+		text = string(b)
 	}
-	gf, err := image_formula_find.ParseFunction("x = 4 * y + 5")
+	script, err := image_formula_find.ParseScript(text)
 	if err != nil {
-		log.Fatalf("Invalid green formula: %v", err)
+		log.Fatalf("Invalid script: %v", err)
 	}
+	if unbound := image_formula_find.Unbound(nil, script.Red, script.Green, script.Blue); len(unbound) > 0 {
+		log.Fatalf("Parameters without a value: %s", strings.Join(unbound, ", "))
+	}
+	i := image.NewRGBA(image.Rect(0, 0, width, height))
 	d := &drawer1.Drawer{
-		RedFormula:   rf,
-		BlueFormula:  bf,
-		GreenFormula: gf,
-	}
-	d.Render(i)
-	log.Printf("Red: %s", d.RedFormula.String())
-	log.Printf("Blue: %s", d.BlueFormula.String())
-	log.Printf("Green: %s", d.GreenFormula.String())
-	f, err := os.Create("out.png")
+		RedFormula:   script.Red,
+		BlueFormula:  script.Blue,
+		GreenFormula: script.Green,
+		Width:        width,
+		Height:       height,
+		Policy:       policy,
+		Viewport:     viewport,
+	}
+	if frames > 1 {
+		animate(&drawer1.Animation{Drawer: d, Start: tStart, End: tEnd, Frames: frames, Delay: delay, Loop: loop}, outputPath)
+		return
+	}
+	if diagnostics := d.RenderDiagnostics(i); diagnostics.Invalid > 0 {
+		log.Printf("%d of %d pixels invalid, by channel %v", diagnostics.Invalid, diagnostics.Pixels, diagnostics.Channels)
+	}
+	for _, c := range []struct {
+		name    string
+		formula *image_formula_find.Function
+	}{{"Red", d.RedFormula}, {"Blue", d.BlueFormula}, {"Green", d.GreenFormula}} {
+		if c.formula != nil {
+			log.Printf("%s: %s", c.name, c.formula.String())
+		}
+	}
+	save(i, outputPath)
+}
+
+// save writes the image to outputPath as a PNG.
+func save(i image.Image, outputPath string) {
+	f, err := os.Create(outputPath)
 	if err != nil {
 		log.Panicf("Error: %v", err)
 	}
@@ -47,3 +136,26 @@ func main() {
 	}
 	log.Printf("Done")
 }
+
+// animate writes the animation to outputPath, as an animated PNG for a .png
+// file and a GIF otherwise.
+func animate(a *drawer1.Animation, outputPath string) {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		log.Panicf("Error: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing file: %v", err)
+		}
+	}()
+	if strings.EqualFold(filepath.Ext(outputPath), ".png") {
+		err = a.EncodeAPNG(f)
+	} else {
+		err = a.EncodeGIF(f)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	log.Printf("Done")
+}
@@ -1,36 +1,60 @@
 package main
 
 import (
+	"flag"
 	"image"
 	image_formula_find "image-formula-find"
 	"image-formula-find/drawer1"
 	"image/png"
 	"log"
 	"os"
+	"strings"
 )
 
+// defaultScript is drawn when no script file is given.
+const defaultScript = `let a = sin(x * y)
+r = a * 255; g = (1 - a) * 128; b = a ^ 2`
+
+// Draws the red, green and blue channels of a script, such as
+//
+//	let a = sin(x * y)
+//	r = a * 255; g = (1 - a) * 128; b = a ^ 2
+//
+// read from the file named by the argument.
 func main() {
+	var outputPath string
+	var width, height int
+	flag.StringVar(&outputPath, "output", "out.png", "Output PNG file")
+	flag.IntVar(&width, "width", 100, "Image width")
+	flag.IntVar(&height, "height", 100, "Image height")
+	flag.Parse()
+
 	log.SetFlags(log.Flags() | log.Lshortfile)
-	i := image.NewRGBA(image.Rect(0, 0, 100, 100))
-	rf, err := image_formula_find.ParseFunction("x = y + 1")
-	if err != nil {
-		log.Fatalf("Invalid red formula: %v", err)
+	text := defaultScript
+	if flag.NArg() > 0 {
+		b, err := os.ReadFile(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Error reading script: %v", err)
+		}
+		text = string(b)
 	}
-	bf, err := image_formula_find.ParseFunction("x = 2 * y + 3")
+	script, err := image_formula_find.ParseScript(text)
 	if err != nil {
-		log.Fatalf("Invalid blue formula: %v", err)
+		log.Fatalf("Invalid script: %v", err)
 	}
-	gf, err := image_formula_find.ParseFunction("x = 4 * y + 5")
-	if err != nil {
-		log.Fatalf("Invalid green formula: %v", err)
+	if unbound := image_formula_find.Unbound(nil, script.Red, script.Green, script.Blue); len(unbound) > 0 {
+		log.Fatalf("Parameters without a value: %s", strings.Join(unbound, ", "))
 	}
+	i := image.NewRGBA(image.Rect(0, 0, width, height))
 	d := &drawer1.Drawer{
-		RedFormula:   rf,
-		BlueFormula:  bf,
-		GreenFormula: gf,
+		RedFormula:   script.Red,
+		BlueFormula:  script.Blue,
+		GreenFormula: script.Green,
+		Width:        width,
+		Height:       height,
 	}
 	d.Render(i)
-	f, err := os.Create("out.png")
+	f, err := os.Create(outputPath)
 	if err != nil {
 		log.Panicf("Error: %v", err)
 	}
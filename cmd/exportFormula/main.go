@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"image-formula-find"
+	"image-formula-find/codegen"
+	"image-formula-find/dna1"
+	"image-formula-find/dna3"
+	"image-formula-find/dna4"
+	"image-formula-find/dna5"
+	"image-formula-find/drawer1"
+	"image-formula-find/gp"
+	"log"
+	"os"
+)
+
+// Writes the formulas of a DNA string, as found in the Dna column of out.csv,
+// or of three formulas as Go, GLSL or JavaScript source, drawn through the
+// viewport of its Viewport column.
+func main() {
+	var dna, encoding, compat, red, green, blue, lang, name, pkg, outputPath, view string
+	var alphabet int
+	flag.StringVar(&dna, "dna", "", "DNA string to export")
+	flag.StringVar(&encoding, "encoding", "dna1", "DNA encoding: dna1, dna3, dna4, dna5 or gp")
+	flag.StringVar(&compat, "compat", "v1", "Function table for dna1 genomes without a header: v0 or v1")
+	flag.IntVar(&alphabet, "alphabet", 0, "RPN alphabet for dna4 and dna5 genomes without a header: 0 to 3")
+	flag.StringVar(&red, "red", "", "Red formula, used when no DNA is given")
+	flag.StringVar(&green, "green", "", "Green formula, used when no DNA is given")
+	flag.StringVar(&blue, "blue", "", "Blue formula, used when no DNA is given")
+	flag.StringVar(&lang, "lang", "go", "Output language: go, glsl or js")
+	flag.StringVar(&name, "name", "Formula", "Name of the generated function")
+	flag.StringVar(&pkg, "package", "main", "Package of the generated Go file")
+	flag.StringVar(&outputPath, "output", "", "Output file, standard output if empty")
+	flag.StringVar(&view, "viewport", "", "View to draw, such as x=2,y=-1,scale=5,rotation=0.3,aspect=fit, as in the Viewport column of out.csv")
+	flag.Parse()
+
+	log.SetFlags(log.Flags() | log.Lshortfile)
+
+	viewport, err := drawer1.ParseViewport(view)
+	if err != nil {
+		log.Fatalf("Invalid viewport: %v", err)
+	}
+
+	var rf, gf, bf *image_formula_find.Function
+	if dna != "" {
+		functions, err := image_formula_find.ParseFunctionSet(compat)
+		if err != nil {
+			log.Fatalf("Invalid compat table: %v", err)
+		}
+		dna1.Compat = functions
+		dna4.Compat, dna5.Compat = alphabet, alphabet
+		// genome reports a genome that does not decode.
+		genome := func(parse func(string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function, error)) func(string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
+			return func(genome string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
+				rf, bf, gf, err := parse(genome)
+				if err != nil {
+					log.Fatalf("Invalid genome: %v", err)
+				}
+				return rf, bf, gf
+			}
+		}
+		parse := map[string]func(string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function){
+			"dna1": genome(dna1.ParseGenome),
+			"dna3": dna3.ParseDNA,
+			"dna4": genome(dna4.ParseGenome),
+			"dna5": genome(dna5.ParseGenome),
+			"gp":   gp.ParseDNA,
+		}[encoding]
+		if parse == nil {
+			log.Fatalf("Unknown encoding: %s", encoding)
+		}
+		// ParseDNA returns the channels in Individual order: red, blue, green.
+		rf, bf, gf = parse(dna)
+	} else {
+		for _, c := range []struct {
+			formula string
+			f       **image_formula_find.Function
+		}{{red, &rf}, {green, &gf}, {blue, &bf}} {
+			if c.formula == "" {
+				continue
+			}
+			f, err := image_formula_find.ParseFunction(c.formula)
+			if err != nil {
+				log.Fatalf("Invalid formula %q: %v", c.formula, err)
+			}
+			*c.f = f
+		}
+	}
+
+	var source []byte
+	switch lang {
+	case "go":
+		source, err = codegen.GoWith(pkg, name, viewport, rf, gf, bf)
+	case "glsl":
+		var s string
+		s, err = codegen.GLSLWith(viewport, rf, gf, bf)
+		source = []byte(s)
+	case "js":
+		var s string
+		s, err = codegen.JavaScriptWith(name, viewport, rf, gf, bf)
+		source = []byte(s)
+	default:
+		log.Fatalf("Unknown language: %s", lang)
+	}
+	if err != nil {
+		log.Fatalf("Error generating %s: %v", lang, err)
+	}
+
+	if outputPath == "" {
+		if _, err := os.Stdout.Write(source); err != nil {
+			log.Panicf("Error: %v", err)
+		}
+		return
+	}
+	if err := os.WriteFile(outputPath, source, 0o644); err != nil {
+		log.Panicf("Error: %v", err)
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 
+	"image-formula-find"
 	"image-formula-find/dna3"
+	"image-formula-find/evolve"
 )
 
 func main() {
@@ -27,13 +29,26 @@ func main() {
 	var outputPath string
 	var generations int
 	var steps int
+	var notation string
+	var bloatControl string
 
 	flag.StringVar(&inputPath, "input", "in5.png", "Path to input image")
 	flag.StringVar(&outputPath, "output", "evolution-dna3_01.gif", "Path to output GIF")
 	flag.IntVar(&generations, "generations", 500, "Number of generations")
 	flag.IntVar(&steps, "steps", 20, "Number of steps (frames in GIF)")
+	flag.StringVar(&notation, "notation", "text", "Formula notation: text, latex or mathml")
+	flag.StringVar(&bloatControl, "bloat", "", "Bloat control, such as size=2,maxdepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01")
 	flag.Parse()
 
+	formulaNotation, ok := image_formula_find.Notations[notation]
+	if !ok {
+		log.Panicf("Unknown notation: %s", notation)
+	}
+	control, err := image_formula_find.ParseBloat(bloatControl)
+	if err != nil {
+		log.Panicf("Invalid bloat control: %v", err)
+	}
+
 	log.SetFlags(log.Flags() | log.Lshortfile)
 
 	srcimg := LoadImage(inputPath)
@@ -64,8 +79,9 @@ func main() {
 
 	// Using BasicRequired implementation from dna3
 	worker := &dna3.BasicRequired{
-		R: plotSize,
-		I: srcimg,
+		R:     plotSize,
+		I:     srcimg,
+		Bloat: control,
 	}
 
 	var lastGeneration []*dna3.Individual
@@ -97,7 +113,7 @@ func main() {
 
 		// Capture frame if it's time
 		if (generation+1)%stepInterval == 0 || generation == generations-1 {
-			log.Printf("Capturing frame at generation %d", generation+1)
+			log.Printf("Capturing frame at generation %d, best size %d, mean size %.1f", generation+1, lastGeneration[0].Size, evolve.MeanSize(lastGeneration))
 
 			best := lastGeneration[0]
 			evolvedImg := best.Image()
@@ -164,9 +180,9 @@ func main() {
 				}
 			}
 
-			drawWrappedFormula("R: ", best.Rf.String())
-			drawWrappedFormula("G: ", best.Gf.String())
-			drawWrappedFormula("B: ", best.Bf.String())
+			drawWrappedFormula("R: ", best.Rf.Simplify().Format(formulaNotation))
+			drawWrappedFormula("G: ", best.Gf.Simplify().Format(formulaNotation))
+			drawWrappedFormula("B: ", best.Bf.Simplify().Format(formulaNotation))
 
 			// Convert to Paletted for GIF
 			palettedImg := image.NewPaletted(compositeRect, palette.Plan9)
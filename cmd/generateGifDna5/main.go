@@ -21,7 +21,9 @@ import (
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 
+	"image-formula-find"
 	"image-formula-find/dna5"
+	"image-formula-find/evolve"
 )
 
 func main() {
@@ -29,13 +31,26 @@ func main() {
 	var outputPath string
 	var generations int
 	var steps int
+	var notation string
+	var bloatControl string
 
 	flag.StringVar(&inputPath, "input", "flag_space.png", "Path to input image")
 	flag.StringVar(&outputPath, "output", "evolution-dna5.gif", "Path to output GIF")
 	flag.IntVar(&generations, "generations", 1000, "Number of generations")
 	flag.IntVar(&steps, "steps", 10, "Number of steps (frames in GIF)")
+	flag.StringVar(&notation, "notation", "text", "Formula notation: text, latex or mathml")
+	flag.StringVar(&bloatControl, "bloat", "", "Bloat control, such as size=2,maxdepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01")
 	flag.Parse()
 
+	formulaNotation, ok := image_formula_find.Notations[notation]
+	if !ok {
+		log.Panicf("Unknown notation: %s", notation)
+	}
+	control, err := image_formula_find.ParseBloat(bloatControl)
+	if err != nil {
+		log.Panicf("Invalid bloat control: %v", err)
+	}
+
 	log.SetFlags(log.Flags() | log.Lshortfile)
 
 	srcimg := LoadImage(inputPath)
@@ -59,8 +74,9 @@ func main() {
 
 	// Using BasicRequired implementation from dna5
 	worker := &dna5.BasicRequired{
-		R: plotSize,
-		I: srcimg,
+		R:     plotSize,
+		I:     srcimg,
+		Bloat: control,
 	}
 
 	var lastGeneration []*dna5.Individual
@@ -105,7 +121,7 @@ func main() {
 		}
 
 		if (generation+1)%stepInterval == 0 || generation == generations-1 {
-			log.Printf("Capturing frame at generation %d", generation+1)
+			log.Printf("Capturing frame at generation %d, best size %d, mean size %.1f", generation+1, lastGeneration[0].Size, evolve.MeanSize(lastGeneration))
 
 			best := lastGeneration[0]
 			evolvedImg := best.Image()
@@ -160,9 +176,9 @@ func main() {
 				}
 			}
 
-			drawWrappedFormula("R: ", best.Rf.String())
-			drawWrappedFormula("G: ", best.Gf.String())
-			drawWrappedFormula("B: ", best.Bf.String())
+			drawWrappedFormula("R: ", best.Rf.Simplify().Format(formulaNotation))
+			drawWrappedFormula("G: ", best.Gf.Simplify().Format(formulaNotation))
+			drawWrappedFormula("B: ", best.Bf.Simplify().Format(formulaNotation))
 
 			palettedImg := image.NewPaletted(compositeRect, palette.Plan9)
 			draw.FloydSteinberg.Draw(palettedImg, compositeRect, compositeImg, image.Pt(0, 0))
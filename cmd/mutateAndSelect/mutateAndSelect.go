@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"image"
+	"image-formula-find"
 	"image-formula-find/dna1"
+	"image-formula-find/drawer1"
+	"image-formula-find/evolve"
 	"image/draw"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -14,7 +18,30 @@ import (
 )
 
 func main() {
+	notation := flag.String("notation", "text", "Formula notation in out.csv: text, latex or mathml")
+	functions := flag.String("functions", "evolvable", "Functions to evolve with: evolvable, all or a comma separated list of names")
+	silhouette := flag.Bool("silhouette", false, "Fit the red formula as an implicit shape to a black on white source image")
+	bloatControl := flag.String("bloat", "", "Bloat control, such as size=2,maxdepth=12,tarpeian=0.3,tournament=4,lexicographic=0.01")
+	view := flag.String("viewport", "", "View the formulas are drawn through, such as x=2,y=-1,scale=5,rotation=0.3,aspect=fit")
+	flag.Parse()
 	log.SetFlags(log.Flags() | log.Lshortfile)
+	formulaNotation, ok := image_formula_find.Notations[*notation]
+	if !ok {
+		log.Panicf("Unknown notation: %s", *notation)
+	}
+	functionSet, err := image_formula_find.ParseFunctionSet(*functions)
+	if err != nil {
+		log.Panicf("Invalid functions: %v", err)
+	}
+	dna1.Functions = functionSet
+	control, err := image_formula_find.ParseBloat(*bloatControl)
+	if err != nil {
+		log.Panicf("Invalid bloat control: %v", err)
+	}
+	viewport, err := drawer1.ParseViewport(*view)
+	if err != nil {
+		log.Panicf("Invalid viewport: %v", err)
+	}
 	const logGenerations = 10
 	const generations = 1000
 	const childrenCount = 10
@@ -46,7 +73,8 @@ func main() {
 			fmt.Sprintf("C%d Formula Red", i+1),
 			fmt.Sprintf("C%d Formula Blue", i+1),
 			fmt.Sprintf("C%d Formula Green", i+1),
-			fmt.Sprintf("C%d Distance", i+1))
+			fmt.Sprintf("C%d Distance", i+1),
+			fmt.Sprintf("C%d Viewport", i+1))
 	}
 	headerSize := len(row)
 	if err := csvw.Write(row); err != nil {
@@ -63,19 +91,21 @@ func main() {
 		}
 	}()
 	worker := &dna1.BasicRequired{
-		R: plotSize,
-		I: srcimg,
+		R:          plotSize,
+		I:          srcimg,
+		Silhouette: *silhouette,
+		Bloat:      control,
+		Viewport:   viewport,
 	}
 	for generation := 0; generation < generations; generation++ {
-		log.Printf("Generation %d", generation+1)
-
 		lastGeneration = dna1.GenerationProcess(worker, lastGeneration, generation, newDNA)
+		log.Printf("Generation %d: best %0.2f, size %d, mean size %0.1f", generation+1, lastGeneration[0].Score, lastGeneration[0].Size, evolve.MeanSize(lastGeneration))
 
 		if (generation % (generations / logGenerations)) == 0 {
 			row = make([]string, 0, headerSize)
 			for i, child := range lastGeneration {
 				draw.Draw(destimg, plotSize.Add(image.Pt(plotSize.Dx()*i, plotSize.Dy()*(generation/(generations/logGenerations)))), child.Image(), image.Pt(0, 0), draw.Src)
-				row = append(row, child.CsvRow()...)
+				row = append(row, child.CsvRowWith(formulaNotation)...)
 			}
 			if err := csvw.Write(row); err != nil {
 				log.Panicf("Error writing csv: %v", err)
@@ -56,11 +56,11 @@ func (game *Game) Draw(screen *EbitenImage) {
 		ty += 20
 		EbitenDebugPrintAt(screen, fmt.Sprintf("DNA %s", ind.DNA), tx, ty)
 		ty += 20
-		EbitenDebugPrintAt(screen, fmt.Sprintf("Red   = %s", ind.Rf.String()), tx, ty)
+		EbitenDebugPrintAt(screen, fmt.Sprintf("Red   = %s", ind.Rf.Simplify().Text()), tx, ty)
 		ty += 20
-		EbitenDebugPrintAt(screen, fmt.Sprintf("Green = %s", ind.Gf.String()), tx, ty)
+		EbitenDebugPrintAt(screen, fmt.Sprintf("Green = %s", ind.Gf.Simplify().Text()), tx, ty)
 		ty += 20
-		EbitenDebugPrintAt(screen, fmt.Sprintf("Blue  = %s", ind.Bf.String()), tx, ty)
+		EbitenDebugPrintAt(screen, fmt.Sprintf("Blue  = %s", ind.Bf.Simplify().Text()), tx, ty)
 	}
 }
 
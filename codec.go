@@ -0,0 +1,682 @@
+package image_formula_find
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Formulas serialize losslessly, unlike String: every node keeps its type,
+// operand order, function name, comparison operator and Infix flag. Decoding
+// always produces pointer nodes, with functions looked up by name as
+// NewSingleFunction, NewDoubleFunction and NewTripleFunction do. A custom Fn
+// that is not registered under its name cannot be stored.
+//
+// In JSON every node is an object with a "type" field, for example
+//
+//	{"type":"Plus","lhs":{"type":"Var","var":"X"},"rhs":{"type":"Const","value":2}}
+//
+// Constants that JSON numbers cannot hold are written as the strings "NaN",
+// "+Inf" and "-Inf". A NaN other than math.NaN() is written with its bits, as
+// in "NaN(0x7ff8000000000002)", so its payload survives.
+
+// maxDecodeDepth bounds the nesting accepted when decoding, so corrupt input
+// cannot exhaust the stack.
+const maxDecodeDepth = 10000
+
+// jsonNode holds the fields of every node type.
+type jsonNode struct {
+	Type  string          `json:"type"`
+	Var   string          `json:"var,omitempty"`
+	Value *jsonFloat      `json:"value,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Infix bool            `json:"infix,omitempty"`
+	LHS   json.RawMessage `json:"lhs,omitempty"`
+	RHS   json.RawMessage `json:"rhs,omitempty"`
+	Expr  json.RawMessage `json:"expr,omitempty"`
+	Expr1 json.RawMessage `json:"expr1,omitempty"`
+	Expr2 json.RawMessage `json:"expr2,omitempty"`
+	Expr3 json.RawMessage `json:"expr3,omitempty"`
+}
+
+// jsonFloat is a float64 that also encodes NaN and the infinities.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		if bits := math.Float64bits(v); bits != math.Float64bits(math.NaN()) {
+			return []byte(fmt.Sprintf(`"NaN(%#016x)"`, bits)), nil
+		}
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		switch s {
+		case "NaN":
+			*f = jsonFloat(math.NaN())
+		case "+Inf":
+			*f = jsonFloat(math.Inf(1))
+		case "-Inf":
+			*f = jsonFloat(math.Inf(-1))
+		default:
+			var bits uint64
+			fmt.Sscanf(s, "NaN(%v)", &bits)
+			if !math.IsNaN(math.Float64frombits(bits)) || fmt.Sprintf("NaN(%#016x)", bits) != s {
+				return fmt.Errorf("invalid constant %q", s)
+			}
+			*f = jsonFloat(math.Float64frombits(bits))
+		}
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+func (v Function) MarshalJSON() ([]byte, error) {
+	if v.Equals == nil {
+		return []byte("null"), nil
+	}
+	return v.Equals.MarshalJSON()
+}
+
+func (v *Function) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		v.Equals = nil
+		return nil
+	}
+	v.Equals = &Equals{}
+	return v.Equals.UnmarshalJSON(data)
+}
+
+func (v Equals) MarshalJSON() ([]byte, error) {
+	node := jsonNode{Type: "Equals"}
+	var err error
+	if v.LHS != nil {
+		if node.LHS, err = json.Marshal(v.LHS); err != nil {
+			return nil, err
+		}
+	}
+	if node.RHS, err = json.Marshal(v.RHS); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func (v Var) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonNode{Type: "Var", Var: v.Var})
+}
+
+func (c Const) MarshalJSON() ([]byte, error) {
+	value := jsonFloat(c.Value)
+	return json.Marshal(jsonNode{Type: "Const", Value: &value})
+}
+
+func (v Plus) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Plus", v.LHS, v.RHS)
+}
+
+func (v Subtract) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Subtract", v.LHS, v.RHS)
+}
+
+func (v Multiply) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Multiply", v.LHS, v.RHS)
+}
+
+func (v Divide) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Divide", v.LHS, v.RHS)
+}
+
+func (v Power) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Power", v.LHS, v.RHS)
+}
+
+func (v Modulus) MarshalJSON() ([]byte, error) {
+	return marshalPairNode("Modulus", v.LHS, v.RHS)
+}
+
+func (v Negate) MarshalJSON() ([]byte, error) {
+	return marshalUnaryNode(jsonNode{Type: "Negate"}, v.Expr)
+}
+
+func (v Brackets) MarshalJSON() ([]byte, error) {
+	return marshalUnaryNode(jsonNode{Type: "Brackets"}, v.Expr)
+}
+
+func (v SingleFunction) MarshalJSON() ([]byte, error) {
+	return marshalUnaryNode(jsonNode{Type: "SingleFunction", Name: v.Name}, v.Expr)
+}
+
+func (v DoubleFunction) MarshalJSON() ([]byte, error) {
+	node := jsonNode{Type: "DoubleFunction", Name: v.Name, Infix: v.Infix}
+	var err error
+	if node.Expr1, err = json.Marshal(v.Expr1); err != nil {
+		return nil, err
+	}
+	if node.Expr2, err = json.Marshal(v.Expr2); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func (v TripleFunction) MarshalJSON() ([]byte, error) {
+	node := jsonNode{Type: "TripleFunction", Name: v.Name}
+	var err error
+	if node.Expr1, err = json.Marshal(v.Expr1); err != nil {
+		return nil, err
+	}
+	if node.Expr2, err = json.Marshal(v.Expr2); err != nil {
+		return nil, err
+	}
+	if node.Expr3, err = json.Marshal(v.Expr3); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func (v Compare) MarshalJSON() ([]byte, error) {
+	node := jsonNode{Type: "Compare", Op: v.Op}
+	var err error
+	if node.LHS, err = json.Marshal(v.LHS); err != nil {
+		return nil, err
+	}
+	if node.RHS, err = json.Marshal(v.RHS); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func marshalPairNode(kind string, lhs, rhs Expression) ([]byte, error) {
+	node := jsonNode{Type: kind}
+	var err error
+	if node.LHS, err = json.Marshal(lhs); err != nil {
+		return nil, err
+	}
+	if node.RHS, err = json.Marshal(rhs); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+func marshalUnaryNode(node jsonNode, expr Expression) ([]byte, error) {
+	var err error
+	if node.Expr, err = json.Marshal(expr); err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+// UnmarshalExpression decodes an expression written by json.Marshal.
+func UnmarshalExpression(data []byte) (Expression, error) {
+	return unmarshalExpression(data, 0)
+}
+
+func unmarshalExpression(data []byte, depth int) (Expression, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("expression nested too deeply")
+	}
+	var node jsonNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	child := func(data json.RawMessage, field string) (Expression, error) {
+		if len(data) == 0 || string(data) == "null" {
+			return nil, fmt.Errorf("%s is missing %s", node.Type, field)
+		}
+		return unmarshalExpression(data, depth+1)
+	}
+	var lhs, rhs Expression
+	var err error
+	switch node.Type {
+	case "Plus", "Subtract", "Multiply", "Divide", "Power", "Modulus", "Compare":
+		if lhs, err = child(node.LHS, "lhs"); err != nil {
+			return nil, err
+		}
+		if rhs, err = child(node.RHS, "rhs"); err != nil {
+			return nil, err
+		}
+	}
+	switch node.Type {
+	case "Equals":
+		e := &Equals{}
+		if len(node.LHS) != 0 && string(node.LHS) != "null" {
+			if e.LHS, err = unmarshalExpression(node.LHS, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		if e.RHS, err = child(node.RHS, "rhs"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "Var":
+		return &Var{Var: node.Var}, nil
+	case "Const":
+		if node.Value == nil {
+			return nil, errors.New("Const is missing value")
+		}
+		return &Const{Value: float64(*node.Value)}, nil
+	case "Plus":
+		return &Plus{LHS: lhs, RHS: rhs}, nil
+	case "Subtract":
+		return &Subtract{LHS: lhs, RHS: rhs}, nil
+	case "Multiply":
+		return &Multiply{LHS: lhs, RHS: rhs}, nil
+	case "Divide":
+		return &Divide{LHS: lhs, RHS: rhs}, nil
+	case "Power":
+		return &Power{LHS: lhs, RHS: rhs}, nil
+	case "Modulus":
+		return &Modulus{LHS: lhs, RHS: rhs}, nil
+	case "Compare":
+		if comparison(node.Op) == nil {
+			return nil, fmt.Errorf("unknown comparison %q", node.Op)
+		}
+		return &Compare{Op: node.Op, LHS: lhs, RHS: rhs}, nil
+	case "Negate", "Brackets", "SingleFunction":
+		expr, err := child(node.Expr, "expr")
+		if err != nil {
+			return nil, err
+		}
+		switch node.Type {
+		case "Negate":
+			return &Negate{Expr: expr}, nil
+		case "Brackets":
+			return &Brackets{Expr: expr}, nil
+		}
+		return NewSingleFunction(node.Name, expr), nil
+	case "DoubleFunction":
+		expr1, err := child(node.Expr1, "expr1")
+		if err != nil {
+			return nil, err
+		}
+		expr2, err := child(node.Expr2, "expr2")
+		if err != nil {
+			return nil, err
+		}
+		return NewDoubleFunction(node.Name, expr1, expr2, node.Infix), nil
+	case "TripleFunction":
+		expr1, err := child(node.Expr1, "expr1")
+		if err != nil {
+			return nil, err
+		}
+		expr2, err := child(node.Expr2, "expr2")
+		if err != nil {
+			return nil, err
+		}
+		expr3, err := child(node.Expr3, "expr3")
+		if err != nil {
+			return nil, err
+		}
+		return NewTripleFunction(node.Name, expr1, expr2, expr3), nil
+	}
+	return nil, fmt.Errorf("unknown expression type %q", node.Type)
+}
+
+// unmarshalInto decodes data and stores it in dst, which must point to a node
+// of the type found in data.
+func unmarshalInto[T any](data []byte, dst *T) error {
+	e, err := UnmarshalExpression(data)
+	if err != nil {
+		return err
+	}
+	v, ok := any(e).(*T)
+	if !ok {
+		return fmt.Errorf("cannot decode %T into %T", e, dst)
+	}
+	*dst = *v
+	return nil
+}
+
+func (v *Equals) UnmarshalJSON(data []byte) error         { return unmarshalInto(data, v) }
+func (v *Var) UnmarshalJSON(data []byte) error            { return unmarshalInto(data, v) }
+func (c *Const) UnmarshalJSON(data []byte) error          { return unmarshalInto(data, c) }
+func (v *Plus) UnmarshalJSON(data []byte) error           { return unmarshalInto(data, v) }
+func (v *Subtract) UnmarshalJSON(data []byte) error       { return unmarshalInto(data, v) }
+func (v *Multiply) UnmarshalJSON(data []byte) error       { return unmarshalInto(data, v) }
+func (v *Divide) UnmarshalJSON(data []byte) error         { return unmarshalInto(data, v) }
+func (v *Power) UnmarshalJSON(data []byte) error          { return unmarshalInto(data, v) }
+func (v *Modulus) UnmarshalJSON(data []byte) error        { return unmarshalInto(data, v) }
+func (v *Negate) UnmarshalJSON(data []byte) error         { return unmarshalInto(data, v) }
+func (v *Brackets) UnmarshalJSON(data []byte) error       { return unmarshalInto(data, v) }
+func (v *SingleFunction) UnmarshalJSON(data []byte) error { return unmarshalInto(data, v) }
+func (v *DoubleFunction) UnmarshalJSON(data []byte) error { return unmarshalInto(data, v) }
+func (v *TripleFunction) UnmarshalJSON(data []byte) error { return unmarshalInto(data, v) }
+func (v *Compare) UnmarshalJSON(data []byte) error        { return unmarshalInto(data, v) }
+
+// The binary form is a preorder walk of the tree with one tag byte per node.
+// Var and function names and comparison operators are a uvarint length
+// followed by the bytes and
+// constants are the 8 little endian bytes of the float, so even NaN payloads
+// survive. Functions start with a format version byte.
+const binaryVersion = 1
+
+const (
+	tagNone byte = iota
+	tagEquals
+	tagVar
+	tagConst
+	tagPlus
+	tagSubtract
+	tagMultiply
+	tagDivide
+	tagPower
+	tagModulus
+	tagNegate
+	tagBrackets
+	tagSingleFunction
+	tagDoubleFunction
+	tagInfixFunction
+	tagTripleFunction
+	tagCompare
+)
+
+func (v Function) MarshalBinary() ([]byte, error) {
+	b := []byte{binaryVersion}
+	if v.Equals == nil {
+		return append(b, tagNone), nil
+	}
+	return appendExpression(b, v.Equals)
+}
+
+func (v *Function) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != binaryVersion {
+		return errors.New("unsupported binary formula version")
+	}
+	if len(data) == 2 && data[1] == tagNone {
+		v.Equals = nil
+		return nil
+	}
+	e, err := UnmarshalExpressionBinary(data[1:])
+	if err != nil {
+		return err
+	}
+	eq, ok := e.(*Equals)
+	if !ok {
+		return fmt.Errorf("expected Equals, got %T", e)
+	}
+	v.Equals = eq
+	return nil
+}
+
+// MarshalExpressionBinary encodes an expression in the compact binary form.
+func MarshalExpressionBinary(e Expression) ([]byte, error) {
+	return appendExpression(nil, e)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendExpression(b []byte, e Expression) ([]byte, error) {
+	var err error
+	pair := func(tag byte, lhs, rhs Expression) ([]byte, error) {
+		if b, err = appendExpression(append(b, tag), lhs); err != nil {
+			return nil, err
+		}
+		return appendExpression(b, rhs)
+	}
+	switch v := e.(type) {
+	case *Equals:
+		b = append(b, tagEquals)
+		if v.LHS == nil {
+			b = append(b, tagNone)
+		} else if b, err = appendExpression(b, v.LHS); err != nil {
+			return nil, err
+		}
+		return appendExpression(b, v.RHS)
+	case *Var:
+		return appendString(append(b, tagVar), v.Var), nil
+	case *Const:
+		return binary.LittleEndian.AppendUint64(append(b, tagConst), math.Float64bits(v.Value)), nil
+	case *Plus:
+		return pair(tagPlus, v.LHS, v.RHS)
+	case *Subtract:
+		return pair(tagSubtract, v.LHS, v.RHS)
+	case *Multiply:
+		return pair(tagMultiply, v.LHS, v.RHS)
+	case *Divide:
+		return pair(tagDivide, v.LHS, v.RHS)
+	case *Power:
+		return pair(tagPower, v.LHS, v.RHS)
+	case *Modulus:
+		return pair(tagModulus, v.LHS, v.RHS)
+	case *Negate:
+		return appendExpression(append(b, tagNegate), v.Expr)
+	case *Brackets:
+		return appendExpression(append(b, tagBrackets), v.Expr)
+	case *SingleFunction:
+		return appendExpression(appendString(append(b, tagSingleFunction), v.Name), v.Expr)
+	case *DoubleFunction:
+		tag := tagDoubleFunction
+		if v.Infix {
+			tag = tagInfixFunction
+		}
+		b = appendString(append(b, tag), v.Name)
+		if b, err = appendExpression(b, v.Expr1); err != nil {
+			return nil, err
+		}
+		return appendExpression(b, v.Expr2)
+	case *TripleFunction:
+		b = appendString(append(b, tagTripleFunction), v.Name)
+		for _, each := range []Expression{v.Expr1, v.Expr2} {
+			if b, err = appendExpression(b, each); err != nil {
+				return nil, err
+			}
+		}
+		return appendExpression(b, v.Expr3)
+	case *Compare:
+		b = appendString(append(b, tagCompare), v.Op)
+		if b, err = appendExpression(b, v.LHS); err != nil {
+			return nil, err
+		}
+		return appendExpression(b, v.RHS)
+	}
+	if p := pointerTo(e); p != nil {
+		return appendExpression(b, p)
+	}
+	return nil, fmt.Errorf("cannot encode expression type %T", e)
+}
+
+// pointerTo returns a pointer node for a value node, or nil.
+func pointerTo(e Expression) Expression {
+	switch v := e.(type) {
+	case Equals:
+		return &v
+	case Var:
+		return &v
+	case Const:
+		return &v
+	case Plus:
+		return &v
+	case Subtract:
+		return &v
+	case Multiply:
+		return &v
+	case Divide:
+		return &v
+	case Power:
+		return &v
+	case Modulus:
+		return &v
+	case Negate:
+		return &v
+	case Brackets:
+		return &v
+	case SingleFunction:
+		return &v
+	case DoubleFunction:
+		return &v
+	case TripleFunction:
+		return &v
+	case Compare:
+		return &v
+	}
+	return nil
+}
+
+// UnmarshalExpressionBinary decodes an expression written by
+// MarshalExpressionBinary.
+func UnmarshalExpressionBinary(data []byte) (Expression, error) {
+	d := &binaryDecoder{data: data}
+	e, err := d.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.data) != 0 {
+		return nil, errors.New("trailing data after expression")
+	}
+	return e, nil
+}
+
+type binaryDecoder struct {
+	data []byte
+}
+
+var errTruncated = errors.New("truncated binary expression")
+
+func (d *binaryDecoder) string() (string, error) {
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 || uint64(len(d.data)-size) < n {
+		return "", errTruncated
+	}
+	s := string(d.data[size : size+int(n)])
+	d.data = d.data[size+int(n):]
+	return s, nil
+}
+
+func (d *binaryDecoder) expression(depth int) (Expression, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("expression nested too deeply")
+	}
+	if len(d.data) == 0 {
+		return nil, errTruncated
+	}
+	tag := d.data[0]
+	d.data = d.data[1:]
+	var err error
+	var lhs, rhs Expression
+	switch tag {
+	case tagPlus, tagSubtract, tagMultiply, tagDivide, tagPower, tagModulus:
+		if lhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		if rhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	switch tag {
+	case tagEquals:
+		e := &Equals{}
+		if len(d.data) != 0 && d.data[0] == tagNone {
+			d.data = d.data[1:]
+		} else if e.LHS, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		if e.RHS, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tagVar:
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return &Var{Var: s}, nil
+	case tagConst:
+		if len(d.data) < 8 {
+			return nil, errTruncated
+		}
+		c := &Const{Value: math.Float64frombits(binary.LittleEndian.Uint64(d.data))}
+		d.data = d.data[8:]
+		return c, nil
+	case tagPlus:
+		return &Plus{LHS: lhs, RHS: rhs}, nil
+	case tagSubtract:
+		return &Subtract{LHS: lhs, RHS: rhs}, nil
+	case tagMultiply:
+		return &Multiply{LHS: lhs, RHS: rhs}, nil
+	case tagDivide:
+		return &Divide{LHS: lhs, RHS: rhs}, nil
+	case tagPower:
+		return &Power{LHS: lhs, RHS: rhs}, nil
+	case tagModulus:
+		return &Modulus{LHS: lhs, RHS: rhs}, nil
+	case tagNegate, tagBrackets:
+		expr, err := d.expression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagNegate {
+			return &Negate{Expr: expr}, nil
+		}
+		return &Brackets{Expr: expr}, nil
+	case tagSingleFunction:
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		expr, err := d.expression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return NewSingleFunction(name, expr), nil
+	case tagDoubleFunction, tagInfixFunction:
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if lhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		if rhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		return NewDoubleFunction(name, lhs, rhs, tag == tagInfixFunction), nil
+	case tagTripleFunction:
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		var args [3]Expression
+		for i := range args {
+			if args[i], err = d.expression(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return NewTripleFunction(name, args[0], args[1], args[2]), nil
+	case tagCompare:
+		op, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if comparison(op) == nil {
+			return nil, fmt.Errorf("unknown comparison %q", op)
+		}
+		if lhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		if rhs, err = d.expression(depth + 1); err != nil {
+			return nil, err
+		}
+		return &Compare{Op: op, LHS: lhs, RHS: rhs}, nil
+	}
+	return nil, fmt.Errorf("unknown expression tag %d", tag)
+}
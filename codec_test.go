@@ -0,0 +1,154 @@
+package image_formula_find
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	points := [][2]float64{{-10, -10}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 6)
+
+		js, err := json.Marshal(f)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		var fromJSON Function
+		if err := json.Unmarshal(js, &fromJSON); err != nil {
+			t.Fatalf("%s: %v", js, err)
+		}
+		bin, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		var fromBinary Function
+		if err := fromBinary.UnmarshalBinary(bin); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+
+		for _, g := range []*Function{&fromJSON, &fromBinary} {
+			if g.String() != f.String() {
+				t.Fatalf("String changed from %q to %q", f, g)
+			}
+			if again, _ := g.MarshalBinary(); !bytes.Equal(again, bin) {
+				t.Fatalf("%s: binary form changed on round trip", f)
+			}
+			if again, _ := json.Marshal(g); !bytes.Equal(again, js) {
+				t.Fatalf("%s: JSON changed on round trip:\n%s\n%s", f, js, again)
+			}
+			for _, p := range points {
+				want, _, _ := f.Evaluate(p[0], p[1], 1)
+				got, _, _ := g.Evaluate(p[0], p[1], 1)
+				if !sameFloat(got, want) {
+					t.Fatalf("%s at %v: got %v want %v", f, p, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCodecKeepsInfixAndSpecialConstants(t *testing.T) {
+	f := &Function{Equals: &Equals{RHS: NewDoubleFunction("Atan2", &Var{Var: "x"}, &Plus{
+		LHS: &Const{Value: math.Inf(-1)},
+		RHS: &Negate{Expr: &Const{Value: math.Copysign(0, -1)}},
+	}, true)}}
+	js, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"Equals","rhs":{"type":"DoubleFunction","name":"Atan2","infix":true,` +
+		`"expr1":{"type":"Var","var":"x"},"expr2":{"type":"Plus","lhs":{"type":"Const","value":"-Inf"},` +
+		`"rhs":{"type":"Negate","expr":{"type":"Const","value":-0}}}}}`
+	if string(js) != want {
+		t.Errorf("Got %s\nwant %s", js, want)
+	}
+	var g Function
+	if err := json.Unmarshal(js, &g); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := g.Equals.RHS.(*DoubleFunction)
+	if !ok || !d.Infix || d.Name != "Atan2" || d.Fn == nil {
+		t.Errorf("Infix function not restored: %#v", g.Equals.RHS)
+	}
+	zero := d.Expr2.(*Plus).RHS.(*Negate).Expr.(*Const).Value
+	if zero != 0 || !math.Signbit(zero) {
+		t.Errorf("Expected -0, got %v", zero)
+	}
+}
+
+func TestCodecKeepsNaNPayloads(t *testing.T) {
+	for _, bits := range []uint64{math.Float64bits(math.NaN()), 0x7ff8000000000002, 0xfff0000000000003} {
+		f := &Function{Equals: &Equals{RHS: &Const{Value: math.Float64frombits(bits)}}}
+		js, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var g Function
+		if err := json.Unmarshal(js, &g); err != nil {
+			t.Fatalf("%s: %v", js, err)
+		}
+		if got := math.Float64bits(g.Equals.RHS.(*Const).Value); got != bits {
+			t.Errorf("%s decoded to %#016x, want %#016x", js, got, bits)
+		}
+	}
+	for _, bad := range []string{`"NaN(0x7ff8000000000002)x"`, `"NaN(0x3ff0000000000000)"`, `"NaN(12)"`} {
+		var f jsonFloat
+		if err := json.Unmarshal([]byte(bad), &f); err == nil {
+			t.Errorf("Expected error decoding %s", bad)
+		}
+	}
+}
+
+func TestCodecNodes(t *testing.T) {
+	var p Plus
+	if err := json.Unmarshal([]byte(`{"type":"Plus","lhs":{"type":"Var","var":"X"},"rhs":{"type":"Const","value":2}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "X + 2" {
+		t.Errorf("Got %s", p)
+	}
+	if err := json.Unmarshal([]byte(`{"type":"Minus"}`), &p); err == nil {
+		t.Error("Expected error for unknown type")
+	}
+	if err := json.Unmarshal([]byte(`{"type":"Negate","expr":{"type":"Var","var":"X"}}`), &p); err == nil {
+		t.Error("Expected error decoding Negate into Plus")
+	}
+	if _, err := UnmarshalExpression([]byte(`{"type":"Plus","lhs":{"type":"Var","var":"X"}}`)); err == nil {
+		t.Error("Expected error for missing operand")
+	}
+}
+
+func TestCodecBinaryErrors(t *testing.T) {
+	f, err := ParseFunction("y = sin(x) + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g Function
+	for n := 0; n < len(bin); n++ {
+		if err := g.UnmarshalBinary(bin[:n]); err == nil {
+			t.Errorf("Expected error for %d of %d bytes", n, len(bin))
+		}
+	}
+	if err := g.UnmarshalBinary(append(bin, 0)); err == nil {
+		t.Error("Expected error for trailing data")
+	}
+	if _, err := MarshalExpressionBinary(opaqueExpression{&Var{Var: "X"}}); err == nil {
+		t.Error("Expected error for unknown expression type")
+	}
+	empty, err := Function{}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.UnmarshalBinary(empty); err != nil || g.Equals != nil {
+		t.Errorf("Expected empty formula, got %v %v", g.Equals, err)
+	}
+}
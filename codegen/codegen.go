@@ -0,0 +1,375 @@
+// Package codegen turns the three channel formulas of an individual into
+// standalone source code: a Go function, a GLSL fragment shader or a
+// JavaScript function drawing on a canvas. Each maps pixels onto X and Y
+// through a drawer1.Viewport, X and Y from -10 to 10 by default, and converts
+// channel values to bytes the way drawer1 does.
+//
+// The Go output evaluates exactly as drawer1 renders, bit for bit. GLSL works
+// in single precision and JavaScript has its own maths library, so their
+// output is close but can differ where a formula is sensitive to the last
+// bits. Both emulate the float to uint8 conversion Go does on amd64:
+// truncate, keep the low byte, and give 0 for NaN and values outside the
+// int32 range.
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupported is returned for formulas using a function that has no
+// equivalent in the target language.
+var ErrUnsupported = errors.New("unsupported in target language")
+
+// dialect describes how a target language writes the parts of a formula.
+// Templates take their operands through %s verbs, in order.
+type dialect struct {
+	name     string
+	number   func(g *generator, v float64) string
+	multiply string
+	power    string
+	modulus  string
+	// condition turns a comparison into 1 or 0.
+	condition string
+	// width and height are the size of the image in pixels, for PX and PY.
+	width, height string
+	// functions are the templates for the registered functions by upper case
+	// name.
+	functions map[string]string
+	// helpers are the support functions templates may call, by name.
+	helpers map[string]string
+}
+
+// generator writes the expressions of one source file, remembering what
+// they need declared alongside them.
+type generator struct {
+	dialect *dialect
+	used    map[string]bool
+	consts  []float64
+	// rotated is set when the view turns X and Y away from the pixel axes.
+	rotated bool
+	// view is set once an expression refers to the view, see unit.
+	view bool
+}
+
+func newGenerator(d *dialect, view drawer1.Viewport) *generator {
+	return &generator{dialect: d, used: map[string]bool{}, rotated: view.Transform(1, 1).Rotated()}
+}
+
+// settings returns the centre, scale and rotation of the view, the scale
+// DefaultScale when not positive, for the generated code to work out the
+// view of an image at run time.
+func settings(view drawer1.Viewport) []float64 {
+	scale := view.Scale
+	if scale <= 0 {
+		scale = drawer1.DefaultScale
+	}
+	return []float64{view.CenterX, view.CenterY, scale, view.Rotation}
+}
+
+// channel returns the expression for a channel formula. Missing formulas
+// render as 0, as they do in drawer1.
+func (g *generator) channel(f *image_formula_find.Function) (string, error) {
+	if f == nil || f.Equals == nil {
+		return g.dialect.number(g, 0), nil
+	}
+	return g.expression(f.Equals)
+}
+
+// apply fills in a template, noting any helpers it calls.
+func (g *generator) apply(template string, args ...string) string {
+	for name := range g.dialect.helpers {
+		if refers(template, name) {
+			g.use(name)
+		}
+	}
+	a := make([]any, len(args))
+	for i := range args {
+		a[i] = args[i]
+	}
+	return fmt.Sprintf(template, a...)
+}
+
+// use marks a helper as needed, along with the helpers it refers to in turn.
+func (g *generator) use(helper string) {
+	if g.used[helper] {
+		return
+	}
+	g.used[helper] = true
+	for name := range g.dialect.helpers {
+		if name != helper && refers(g.dialect.helpers[helper], name) {
+			g.use(name)
+		}
+	}
+}
+
+// helperSource returns the source of the helpers used so far, in name order
+// except that a helper comes after the helpers it refers to, closures having
+// to be declared before they are used.
+func (g *generator) helperSource() string {
+	names := make([]string, 0, len(g.used))
+	for name := range g.used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	written := map[string]bool{}
+	var write func(name string)
+	write = func(name string) {
+		if written[name] {
+			return
+		}
+		written[name] = true
+		for _, callee := range names {
+			if callee != name && refers(g.dialect.helpers[name], callee) {
+				write(callee)
+			}
+		}
+		sb.WriteString(g.dialect.helpers[name])
+		sb.WriteString("\n\n")
+	}
+	for _, name := range names {
+		write(name)
+	}
+	return sb.String()
+}
+
+// refers reports whether source mentions the identifier name, called or
+// passed as a value.
+func refers(source, name string) bool {
+	for i := 0; ; {
+		at := strings.Index(source[i:], name)
+		if at < 0 {
+			return false
+		}
+		at += i
+		end := at + len(name)
+		if (at == 0 || !identifier(source[at-1])) && (end == len(source) || !identifier(source[end])) {
+			return true
+		}
+		i = end
+	}
+}
+
+// identifier reports whether c may occur in an identifier.
+func identifier(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// expression writes e fully parenthesised, with the operand order of
+// Expression.Evaluate.
+func (g *generator) expression(e image_formula_find.Expression) (string, error) {
+	binary := func(template string, a, b image_formula_find.Expression) (string, error) {
+		sa, err := g.expression(a)
+		if err != nil {
+			return "", err
+		}
+		sb, err := g.expression(b)
+		if err != nil {
+			return "", err
+		}
+		return g.apply(template, sa, sb), nil
+	}
+	switch e := pointer(e).(type) {
+	case *image_formula_find.Equals:
+		if e.LHS == nil {
+			return g.expression(e.RHS)
+		}
+		return binary("(%s - %s)", e.RHS, e.LHS)
+	case *image_formula_find.Var:
+		switch strings.ToUpper(e.Var) {
+		case "X":
+			return "x", nil
+		case "Y":
+			return "y", nil
+		case "T":
+			return "t", nil
+		case "R":
+			return g.expression(image_formula_find.NewDoubleFunction("Hypot", &image_formula_find.Var{Var: "X"}, &image_formula_find.Var{Var: "Y"}, false))
+		case "A":
+			return g.expression(image_formula_find.NewDoubleFunction("Atan2", &image_formula_find.Var{Var: "Y"}, &image_formula_find.Var{Var: "X"}, false))
+		case "U":
+			return g.unit(0), nil
+		case "V":
+			return g.unit(1), nil
+		case "PX":
+			return g.apply(g.dialect.multiply, g.unit(0), g.dialect.width), nil
+		case "PY":
+			return g.apply(g.dialect.multiply, g.unit(1), g.dialect.height), nil
+		}
+		// Parameters are bound when drawing.
+		return "", fmt.Errorf("%w: parameter %s", ErrUnsupported, e.Var)
+	case *image_formula_find.Const:
+		return g.dialect.number(g, e.Value), nil
+	case *image_formula_find.Plus:
+		return binary("(%s + %s)", e.RHS, e.LHS)
+	case *image_formula_find.Subtract:
+		return binary("(%s - %s)", e.RHS, e.LHS)
+	case *image_formula_find.Multiply:
+		return binary(g.dialect.multiply, e.RHS, e.LHS)
+	case *image_formula_find.Divide:
+		return binary("(%s / %s)", e.RHS, e.LHS)
+	case *image_formula_find.Power:
+		return binary(g.dialect.power, e.LHS, e.RHS)
+	case *image_formula_find.Modulus:
+		return binary(g.dialect.modulus, e.LHS, e.RHS)
+	case *image_formula_find.Negate:
+		s, err := g.expression(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "(-" + s + ")", nil
+	case *image_formula_find.Brackets:
+		return g.expression(e.Expr)
+	case *image_formula_find.SingleFunction:
+		s, err := g.expression(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		_, registered := image_formula_find.SingleFunctions[strings.ToUpper(e.Name)]
+		return g.call(e.Name, registered || e.Fn != nil, s)
+	case *image_formula_find.DoubleFunction:
+		s1, err := g.expression(e.Expr1)
+		if err != nil {
+			return "", err
+		}
+		s2, err := g.expression(e.Expr2)
+		if err != nil {
+			return "", err
+		}
+		_, registered := image_formula_find.DoubleFunctions[strings.ToUpper(e.Name)]
+		return g.call(e.Name, registered || e.Fn != nil, s1, s2)
+	case *image_formula_find.TripleFunction:
+		args := make([]string, 3)
+		for i, each := range []image_formula_find.Expression{e.Expr1, e.Expr2, e.Expr3} {
+			s, err := g.expression(each)
+			if err != nil {
+				return "", err
+			}
+			args[i] = s
+		}
+		_, registered := image_formula_find.TripleFunctions[strings.ToUpper(e.Name)]
+		return g.call(e.Name, registered || e.Fn != nil, args...)
+	case *image_formula_find.Compare:
+		if !slices.Contains(image_formula_find.Comparisons, e.Op) {
+			return g.dialect.number(g, 0), nil
+		}
+		return binary(fmt.Sprintf(g.dialect.condition, "(%s "+e.Op+" %s)"), e.LHS, e.RHS)
+	}
+	return "", fmt.Errorf("%w: expression type %T", ErrUnsupported, e)
+}
+
+// unit returns U for axis 0 and V for axis 1, x and y mapped back onto the
+// image as image_formula_find.Frame does. The generated code holds the view
+// of the image in an array, view, of XU, XV, X0, YU, YV and Y0.
+func (g *generator) unit(axis int) string {
+	g.view = true
+	if !g.rotated {
+		if axis == 0 {
+			return "((x - view[2]) / view[0])"
+		}
+		return "((y - view[5]) / view[4])"
+	}
+	multiply := func(a, b string) string {
+		return g.apply(g.dialect.multiply, a, b)
+	}
+	det := "(" + multiply("view[0]", "view[4]") + " - " + multiply("view[1]", "view[3]") + ")"
+	dx, dy := "(x - view[2])", "(y - view[5])"
+	if axis == 0 {
+		return "((" + multiply("view[4]", dx) + " - " + multiply("view[1]", dy) + ") / " + det + ")"
+	}
+	return "((" + multiply("view[0]", dy) + " - " + multiply("view[3]", dx) + ") / " + det + ")"
+}
+
+// viewComment describes the view for the comments of the generated code.
+func viewComment(view drawer1.Viewport) string {
+	if view == (drawer1.Viewport{}) {
+		return "the default view, X and Y from -10 to 10"
+	}
+	return view.String()
+}
+
+// spans returns the expressions of the spans of the view across the width
+// and the height, w and h, given the scale and the names of the min and max
+// functions, as drawer1.Viewport.Transform works them out.
+func spans(aspect drawer1.Aspect, scale, min, max, w, h string) (x, y string) {
+	switch aspect {
+	case drawer1.Fit:
+		return fmt.Sprintf("%s * %s / %s(%s, %s)", scale, w, min, w, h), fmt.Sprintf("%s * %s / %s(%s, %s)", scale, h, min, w, h)
+	case drawer1.Fill:
+		return fmt.Sprintf("%s * %s / %s(%s, %s)", scale, w, max, w, h), fmt.Sprintf("%s * %s / %s(%s, %s)", scale, h, max, w, h)
+	}
+	return scale, scale
+}
+
+// call writes a function call. Functions that are not registered evaluate to
+// their first argument.
+func (g *generator) call(name string, registered bool, args ...string) (string, error) {
+	if !registered {
+		return args[0], nil
+	}
+	template, ok := g.dialect.functions[strings.ToUpper(name)]
+	if !ok || strings.Count(template, "%s") != len(args) {
+		return "", fmt.Errorf("%w: %s has no %s equivalent", ErrUnsupported, name, g.dialect.name)
+	}
+	return g.apply(template, args...), nil
+}
+
+// pointer returns the pointer form of a value node, so expression only needs
+// one case per node type.
+func pointer(e image_formula_find.Expression) image_formula_find.Expression {
+	switch v := e.(type) {
+	case image_formula_find.Equals:
+		return &v
+	case image_formula_find.Var:
+		return &v
+	case image_formula_find.Const:
+		return &v
+	case image_formula_find.Plus:
+		return &v
+	case image_formula_find.Subtract:
+		return &v
+	case image_formula_find.Multiply:
+		return &v
+	case image_formula_find.Divide:
+		return &v
+	case image_formula_find.Power:
+		return &v
+	case image_formula_find.Modulus:
+		return &v
+	case image_formula_find.Negate:
+		return &v
+	case image_formula_find.Brackets:
+		return &v
+	case image_formula_find.SingleFunction:
+		return &v
+	case image_formula_find.DoubleFunction:
+		return &v
+	case image_formula_find.TripleFunction:
+		return &v
+	case image_formula_find.Compare:
+		return &v
+	}
+	return e
+}
+
+// comment returns the formulas as comment lines with the given prefix.
+func comment(prefix string, r, g, b *image_formula_find.Function) string {
+	var sb strings.Builder
+	for _, c := range []struct {
+		label string
+		f     *image_formula_find.Function
+	}{{"R", r}, {"G", g}, {"B", b}} {
+		text := "0"
+		if c.f != nil && c.f.Equals != nil {
+			text = c.f.Text()
+		}
+		fmt.Fprintf(&sb, "%s%s: %s\n", prefix, c.label, text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,288 @@
+package codegen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image-formula-find"
+	"image-formula-find/dna4"
+	"image-formula-find/dna5"
+	"image-formula-find/drawer1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const width, height = 40, 30
+
+// at is the time the formulas are rendered at.
+const at = 0.75
+
+// channels are formula triples covering every node type and registered
+// function.
+var channels = [][3]string{
+	{"y = x * x + 2", "y / 4 = x - 7 % y", "0 = -(x ^ 2) + t"},
+	{"0 = abs(x) * acos(y / 10) + acosh(x + 12) * asin(x / 11)", "0 = asinh(y) * 40 + atan(x) * atanh(y / 11)", "0 = cbrt(x * y) * ceil(y) + cos(x) * cosh(y / 3)"},
+	{"0 = erf(x) * 100 + erfc(y) * 50 + erfcinv(y / 11 + 1) * 20", "0 = erfinv(x / 11) * 70 + exp(y / 3) + exp2(x / 2) + expm1(y / 4)", "0 = floor(x * y) + gamma(x / 3) * 5"},
+	{"0 = ilogb(x * 100) * 8 + inf(x) + j0(x) * 90 + j1(y) * 90", "0 = jn(3, x) * 200 + ldexp(y, 3) + log(x + 11) * 30", "0 = log10(abs(y)) * 40 + log1p(x + 10.5) * 20 + log2(abs(x * y)) * 9"},
+	{"0 = logb(x * 7) * 15 + pow10(y / 5) + round(x * 3.3) + roundtoeven(y * 2.5)", "0 = sin(x) * 120 + sinh(y / 3) + sqrt(x + y) * 30", "0 = tan(x) * 30 + tanh(y) * 90 + trunc(x * 4.7) + y0(abs(x) + 1) * 40"},
+	{"0 = y1(abs(y) + 1) * 50 + yn(2, abs(x) + 1) * 30", "0 = atan2(x, y) * 40 + copysign(3, x) + dim(x, y) * 9 + hypot(x, y) * 12", "0 = max(x, y) * 13 + min(x, y) * 7 + mod(x * 30, y) + nextafter(x, y) * 5"},
+	{"0 = pow(x, y) + remainder(x * 30, y) + x atan2 y * 30", "0 = 0 / x + 1e300 * 1e300 * x - -0", "x = 255.5 - y * 1e9"},
+	{"0 = r * 20 + a * 40", "0 = u * 255 + v * 100", "0 = (r < 5) * 200 + px + py * 2"},
+	{"0 = (x < y) * 100 + (x <= 0) * 50 + (y > x * x) * 30 + (y >= 2) * 20 + (x == 0) * 70", "0 = if(x - y, 200, 30) + step(x, y) * 40 + clamp(x * 30, 0, 120)", "0 = lerp(x, y, 3) * 9 + smoothstep(-3, 4, x) * 200"},
+	{"0 = perlin(x, y) * 100 + perlin3(x, y, 2.5) * 100 + simplex(x, y) * 50", "0 = simplex3(x, y, x * y) * 90 + valuenoise(x, y) * 80 + valuenoise3(y, x, 1.5) * 60", "0 = worley(x, y) * 60 + worley3(x, y, 0.5) * 50 + fbm(x, y, 4) * 90 + turbulence(y, x, 3) * 90"},
+}
+
+func parse(t *testing.T, formulas [3]string) [3]*image_formula_find.Function {
+	var fs [3]*image_formula_find.Function
+	for i, each := range formulas {
+		f, err := image_formula_find.ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		fs[i] = f
+	}
+	return fs
+}
+
+// triples returns the fixed formulas followed by random ones from the dna
+// packages.
+func triples(t *testing.T) [][3]*image_formula_find.Function {
+	var result [][3]*image_formula_find.Function
+	for _, each := range channels {
+		result = append(result, parse(t, each))
+	}
+	// The parser rejects unknown functions, which DNA may still call.
+	x, y := &image_formula_find.Var{Var: "x"}, &image_formula_find.Var{Var: "y"}
+	result = append(result, [3]*image_formula_find.Function{
+		{Equals: &image_formula_find.Equals{RHS: image_formula_find.NewSingleFunction("unknown", &image_formula_find.Multiply{LHS: x, RHS: &image_formula_find.Const{Value: 20}})}},
+		{Equals: &image_formula_find.Equals{RHS: image_formula_find.NewDoubleFunction("unknown", y, x, false)}},
+		{Equals: &image_formula_find.Equals{RHS: image_formula_find.NewTripleFunction("unknown", &image_formula_find.Multiply{LHS: y, RHS: &image_formula_find.Const{Value: 20}}, x, y)}},
+	})
+	for i := 0; i < 5; i++ {
+		r, g, b := dna4.ParseDNA(dna4.RndStr(60))
+		result = append(result, [3]*image_formula_find.Function{r, g, b})
+		r, g, b = dna5.ParseDNA(dna5.RndStr(60))
+		result = append(result, [3]*image_formula_find.Function{r, g, b})
+	}
+	return append(result, [3]*image_formula_find.Function{})
+}
+
+// views are the viewports the formulas using U, V, PX and PY are drawn
+// through besides the default one.
+var views = []drawer1.Viewport{
+	{CenterX: 4, CenterY: -2, Scale: 7, Aspect: drawer1.Fit},
+	{Scale: 30, Aspect: drawer1.Fill},
+	{CenterX: 1, Rotation: 0.7},
+}
+
+// render draws the formulas with drawer1, returning the pixels.
+func render(fs [3]*image_formula_find.Function) []byte {
+	return renderWith(drawer1.Viewport{}, fs)
+}
+
+// renderWith is render through view.
+func renderWith(view drawer1.Viewport, fs [3]*image_formula_find.Function) []byte {
+	d := &drawer1.Drawer{RedFormula: fs[0], GreenFormula: fs[1], BlueFormula: fs[2], Width: width, Height: height, T: at, Viewport: view}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	d.Render(dst)
+	return dst.Pix
+}
+
+// run writes the files into a temporary directory, runs the command there
+// and returns its output, skipping the test when the tool is not installed.
+func run(t *testing.T, files map[string]string, tool string, args ...string) []byte {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping in short mode")
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		t.Skipf("%s not found", tool)
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", tool, err, stderr.String())
+	}
+	return out
+}
+
+func TestGoMatchesDrawer1(t *testing.T) {
+	fs := triples(t)
+	files := map[string]string{"go.mod": "module generated\n\ngo 1.21\n"}
+	var main strings.Builder
+	main.WriteString("package main\n\nimport \"os\"\n\nfunc main() {\n")
+	for i, each := range fs {
+		src, err := Go("main", fmt.Sprintf("formula%d", i), each[0], each[1], each[2])
+		if err != nil {
+			t.Fatalf("%v: %v", each, err)
+		}
+		files[fmt.Sprintf("formula%d.go", i)] = string(src)
+		fmt.Fprintf(&main, "\tos.Stdout.Write(formula%dImage(%d, %d, %v).Pix)\n", i, width, height, at)
+	}
+	// The variables measuring the image, and a formula of X and Y, through
+	// other views.
+	viewOf := make([]drawer1.Viewport, len(fs))
+	for _, view := range views {
+		for _, each := range [][3]*image_formula_find.Function{parse(t, channels[7]), parse(t, channels[1])} {
+			i := len(fs)
+			src, err := GoWith("main", fmt.Sprintf("formula%d", i), view, each[0], each[1], each[2])
+			if err != nil {
+				t.Fatalf("%v: %v", each, err)
+			}
+			files[fmt.Sprintf("formula%d.go", i)] = string(src)
+			fmt.Fprintf(&main, "\tos.Stdout.Write(formula%dImage(%d, %d, %v).Pix)\n", i, width, height, at)
+			fs, viewOf = append(fs, each), append(viewOf, view)
+		}
+	}
+	main.WriteString("}\n")
+	files["main.go"] = main.String()
+
+	out := run(t, files, "go", "run", ".")
+	size := width * height * 4
+	if len(out) != len(fs)*size {
+		t.Fatalf("Got %d bytes, want %d", len(out), len(fs)*size)
+	}
+	for i, each := range fs {
+		want := renderWith(viewOf[i], each)
+		got := out[i*size : (i+1)*size]
+		for p := range want {
+			if got[p] != want[p] {
+				t.Fatalf("%v %v in %v: pixel (%d, %d) channel %d is %d, want %d\n%s",
+					each[0], each[1], viewOf[i], p/4%width, p/4/width, p%4, got[p], want[p], files[fmt.Sprintf("formula%d.go", i)])
+			}
+		}
+	}
+}
+
+// TestJavaScriptMatchesDrawer1 sticks to operations JavaScript rounds the
+// same way as Go, so the pixels must match exactly.
+func TestJavaScriptMatchesDrawer1(t *testing.T) {
+	fs := [][3]*image_formula_find.Function{
+		parse(t, [3]string{"y = x * x + 2", "y / 4 = x - 7 % y", "0 = -(x ^ 2) * 3 + t"}),
+		parse(t, [3]string{"0 = abs(x) * sqrt(y + 10) * 30", "0 = floor(x * y) + ceil(y) * trunc(x * 4.7)", "x = 255.5 - y * 1e9"}),
+		parse(t, [3]string{"0 = max(x, y) * 13 + min(x, y) * 7", "0 = round(x * 3.3) + roundtoeven(y * 2.5) + copysign(3, x)", "0 = dim(x, y) * 9 + x / 0"}),
+		parse(t, [3]string{"0 = u * 255 + v * 100", "0 = px * 3 + py", "0 = (x * x + y * y < 25) * 200"}),
+		parse(t, [3]string{"0 = (x < y) * 100 + (y >= 2) * 20 + (x == 0) * 70", "0 = if(x - y, 200, 30) + step(x, y) * 40 + clamp(x * 30, 0, 120)", "0 = lerp(x, y, 3) * 9 + smoothstep(-3, 4, x) * 200"}),
+	}
+	viewOf := make([]drawer1.Viewport, len(fs))
+	// Unturned, the views only take arithmetic JavaScript rounds as Go does.
+	for _, view := range views[:2] {
+		fs, viewOf = append(fs, fs[3]), append(viewOf, view)
+	}
+	var script strings.Builder
+	script.WriteString("const out = [];\n")
+	for i, each := range fs {
+		src, err := JavaScriptWith(fmt.Sprintf("formula%d", i), viewOf[i], each[0], each[1], each[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		script.WriteString(src)
+		fmt.Fprintf(&script, `formula%d({
+  createImageData: (w, h) => ({data: new Uint8ClampedArray(w * h * 4)}),
+  putImageData: (img) => out.push(...img.data),
+}, %d, %d, %v);
+`, i, width, height, at)
+	}
+	script.WriteString("process.stdout.write(Buffer.from(out));\n")
+
+	out := run(t, map[string]string{"main.js": script.String()}, "node", "main.js")
+	size := width * height * 4
+	for i, each := range fs {
+		if want := renderWith(viewOf[i], each); !bytes.Equal(out[i*size:(i+1)*size], want) {
+			t.Errorf("%v in %v: JavaScript pixels differ from drawer1", each, viewOf[i])
+		}
+	}
+}
+
+func TestGLSL(t *testing.T) {
+	fs := parse(t, channels[0])
+	src, err := GLSL(fs[0], fs[1], fs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#version 300 es\n",
+		"// R: y = x * x + 2\n",
+		"float r = ((2.0 + (x * x)) - y);\n",
+		"float g = ((x - goMod(7.0, y)) - (y / 4.0));\n",
+		"float b = ((t + (-goPow(x, 2.0))) - 0.0);\n",
+		"float goMod(float a, float b) {",
+		"float goPow(float a, float b) {",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("Expected %q in\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "goRound") {
+		t.Error("Unused helper included")
+	}
+
+	fs = parse(t, channels[7])
+	src, err = GLSLWith(views[0], fs[0], fs[1], fs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// The view, x=4,y=-2,scale=7,aspect=fit.\n",
+		"float s[4] = float[4](4.0, (-2.0), 7.0, 0.0);\n",
+		"float spanX = s[2] * resolution.x / min(resolution.x, resolution.y), spanY = s[2] * resolution.y / min(resolution.x, resolution.y);\n",
+		"((x - view[2]) / view[0])",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("Expected %q in\n%s", want, src)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	fs := parse(t, [3]string{"0 = gamma(x)", "0 = x", "0 = y"})
+	if _, err := GLSL(fs[0], fs[1], fs[2]); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("GLSL: expected ErrUnsupported, got %v", err)
+	}
+	if _, err := JavaScript("f", fs[0], fs[1], fs[2]); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("JavaScript: expected ErrUnsupported, got %v", err)
+	}
+	if _, err := Go("main", "f", fs[0], fs[1], fs[2]); err != nil {
+		t.Errorf("Go: %v", err)
+	}
+	if _, err := Go("main", "not valid", fs[0], fs[1], fs[2]); err == nil {
+		t.Error("Expected error for invalid name")
+	}
+	fs = parse(t, [3]string{"0 = scale * y", "0 = x", "0 = y"})
+	if _, err := Go("main", "f", fs[0], fs[1], fs[2]); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Go: expected ErrUnsupported for a parameter, got %v", err)
+	}
+}
+
+func TestGoCoversRegisteredFunctions(t *testing.T) {
+	for name := range image_formula_find.SingleFunctions {
+		if template, ok := goFunctions[name]; !ok || strings.Count(template, "%s") != 1 {
+			t.Errorf("No Go template for %s", name)
+		}
+	}
+	for name := range image_formula_find.DoubleFunctions {
+		if template, ok := goFunctions[name]; !ok || strings.Count(template, "%s") != 2 {
+			t.Errorf("No Go template for %s", name)
+		}
+	}
+	for name := range image_formula_find.TripleFunctions {
+		if template, ok := goFunctions[name]; !ok || strings.Count(template, "%s") != 3 {
+			t.Errorf("No Go template for %s", name)
+		}
+	}
+}
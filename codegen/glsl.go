@@ -0,0 +1,186 @@
+package codegen
+
+import (
+	"fmt"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// glslDialect targets GLSL ES 3.00. Functions GLSL leaves undefined for some
+// arguments, such as pow with a negative base, go through helpers.
+var glslDialect = &dialect{
+	name: "GLSL",
+	number: func(g *generator, v float64) string {
+		switch {
+		case math.IsNaN(v):
+			return "uintBitsToFloat(0x7fc00000u)"
+		case v > math.MaxFloat32:
+			return "uintBitsToFloat(0x7f800000u)"
+		case v < -math.MaxFloat32:
+			return "uintBitsToFloat(0xff800000u)"
+		}
+		s := strconv.FormatFloat(math.Abs(float64(float32(v))), 'g', -1, 32)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		if math.Signbit(v) {
+			return "(-" + s + ")"
+		}
+		return s
+	},
+	multiply:  "(%s * %s)",
+	power:     "goPow(%s, %s)",
+	modulus:   "goMod(%s, %s)",
+	condition: "float(%s)",
+	width:     "resolution.x",
+	height:    "resolution.y",
+	functions: map[string]string{
+		"ABS":         "abs(%s)",
+		"ACOS":        "acos(%s)",
+		"ACOSH":       "acosh(%s)",
+		"ASIN":        "asin(%s)",
+		"ASINH":       "asinh(%s)",
+		"ATAN":        "atan(%s)",
+		"ATAN2":       "atan(%s, %s)",
+		"ATANH":       "atanh(%s)",
+		"CBRT":        "goCbrt(%s)",
+		"CEIL":        "ceil(%s)",
+		"CLAMP":       "min(max(%s, %s), %s)",
+		"COPYSIGN":    "goCopysign(%s, %s)",
+		"COS":         "cos(%s)",
+		"COSH":        "cosh(%s)",
+		"DIM":         "max(%s - %s, 0.0)",
+		"EXP":         "exp(%s)",
+		"EXP2":        "exp2(%s)",
+		"EXPM1":       "(exp(%s) - 1.0)",
+		"FLOOR":       "floor(%s)",
+		"HYPOT":       "length(vec2(%s, %s))",
+		"IF":          "(%s != 0.0 ? %s : %s)",
+		"ILOGB":       "floor(log2(abs(%s)))",
+		"INF":         "(trunc(%s) >= 0.0 ? uintBitsToFloat(0x7f800000u) : uintBitsToFloat(0xff800000u))",
+		"LDEXP":       "(%s * exp2(trunc(%s)))",
+		"LERP":        "goLerp(%s, %s, %s)",
+		"LOG":         "log(%s)",
+		"LOG10":       "(log(%s) * 0.4342944819032518)",
+		"LOG1P":       "log(1.0 + %s)",
+		"LOG2":        "log2(%s)",
+		"LOGB":        "floor(log2(abs(%s)))",
+		"MAX":         "max(%s, %s)",
+		"MIN":         "min(%s, %s)",
+		"MOD":         "goMod(%s, %s)",
+		"POW":         "goPow(%s, %s)",
+		"POW10":       "pow(10.0, trunc(%s))",
+		"REMAINDER":   "goRemainder(%s, %s)",
+		"ROUND":       "goRound(%s)",
+		"ROUNDTOEVEN": "roundEven(%s)",
+		"SIN":         "sin(%s)",
+		"SINH":        "sinh(%s)",
+		"SMOOTHSTEP":  "goSmoothstep(%s, %s, %s)",
+		"SQRT":        "sqrt(%s)",
+		"STEP":        "step(%s, %s)",
+		"TAN":         "tan(%s)",
+		"TANH":        "tanh(%s)",
+		"TRUNC":       "trunc(%s)",
+	},
+	helpers: map[string]string{
+		"goCbrt": `float goCbrt(float a) {
+	return sign(a) * pow(abs(a), 1.0 / 3.0);
+}`,
+		"goCopysign": `float goCopysign(float a, float b) {
+	return (floatBitsToUint(b) & 0x80000000u) != 0u ? -abs(a) : abs(a);
+}`,
+		"goLerp": `float goLerp(float a, float b, float t) {
+	return a + (b - a) * t;
+}`,
+		"goMod": `float goMod(float a, float b) {
+	return a - b * trunc(a / b);
+}`,
+		"goPow": `float goPow(float a, float b) {
+	if (a >= 0.0 || b != floor(b)) {
+		return pow(a, b);
+	}
+	float p = pow(-a, b);
+	return mod(b, 2.0) == 0.0 ? p : -p;
+}`,
+		"goRound": `float goRound(float a) {
+	return sign(a) * floor(abs(a) + 0.5);
+}`,
+		"goRemainder": `float goRemainder(float a, float b) {
+	return a - b * roundEven(a / b);
+}`,
+		"goSmoothstep": `float goSmoothstep(float e0, float e1, float x) {
+	float t = min(max((x - e0) / (e1 - e0), 0.0), 1.0);
+	return t * t * (3.0 - 2.0 * t);
+}`,
+	},
+}
+
+// GLSL returns a GLSL ES 3.00 fragment shader drawing the formulas. It takes
+// the size of the canvas in pixels from the resolution uniform and the time
+// from t, and maps the canvas onto X and Y from -10 to 10 with the top row
+// at Y = -10, as drawer1 does.
+func GLSL(r, g, b *image_formula_find.Function) (string, error) {
+	return GLSLWith(drawer1.Viewport{}, r, g, b)
+}
+
+// GLSLWith is GLSL mapping the canvas onto X and Y through view, as drawer1
+// does.
+func GLSLWith(view drawer1.Viewport, r, g, b *image_formula_find.Function) (string, error) {
+	gen := newGenerator(glslDialect, view)
+	var channels [3]string
+	for i, f := range []*image_formula_find.Function{r, g, b} {
+		s, err := gen.channel(f)
+		if err != nil {
+			return "", err
+		}
+		channels[i] = s
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#version 300 es\n// Generated by image-formula-find codegen.\n//\n")
+	sb.WriteString(comment("// ", r, g, b))
+	sb.WriteString(`
+precision highp float;
+
+uniform vec2 resolution;
+uniform float t;
+out vec4 fragColor;
+
+`)
+	sb.WriteString(gen.helperSource())
+	sb.WriteString(`// channel converts a value to a byte the way Go does on amd64.
+float channel(float v) {
+	v = trunc(v);
+	if (isnan(v) || v < -2147483648.0 || v >= 2147483648.0) {
+		return 0.0;
+	}
+	return mod(v, 256.0);
+}
+
+void main() {
+	float px = floor(gl_FragCoord.x);
+	float py = resolution.y - 1.0 - floor(gl_FragCoord.y);
+`)
+	s := settings(view)
+	values := make([]string, len(s))
+	for i, each := range s {
+		values[i] = glslDialect.number(gen, each)
+	}
+	fmt.Fprintf(&sb, "\t// The view, %s.\n", viewComment(view))
+	fmt.Fprintf(&sb, "\tfloat s[4] = float[4](%s);\n", strings.Join(values, ", "))
+	spanX, spanY := spans(view.Aspect, "s[2]", "min", "max", "resolution.x", "resolution.y")
+	fmt.Fprintf(&sb, "\tfloat spanX = %s, spanY = %s;\n", spanX, spanY)
+	sb.WriteString(`	float view[6] = float[6](cos(s[3]) * spanX, -sin(s[3]) * spanY, 0.0, sin(s[3]) * spanX, cos(s[3]) * spanY, 0.0);
+	view[2] = s[0] - view[0] / 2.0 - view[1] / 2.0;
+	view[5] = s[1] - view[3] / 2.0 - view[4] / 2.0;
+	float u = px / resolution.x, v = py / resolution.y;
+	float x = view[0] * u + view[1] * v + view[2];
+	float y = view[3] * u + view[4] * v + view[5];
+`)
+	fmt.Fprintf(&sb, "\tfloat r = %s;\n\tfloat g = %s;\n\tfloat b = %s;\n", channels[0], channels[1], channels[2])
+	sb.WriteString("\tfragColor = vec4(channel(r), channel(g), channel(b), 255.0) / 255.0;\n}\n")
+	return sb.String(), nil
+}
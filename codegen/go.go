@@ -0,0 +1,258 @@
+package codegen
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// goDialect calls the math package functions the formulas are registered
+// with. Products are wrapped in conversions, which stops the compiler fusing
+// them into multiply-adds, and constants go through a variable so they are
+// not folded with arbitrary precision.
+var goDialect = &dialect{
+	name: "Go",
+	number: func(g *generator, v float64) string {
+		for i, c := range g.consts {
+			if math.Float64bits(c) == math.Float64bits(v) {
+				return fmt.Sprintf("k[%d]", i)
+			}
+		}
+		g.consts = append(g.consts, v)
+		return fmt.Sprintf("k[%d]", len(g.consts)-1)
+	},
+	multiply:  "float64(%s * %s)",
+	power:     "math.Pow(%s, %s)",
+	modulus:   "math.Mod(%s, %s)",
+	condition: "goBool(%s)",
+	width:     "float64(width)",
+	height:    "float64(height)",
+	functions: goFunctions,
+	// Helpers are closures declared at the top of the function, so several
+	// generated files can share a package. The noise functions are
+	// translated from their source.
+	helpers: withHelpers(map[string]string{
+		"goBool": `goBool := func(b bool) float64 {
+if b {
+return 1
+}
+return 0
+}`,
+		"goIf": `goIf := func(c, a, b float64) float64 {
+if c != 0 {
+return a
+}
+return b
+}`,
+		"goLerp": `goLerp := func(a, b, t float64) float64 {
+return a + float64((b-a)*t)
+}`,
+		"goSmoothstep": `goSmoothstep := func(e0, e1, x float64) float64 {
+t := math.Min(math.Max((x-e0)/(e1-e0), 0), 1)
+return float64(t*t) * (3 - float64(2*t))
+}`,
+		"goStep": `goStep := func(edge, x float64) float64 {
+if x < edge {
+return 0
+}
+return 1
+}`,
+	}, noiseHelpers()),
+}
+
+var goFunctions = map[string]string{
+	"ABS":         "math.Abs(%s)",
+	"ACOS":        "math.Acos(%s)",
+	"ACOSH":       "math.Acosh(%s)",
+	"ASIN":        "math.Asin(%s)",
+	"ASINH":       "math.Asinh(%s)",
+	"ATAN":        "math.Atan(%s)",
+	"ATAN2":       "math.Atan2(%s, %s)",
+	"ATANH":       "math.Atanh(%s)",
+	"CBRT":        "math.Cbrt(%s)",
+	"CEIL":        "math.Ceil(%s)",
+	"CLAMP":       "math.Min(math.Max(%s, %s), %s)",
+	"COPYSIGN":    "math.Copysign(%s, %s)",
+	"COS":         "math.Cos(%s)",
+	"COSH":        "math.Cosh(%s)",
+	"DIM":         "math.Dim(%s, %s)",
+	"ERF":         "math.Erf(%s)",
+	"ERFC":        "math.Erfc(%s)",
+	"ERFCINV":     "math.Erfcinv(%s)",
+	"ERFINV":      "math.Erfinv(%s)",
+	"EXP":         "math.Exp(%s)",
+	"EXP2":        "math.Exp2(%s)",
+	"EXPM1":       "math.Expm1(%s)",
+	"FBM":         "goFbm(%s, %s, %s)",
+	"FLOOR":       "math.Floor(%s)",
+	"GAMMA":       "math.Gamma(%s)",
+	"HYPOT":       "math.Hypot(%s, %s)",
+	"IF":          "goIf(%s, %s, %s)",
+	"ILOGB":       "float64(math.Ilogb(%s))",
+	"INF":         "math.Inf(int(%s))",
+	"J0":          "math.J0(%s)",
+	"J1":          "math.J1(%s)",
+	"JN":          "math.Jn(int(%s), %s)",
+	"LDEXP":       "math.Ldexp(%s, int(%s))",
+	"LERP":        "goLerp(%s, %s, %s)",
+	"LOG":         "math.Log(%s)",
+	"LOG10":       "math.Log10(%s)",
+	"LOG1P":       "math.Log1p(%s)",
+	"LOG2":        "math.Log2(%s)",
+	"LOGB":        "math.Logb(%s)",
+	"MAX":         "math.Max(%s, %s)",
+	"MIN":         "math.Min(%s, %s)",
+	"MOD":         "math.Mod(%s, %s)",
+	"NEXTAFTER":   "math.Nextafter(%s, %s)",
+	"PERLIN":      "goPerlin(%s, %s)",
+	"PERLIN3":     "goPerlin3(%s, %s, %s)",
+	"POW":         "math.Pow(%s, %s)",
+	"POW10":       "math.Pow10(int(%s))",
+	"REMAINDER":   "math.Remainder(%s, %s)",
+	"ROUND":       "math.Round(%s)",
+	"ROUNDTOEVEN": "math.RoundToEven(%s)",
+	"SIMPLEX":     "goSimplex(%s, %s)",
+	"SIMPLEX3":    "goSimplex3(%s, %s, %s)",
+	"SIN":         "math.Sin(%s)",
+	"SINH":        "math.Sinh(%s)",
+	"SMOOTHSTEP":  "goSmoothstep(%s, %s, %s)",
+	"SQRT":        "math.Sqrt(%s)",
+	"STEP":        "goStep(%s, %s)",
+	"TAN":         "math.Tan(%s)",
+	"TANH":        "math.Tanh(%s)",
+	"TRUNC":       "math.Trunc(%s)",
+	"TURBULENCE":  "goTurbulence(%s, %s, %s)",
+	"VALUENOISE":  "goValueNoise(%s, %s)",
+	"VALUENOISE3": "goValueNoise3(%s, %s, %s)",
+	"WORLEY":      "goWorley(%s, %s)",
+	"WORLEY3":     "goWorley3(%s, %s, %s)",
+	"Y0":          "math.Y0(%s)",
+	"Y1":          "math.Y1(%s)",
+	"YN":          "math.Yn(int(%s), %s)",
+}
+
+// Go returns a Go source file in package pkg declaring
+//
+//	func name(x, y, t float64, width, height int) color.RGBA
+//
+// which returns the colour for a point x, y of an image of the given size,
+//
+//	func nameImage(width, height int, t float64) *image.RGBA
+//
+// which renders an image the way drawer1 does, and
+//
+//	func nameView(width, height int) [6]float64
+//
+// which returns the view of an image, see GoWith. The result is identical to
+// drawer1 at the same T.
+func Go(pkg, name string, r, g, b *image_formula_find.Function) ([]byte, error) {
+	return GoWith(pkg, name, drawer1.Viewport{}, r, g, b)
+}
+
+// GoWith is Go drawing through view, X and Y from -10 to 10 for the zero
+// Viewport. nameView returns the XU, XV, X0, YU, YV and Y0 of the
+// drawer1.Transform of view for an image of the given size.
+func GoWith(pkg, name string, view drawer1.Viewport, r, g, b *image_formula_find.Function) ([]byte, error) {
+	if !token.IsIdentifier(pkg) || !token.IsIdentifier(name) {
+		return nil, fmt.Errorf("invalid package or function name: %q %q", pkg, name)
+	}
+	gen := newGenerator(goDialect, view)
+	var channels [3]string
+	for i, f := range []*image_formula_find.Function{r, g, b} {
+		s, err := gen.channel(f)
+		if err != nil {
+			return nil, err
+		}
+		channels[i] = s
+	}
+	helpers := gen.helperSource()
+	// The view always needs math, for Sincos.
+	source := channels[0] + channels[1] + channels[2] + helpers + "math."
+	consts := make([]string, len(gen.consts))
+	for i, c := range gen.consts {
+		consts[i] = goNumber(c)
+		source += consts[i]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("// Code generated by image-formula-find codegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&sb, "package %s\n\n", pkg)
+	sb.WriteString("import (\n\t\"image\"\n\t\"image/color\"\n")
+	if strings.Contains(source, "math.") {
+		sb.WriteString("\t\"math\"\n")
+	}
+	sb.WriteString(")\n\n")
+	fmt.Fprintf(&sb, "// %s returns the colour of the formulas at x, y and time t.\n//\n", name)
+	sb.WriteString(comment("//\t", r, g, b))
+	fmt.Fprintf(&sb, "func %s(x, y, t float64, width, height int) color.RGBA {\n", name)
+	if gen.view {
+		fmt.Fprintf(&sb, "view := %sView(width, height)\n", name)
+	}
+	if len(consts) > 0 {
+		fmt.Fprintf(&sb, "k := [...]float64{%s}\n", strings.Join(consts, ", "))
+	}
+	if gen.used["goNoiseHash"] {
+		fmt.Fprintf(&sb, "noiseSeed := uint64(%d)\n", image_formula_find.NoiseSeed)
+	}
+	sb.WriteString(helpers)
+	fmt.Fprintf(&sb, "r := %s\ng := %s\nb := %s\n", channels[0], channels[1], channels[2])
+	sb.WriteString("return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}\n}\n\n")
+	fmt.Fprintf(&sb, "// %sImage renders the formulas at time t, mapping the image onto x and y\n", name)
+	fmt.Fprintf(&sb, "// through its view, see %sView.\n", name)
+	fmt.Fprintf(&sb, "func %sImage(width, height int, t float64) *image.RGBA {\n", name)
+	sb.WriteString("img := image.NewRGBA(image.Rect(0, 0, width, height))\n")
+	fmt.Fprintf(&sb, "view := %sView(width, height)\n", name)
+	sb.WriteString("for py := 0; py < height; py++ {\n")
+	sb.WriteString("v := float64(py) / float64(height)\n")
+	sb.WriteString("for px := 0; px < width; px++ {\n")
+	sb.WriteString("u := float64(px) / float64(width)\n")
+	sb.WriteString("x := float64(view[0]*u) + float64(view[1]*v) + view[2]\n")
+	sb.WriteString("y := float64(view[3]*u) + float64(view[4]*v) + view[5]\n")
+	fmt.Fprintf(&sb, "img.SetRGBA(px, py, %s(x, y, t, width, height))\n", name)
+	sb.WriteString("}\n}\nreturn img\n}\n\n")
+	goView(&sb, name, view)
+	return format.Source([]byte(sb.String()))
+}
+
+// goView writes nameView, which returns the view of an image of the given
+// size as drawer1.Viewport.Transform works it out.
+func goView(sb *strings.Builder, name string, view drawer1.Viewport) {
+	s := settings(view)
+	values := make([]string, len(s))
+	for i, each := range s {
+		values[i] = goNumber(each)
+	}
+	fmt.Fprintf(sb, "// %sView returns the map from the pixels of an image of the given size to x\n", name)
+	sb.WriteString("// and y, XU, XV, X0, YU, YV and Y0 of drawer1.Transform, for the view\n//\n")
+	fmt.Fprintf(sb, "//\t%s\n", viewComment(view))
+	fmt.Fprintf(sb, "func %sView(width, height int) [6]float64 {\n", name)
+	fmt.Fprintf(sb, "s := [...]float64{%s}\n", strings.Join(values, ", "))
+	if view.Aspect == drawer1.Fit || view.Aspect == drawer1.Fill {
+		sb.WriteString("w, h := float64(width), float64(height)\n")
+	}
+	spanX, spanY := spans(view.Aspect, "s[2]", "min", "max", "w", "h")
+	fmt.Fprintf(sb, "spanX, spanY := %s, %s\n", spanX, spanY)
+	sb.WriteString("sin, cos := math.Sincos(s[3])\n")
+	sb.WriteString("xu, xv := cos*spanX, -sin*spanY\n")
+	sb.WriteString("yu, yv := sin*spanX, cos*spanY\n")
+	sb.WriteString("return [6]float64{xu, xv, s[0] - xu/2 - xv/2, yu, yv, s[1] - yu/2 - yv/2}\n}\n")
+}
+
+// goNumber writes a constant so that it keeps its exact value, sign of zero
+// included.
+func goNumber(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "math.NaN()"
+	case math.IsInf(v, 0):
+		return fmt.Sprintf("math.Inf(%d)", int(math.Copysign(1, v)))
+	case v == 0 && math.Signbit(v):
+		return "math.Copysign(0, -1)"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
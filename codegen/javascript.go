@@ -0,0 +1,215 @@
+package codegen
+
+import (
+	"fmt"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsDialect uses Math where it matches the Go function and small helpers
+// where the special cases differ.
+var jsDialect = &dialect{
+	name: "JavaScript",
+	number: func(g *generator, v float64) string {
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "(-Infinity)"
+		case math.Signbit(v):
+			return "(-" + strconv.FormatFloat(-v, 'g', -1, 64) + ")"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	},
+	multiply:  "(%s * %s)",
+	power:     "goPow(%s, %s)",
+	modulus:   "(%s %% %s)",
+	condition: "(%s ? 1 : 0)",
+	width:     "width",
+	height:    "height",
+	functions: map[string]string{
+		"ABS":         "Math.abs(%s)",
+		"ACOS":        "Math.acos(%s)",
+		"ACOSH":       "Math.acosh(%s)",
+		"ASIN":        "Math.asin(%s)",
+		"ASINH":       "Math.asinh(%s)",
+		"ATAN":        "Math.atan(%s)",
+		"ATAN2":       "Math.atan2(%s, %s)",
+		"ATANH":       "Math.atanh(%s)",
+		"CBRT":        "Math.cbrt(%s)",
+		"CEIL":        "Math.ceil(%s)",
+		"CLAMP":       "goMin(goMax(%s, %s), %s)",
+		"COPYSIGN":    "goCopysign(%s, %s)",
+		"COS":         "Math.cos(%s)",
+		"COSH":        "Math.cosh(%s)",
+		"DIM":         "goDim(%s, %s)",
+		"EXP":         "Math.exp(%s)",
+		"EXP2":        "Math.pow(2, %s)",
+		"EXPM1":       "Math.expm1(%s)",
+		"FLOOR":       "Math.floor(%s)",
+		"HYPOT":       "Math.hypot(%s, %s)",
+		"IF":          "(%s !== 0 ? %s : %s)",
+		"ILOGB":       "goIlogb(%s)",
+		"INF":         "(Math.trunc(%s) >= 0 ? Infinity : -Infinity)",
+		"LDEXP":       "goLdexp(%s, %s)",
+		"LERP":        "goLerp(%s, %s, %s)",
+		"LOG":         "Math.log(%s)",
+		"LOG10":       "Math.log10(%s)",
+		"LOG1P":       "Math.log1p(%s)",
+		"LOG2":        "Math.log2(%s)",
+		"LOGB":        "goLogb(%s)",
+		"MAX":         "goMax(%s, %s)",
+		"MIN":         "goMin(%s, %s)",
+		"MOD":         "(%s %% %s)",
+		"POW":         "goPow(%s, %s)",
+		"POW10":       "Math.pow(10, Math.trunc(%s))",
+		"REMAINDER":   "goRemainder(%s, %s)",
+		"ROUND":       "goRound(%s)",
+		"ROUNDTOEVEN": "goRoundToEven(%s)",
+		"SIN":         "Math.sin(%s)",
+		"SINH":        "Math.sinh(%s)",
+		"SMOOTHSTEP":  "goSmoothstep(%s, %s, %s)",
+		"SQRT":        "Math.sqrt(%s)",
+		"STEP":        "goStep(%s, %s)",
+		"TAN":         "Math.tan(%s)",
+		"TANH":        "Math.tanh(%s)",
+		"TRUNC":       "Math.trunc(%s)",
+	},
+	helpers: map[string]string{
+		"goCopysign": `function goCopysign(a, b) {
+  return (b < 0 || Object.is(b, -0)) !== (a < 0 || Object.is(a, -0)) ? -a : a;
+}`,
+		"goDim": `function goDim(a, b) {
+  const v = a - b;
+  return v <= 0 ? 0 : v;
+}`,
+		"goIlogb": `function goIlogb(a) {
+  if (a === 0) return -2147483648;
+  if (!isFinite(a)) return 2147483647;
+  return goLogb(a);
+}`,
+		"goLdexp": `function goLdexp(a, b) {
+  return a * Math.pow(2, Math.trunc(b));
+}`,
+		"goLerp": `function goLerp(a, b, t) {
+  return a + (b - a) * t;
+}`,
+		"goLogb": `function goLogb(a) {
+  if (a === 0) return -Infinity;
+  return Math.floor(Math.log2(Math.abs(a)));
+}`,
+		"goMax": `function goMax(a, b) {
+  if (a === Infinity || b === Infinity) return Infinity;
+  return Math.max(a, b);
+}`,
+		"goMin": `function goMin(a, b) {
+  if (a === -Infinity || b === -Infinity) return -Infinity;
+  return Math.min(a, b);
+}`,
+		"goPow": `function goPow(a, b) {
+  if (a === 1 || (a === -1 && (b === Infinity || b === -Infinity))) return 1;
+  return Math.pow(a, b);
+}`,
+		"goRemainder": `function goRemainder(a, b) {
+  if (b === Infinity || b === -Infinity) return isFinite(a) ? a : NaN;
+  return a - b * goRoundToEven(a / b);
+}`,
+		"goRound": `function goRound(a) {
+  return Math.sign(a) * Math.round(Math.abs(a));
+}`,
+		"goRoundToEven": `function goRoundToEven(a) {
+  const r = Math.round(a);
+  return r - a === 0.5 && r % 2 !== 0 ? r - 1 : r;
+}`,
+		"goSmoothstep": `function goSmoothstep(e0, e1, x) {
+  const t = goMin(goMax((x - e0) / (e1 - e0), 0), 1);
+  return t * t * (3 - 2 * t);
+}`,
+		"goStep": `function goStep(edge, x) {
+  return x < edge ? 0 : 1;
+}`,
+	},
+}
+
+var jsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// JavaScript returns a function
+//
+//	name(ctx, width, height, t)
+//
+// which draws the formulas at time t into a canvas 2D context, mapping the
+// canvas onto X and Y from -10 to 10 like drawer1.
+func JavaScript(name string, r, g, b *image_formula_find.Function) (string, error) {
+	return JavaScriptWith(name, drawer1.Viewport{}, r, g, b)
+}
+
+// JavaScriptWith is JavaScript mapping the canvas onto X and Y through view,
+// like drawer1.
+func JavaScriptWith(name string, view drawer1.Viewport, r, g, b *image_formula_find.Function) (string, error) {
+	if !jsIdentifier.MatchString(name) {
+		return "", fmt.Errorf("invalid function name: %q", name)
+	}
+	gen := newGenerator(jsDialect, view)
+	var channels [3]string
+	for i, f := range []*image_formula_find.Function{r, g, b} {
+		s, err := gen.channel(f)
+		if err != nil {
+			return "", err
+		}
+		channels[i] = s
+	}
+
+	var sb strings.Builder
+	sb.WriteString("// Generated by image-formula-find codegen.\n//\n")
+	sb.WriteString(comment("// ", r, g, b))
+	fmt.Fprintf(&sb, "function %s(ctx, width, height, t) {\n", name)
+	for _, line := range strings.SplitAfter(gen.helperSource(), "\n") {
+		if line != "" && line != "\n" {
+			sb.WriteString("  " + line)
+		}
+	}
+	sb.WriteString(`  // channel converts a value to a byte the way Go does on amd64.
+  function channel(v) {
+    v = Math.trunc(v);
+    if (!(v >= -2147483648 && v < 2147483648)) return 0;
+    return ((v % 256) + 256) % 256;
+  }
+`)
+	s := settings(view)
+	values := make([]string, len(s))
+	for i, each := range s {
+		values[i] = jsDialect.number(gen, each)
+	}
+	fmt.Fprintf(&sb, "  // The view, %s.\n", viewComment(view))
+	fmt.Fprintf(&sb, "  const s = [%s];\n", strings.Join(values, ", "))
+	spanX, spanY := spans(view.Aspect, "s[2]", "Math.min", "Math.max", "width", "height")
+	fmt.Fprintf(&sb, "  const spanX = %s, spanY = %s;\n", spanX, spanY)
+	sb.WriteString(`  const view = [Math.cos(s[3]) * spanX, -Math.sin(s[3]) * spanY, 0, Math.sin(s[3]) * spanX, Math.cos(s[3]) * spanY, 0];
+  view[2] = s[0] - view[0] / 2 - view[1] / 2;
+  view[5] = s[1] - view[3] / 2 - view[4] / 2;
+  const image = ctx.createImageData(width, height);
+  for (let py = 0; py < height; py++) {
+    const v = py / height;
+    for (let px = 0; px < width; px++) {
+      const u = px / width;
+      const x = view[0] * u + view[1] * v + view[2];
+      const y = view[3] * u + view[4] * v + view[5];
+      const i = 4 * (py * width + px);
+`)
+	for i, c := range channels {
+		fmt.Fprintf(&sb, "      image.data[i + %d] = channel(%s);\n", i, c)
+	}
+	sb.WriteString(`      image.data[i + 3] = 255;
+    }
+  }
+  ctx.putImageData(image, 0, 0);
+}
+`)
+	return sb.String(), nil
+}
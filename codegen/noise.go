@@ -0,0 +1,101 @@
+package codegen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"image-formula-find"
+	"maps"
+	"unicode"
+	"unicode/utf8"
+)
+
+// noiseHelpers translates the noise functions, image_formula_find.NoiseSource,
+// into Go helpers, so generated code computes noise as drawer1 does without a
+// copy to keep in step. Every function, constant and variable the source
+// declares becomes a helper named after it with a "go" prefix, perlinLayer
+// becoming goPerlinLayer, and NoiseSeed becomes the noiseSeed Go declares.
+func noiseHelpers() map[string]string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "noise.go", image_formula_find.NoiseSource, parser.SkipObjectResolution)
+	if err != nil {
+		panic("codegen: parsing the noise source: " + err.Error())
+	}
+	names := map[string]string{"NoiseSeed": "noiseSeed"}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			names[d.Name.Name] = goHelperName(d.Name.Name)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if v, ok := spec.(*ast.ValueSpec); ok {
+					for _, name := range v.Names {
+						names[name.Name] = goHelperName(name.Name)
+					}
+				}
+			}
+		}
+	}
+	// Locals named after a declaration are renamed with it, which keeps the
+	// code meaning the same.
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			ast.Inspect(sel.X, func(n ast.Node) bool { return rename(n, names) })
+			return false
+		}
+		return rename(n, names)
+	})
+
+	helpers := map[string]string{}
+	print := func(name, prefix string, node ast.Node) {
+		var buf bytes.Buffer
+		buf.WriteString(prefix)
+		if err := format.Node(&buf, fset, node); err != nil {
+			panic("codegen: printing the noise source: " + err.Error())
+		}
+		helpers[name] = buf.String()
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			print(d.Name.Name, d.Name.Name+" := ", &ast.FuncLit{Type: d.Type, Body: d.Body})
+		case *ast.GenDecl:
+			if d.Tok != token.CONST && d.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range d.Specs {
+				v := spec.(*ast.ValueSpec)
+				for _, name := range v.Names {
+					print(name.Name, "", &ast.GenDecl{Tok: d.Tok, Specs: []ast.Spec{v}})
+				}
+			}
+		}
+	}
+	return helpers
+}
+
+// rename gives an identifier its name in generated code.
+func rename(n ast.Node, names map[string]string) bool {
+	if id, ok := n.(*ast.Ident); ok {
+		if name, ok := names[id.Name]; ok {
+			id.Name = name
+		}
+	}
+	return true
+}
+
+// goHelperName is the name of the helper for a declaration of the noise
+// source.
+func goHelperName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return "go" + string(unicode.ToUpper(r)) + name[size:]
+}
+
+// withHelpers returns the helpers of both maps.
+func withHelpers(a, b map[string]string) map[string]string {
+	result := maps.Clone(a)
+	maps.Copy(result, b)
+	return result
+}
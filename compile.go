@@ -0,0 +1,493 @@
+package image_formula_find
+
+import (
+	"maps"
+	"math"
+	"strings"
+)
+
+// Op is a single bytecode operation of a compiled Program.
+type Op uint8
+
+const (
+	OpConst Op = iota
+	OpX
+	OpY
+	OpT
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpPow
+	OpMod
+	OpNeg
+	OpCall1
+	OpCall2
+	OpCall3
+	// OpEval falls back to the tree walker for Expression implementations the
+	// compiler does not know how to lower.
+	OpEval
+	// OpStore copies the top of the stack into register Slot, leaving it on
+	// the stack, and OpLoad pushes register Slot. They let a subtree that
+	// appears more than once in a formula be evaluated once.
+	OpStore
+	OpLoad
+)
+
+// Instruction is one step of a Program. Binary operations pop b then a and
+// push a op b, OpCall3 pops c, b then a and pushes Fn3(a, b, c).
+type Instruction struct {
+	Op    Op
+	Value float64
+	Slot  int
+	Fn1   SingleFunctionDef
+	Fn2   DoubleFunctionDef
+	Fn3   TripleFunctionDef
+	Expr  Expression
+}
+
+// Program is a Function lowered into a flat stack machine. Function lookups
+// are resolved at compile time, constant subtrees are folded and repeated
+// subtrees are evaluated once and kept in registers, so evaluating a Program
+// does no allocation, map lookups or interface calls. A Program is immutable
+// and safe for concurrent use.
+type Program struct {
+	Code      []Instruction
+	MaxStack  int
+	Registers int
+	UsesT     bool
+	// Policy is the numeric policy the program was compiled with.
+	Policy Policy
+	// env is the image size, view and parameters the program was compiled
+	// with, which the OpEval fallbacks are evaluated with.
+	env State
+}
+
+// Compile lowers the formula into a Program that evaluates to the same value
+// as Function.Evaluate. A nil formula compiles to a Program that returns 0.
+func Compile(f *Function) Program {
+	return CompileWith(f, nil)
+}
+
+// CompileWith is Compile for evaluating with the image size, view, parameters
+// and numeric policy of env, which are bound into the Program. X, Y and T of env
+// are ignored.
+func CompileWith(f *Function, env *State) Program {
+	if env == nil {
+		env = &State{}
+	}
+	c := &compiler{env: env}
+	if f != nil && f.Equals != nil {
+		d := newDAG()
+		root, _ := d.intern(f.Equals)
+		d.count(root)
+		c.refs = d.refs
+		c.saved = map[Expression]Instruction{}
+		c.expr(root)
+	}
+	return Program{
+		Code:      c.code,
+		MaxStack:  c.maxDepth,
+		Registers: c.registers,
+		UsesT:     c.usesT,
+		Policy:    env.Policy,
+		env: State{
+			Width:  env.Width,
+			Height: env.Height,
+			Frame:  env.Frame,
+			Params: maps.Clone(env.Params),
+			Policy: env.Policy,
+		},
+	}
+}
+
+type compiler struct {
+	env       *State
+	code      []Instruction
+	depth     int
+	maxDepth  int
+	usesT     bool
+	registers int
+	// refs counts the parents of each node of the DAG being compiled, and
+	// saved holds the instruction that pushes the value of a shared node
+	// once it has been compiled.
+	refs  map[Expression]int
+	saved map[Expression]Instruction
+}
+
+type compilable interface {
+	compile(c *compiler)
+}
+
+func (c *compiler) expr(e Expression) {
+	if !c.shared(e) {
+		c.lower(e)
+		return
+	}
+	if in, ok := c.saved[e]; ok {
+		c.push(in)
+		return
+	}
+	start := len(c.code)
+	c.lower(e)
+	if len(c.code) == start+1 && c.code[start].Op == OpConst {
+		// Folded to a constant, which is as cheap to push as a register.
+		c.saved[e] = c.code[start]
+		return
+	}
+	c.code = append(c.code, Instruction{Op: OpStore, Slot: c.registers})
+	c.saved[e] = Instruction{Op: OpLoad, Slot: c.registers}
+	c.registers++
+}
+
+// shared reports whether e is a node of the DAG with more than one parent
+// that is worth keeping in a register.
+func (c *compiler) shared(e Expression) bool {
+	switch e.(type) {
+	case *Var, *Const:
+		return false
+	}
+	return interned(e) && c.refs[e] > 1
+}
+
+func (c *compiler) lower(e Expression) {
+	if e, ok := e.(compilable); ok {
+		e.compile(c)
+		return
+	}
+	c.push(Instruction{Op: OpEval, Expr: e})
+}
+
+func (c *compiler) push(in Instruction) {
+	c.code = append(c.code, in)
+	c.depth++
+	if c.depth > c.maxDepth {
+		c.maxDepth = c.depth
+	}
+}
+
+// constTail reports whether the last n instructions are all constants, which
+// means the operation about to be emitted can be folded.
+func (c *compiler) constTail(n int) bool {
+	if len(c.code) < n {
+		return false
+	}
+	for _, in := range c.code[len(c.code)-n:] {
+		if in.Op != OpConst {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compiler) unary(in Instruction) {
+	if c.constTail(1) {
+		last := &c.code[len(c.code)-1]
+		last.Value = apply1(in, last.Value)
+		return
+	}
+	c.code = append(c.code, in)
+}
+
+func (c *compiler) binary(in Instruction) {
+	if c.constTail(2) {
+		a, b := c.code[len(c.code)-2].Value, c.code[len(c.code)-1].Value
+		c.code = c.code[:len(c.code)-1]
+		c.code[len(c.code)-1].Value = apply2(in, a, b)
+		c.depth--
+		return
+	}
+	c.code = append(c.code, in)
+	c.depth--
+}
+
+func (c *compiler) ternary(in Instruction) {
+	if c.constTail(3) {
+		a, b, v := c.code[len(c.code)-3].Value, c.code[len(c.code)-2].Value, c.code[len(c.code)-1].Value
+		c.code = c.code[:len(c.code)-2]
+		c.code[len(c.code)-1].Value = in.Fn3(a, b, v)
+		c.depth -= 2
+		return
+	}
+	c.code = append(c.code, in)
+	c.depth -= 2
+}
+
+func apply1(in Instruction, a float64) float64 {
+	switch in.Op {
+	case OpNeg:
+		return -a
+	case OpCall1:
+		return in.Fn1(a)
+	}
+	return a
+}
+
+func apply2(in Instruction, a, b float64) float64 {
+	switch in.Op {
+	case OpAdd:
+		return a + b
+	case OpSub:
+		return a - b
+	case OpMul:
+		return a * b
+	case OpDiv:
+		return a / b
+	case OpPow:
+		return math.Pow(a, b)
+	case OpMod:
+		return math.Mod(a, b)
+	case OpCall2:
+		return in.Fn2(a, b)
+	}
+	return a
+}
+
+// Evaluate runs the program for a single point.
+func (p *Program) Evaluate(X, Y, T float64) float64 {
+	if len(p.Code) == 0 {
+		return 0
+	}
+	var buf [32]float64
+	stack := buf[:]
+	if p.MaxStack > len(buf) {
+		stack = make([]float64, p.MaxStack)
+	}
+	var regBuf [16]float64
+	registers := regBuf[:]
+	if p.Registers > len(regBuf) {
+		registers = make([]float64, p.Registers)
+	}
+	// state is taken from statePool by the first OpEval and shared by the
+	// rest.
+	var state *State
+	sp := 0
+	for i := range p.Code {
+		in := &p.Code[i]
+		switch in.Op {
+		case OpConst:
+			stack[sp] = in.Value
+			sp++
+		case OpX:
+			stack[sp] = X
+			sp++
+		case OpY:
+			stack[sp] = Y
+			sp++
+		case OpT:
+			stack[sp] = T
+			sp++
+		case OpAdd:
+			sp--
+			stack[sp-1] += stack[sp]
+		case OpSub:
+			sp--
+			stack[sp-1] -= stack[sp]
+		case OpMul:
+			sp--
+			stack[sp-1] *= stack[sp]
+		case OpDiv:
+			sp--
+			stack[sp-1] /= stack[sp]
+		case OpPow:
+			sp--
+			stack[sp-1] = math.Pow(stack[sp-1], stack[sp])
+		case OpMod:
+			sp--
+			stack[sp-1] = math.Mod(stack[sp-1], stack[sp])
+		case OpNeg:
+			stack[sp-1] = -stack[sp-1]
+		case OpCall1:
+			stack[sp-1] = in.Fn1(stack[sp-1])
+		case OpCall2:
+			sp--
+			stack[sp-1] = in.Fn2(stack[sp-1], stack[sp])
+		case OpCall3:
+			sp -= 2
+			stack[sp-1] = in.Fn3(stack[sp-1], stack[sp], stack[sp+1])
+		case OpEval:
+			if state == nil {
+				state = p.state(X, Y, T)
+			}
+			stack[sp] = in.Expr.Evaluate(state)
+			sp++
+		case OpStore:
+			registers[in.Slot] = stack[sp-1]
+		case OpLoad:
+			stack[sp] = registers[in.Slot]
+			sp++
+		}
+	}
+	if state != nil {
+		statePool.Put(state)
+	}
+	return protectResult(p.Policy, stack[0])
+}
+
+// state returns a State from statePool holding the environment the program
+// was compiled with at (X, Y, T), for the tree walker to evaluate the OpEval
+// fallbacks with. The caller puts it back in the pool.
+func (p *Program) state(X, Y, T float64) *State {
+	state := statePool.Get().(*State)
+	*state = p.env
+	state.X, state.Y, state.T = X, Y, T
+	return state
+}
+
+// The compile methods mirror the Evaluate methods in math.go, including their
+// operand order, so compiled and interpreted results are bit for bit equal.
+
+func (v Equals) compile(c *compiler) {
+	c.expr(v.RHS)
+	if v.LHS == nil {
+		return
+	}
+	c.expr(v.LHS)
+	c.binary(Instruction{Op: OpSub})
+}
+
+func (v Var) compile(c *compiler) {
+	switch name := strings.ToUpper(v.Var); name {
+	case "X":
+		c.push(Instruction{Op: OpX})
+	case "Y":
+		c.push(Instruction{Op: OpY})
+	case "T":
+		c.usesT = true
+		c.push(Instruction{Op: OpT})
+	default:
+		if d := derivation(name, c.env.Width, c.env.Height, c.env.Frame); d != nil {
+			c.expr(d)
+			return
+		}
+		c.push(Instruction{Op: OpConst, Value: parameter(c.env.Params, name)})
+	}
+}
+
+func (v Const) compile(c *compiler) {
+	c.push(Instruction{Op: OpConst, Value: v.Value})
+}
+
+func (v Plus) compile(c *compiler) {
+	c.expr(v.RHS)
+	c.expr(v.LHS)
+	c.binary(Instruction{Op: OpAdd})
+}
+
+func (v Subtract) compile(c *compiler) {
+	c.expr(v.RHS)
+	c.expr(v.LHS)
+	c.binary(Instruction{Op: OpSub})
+}
+
+func (v Multiply) compile(c *compiler) {
+	c.expr(v.RHS)
+	c.expr(v.LHS)
+	c.binary(Instruction{Op: OpMul})
+}
+
+func (v Divide) compile(c *compiler) {
+	c.expr(v.RHS)
+	c.expr(v.LHS)
+	if c.env.Policy == Protected {
+		c.binary(Instruction{Op: OpCall2, Fn2: protectedDivide})
+		return
+	}
+	c.binary(Instruction{Op: OpDiv})
+}
+
+func (v Power) compile(c *compiler) {
+	c.expr(v.LHS)
+	c.expr(v.RHS)
+	if c.env.Policy == Protected {
+		c.binary(Instruction{Op: OpCall2, Fn2: protected2(math.Pow)})
+		return
+	}
+	c.binary(Instruction{Op: OpPow})
+}
+
+func (v Modulus) compile(c *compiler) {
+	c.expr(v.LHS)
+	c.expr(v.RHS)
+	if c.env.Policy == Protected {
+		c.binary(Instruction{Op: OpCall2, Fn2: protected2(math.Mod)})
+		return
+	}
+	c.binary(Instruction{Op: OpMod})
+}
+
+func (v Negate) compile(c *compiler) {
+	c.expr(v.Expr)
+	c.unary(Instruction{Op: OpNeg})
+}
+
+func (v Brackets) compile(c *compiler) {
+	c.expr(v.Expr)
+}
+
+func (v SingleFunction) compile(c *compiler) {
+	c.expr(v.Expr)
+	f := v.Fn
+	if f == nil {
+		f = SingleFunctions[strings.ToUpper(v.Name)]
+	}
+	if f == nil {
+		return
+	}
+	if c.env.Policy == Protected {
+		f = protected1(f)
+	}
+	c.unary(Instruction{Op: OpCall1, Fn1: f})
+}
+
+func first(a, b float64) float64 {
+	return a
+}
+
+func first3(a, b, c float64) float64 {
+	return a
+}
+
+func (v DoubleFunction) compile(c *compiler) {
+	f := v.Fn
+	if f == nil {
+		f = DoubleFunctions[strings.ToUpper(v.Name)]
+	}
+	if f == nil {
+		// Unknown functions evaluate to their first argument.
+		f = first
+	} else if c.env.Policy == Protected {
+		f = protected2(f)
+	}
+	c.expr(v.Expr1)
+	c.expr(v.Expr2)
+	c.binary(Instruction{Op: OpCall2, Fn2: f})
+}
+
+func (v TripleFunction) compile(c *compiler) {
+	f := v.Fn
+	if f == nil {
+		f = TripleFunctions[strings.ToUpper(v.Name)]
+	}
+	if f == nil {
+		// Unknown functions evaluate to their first argument.
+		f = first3
+	} else if c.env.Policy == Protected {
+		f = protected3(f)
+	}
+	c.expr(v.Expr1)
+	c.expr(v.Expr2)
+	c.expr(v.Expr3)
+	c.ternary(Instruction{Op: OpCall3, Fn3: f})
+}
+
+func (v Compare) compile(c *compiler) {
+	f := comparison(v.Op)
+	if f == nil {
+		c.push(Instruction{Op: OpConst})
+		return
+	}
+	c.expr(v.LHS)
+	c.expr(v.RHS)
+	c.binary(Instruction{Op: OpCall2, Fn2: f})
+}
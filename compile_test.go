@@ -0,0 +1,103 @@
+package image_formula_find
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// opaqueExpression hides the concrete node so the compiler cannot lower it.
+type opaqueExpression struct {
+	Expression
+}
+
+func TestCompileMatchesEvaluate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	points := [][3]float64{{0, 0, 0}, {1.5, -2, 1}, {-10, 10, 3}, {7.25, 0.5, -2}, {2, -3, 0.375}}
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 6)
+		p := Compile(f)
+		for _, pt := range points {
+			want, _, err := f.Evaluate(pt[0], pt[1], pt[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Evaluate(pt[0], pt[1], pt[2]); !sameFloat(got, want) {
+				t.Fatalf("%s at %v: compiled %v, evaluated %v", f, pt, got, want)
+			}
+		}
+		if p.UsesT != f.HasVar("T") {
+			t.Fatalf("%s: UsesT = %v", f, p.UsesT)
+		}
+	}
+}
+
+func TestCompileFoldsConstants(t *testing.T) {
+	f, err := ParseFunction("y = sin(2 * 3) + x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Compile(f)
+	if len(p.Code) != 5 {
+		t.Errorf("expected 5 instructions, got %d: %+v", len(p.Code), p.Code)
+	}
+}
+
+func TestCompileFallback(t *testing.T) {
+	f := &Function{Equals: &Equals{RHS: &Plus{LHS: &Var{Var: "X"}, RHS: opaqueExpression{&Const{Value: 2}}}}}
+	p := Compile(f)
+	if got := p.Evaluate(3, 0, 0); got != 5 {
+		t.Errorf("expected 5, got %v", got)
+	}
+	var empty Program
+	if got := empty.Evaluate(3, 0, 0); got != 0 {
+		t.Errorf("expected 0 from empty program, got %v", got)
+	}
+}
+
+func TestCompileFallbackMatchesEvaluate(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	env := &State{
+		Width:  40,
+		Height: 30,
+		Frame:  Frame{XU: 8, XV: 3, X0: -5, YU: -2, YV: 12, Y0: -4},
+		Params: map[string]float64{"SCALE": 2.5},
+		Policy: Protected,
+	}
+	points := [][3]float64{{0, 0, 0}, {1.5, -2, 1}, {-10, 10, 3}, {9.75, 2.5, -2}}
+	for i := 0; i < 500; i++ {
+		f := randomFunction(r, 5)
+		// Hiding the sides from the compiler leaves them to the tree walker.
+		hidden := &Function{Equals: &Equals{RHS: opaqueExpression{f.Equals.RHS}}}
+		if f.Equals.LHS != nil {
+			hidden.Equals.LHS = opaqueExpression{f.Equals.LHS}
+		}
+		p := CompileWith(hidden, env)
+		for _, pt := range points {
+			state := *env
+			state.X, state.Y, state.T = pt[0], pt[1], pt[2]
+			want := f.Equals.Evaluate(&state)
+			if got := p.Evaluate(pt[0], pt[1], pt[2]); !sameFloat(got, want) {
+				t.Fatalf("%s at %v: compiled %v, evaluated %v", f, pt, got, want)
+			}
+		}
+	}
+	p := CompileWith(&Function{Equals: &Equals{RHS: opaqueExpression{&Var{Var: "PX"}}}}, env)
+	if allocs := testing.AllocsPerRun(100, func() { p.Evaluate(1, 2, 0) }); allocs != 0 {
+		t.Errorf("Evaluating a fallback allocated %v times", allocs)
+	}
+}
+
+func BenchmarkProgramEvaluate(b *testing.B) {
+	f, err := ParseFunction("y / 4 = x * x + 2")
+	if err != nil {
+		b.Fatalf("Failed to parse function: %v", err)
+	}
+	p := Compile(f)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = p.Evaluate(float64(i), float64(i), float64(i))
+	}
+}
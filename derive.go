@@ -0,0 +1,414 @@
+package image_formula_find
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrNoDerivative is returned by Derive when the expression contains a
+// function or variable with no known derivative.
+var ErrNoDerivative = errors.New("no known derivative")
+
+// Derivatives follow the evaluation order of each node, so for example
+// Subtract differentiates RHS - LHS. Functions that are piecewise constant,
+// such as Floor, have a zero derivative and the kinks of Abs, Max, Min and
+// friends take the derivative of the side chosen by Copysign.
+
+// The helpers below build nodes in the order they evaluate in.
+
+func num(v float64) Expression {
+	return &Const{Value: v}
+}
+
+func sum(a, b Expression) Expression {
+	return &Plus{LHS: a, RHS: b}
+}
+
+func minus(a, b Expression) Expression {
+	return &Subtract{LHS: bracket(b), RHS: bracket(a)}
+}
+
+func times(a, b Expression) Expression {
+	return &Multiply{LHS: bracket(a), RHS: bracket(b)}
+}
+
+func over(a, b Expression) Expression {
+	return &Divide{LHS: bracket(b), RHS: bracket(a)}
+}
+
+func raise(a, b Expression) Expression {
+	return &Power{LHS: bracket(a), RHS: bracket(b)}
+}
+
+func call(name string, args ...Expression) Expression {
+	switch len(args) {
+	case 1:
+		return NewSingleFunction(name, args[0])
+	case 2:
+		return NewDoubleFunction(name, args[0], args[1], false)
+	}
+	return NewTripleFunction(name, args[0], args[1], args[2])
+}
+
+// bracket wraps compound expressions so the derivative prints unambiguously.
+func bracket(e Expression) Expression {
+	switch e.(type) {
+	case *Const, *Var, *Brackets, *SingleFunction, *DoubleFunction, *TripleFunction:
+		return e
+	}
+	return &Brackets{Expr: e}
+}
+
+// step is 1 when u is positive or +0 and 0 when it is negative or -0.
+func step(u Expression) Expression {
+	return call("Max", call("Copysign", num(1), u), num(0))
+}
+
+// singleDerivatives hold f'(u) for the built in single argument functions.
+var singleDerivatives = map[string]func(u Expression) Expression{
+	"ABS": func(u Expression) Expression { return call("Copysign", num(1), u) },
+	"ACOS": func(u Expression) Expression {
+		return over(num(-1), call("Sqrt", minus(num(1), raise(u, num(2)))))
+	},
+	"ACOSH": func(u Expression) Expression {
+		return over(num(1), call("Sqrt", minus(raise(u, num(2)), num(1))))
+	},
+	"ASIN": func(u Expression) Expression {
+		return over(num(1), call("Sqrt", minus(num(1), raise(u, num(2)))))
+	},
+	"ASINH": func(u Expression) Expression {
+		return over(num(1), call("Sqrt", sum(raise(u, num(2)), num(1))))
+	},
+	"ATAN": func(u Expression) Expression {
+		return over(num(1), sum(raise(u, num(2)), num(1)))
+	},
+	"ATANH": func(u Expression) Expression {
+		return over(num(1), minus(num(1), raise(u, num(2))))
+	},
+	"CBRT": func(u Expression) Expression {
+		return over(num(1), times(num(3), raise(call("Cbrt", u), num(2))))
+	},
+	"COS":  func(u Expression) Expression { return &Negate{Expr: call("Sin", u)} },
+	"COSH": func(u Expression) Expression { return call("Sinh", u) },
+	"ERF": func(u Expression) Expression {
+		return times(num(2/math.SqrtPi), call("Exp", &Negate{Expr: raise(u, num(2))}))
+	},
+	"ERFC": func(u Expression) Expression {
+		return times(num(-2/math.SqrtPi), call("Exp", &Negate{Expr: raise(u, num(2))}))
+	},
+	"ERFINV": func(u Expression) Expression {
+		return times(num(math.SqrtPi/2), call("Exp", raise(call("Erfinv", u), num(2))))
+	},
+	"ERFCINV": func(u Expression) Expression {
+		return times(num(-math.SqrtPi/2), call("Exp", raise(call("Erfcinv", u), num(2))))
+	},
+	"EXP":   func(u Expression) Expression { return call("Exp", u) },
+	"EXP2":  func(u Expression) Expression { return times(num(math.Ln2), call("Exp2", u)) },
+	"EXPM1": func(u Expression) Expression { return call("Exp", u) },
+	"J0":    func(u Expression) Expression { return &Negate{Expr: call("J1", u)} },
+	"J1": func(u Expression) Expression {
+		return minus(call("J0", u), over(call("J1", u), u))
+	},
+	"LOG":   func(u Expression) Expression { return over(num(1), u) },
+	"LOG10": func(u Expression) Expression { return over(num(1), times(num(math.Ln10), u)) },
+	"LOG1P": func(u Expression) Expression { return over(num(1), sum(u, num(1))) },
+	"LOG2":  func(u Expression) Expression { return over(num(1), times(num(math.Ln2), u)) },
+	"SIN":   func(u Expression) Expression { return call("Cos", u) },
+	"SINH":  func(u Expression) Expression { return call("Cosh", u) },
+	"SQRT": func(u Expression) Expression {
+		return over(num(1), times(num(2), call("Sqrt", u)))
+	},
+	"TAN": func(u Expression) Expression {
+		return over(num(1), raise(call("Cos", u), num(2)))
+	},
+	"TANH": func(u Expression) Expression {
+		return minus(num(1), raise(call("Tanh", u), num(2)))
+	},
+	"Y0": func(u Expression) Expression { return &Negate{Expr: call("Y1", u)} },
+	"Y1": func(u Expression) Expression {
+		return minus(call("Y0", u), over(call("Y1", u), u))
+	},
+}
+
+// piecewiseConstant functions have a zero derivative almost everywhere.
+var piecewiseConstant = map[string]bool{
+	"CEIL": true, "FLOOR": true, "ILOGB": true, "INF": true, "LOGB": true,
+	"POW10": true, "ROUND": true, "ROUNDTOEVEN": true, "STEP": true,
+	"TRUNC": true,
+}
+
+// doubleDerivatives hold the derivative of f(a, b) given the derivatives da
+// and db of its arguments.
+var doubleDerivatives = map[string]func(a, b, da, db Expression) Expression{
+	"ATAN2": func(a, b, da, db Expression) Expression {
+		return over(minus(times(b, da), times(a, db)), sum(raise(a, num(2)), raise(b, num(2))))
+	},
+	"COPYSIGN": func(a, b, da, db Expression) Expression {
+		return times(times(call("Copysign", num(1), a), call("Copysign", num(1), b)), da)
+	},
+	"DIM": func(a, b, da, db Expression) Expression {
+		return times(step(minus(a, b)), minus(da, db))
+	},
+	"HYPOT": func(a, b, da, db Expression) Expression {
+		return over(sum(times(a, da), times(b, db)), call("Hypot", a, b))
+	},
+	"JN": func(a, b, da, db Expression) Expression {
+		n := call("Trunc", a)
+		return times(over(minus(call("Jn", minus(n, num(1)), b), call("Jn", sum(n, num(1)), b)), num(2)), db)
+	},
+	"LDEXP": func(a, b, da, db Expression) Expression {
+		return call("Ldexp", da, b)
+	},
+	"MAX": func(a, b, da, db Expression) Expression {
+		s := step(minus(a, b))
+		return sum(times(s, da), times(minus(num(1), s), db))
+	},
+	"MIN": func(a, b, da, db Expression) Expression {
+		s := step(minus(a, b))
+		return sum(times(minus(num(1), s), da), times(s, db))
+	},
+	"MOD": func(a, b, da, db Expression) Expression {
+		return minus(da, times(call("Trunc", over(a, b)), db))
+	},
+	"NEXTAFTER": func(a, b, da, db Expression) Expression {
+		return da
+	},
+	"PERLIN": noiseDerivative2("Perlin"),
+	"POW":    powerDerivative,
+	"REMAINDER": func(a, b, da, db Expression) Expression {
+		return minus(da, times(call("RoundToEven", over(a, b)), db))
+	}, "SIMPLEX": noiseDerivative2("Simplex"),
+	"VALUENOISE": noiseDerivative2("ValueNoise"),
+	"WORLEY":     noiseDerivative2("Worley"),
+	"YN": func(a, b, da, db Expression) Expression {
+		n := call("Trunc", a)
+		return times(over(minus(call("Yn", minus(n, num(1)), b), call("Yn", sum(n, num(1)), b)), num(2)), db)
+	},
+}
+
+// tripleDerivatives hold the derivative of f(a, b, c) given the derivatives
+// da, db and dc of its arguments.
+var tripleDerivatives = map[string]func(a, b, c, da, db, dc Expression) Expression{
+	"CLAMP": func(x, lo, hi, dx, dlo, dhi Expression) Expression {
+		return doubleDerivatives["MIN"](call("Max", x, lo), hi, doubleDerivatives["MAX"](x, lo, dx, dlo), dhi)
+	},
+	"FBM": noiseDerivative3("Fbm", true),
+	"IF": func(c, a, b, dc, da, db Expression) Expression {
+		return call("If", c, da, db)
+	},
+	"LERP": func(a, b, t, da, db, dt Expression) Expression {
+		return sum(sum(da, times(minus(db, da), t)), times(minus(b, a), dt))
+	},
+	"PERLIN3":  noiseDerivative3("Perlin3", false),
+	"SIMPLEX3": noiseDerivative3("Simplex3", false),
+	"SMOOTHSTEP": func(e0, e1, x, de0, de1, dx Expression) Expression {
+		// The slope 6u(1 - u) is zero where u is clamped, so the derivative
+		// of the unclamped ratio can be used throughout.
+		width := minus(e1, e0)
+		ratio := over(minus(x, e0), width)
+		u := call("Clamp", ratio, num(0), num(1))
+		dRatio := over(minus(minus(dx, de0), times(ratio, minus(de1, de0))), width)
+		return times(times(num(6), times(u, minus(num(1), u))), dRatio)
+	},
+	"TURBULENCE":  noiseDerivative3("Turbulence", true),
+	"VALUENOISE3": noiseDerivative3("ValueNoise3", false),
+	"WORLEY3":     noiseDerivative3("Worley3", false),
+}
+
+// powerDerivative differentiates a ^ b, using the simpler power rule when the
+// exponent does not vary.
+func powerDerivative(a, b, da, db Expression) Expression {
+	if isConst(db.Simplify(), 0) {
+		return times(times(b, raise(a, minus(b, num(1)))), da)
+	}
+	return times(raise(a, b), sum(times(db, call("Log", a)), over(times(b, da), a)))
+}
+
+func (v Function) Derive(vs string) (*Function, error) {
+	if v.Equals == nil {
+		return nil, errors.New("no such formula")
+	}
+	e, err := v.Equals.Derive(vs)
+	if err != nil {
+		return nil, err
+	}
+	v.Equals = e.(*Equals)
+	return v.Simplify(), nil
+}
+
+func (v Equals) Derive(vs string) (Expression, error) {
+	var err error
+	if v.RHS, err = v.RHS.Derive(vs); err != nil {
+		return nil, err
+	}
+	if v.LHS != nil {
+		if v.LHS, err = v.LHS.Derive(vs); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+func (v Var) Derive(vs string) (Expression, error) {
+	if strings.EqualFold(v.Var, vs) {
+		return num(1), nil
+	}
+	switch name := strings.ToUpper(v.Var); name {
+	case "U", "V", "PX", "PY":
+		// These depend on the view and image size, which are not known here.
+		if v.HasVar(strings.ToUpper(vs)) {
+			return nil, fmt.Errorf("%w: %s depends on the view", ErrNoDerivative, v.Var)
+		}
+	case "R", "A":
+		return derivation(name, 0, 0, Frame{}).Derive(vs)
+	}
+	return num(0), nil
+}
+
+func (v Const) Derive(vs string) (Expression, error) {
+	return num(0), nil
+}
+
+func (v Plus) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return &Plus{LHS: l, RHS: r}, nil
+}
+
+func (v Subtract) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return &Subtract{LHS: l, RHS: r}, nil
+}
+
+func (v Multiply) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return sum(times(l, v.RHS), times(v.LHS, r)), nil
+}
+
+func (v Divide) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return over(minus(times(r, v.LHS), times(v.RHS, l)), raise(v.LHS, num(2))), nil
+}
+
+func (v Power) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return powerDerivative(v.LHS, v.RHS, l, r), nil
+}
+
+func (v Modulus) Derive(vs string) (Expression, error) {
+	l, r, err := deriveBoth(v.LHS, v.RHS, vs)
+	if err != nil {
+		return nil, err
+	}
+	return doubleDerivatives["MOD"](v.LHS, v.RHS, l, r), nil
+}
+
+func (v Negate) Derive(vs string) (Expression, error) {
+	d, err := v.Expr.Derive(vs)
+	if err != nil {
+		return nil, err
+	}
+	return &Negate{Expr: bracket(d)}, nil
+}
+
+func (v Brackets) Derive(vs string) (Expression, error) {
+	d, err := v.Expr.Derive(vs)
+	if err != nil {
+		return nil, err
+	}
+	return bracket(d), nil
+}
+
+func (v SingleFunction) Derive(vs string) (Expression, error) {
+	d, err := v.Expr.Derive(vs)
+	if err != nil {
+		return nil, err
+	}
+	def := lookupArity(v.Name, 1)
+	switch {
+	case v.Fn == nil && def == nil:
+		// Unknown functions evaluate as the identity.
+		return d, nil
+	case def == nil:
+	case def.PiecewiseConstant:
+		return num(0), nil
+	case def.Derivative1 != nil:
+		return times(def.Derivative1(v.Expr), d), nil
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNoDerivative, v.Name)
+}
+
+func (v DoubleFunction) Derive(vs string) (Expression, error) {
+	d1, d2, err := deriveBoth(v.Expr1, v.Expr2, vs)
+	if err != nil {
+		return nil, err
+	}
+	def := lookupArity(v.Name, 2)
+	switch {
+	case v.Fn == nil && def == nil:
+		// Unknown functions evaluate to their first argument.
+		return d1, nil
+	case def == nil:
+	case def.PiecewiseConstant:
+		return num(0), nil
+	case def.Derivative2 != nil:
+		return def.Derivative2(v.Expr1, v.Expr2, d1, d2), nil
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNoDerivative, v.Name)
+}
+
+func (v TripleFunction) Derive(vs string) (Expression, error) {
+	d1, d2, err := deriveBoth(v.Expr1, v.Expr2, vs)
+	if err != nil {
+		return nil, err
+	}
+	d3, err := v.Expr3.Derive(vs)
+	if err != nil {
+		return nil, err
+	}
+	def := lookupArity(v.Name, 3)
+	switch {
+	case v.Fn == nil && def == nil:
+		// Unknown functions evaluate to their first argument.
+		return d1, nil
+	case def == nil:
+	case def.PiecewiseConstant:
+		return num(0), nil
+	case def.Derivative3 != nil:
+		return def.Derivative3(v.Expr1, v.Expr2, v.Expr3, d1, d2, d3), nil
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNoDerivative, v.Name)
+}
+
+// Comparisons are piecewise constant.
+func (v Compare) Derive(vs string) (Expression, error) {
+	return num(0), nil
+}
+
+func deriveBoth(a, b Expression, vs string) (Expression, Expression, error) {
+	da, err := a.Derive(vs)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := b.Derive(vs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return da, db, nil
+}
@@ -0,0 +1,101 @@
+package image_formula_find
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDeriveMatchesFiniteDifference(t *testing.T) {
+	formulas := []string{
+		"y = x * x + 2",
+		"y = 3 - x",
+		"x - y = y * x",
+		"y = x / y",
+		"y = x ^ 3",
+		"y = 2 ^ x",
+		"y = x ^ y",
+		"y = x % 3",
+		"y = -(x * y)",
+		"y = sin(x) * cos(y) + tan(x / 4)",
+		"y = exp(x / 4) + exp2(y / 4) + expm1(x / 8)",
+		"y = log(x + 20) + log10(y + 20) + log1p(x + 11) + log2(y + 20)",
+		"y = sqrt(x + 20) + cbrt(y + 11)",
+		"y = asin(x / 20) + acos(y / 20) + atan(x) + asinh(y)",
+		"y = acosh(x + 20) + atanh(y / 20)",
+		"y = sinh(x / 4) + cosh(y / 4) + tanh(x)",
+		"y = erf(x / 4) + erfc(y / 4) + erfinv(x / 20) + erfcinv(y / 20 + 1)",
+		"y = j0(x) + j1(y) + y0(x + 20) + y1(y + 20)",
+		"y = abs(x) + floor(y) * ceil(x)",
+		"y = atan2(x, y) + hypot(x, y)",
+		"y = max(x, y) + min(x * 2, y) + dim(x, y)",
+		"y = copysign(x, y) + mod(x, y + 20) + remainder(x, y + 20)",
+		"y = pow(x + 20, y / 4) + ldexp(x, 3) + nextafter(y, 0)",
+		"y = jn(2, x) + yn(3, y + 20)",
+		"y = x atan2 y",
+		"y = perlin(x, y) + simplex(x, y) + valuenoise(x / 3, y) + worley(x, y)",
+		"y = perlin3(x, y, 0.5) + simplex3(x, y, 2) + valuenoise3(x, y, 0.3) + worley3(x, y, 0.2)",
+		"y = fbm(x, y, 3) + turbulence(x / 3, y / 5, 2)",
+	}
+	fs := make([]*Function, 0, len(formulas)+1)
+	for _, each := range formulas {
+		f, err := ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		fs = append(fs, f)
+	}
+	// The parser rejects unknown functions, which DNA may still call.
+	fs = append(fs, &Function{Equals: &Equals{LHS: &Var{Var: "y"}, RHS: NewSingleFunction("unknown", &Multiply{LHS: &Var{Var: "x"}, RHS: &Var{Var: "y"}})}})
+	points := [][2]float64{{-2.5, 1.25}, {3.3, -4.1}, {0.7, 6.2}}
+	const h = 1e-6
+	for _, f := range fs {
+		each := f.String()
+		for _, vs := range []string{"X", "y"} {
+			d, err := f.Derive(vs)
+			if err != nil {
+				t.Fatalf("Derive(%q) of %q: %v", vs, each, err)
+			}
+			for _, p := range points {
+				at := func(dx, dy float64) float64 {
+					w, _, _ := f.Evaluate(p[0]+dx, p[1]+dy, 0)
+					return w
+				}
+				var want float64
+				if vs == "X" {
+					want = (at(h, 0) - at(-h, 0)) / (2 * h)
+				} else {
+					want = (at(0, h) - at(0, -h)) / (2 * h)
+				}
+				got, _, _ := d.Evaluate(p[0], p[1], 0)
+				if math.Abs(got-want) > 1e-4*math.Max(1, math.Abs(want)) {
+					t.Errorf("d%q/d%s at %v: got %v (%s), want %v", each, vs, p, got, d, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDeriveUnknownDerivative(t *testing.T) {
+	f, err := ParseFunction("y = gamma(x) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Derive("X"); !errors.Is(err, ErrNoDerivative) {
+		t.Errorf("Expected ErrNoDerivative, got %v", err)
+	}
+	if _, err := (Function{}).Derive("X"); err == nil {
+		t.Error("Expected error for empty formula")
+	}
+}
+
+func TestDeriveRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 5)
+		if _, err := f.Derive("X"); err != nil && !errors.Is(err, ErrNoDerivative) {
+			t.Fatalf("%s: unexpected error %v", f, err)
+		}
+	}
+}
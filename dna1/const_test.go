@@ -8,7 +8,7 @@ import (
 
 func TestParseConstValues(t *testing.T) {
 	// chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
-	// Op mapping: i % 17
+	// Op mapping: i % 17, for DNA drawing from function table v1
 	// 0: ParseConst (exp 0)  ('A')
 	// 1-11: Ops
 	// 12: ParseConst (exp 1) ('M', '/')
@@ -46,6 +46,8 @@ func TestParseConstValues(t *testing.T) {
 		{"QBA", "MakeConst 'Q' (val 16) with rest", 16.0, "BA"},
 	}
 
+	defer func(previous *image_formula_find.FunctionSet) { Functions = previous }(Functions)
+	Functions = functionTable(1)
 	for _, tc := range testCases {
 		rest, expr := ParseExpression(tc.input)
 		fmt.Printf("%s -> %v (rest: %q)\n", tc.desc, expr, rest)
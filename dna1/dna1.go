@@ -3,11 +3,11 @@ package dna1
 import (
 	"github.com/agnivade/levenshtein"
 	"image-formula-find"
+	"image-formula-find/evolve"
 	"math"
 	"math/rand"
 	"sort"
 	"strings"
-	"sync"
 )
 
 const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
@@ -16,6 +16,26 @@ var (
 	runeMapPos = map[rune]int{}
 )
 
+// Functions is the set function genes draw from, by default the newest
+// function table. Commands replace it before a run to offer the evolution a
+// different selection, which Encode records in the genome header.
+var Functions = functionTable(image_formula_find.FunctionTableVersion)
+
+// Compat is the function set for genomes stored without a header, which date
+// from before headers were written. Those stored since function genes were
+// split by arity decode with table version 1, the default. Older ones drew
+// from a list in a random order and only decode approximately, with table
+// version 0.
+var Compat = functionTable(1)
+
+func functionTable(version int) *image_formula_find.FunctionSet {
+	s, err := image_formula_find.FunctionTable(version)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
 func init() {
 	for p, c := range chars {
 		runeMapPos[c] = p
@@ -34,7 +54,33 @@ func RndStr(length int) string {
 	return sb.String()
 }
 
+// ParseExpression parses one expression off the front of arg, drawing
+// function genes from Functions, and returns the rest of arg.
 func ParseExpression(arg string) (string, image_formula_find.Expression) {
+	return newDecoder(Functions).expression(arg)
+}
+
+// conditionalsTable is the first function table whose DNA has genes for
+// comparisons and three argument functions.
+const conditionalsTable = 2
+
+// decoder parses DNA with the functions of one function set.
+type decoder struct {
+	functions *image_formula_find.FunctionSet
+	// genes is the number of kinds of gene. DNA drawing from the tables
+	// before conditionalsTable has 17, keeping it decoding as it did.
+	genes int
+}
+
+func newDecoder(functions *image_formula_find.FunctionSet) decoder {
+	genes := 19
+	if v, ok := functions.Version(); ok && v < conditionalsTable {
+		genes = 17
+	}
+	return decoder{functions: functions, genes: genes}
+}
+
+func (d decoder) expression(arg string) (string, image_formula_find.Expression) {
 	if len(arg) == 0 {
 		return "", &image_formula_find.Const{Value: 0}
 	}
@@ -45,33 +91,33 @@ func ParseExpression(arg string) (string, image_formula_find.Expression) {
 		return MakeConst(arg, c)
 	}
 
-	switch i % 17 {
+	switch i % d.genes {
 	case 1:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Plus{
 			LHS: lhs,
 			RHS: rhs,
 		}
 	case 2:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Subtract{
 			LHS: lhs,
 			RHS: rhs,
 		}
 	case 3:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Multiply{
 			LHS: lhs,
 			RHS: rhs,
 		}
 	case 4:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Divide{
 			LHS: lhs,
 			RHS: rhs,
 		}
 	case 5:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Modulus{
 			LHS: lhs,
 			RHS: rhs,
@@ -83,9 +129,13 @@ func ParseExpression(arg string) (string, image_formula_find.Expression) {
 			arg = arg[1:]
 		}
 		fi := runeMapPos[farg]
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
+		name := d.functions.DoubleName(fi)
+		if name == "" {
+			return "", lhs
+		}
 		return "", image_formula_find.NewDoubleFunction(
-			image_formula_find.FunctionNames[fi%len(image_formula_find.FunctionNames)],
+			name,
 			lhs,
 			rhs,
 			false,
@@ -97,18 +147,22 @@ func ParseExpression(arg string) (string, image_formula_find.Expression) {
 			arg = arg[1:]
 		}
 		fi := runeMapPos[farg]
-		arg, expr := ParseExpression(arg)
+		arg, expr := d.expression(arg)
+		name := d.functions.SingleName(fi)
+		if name == "" {
+			return arg, expr
+		}
 		return arg, image_formula_find.NewSingleFunction(
-			image_formula_find.FunctionNames[int(fi)%len(image_formula_find.FunctionNames)],
+			name,
 			expr,
 		)
 	case 8:
-		arg, expr := ParseExpression(arg)
+		arg, expr := d.expression(arg)
 		return arg, image_formula_find.Negate{
 			Expr: expr,
 		}
 	case 9:
-		lhs, rhs := Split2AndParse(arg)
+		lhs, rhs := d.split2AndParse(arg)
 		return "", image_formula_find.Power{
 			LHS: lhs,
 			RHS: rhs,
@@ -121,6 +175,38 @@ func ParseExpression(arg string) (string, image_formula_find.Expression) {
 		return arg, image_formula_find.Var{
 			Var: "Y",
 		}
+	case 17:
+		farg := 'A'
+		if len(arg) > 0 {
+			farg = rune(arg[0])
+			arg = arg[1:]
+		}
+		op := image_formula_find.Comparisons[runeMapPos[farg]%len(image_formula_find.Comparisons)]
+		lhs, rhs := d.split2AndParse(arg)
+		return "", image_formula_find.Compare{
+			Op:  op,
+			LHS: lhs,
+			RHS: rhs,
+		}
+	case 18:
+		farg := 'A'
+		if len(arg) > 0 {
+			farg = rune(arg[0])
+			arg = arg[1:]
+		}
+		fi := runeMapPos[farg]
+		s1, s2, s3 := SplitString3(arg)
+		expr1 := d.expressionAll(s1)
+		name := d.functions.TripleName(fi)
+		if name == "" {
+			return "", expr1
+		}
+		return "", image_formula_find.NewTripleFunction(
+			name,
+			expr1,
+			d.expressionAll(s2),
+			d.expressionAll(s3),
+		)
 	case 0:
 		return ParseConstWithExponent(arg, 0)
 	case 12:
@@ -182,21 +268,29 @@ func ParseConstWithExponent(arg string, exponent int) (string, image_formula_fin
 }
 
 func ParseExpressionAll(arg string) image_formula_find.Expression {
+	return newDecoder(Functions).expressionAll(arg)
+}
+
+func (d decoder) expressionAll(arg string) image_formula_find.Expression {
 	if len(arg) == 0 {
 		return &image_formula_find.Const{Value: 0}
 	}
-	arg, result := ParseExpression(arg)
+	arg, result := d.expression(arg)
 	if len(arg) > 0 {
 		result = image_formula_find.Plus{
 			LHS: result,
-			RHS: ParseExpressionAll(arg),
+			RHS: d.expressionAll(arg),
 		}
 	}
 	return result
 }
 
 func ParseFunction(arg string) *image_formula_find.Function {
-	lhs, rhs := Split2AndParse(arg)
+	return newDecoder(Functions).function(arg)
+}
+
+func (d decoder) function(arg string) *image_formula_find.Function {
+	lhs, rhs := d.split2AndParse(arg)
 	return &image_formula_find.Function{
 		Equals: &image_formula_find.Equals{
 			LHS: lhs,
@@ -206,9 +300,13 @@ func ParseFunction(arg string) *image_formula_find.Function {
 }
 
 func Split2AndParse(arg string) (image_formula_find.Expression, image_formula_find.Expression) {
+	return newDecoder(Functions).split2AndParse(arg)
+}
+
+func (d decoder) split2AndParse(arg string) (image_formula_find.Expression, image_formula_find.Expression) {
 	lhsStr, rhsStr := SplitString2(arg)
-	lhs := ParseExpressionAll(lhsStr)
-	rhs := ParseExpressionAll(rhsStr)
+	lhs := d.expressionAll(lhsStr)
+	rhs := d.expressionAll(rhsStr)
 	return lhs, rhs
 }
 
@@ -368,10 +466,14 @@ func Breed(a string, b string) string {
 }
 
 func ParseDNA(dna string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
+	return newDecoder(Functions).dna(dna)
+}
+
+func (d decoder) dna(dna string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
 	rd, bd, gd := SplitString3(dna)
-	rf := ParseFunction(rd)
-	bf := ParseFunction(bd)
-	gf := ParseFunction(gd)
+	rf := d.function(rd)
+	bf := d.function(bd)
+	gf := d.function(gd)
 	return rf, bf, gf
 }
 
@@ -388,9 +490,16 @@ const (
 
 func GenerationProcess(worker Required, lastGeneration []*Individual, generation int, newDNA chan string) []*Individual {
 	const mutations = 8
+	control := evolve.BloatOf(worker)
 	var children = make([]*Individual, 0, len(lastGeneration)*mutations+len(lastGeneration)*len(lastGeneration)+childrenCount+1)
 
 	seen := map[string]struct{}{}
+	// DNA strings that differ can still decode to the same formulas, those
+	// are only rendered once.
+	var phenotypes image_formula_find.Phenotypes
+	fresh := func(i *Individual) bool {
+		return phenotypes.Add(i.Rf, i.Bf, i.Gf)
+	}
 
 	for _, p := range lastGeneration {
 		dna := p.DNA
@@ -398,6 +507,9 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			continue
 		}
 		seen[dna] = struct{}{}
+		if !fresh(p) {
+			continue
+		}
 		children = append(children, p)
 	}
 
@@ -414,12 +526,14 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			if !Valid(dna) {
 				continue
 			}
-			children = append(children, &Individual{
-				DNA:             dna,
-				Parent:          []*Individual{p},
-				FirstGeneration: generation,
-				Lineage:         p.DNA,
-			})
+			child := NewIndividual(dna)
+			if child.Degenerate(worker) || !fresh(child) {
+				continue
+			}
+			child.Parent = []*Individual{p}
+			child.FirstGeneration = generation
+			child.Lineage = p.DNA
+			children = append(children, child)
 		}
 	}
 
@@ -427,22 +541,19 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 	//	p1 := lastGeneration[i%len(lastGeneration)]
 	//	p2 := lastGeneration[i/len(lastGeneration)]
 	if len(lastGeneration) > 4 {
-		p1 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
-		p2 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
+		p1 := evolve.Choose(control, lastGeneration)
+		p2 := evolve.Choose(control, lastGeneration)
 		dna := Breed(p1.DNA, p2.DNA)
 		if _, ok := seen[dna]; !ok {
 			seen[dna] = struct{}{}
 
 			if Valid(dna) {
-				if dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage {
-					children = append(children, &Individual{
-						DNA: dna,
-						Parent: []*Individual{
-							p1, p2,
-						},
-						Lineage:         dna,
-						FirstGeneration: generation,
-					})
+				child := NewIndividual(dna)
+				if !child.Degenerate(worker) && dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage && fresh(child) {
+					child.Parent = []*Individual{p1, p2}
+					child.Lineage = dna
+					child.FirstGeneration = generation
+					children = append(children, child)
 				}
 			}
 		}
@@ -457,26 +568,18 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 		if !Valid(dna) {
 			continue
 		}
-		children = append(children, &Individual{
-			DNA:             dna,
-			Lineage:         dna,
-			FirstGeneration: generation,
-		})
+		child := NewIndividual(dna)
+		if child.Degenerate(worker) || !fresh(child) {
+			continue
+		}
+		child.Lineage = dna
+		child.FirstGeneration = generation
+		children = append(children, child)
 	}
 
-	wg := sync.WaitGroup{}
-	for fi := range children {
-		wg.Add(1)
-		go func(i int, child *Individual) {
-			defer wg.Done()
-			child.Calculate(worker)
-		}(fi, children[fi])
-	}
-	wg.Wait()
+	children = evolve.ControlBloat(control, children, generation)
 
-	sort.Sort((&Sorter{
-		Children: children,
-	}))
+	children = evolve.Rank(worker, children)
 
 	lastGeneration = make([]*Individual, 0, childrenCount)
 	for len(lastGeneration) < childrenCount && len(children) > 0 {
@@ -502,6 +605,7 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 	}
 	sort.Sort((&Sorter{
 		Children: lastGeneration,
+		Bloat:    control,
 	}))
 	return lastGeneration
 }
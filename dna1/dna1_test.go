@@ -1,6 +1,9 @@
 package dna1
 
 import (
+	"image-formula-find"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -229,3 +232,117 @@ func TestRndStr(t *testing.T) {
 		}
 	}
 }
+
+func TestStringRoundTrip(t *testing.T) {
+	points := [][2]float64{{-10, -10}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 2000; i++ {
+		dna := RndStr(100)
+		r, g, b := ParseDNA(dna)
+		for _, f := range []*image_formula_find.Function{r, g, b} {
+			s := f.String()
+			parsed, err := image_formula_find.ParseFunction(s)
+			if err != nil {
+				t.Fatalf("DNA %q printed %q: %v", dna, s, err)
+			}
+			if parsed.String() != s {
+				t.Fatalf("DNA %q printed %q, parsed back as %q", dna, s, parsed)
+			}
+			for _, p := range points {
+				want, _, _ := f.Evaluate(p[0], p[1], 1)
+				got, _, _ := parsed.Evaluate(p[0], p[1], 1)
+				if math.Float64bits(got) != math.Float64bits(want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Fatalf("%s at %v: got %v want %v", s, p, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFunctionsByArity(t *testing.T) {
+	set, err := image_formula_find.NewFunctionSet("Sin", "Atan2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(previous *image_formula_find.FunctionSet) { Functions = previous }(Functions)
+	Functions = set
+	for i := 0; i < 500; i++ {
+		r, g, b := ParseDNA(RndStr(60))
+		for _, f := range []*image_formula_find.Function{r, g, b} {
+			image_formula_find.Walk(f.Equals, func(e image_formula_find.Expression) bool {
+				switch e := e.(type) {
+				case *image_formula_find.SingleFunction:
+					if e.Name != "Sin" {
+						t.Fatalf("Single argument function %s in %s", e.Name, f)
+					}
+				case *image_formula_find.DoubleFunction:
+					if e.Name != "Atan2" {
+						t.Fatalf("Two argument function %s in %s", e.Name, f)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+func TestConditionalGenes(t *testing.T) {
+	defer func(previous *image_formula_find.FunctionSet) { Functions = previous }(Functions)
+	for _, test := range []struct {
+		table int
+		dna   string
+		want  string
+	}{
+		{2, "RBAKL", "X <= Y"},
+		{2, "SBACKLK", "If(X, Y, X)"},
+		{1, "RBAKL", "64"},
+	} {
+		Functions = functionTable(test.table)
+		if _, e := ParseExpression(test.dna); e.String() != test.want {
+			t.Errorf("Table v%d decoded %q to %s, want %s", test.table, test.dna, e, test.want)
+		}
+	}
+}
+
+func TestGenome(t *testing.T) {
+	dna := RndStr(80)
+	genome := Encode(dna)
+	if !strings.HasPrefix(genome, "v3:") {
+		t.Fatalf("Genome %q without a v3 header", genome)
+	}
+	want := func(rf, bf, gf *image_formula_find.Function) string {
+		return rf.String() + "|" + bf.String() + "|" + gf.String()
+	}
+	rf, bf, gf := ParseDNA(dna)
+	expected := want(rf, bf, gf)
+
+	// The header decides the functions, whatever the current selection.
+	// Without one the DNA decodes with Compat.
+	defer func(previous *image_formula_find.FunctionSet) { Functions = previous }(Functions)
+	Functions = Compat
+	rf, bf, gf = ParseDNA(dna)
+	compat := want(rf, bf, gf)
+	Functions = image_formula_find.AllFunctions()
+	for g, w := range map[string]string{genome: expected, dna: compat} {
+		rf, bf, gf, err := ParseGenome(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := want(rf, bf, gf); got != w {
+			t.Errorf("Genome %q decoded to %s, want %s", g, got, w)
+		}
+	}
+
+	custom, err := image_formula_find.ParseFunctionSet("Sin,Atan2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Functions = custom
+	genome = Encode(dna)
+	d, functions, err := Decode(genome)
+	if err != nil || d != dna || functions.String() != "Sin,Atan2" {
+		t.Errorf("Decoded %q to %q %v %v", genome, d, functions, err)
+	}
+	if _, _, _, err := ParseGenome("v99:" + dna); err == nil {
+		t.Error("Expected an error for an unknown table")
+	}
+}
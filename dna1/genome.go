@@ -0,0 +1,41 @@
+package dna1
+
+import (
+	"fmt"
+	"image-formula-find"
+	"strings"
+)
+
+// A genome is DNA as stored, behind a header naming the function set its
+// function genes index, such as "v1:" for function table version 1 or
+// "Sin,Cos,Atan2:" for a set of its own. The colon never occurs in DNA.
+
+// Encode returns the genome for DNA evolved with Functions.
+func Encode(dna string) string {
+	return Functions.String() + ":" + dna
+}
+
+// Decode splits a genome into its DNA and the function set its header names.
+// A genome without a header is DNA decoded with Compat.
+func Decode(genome string) (string, *image_formula_find.FunctionSet, error) {
+	header, dna, ok := strings.Cut(genome, ":")
+	if !ok {
+		return genome, Compat, nil
+	}
+	functions, err := image_formula_find.ParseFunctionSet(header)
+	if err != nil {
+		return "", nil, fmt.Errorf("genome header %q: %w", header, err)
+	}
+	return dna, functions, nil
+}
+
+// ParseGenome returns the red, blue and green formulas of a genome, as
+// ParseDNA does for its DNA.
+func ParseGenome(genome string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function, error) {
+	dna, functions, err := Decode(genome)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rf, bf, gf := newDecoder(functions).dna(dna)
+	return rf, bf, gf, nil
+}
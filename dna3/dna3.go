@@ -2,11 +2,11 @@ package dna3
 
 import (
 	"image-formula-find"
+	"image-formula-find/evolve"
 	"math"
 	"math/rand"
 	"sort"
 	"strings"
-	"sync"
 
 	"github.com/agnivade/levenshtein"
 )
@@ -183,7 +183,12 @@ func Resolve(idx int, dna string) image_formula_find.Expression {
 		if v >= 54 {
 			// Op
 			opName := MapOp(v)
-			expr = image_formula_find.NewSingleFunction(opName, expr)
+			if opName == "Negate" {
+				// Negate is an operator rather than a function.
+				expr = &image_formula_find.Negate{Expr: expr}
+			} else {
+				expr = image_formula_find.NewSingleFunction(opName, expr)
+			}
 		} else {
 			// Number -> Add
 			// Note: We use MapValue again here, or should we use a simpler addition?
@@ -393,9 +398,16 @@ const (
 
 func GenerationProcess(worker Required, lastGeneration []*Individual, generation int, newDNA chan string) []*Individual {
 	const mutations = 8
+	control := evolve.BloatOf(worker)
 	var children = make([]*Individual, 0, len(lastGeneration)*mutations+len(lastGeneration)*len(lastGeneration)+childrenCount+1)
 
 	seen := map[string]struct{}{}
+	// DNA strings that differ can still decode to the same formulas, those
+	// are only rendered once.
+	var phenotypes image_formula_find.Phenotypes
+	fresh := func(i *Individual) bool {
+		return phenotypes.Add(i.Rf, i.Bf, i.Gf)
+	}
 
 	for _, p := range lastGeneration {
 		dna := p.DNA
@@ -403,6 +415,9 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			continue
 		}
 		seen[dna] = struct{}{}
+		if !fresh(p) {
+			continue
+		}
 		children = append(children, p)
 	}
 
@@ -419,32 +434,31 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			if !Valid(dna) {
 				continue
 			}
-			children = append(children, &Individual{
-				DNA:             dna,
-				Parent:          []*Individual{p},
-				FirstGeneration: generation,
-				Lineage:         p.DNA,
-			})
+			child := NewIndividual(dna)
+			if child.Degenerate(worker) || !fresh(child) {
+				continue
+			}
+			child.Parent = []*Individual{p}
+			child.FirstGeneration = generation
+			child.Lineage = p.DNA
+			children = append(children, child)
 		}
 	}
 
 	if len(lastGeneration) > 4 {
-		p1 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
-		p2 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
+		p1 := evolve.Choose(control, lastGeneration)
+		p2 := evolve.Choose(control, lastGeneration)
 		dna := Breed(p1.DNA, p2.DNA)
 		if _, ok := seen[dna]; !ok {
 			seen[dna] = struct{}{}
 
 			if Valid(dna) {
-				if dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage {
-					children = append(children, &Individual{
-						DNA: dna,
-						Parent: []*Individual{
-							p1, p2,
-						},
-						Lineage:         dna,
-						FirstGeneration: generation,
-					})
+				child := NewIndividual(dna)
+				if !child.Degenerate(worker) && dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage && fresh(child) {
+					child.Parent = []*Individual{p1, p2}
+					child.Lineage = dna
+					child.FirstGeneration = generation
+					children = append(children, child)
 				}
 			}
 		}
@@ -459,26 +473,18 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 		if !Valid(dna) {
 			continue
 		}
-		children = append(children, &Individual{
-			DNA:             dna,
-			Lineage:         dna,
-			FirstGeneration: generation,
-		})
+		child := NewIndividual(dna)
+		if child.Degenerate(worker) || !fresh(child) {
+			continue
+		}
+		child.Lineage = dna
+		child.FirstGeneration = generation
+		children = append(children, child)
 	}
 
-	wg := sync.WaitGroup{}
-	for fi := range children {
-		wg.Add(1)
-		go func(i int, child *Individual) {
-			defer wg.Done()
-			child.Calculate(worker)
-		}(fi, children[fi])
-	}
-	wg.Wait()
+	children = evolve.ControlBloat(control, children, generation)
 
-	sort.Sort((&Sorter{
-		Children: children,
-	}))
+	children = evolve.Rank(worker, children)
 
 	lastGeneration = make([]*Individual, 0, childrenCount)
 	for len(lastGeneration) < childrenCount && len(children) > 0 {
@@ -504,6 +510,7 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 	}
 	sort.Sort((&Sorter{
 		Children: lastGeneration,
+		Bloat:    control,
 	}))
 	return lastGeneration
 }
@@ -1,6 +1,8 @@
 package dna3
 
 import (
+	"image-formula-find"
+	"math"
 	"strings"
 	"testing"
 )
@@ -48,6 +50,12 @@ func TestResolve(t *testing.T) {
 			index:    0,
 			expected: "Cos(25)",
 		},
+		{
+			name:     "Op Layer Negate",
+			dna:      "B" + padding + "+", // Index 0: B(25), Index 30: +(Negate - 62)
+			index:    0,
+			expected: "-(25)",
+		},
 		{
 			name:     "Multi Layer",
 			dna:      "B" + padding + "B" + padding + "2", // 0:B, 30:B, 60:2(Sin)
@@ -156,3 +164,28 @@ func verifyBreed(t *testing.T, a, b string) {
 		t.Errorf("Result length mismatch. Consumed %d, total %d", currentPos, len(result))
 	}
 }
+
+func TestStringRoundTrip(t *testing.T) {
+	points := [][2]float64{{-10, -10}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 2000; i++ {
+		dna := RndStr(100)
+		r, g, b := ParseDNA(dna)
+		for _, f := range []*image_formula_find.Function{r, g, b} {
+			s := f.String()
+			parsed, err := image_formula_find.ParseFunction(s)
+			if err != nil {
+				t.Fatalf("DNA %q printed %q: %v", dna, s, err)
+			}
+			if parsed.String() != s {
+				t.Fatalf("DNA %q printed %q, parsed back as %q", dna, s, parsed)
+			}
+			for _, p := range points {
+				want, _, _ := f.Evaluate(p[0], p[1], 1)
+				got, _, _ := parsed.Evaluate(p[0], p[1], 1)
+				if math.Float64bits(got) != math.Float64bits(want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Fatalf("%s at %v: got %v want %v", s, p, got, want)
+				}
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package dna4
 import (
 	"image"
 	"image-formula-find"
+	"math"
 	"testing"
 )
 
@@ -51,13 +52,13 @@ func TestParseRPN(t *testing.T) {
 	// A=0, B=1, Q=16, C=2(T), S=18(*)
 	dna = "ABQCS"
 	expr = ParseRPN(dna)
-	// Note: String() does not add parentheses for precedence, so (X+Y)*T renders as "X + Y * T"
+	// String() adds the parentheses the precedence needs, so (X+Y)*T renders as "(X + Y) * T"
 	// We verify the structure to be sure.
 	if _, ok := expr.(*image_formula_find.Multiply); !ok {
 		t.Errorf("Expected Multiply root, got %T", expr)
 	}
-	if expr.String() != "X + Y * T" {
-		t.Errorf("Expected X + Y * T, got %s", expr.String())
+	if expr.String() != "(X + Y) * T" {
+		t.Errorf("Expected (X + Y) * T, got %s", expr.String())
 	}
 
 	// Test New Functions
@@ -76,6 +77,26 @@ func TestParseRPN(t *testing.T) {
 	if expr.String() != "Atan2(X, Y)" {
 		t.Errorf("Expected Atan2(X, Y), got %s", expr.String())
 	}
+
+	// Conditionals: L=11 (If), M=12 (Step), 7=59 (<), +=62 (>=)
+	// Variables: s=44 (R), w=48 (PX), Q=16 (+)
+	// Noise: y=50 (Perlin), z=51 (Perlin3), 5=57 (Fbm), F=5 (1)
+	// An If short of arguments leaves the stack as it is.
+	for dna, want := range map[string]string{
+		"ABCL":   "If(X, Y, T)",
+		"ABL":    "Y",
+		"ABM":    "Step(X, Y)",
+		"AB7":    "X < Y",
+		"AB+DEL": "If(X >= Y, 0.1, -0.1)",
+		"swQ":    "R + PX",
+		"ABy":    "Perlin(X, Y)",
+		"ABCz":   "Perlin3(X, Y, T)",
+		"ABF5":   "Fbm(X, Y, 1)",
+	} {
+		if expr := ParseRPN(dna); expr.String() != want {
+			t.Errorf("%s: expected %s, got %s", dna, want, expr)
+		}
+	}
 }
 
 func TestGenerationProcess(t *testing.T) {
@@ -111,3 +132,117 @@ func TestGenerationProcess(t *testing.T) {
 		t.Error("Gen 2 produced no children")
 	}
 }
+
+func TestStringRoundTrip(t *testing.T) {
+	points := [][2]float64{{-10, -10}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 2000; i++ {
+		dna := RndStr(100)
+		r, g, b := ParseDNA(dna)
+		for _, f := range []*image_formula_find.Function{r, g, b} {
+			s := f.String()
+			parsed, err := image_formula_find.ParseFunction(s)
+			if err != nil {
+				t.Fatalf("DNA %q printed %q: %v", dna, s, err)
+			}
+			if parsed.String() != s {
+				t.Fatalf("DNA %q printed %q, parsed back as %q", dna, s, parsed)
+			}
+			for _, p := range points {
+				want, _, _ := f.Evaluate(p[0], p[1], 1)
+				got, _, _ := parsed.Evaluate(p[0], p[1], 1)
+				if math.Float64bits(got) != math.Float64bits(want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Fatalf("%s at %v: got %v want %v", s, p, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestPhenotype checks that DNA strings only share a phenotype when they
+// decode to the same formulas, and that some distinct strings do.
+func TestPhenotype(t *testing.T) {
+	var phenotypes image_formula_find.Phenotypes
+	byFormulas := map[string]string{}
+	shared := 0
+	for i := 0; i < 5000; i++ {
+		dna := RndStr(12)
+		r, b, g := ParseDNA(dna)
+		formulas := r.String() + "; " + b.String() + "; " + g.String()
+		other, ok := byFormulas[formulas]
+		if phenotypes.Add(r, b, g) == ok {
+			t.Fatalf("%q: new phenotype %v, seen formulas %v", dna, !ok, ok)
+		}
+		if !ok {
+			byFormulas[formulas] = dna
+		} else if other != dna {
+			shared++
+		}
+	}
+	if shared == 0 {
+		t.Error("Expected some distinct DNA strings to share a phenotype")
+	}
+}
+
+// TestGenerationProcessNumerics checks that a generation drops the children
+// with invalid pixels when the penalty is negative.
+func TestGenerationProcessNumerics(t *testing.T) {
+	req := &BasicRequired{
+		R:       image.Rect(0, 0, 10, 10),
+		I:       image.NewRGBA(image.Rect(0, 0, 10, 10)),
+		Policy:  image_formula_find.Poison,
+		Penalty: -1,
+	}
+	newDNA := make(chan string, 100)
+	go func() {
+		for {
+			newDNA <- RndStr(50)
+		}
+	}()
+	// The red channel decodes to Sqrt(Y), NaN over the top half.
+	for _, child := range GenerationProcess(req, []*Individual{NewIndividual("znkBh/gE7eL+")}, 1, newDNA) {
+		if child.Invalid > 0 {
+			t.Errorf("%s with %d invalid pixels was not discarded", child.DNA, child.Invalid)
+		}
+	}
+}
+
+func TestGenome(t *testing.T) {
+	for range 20 {
+		dna := RndStr(60)
+		rf, bf, gf, err := ParseGenome(Encode(dna))
+		if err != nil {
+			t.Fatalf("%s: %v", Encode(dna), err)
+		}
+		wr, wb, wg := ParseDNA(dna)
+		if rf.String() != wr.String() || bf.String() != wb.String() || gf.String() != wg.String() {
+			t.Errorf("%s decodes to %s; %s; %s, want %s; %s; %s", Encode(dna), rf, bf, gf, wr, wb, wg)
+		}
+	}
+	// Each alphabet keeps decoding as it did, the opcodes introduced later
+	// pushing the constants they used to.
+	for genome, want := range map[string]string{
+		"ABL":     "-6.6",
+		"AB7":     "3",
+		"sw":      "0.8",
+		"v1:ABL":  "Y",
+		"v1:AB7":  "X < Y",
+		"v1:sw":   "0.8",
+		"v2:sw":   "PX",
+		"v2:ABy":  "1.2",
+		"v3:ABy":  "Perlin(X, Y)",
+		"v3:ABCz": "Perlin3(X, Y, T)",
+	} {
+		dna, version, err := Decode(genome)
+		if err != nil {
+			t.Fatalf("%s: %v", genome, err)
+		}
+		if expr := parseRPN(version, dna); expr.String() != want {
+			t.Errorf("%s: expected %s, got %s", genome, want, expr)
+		}
+	}
+	for _, genome := range []string{"v4:AB", "v:AB", "1:AB", "Sin:AB"} {
+		if _, _, err := Decode(genome); err == nil {
+			t.Errorf("Decode(%q) did not fail", genome)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dna4
+
+import (
+	"fmt"
+	"image-formula-find"
+	"strconv"
+	"strings"
+)
+
+// Alphabet is the newest version of the RPN alphabet, the one ParseDNA decodes
+// with and Encode records.
+const Alphabet = 3
+
+// Compat is the alphabet version of genomes stored without a header, which
+// date from before headers were written, when only opcodes 0-10 and 16-43
+// did anything else than push a constant.
+var Compat = 0
+
+// introduced returns the alphabet version that gave an opcode its meaning. A
+// released alphabet never changes, so the opcodes the baseline alphabet left
+// to constants took a new version each time they were given an operation:
+//
+//	1: If, Step, Clamp, Lerp and Smoothstep (11-15) and comparisons (59-63)
+//	2: the derived variables R, A, U, V, PX and PY (44-49)
+//	3: noise (50-58)
+func introduced(idx int) int {
+	switch {
+	case idx >= 11 && idx <= 15, idx >= 59:
+		return 1
+	case idx >= 44 && idx <= 49:
+		return 2
+	case idx >= 50 && idx <= 58:
+		return 3
+	}
+	return 0
+}
+
+// A genome is DNA as stored, behind a header naming the alphabet it was
+// evolved with, such as "v3:". The colon never occurs in DNA.
+
+// Encode returns the genome for DNA decoded with Alphabet.
+func Encode(dna string) string {
+	return "v" + strconv.Itoa(Alphabet) + ":" + dna
+}
+
+// Decode splits a genome into its DNA and the alphabet version its header
+// names. A genome without a header is DNA decoded with Compat.
+func Decode(genome string) (string, int, error) {
+	header, dna, ok := strings.Cut(genome, ":")
+	if !ok {
+		return genome, Compat, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(header, "v"))
+	if err != nil || !strings.HasPrefix(header, "v") || version < 0 || version > Alphabet {
+		return "", 0, fmt.Errorf("genome header %q: expected an alphabet version from v0 to v%d", header, Alphabet)
+	}
+	return dna, version, nil
+}
+
+// ParseGenome returns the red, blue and green formulas of a genome, as
+// ParseDNA does for its DNA.
+func ParseGenome(genome string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function, error) {
+	dna, version, err := Decode(genome)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rf, bf, gf := parseDNA(version, dna)
+	return rf, bf, gf, nil
+}
@@ -1,82 +1,40 @@
 package dna4
 
 import (
-	"fmt"
-	"image"
 	"image-formula-find"
-	"image-formula-find/drawer1"
-	"image-formula-find/imageutil"
-	"image/draw"
+	"image-formula-find/evolve"
 )
 
-type Sorter struct {
-	Children []*Individual
-}
-
-func (s *Sorter) Len() int {
-	return len(s.Children)
-}
-
-func (s *Sorter) Less(i, j int) bool {
-	return s.Children[i].Score < s.Children[j].Score
-}
-
-func (s *Sorter) Swap(i, j int) {
-	s.Children[i], s.Children[j] = s.Children[j], s.Children[i]
-}
+// Required, BasicRequired and Sorter are those of evolve, named here for the
+// callers of GenerationProcess.
+type (
+	Required      = evolve.Required
+	BasicRequired = evolve.BasicRequired
+	Sorter        = evolve.Sorter[*Individual]
+)
 
 type Individual struct {
-	DNA             string
-	Lineage         string
-	Parent          []*Individual
-	Score           float64
-	Rf              *image_formula_find.Function
-	Bf              *image_formula_find.Function
-	Gf              *image_formula_find.Function
-	i               draw.Image
-	d               *drawer1.Drawer
-	FirstGeneration int
-}
-
-type Required interface {
-	PlotSize() image.Rectangle
-	SourceImage() image.Image
-}
-
-type BasicRequired struct {
-	R image.Rectangle
-	I image.Image
-}
-
-func (b *BasicRequired) PlotSize() image.Rectangle {
-	return b.R
-}
-
-func (b *BasicRequired) SourceImage() image.Image {
-	return b.I
+	evolve.Individual
+	Parent []*Individual
 }
 
-func (i *Individual) Calculate(required Required) {
-	i.Rf, i.Bf, i.Gf = ParseDNA(i.DNA)
-	rect := required.PlotSize()
-	i.d = &drawer1.Drawer{
-		RedFormula:   i.Rf,
-		BlueFormula:  i.Bf,
-		GreenFormula: i.Gf,
-		Width:        rect.Dx(),
-		Height:       rect.Dy(),
-	}
-	i.i = image.NewRGBA(rect.Bounds())
-	i.d.Render(i.i)
-	i.Score = imageutil.CalculateDistance(required.SourceImage(), i.i)
+// NewIndividual returns the individual of the DNA with its formulas, which
+// are decoded once and carried on it from then on.
+func NewIndividual(dna string) *Individual {
+	i := &Individual{Individual: evolve.Individual{DNA: dna}}
+	i.Rf, i.Bf, i.Gf = ParseDNA(dna)
+	return i
 }
 
+// CsvRow returns the genome, see Encode, the red, blue and green formulas,
+// the score and the viewport, which is empty for the default view, of the
+// individual.
 func (i *Individual) CsvRow() []string {
-	return []string{
-		i.DNA, i.Rf.String(), i.Bf.String(), i.Gf.String(), fmt.Sprintf("%0.2f", i.Score),
-	}
+	return i.CsvRowWith(image_formula_find.Text)
 }
 
-func (i *Individual) Image() image.Image {
-	return i.i
+// CsvRowWith is CsvRow with the formulas printed by print, one of
+// image_formula_find.Notations.
+func (i *Individual) CsvRowWith(print func(image_formula_find.Expression) string) []string {
+	return i.Row(Encode(i.DNA), print)
 }
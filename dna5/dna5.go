@@ -2,11 +2,11 @@ package dna5
 
 import (
 	"image-formula-find"
+	"image-formula-find/evolve"
 	"math"
 	"math/rand"
 	"sort"
 	"strings"
-	"sync"
 
 	"github.com/agnivade/levenshtein"
 )
@@ -35,16 +35,25 @@ func RndStr(length int) string {
 
 // ParseDNA splits the DNA into 3 channels (R, G, B) and parses each using the Stack Machine (RPN).
 func ParseDNA(dna string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
+	return parseDNA(Alphabet, dna)
+}
+
+// parseDNA is ParseDNA with the given version of the alphabet.
+func parseDNA(version int, dna string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
 	rd, gd, bd := SplitString3(dna)
-	rf := ParseFunction(rd)
-	gf := ParseFunction(gd)
-	bf := ParseFunction(bd)
+	rf := parseFunction(version, rd)
+	gf := parseFunction(version, gd)
+	bf := parseFunction(version, bd)
 	// Return in order expected by Individual: R, B, G
 	return rf, bf, gf
 }
 
 func ParseFunction(arg string) *image_formula_find.Function {
-	expr := ParseRPN(arg)
+	return parseFunction(Alphabet, arg)
+}
+
+func parseFunction(version int, arg string) *image_formula_find.Function {
+	expr := parseRPN(version, arg)
 	return &image_formula_find.Function{
 		Equals: &image_formula_find.Equals{
 			LHS: nil, // Removed "Y" assignment to match requested format
@@ -54,6 +63,12 @@ func ParseFunction(arg string) *image_formula_find.Function {
 }
 
 func ParseRPN(arg string) image_formula_find.Expression {
+	return parseRPN(Alphabet, arg)
+}
+
+// parseRPN is ParseRPN with the given version of the alphabet, in which the
+// opcodes introduced later push the constant (idx-44)/5.
+func parseRPN(version int, arg string) image_formula_find.Expression {
 	stack := []image_formula_find.Expression{}
 
 	push := func(e image_formula_find.Expression) {
@@ -67,12 +82,43 @@ func ParseRPN(arg string) image_formula_find.Expression {
 		stack = stack[:len(stack)-1]
 		return e
 	}
+	// binary replaces the top two entries with a two argument function of
+	// them, leaving a single entry as it is.
+	binary := func(name string) {
+		rhs := pop()
+		lhs := pop()
+		if lhs != nil && rhs != nil {
+			push(image_formula_find.NewDoubleFunction(name, lhs, rhs, false))
+		} else if rhs != nil {
+			push(rhs)
+		}
+	}
+	// ternary replaces the top three entries with a three argument
+	// function of them, leaving a shorter stack as it is.
+	ternary := func(name string) {
+		c := pop()
+		b := pop()
+		a := pop()
+		if a != nil {
+			push(image_formula_find.NewTripleFunction(name, a, b, c))
+			return
+		}
+		for _, e := range []image_formula_find.Expression{b, c} {
+			if e != nil {
+				push(e)
+			}
+		}
+	}
 
 	for _, char := range arg {
 		idx, ok := runeMapPos[char]
 		if !ok {
 			continue
 		}
+		if introduced(idx) > version {
+			push(&image_formula_find.Const{Value: float64(idx-44) / 5.0})
+			continue
+		}
 
 		switch idx {
 		// Vars (0-2)
@@ -101,6 +147,24 @@ func ParseRPN(arg string) image_formula_find.Expression {
 		case 10:
 			push(&image_formula_find.Const{Value: math.Pi})
 
+		// Conditionals (11-15)
+		case 11: // If
+			ternary("If")
+		case 12: // Step
+			rhs := pop()
+			lhs := pop()
+			if lhs != nil && rhs != nil {
+				push(image_formula_find.NewDoubleFunction("Step", lhs, rhs, false))
+			} else if rhs != nil {
+				push(rhs)
+			}
+		case 13: // Clamp
+			ternary("Clamp")
+		case 14: // Lerp
+			ternary("Lerp")
+		case 15: // Smoothstep
+			ternary("Smoothstep")
+
 		// Binary Ops (16-21)
 		case 16: // +
 			rhs := pop()
@@ -280,12 +344,49 @@ func ParseRPN(arg string) image_formula_find.Expression {
 				push(rhs)
 			}
 
-		default:
-			// Map other chars to small random constants based on index
-			// Indices not used: 11-15, 44-63.
-			// Let's use them for more constants.
-			v := float64(idx-44) / 5.0
-			push(&image_formula_find.Const{Value: v})
+		// Derived variables (44-49)
+		case 44:
+			push(&image_formula_find.Var{Var: "R"})
+		case 45:
+			push(&image_formula_find.Var{Var: "A"})
+		case 46:
+			push(&image_formula_find.Var{Var: "U"})
+		case 47:
+			push(&image_formula_find.Var{Var: "V"})
+		case 48:
+			push(&image_formula_find.Var{Var: "PX"})
+		case 49:
+			push(&image_formula_find.Var{Var: "PY"})
+
+		// Noise (50-58)
+		case 50: // Perlin
+			binary("Perlin")
+		case 51: // Perlin3
+			ternary("Perlin3")
+		case 52: // Simplex
+			binary("Simplex")
+		case 53: // Simplex3
+			ternary("Simplex3")
+		case 54: // ValueNoise
+			binary("ValueNoise")
+		case 55: // Worley
+			binary("Worley")
+		case 56: // Worley3
+			ternary("Worley3")
+		case 57: // Fbm
+			ternary("Fbm")
+		case 58: // Turbulence
+			ternary("Turbulence")
+
+		// Comparisons (59-63)
+		case 59, 60, 61, 62, 63:
+			rhs := pop()
+			lhs := pop()
+			if lhs != nil && rhs != nil {
+				push(&image_formula_find.Compare{Op: image_formula_find.Comparisons[idx-59], LHS: lhs, RHS: rhs})
+			} else if rhs != nil {
+				push(rhs)
+			}
 		}
 	}
 
@@ -429,9 +530,16 @@ const (
 
 func GenerationProcess(worker Required, lastGeneration []*Individual, generation int, newDNA chan string) []*Individual {
 	const mutations = 8
+	control := evolve.BloatOf(worker)
 	var children = make([]*Individual, 0, len(lastGeneration)*mutations+len(lastGeneration)*len(lastGeneration)+childrenCount+1)
 
 	seen := map[string]struct{}{}
+	// DNA strings that differ can still decode to the same formulas, those
+	// are only rendered once.
+	var phenotypes image_formula_find.Phenotypes
+	fresh := func(i *Individual) bool {
+		return phenotypes.Add(i.Rf, i.Bf, i.Gf)
+	}
 
 	for _, p := range lastGeneration {
 		dna := p.DNA
@@ -439,6 +547,9 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			continue
 		}
 		seen[dna] = struct{}{}
+		if !fresh(p) {
+			continue
+		}
 		children = append(children, p)
 	}
 
@@ -455,32 +566,31 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 			if !Valid(dna) {
 				continue
 			}
-			children = append(children, &Individual{
-				DNA:             dna,
-				Parent:          []*Individual{p},
-				FirstGeneration: generation,
-				Lineage:         p.DNA,
-			})
+			child := NewIndividual(dna)
+			if child.Degenerate(worker) || !fresh(child) {
+				continue
+			}
+			child.Parent = []*Individual{p}
+			child.FirstGeneration = generation
+			child.Lineage = p.DNA
+			children = append(children, child)
 		}
 	}
 
 	if len(lastGeneration) > 4 {
-		p1 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
-		p2 := lastGeneration[int(rand.Int31n(int32(len(lastGeneration))))]
+		p1 := evolve.Choose(control, lastGeneration)
+		p2 := evolve.Choose(control, lastGeneration)
 		dna := Breed(p1.DNA, p2.DNA)
 		if _, ok := seen[dna]; !ok {
 			seen[dna] = struct{}{}
 
 			if Valid(dna) {
-				if dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage {
-					children = append(children, &Individual{
-						DNA: dna,
-						Parent: []*Individual{
-							p1, p2,
-						},
-						Lineage:         dna,
-						FirstGeneration: generation,
-					})
+				child := NewIndividual(dna)
+				if !child.Degenerate(worker) && dna != p1.DNA && dna != p2.DNA && p1.Lineage != p2.Lineage && fresh(child) {
+					child.Parent = []*Individual{p1, p2}
+					child.Lineage = dna
+					child.FirstGeneration = generation
+					children = append(children, child)
 				}
 			}
 		}
@@ -498,26 +608,18 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 		if !Valid(dna) {
 			continue
 		}
-		children = append(children, &Individual{
-			DNA:             dna,
-			Lineage:         dna,
-			FirstGeneration: generation,
-		})
+		child := NewIndividual(dna)
+		if child.Degenerate(worker) || !fresh(child) {
+			continue
+		}
+		child.Lineage = dna
+		child.FirstGeneration = generation
+		children = append(children, child)
 	}
 
-	wg := sync.WaitGroup{}
-	for fi := range children {
-		wg.Add(1)
-		go func(i int, child *Individual) {
-			defer wg.Done()
-			child.Calculate(worker)
-		}(fi, children[fi])
-	}
-	wg.Wait()
+	children = evolve.ControlBloat(control, children, generation)
 
-	sort.Sort((&Sorter{
-		Children: children,
-	}))
+	children = evolve.Rank(worker, children)
 
 	lastGeneration = make([]*Individual, 0, childrenCount)
 	for len(lastGeneration) < childrenCount && len(children) > 0 {
@@ -543,6 +645,7 @@ func GenerationProcess(worker Required, lastGeneration []*Individual, generation
 	}
 	sort.Sort((&Sorter{
 		Children: lastGeneration,
+		Bloat:    control,
 	}))
 	return lastGeneration
 }
@@ -0,0 +1,186 @@
+package drawer1
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	image_formula_find "image-formula-find"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+)
+
+// ErrNotPeriodic is returned for a looping animation of formulas that do not
+// repeat over its range of T.
+var ErrNotPeriodic = errors.New("formulas do not repeat over the time range")
+
+// Animation renders the formulas of a Drawer as a sequence of frames, with T
+// running over a range.
+type Animation struct {
+	Drawer *Drawer
+	// Start and End are the range of T. Frame i of n is drawn at Start plus
+	// i/n of the range, so the frame that would follow the last is at End.
+	Start, End float64
+	// Frames is the number of frames.
+	Frames int
+	// Delay is the time each frame is shown, in hundredths of a second.
+	Delay int
+	// Loop asks for an animation that loops seamlessly. Rendering fails with
+	// ErrNotPeriodic unless the formulas at End are those at Start.
+	Loop bool
+}
+
+// Times returns the values of T of the frames.
+func (a *Animation) Times() []float64 {
+	times := make([]float64, max(a.Frames, 0))
+	for i := range times {
+		times[i] = a.Start + (a.End-a.Start)*float64(i)/float64(a.Frames)
+	}
+	return times
+}
+
+// Periodic reports whether every formula repeats over the range of T across
+// the image of the Drawer, through its viewport, see
+// image_formula_find.Function.PeriodicWith.
+func (a *Animation) Periodic() bool {
+	env := a.Drawer.env()
+	for _, f := range []*image_formula_find.Function{a.Drawer.RedFormula, a.Drawer.GreenFormula, a.Drawer.BlueFormula} {
+		if f != nil && !f.PeriodicWith(a.Start, a.End-a.Start, &env) {
+			return false
+		}
+	}
+	return true
+}
+
+// Render draws the frames at the Width and Height of the Drawer, leaving the
+// Drawer at its original T.
+func (a *Animation) Render() ([]*image.RGBA, error) {
+	d := a.Drawer
+	if a.Frames <= 0 || d.Width <= 0 || d.Height <= 0 {
+		return nil, fmt.Errorf("invalid animation of %d frames of %dx%d", a.Frames, d.Width, d.Height)
+	}
+	if math.IsNaN(a.Start) || math.IsInf(a.Start, 0) || math.IsNaN(a.End) || math.IsInf(a.End, 0) {
+		return nil, fmt.Errorf("invalid time range %v to %v", a.Start, a.End)
+	}
+	if a.Loop && !a.Periodic() {
+		return nil, ErrNotPeriodic
+	}
+	defer func(t float64) { d.T = t }(d.T)
+	frames := make([]*image.RGBA, a.Frames)
+	for i, t := range a.Times() {
+		d.T = t
+		frames[i] = image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+		d.Render(frames[i])
+	}
+	return frames, nil
+}
+
+// EncodeGIF writes the animation as a GIF repeating forever, dithered to
+// the Plan 9 palette.
+func (a *Animation) EncodeGIF(w io.Writer) error {
+	frames, err := a.Render()
+	if err != nil {
+		return err
+	}
+	g := &gif.GIF{}
+	for _, each := range frames {
+		p := image.NewPaletted(each.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(p, each.Bounds(), each, image.Point{})
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, a.Delay)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// EncodeAPNG writes the animation as an animated PNG repeating forever.
+// Viewers without APNG support show the first frame.
+func (a *Animation) EncodeAPNG(w io.Writer) error {
+	frames, err := a.Render()
+	if err != nil {
+		return err
+	}
+	width, height := uint32(a.Drawer.Width), uint32(a.Drawer.Height)
+	p := &apngWriter{w: w}
+	p.write([]byte("\x89PNG\r\n\x1a\n"))
+	// 8 bit RGBA, deflate, filter method 0, not interlaced.
+	p.chunk("IHDR", be32(width), be32(height), []byte{8, 6, 0, 0, 0})
+	p.chunk("acTL", be32(uint32(len(frames))), be32(0))
+	var seq uint32
+	for i, each := range frames {
+		p.chunk("fcTL", be32(seq), be32(width), be32(height), be32(0), be32(0),
+			be16(uint16(a.Delay)), be16(100), []byte{0, 0})
+		seq++
+		data, err := compressFrame(each)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			p.chunk("IDAT", data)
+			continue
+		}
+		p.chunk("fdAT", be32(seq), data)
+		seq++
+	}
+	p.chunk("IEND")
+	return p.err
+}
+
+// apngWriter writes PNG chunks, keeping the first error.
+type apngWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *apngWriter) write(b []byte) {
+	if p.err == nil {
+		_, p.err = p.w.Write(b)
+	}
+}
+
+func (p *apngWriter) chunk(kind string, parts ...[]byte) {
+	data := bytes.Join(parts, nil)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	p.write(be32(uint32(len(data))))
+	p.write([]byte(kind))
+	p.write(data)
+	p.write(be32(crc.Sum32()))
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func be16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+// compressFrame returns the image data of a frame, rows of non premultiplied
+// RGBA without filtering.
+func compressFrame(img *image.RGBA) ([]byte, error) {
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	b := img.Bounds()
+	row := make([]byte, 1+4*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA)
+			copy(row[1+4*(x-b.Min.X):], []byte{c.R, c.G, c.B, c.A})
+		}
+		if _, err := z.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package drawer1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image-formula-find"
+	"image/gif"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func animation(t *testing.T, formula string) *Animation {
+	f, err := image_formula_find.ParseFunction(formula)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", formula, err)
+	}
+	return &Animation{
+		Drawer: &Drawer{RedFormula: f, GreenFormula: f, BlueFormula: f, Width: 16, Height: 12},
+		Start:  0,
+		End:    2 * math.Pi,
+		Frames: 5,
+		Delay:  4,
+	}
+}
+
+func TestAnimationRender(t *testing.T) {
+	a := animation(t, "0 = sin(x + t) * 100 + 120")
+	frames, err := a.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != a.Frames {
+		t.Fatalf("Got %d frames, want %d", len(frames), a.Frames)
+	}
+	for i, tt := range a.Times() {
+		if want := 2 * math.Pi * float64(i) / 5; tt != want {
+			t.Errorf("Frame %d at T %v, want %v", i, tt, want)
+		}
+		d := &Drawer{RedFormula: a.Drawer.RedFormula, GreenFormula: a.Drawer.GreenFormula, BlueFormula: a.Drawer.BlueFormula, Width: 16, Height: 12, T: tt}
+		want := image.NewRGBA(frames[i].Bounds())
+		d.Render(want)
+		if !bytes.Equal(frames[i].Pix, want.Pix) {
+			t.Errorf("Frame %d differs from a render at T %v", i, tt)
+		}
+	}
+	if bytes.Equal(frames[0].Pix, frames[1].Pix) {
+		t.Error("Frames do not change with T")
+	}
+	if a.Drawer.T != 0 {
+		t.Errorf("Drawer left at T %v", a.Drawer.T)
+	}
+}
+
+func TestAnimationLoop(t *testing.T) {
+	a := animation(t, "0 = sin(x + t) * 100 + 120")
+	a.Loop = true
+	if _, err := a.Render(); err != nil {
+		t.Errorf("Periodic formula: %v", err)
+	}
+	a.End = math.Pi
+	if _, err := a.Render(); !errors.Is(err, ErrNotPeriodic) {
+		t.Errorf("Half a period: got %v, want ErrNotPeriodic", err)
+	}
+	a.Frames = 0
+	if _, err := a.Render(); err == nil {
+		t.Error("Expected an error for no frames")
+	}
+
+	// T only shows for X over 20, so the loop depends on the view.
+	a = animation(t, "0 = max(x + -20, 0) * t")
+	a.Loop = true
+	if !a.Periodic() {
+		t.Errorf("%s does not loop in the default view", a.Drawer.RedFormula)
+	}
+	a.Drawer.Viewport = Viewport{CenterX: 30}
+	if a.Periodic() {
+		t.Errorf("%s loops in %v", a.Drawer.RedFormula, a.Drawer.Viewport)
+	}
+}
+
+func TestAnimationGIF(t *testing.T) {
+	a := animation(t, "0 = sin(x + t) * 100 + 120")
+	var buf bytes.Buffer
+	if err := a.EncodeGIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != a.Frames || g.Delay[0] != a.Delay || g.Image[0].Bounds() != image.Rect(0, 0, 16, 12) {
+		t.Errorf("Got %d frames of %v, delay %v", len(g.Image), g.Image[0].Bounds(), g.Delay)
+	}
+}
+
+func TestAnimationAPNG(t *testing.T) {
+	a := animation(t, "0 = sin(x + t) * 100 + 120")
+	var buf bytes.Buffer
+	if err := a.EncodeAPNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// The first frame is the default image of the PNG.
+	first, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := a.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := first.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r1, g1, b1, a1 := first.At(x, y).RGBA(); [4]uint32{r1, g1, b1, a1} != rgba(frames[0], x, y) {
+				t.Fatalf("First frame differs at (%d, %d)", x, y)
+			}
+		}
+	}
+	// Walk the chunks, checking the frame count and sequence numbers.
+	var controls, seq uint32
+	chunks := map[string]int{}
+	for p := 8; p < len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		kind := string(data[p+4 : p+8])
+		body := data[p+8 : p+8+n]
+		chunks[kind]++
+		switch kind {
+		case "acTL":
+			controls = binary.BigEndian.Uint32(body)
+		case "fcTL", "fdAT":
+			if got := binary.BigEndian.Uint32(body); got != seq {
+				t.Errorf("%s sequence number %d, want %d", kind, got, seq)
+			}
+			seq++
+		}
+		p += 12 + n
+	}
+	if controls != uint32(a.Frames) || chunks["fcTL"] != a.Frames || chunks["fdAT"] != a.Frames-1 || chunks["IDAT"] != 1 {
+		t.Errorf("acTL %d frames, chunks %v", controls, chunks)
+	}
+}
+
+func rgba(img *image.RGBA, x, y int) [4]uint32 {
+	r, g, b, a := img.At(x, y).RGBA()
+	return [4]uint32{r, g, b, a}
+}
@@ -6,8 +6,10 @@ import (
 	"image/color"
 	"image/draw"
 	"log"
+	"maps"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 type Drawer struct {
@@ -15,6 +17,59 @@ type Drawer struct {
 	BlueFormula   *image_formula_find.Function
 	GreenFormula  *image_formula_find.Function
 	Width, Height int
+	// Params are the values of the named parameters in the formulas, by
+	// upper case name.
+	Params map[string]float64
+	// T is the time the formulas are drawn at.
+	T float64
+	// Policy is the numeric policy the formulas are evaluated with. Under
+	// Poison, pixels with a channel that is NaN or infinite are drawn
+	// transparent black.
+	Policy image_formula_find.Policy
+	// Viewport is the window of formula coordinates the image shows, the
+	// default view when zero.
+	Viewport Viewport
+
+	mu       sync.Mutex
+	compiled *programs
+}
+
+// programs caches the compiled channel formulas along with the formulas and
+// bindings they were compiled with, so a Drawer whose formulas, size or
+// parameters change recompiles.
+type programs struct {
+	rf, bf, gf *image_formula_find.Function
+	env        image_formula_find.State
+	r, b, g    image_formula_find.Program
+}
+
+// env returns the time, image size, view, parameters and policy the formulas
+// are evaluated with.
+func (d *Drawer) env() image_formula_find.State {
+	return image_formula_find.State{T: d.T, Width: d.Width, Height: d.Height, Frame: d.Viewport.frame(d.Width, d.Height), Params: d.Params, Policy: d.Policy}
+}
+
+// Compile returns the compiled red, blue and green programs, compiling them
+// on first use or when a formula or binding has changed.
+func (d *Drawer) Compile() (r, b, g *image_formula_find.Program) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, env := d.compiled, d.env()
+	if p == nil || p.rf != d.RedFormula || p.bf != d.BlueFormula || p.gf != d.GreenFormula ||
+		p.env.Width != d.Width || p.env.Height != d.Height || p.env.Frame != env.Frame || p.env.Policy != d.Policy || !maps.Equal(p.env.Params, d.Params) {
+		env.Params = maps.Clone(d.Params)
+		p = &programs{
+			rf:  d.RedFormula,
+			bf:  d.BlueFormula,
+			gf:  d.GreenFormula,
+			env: env,
+			r:   image_formula_find.CompileWith(d.RedFormula, &env),
+			b:   image_formula_find.CompileWith(d.BlueFormula, &env),
+			g:   image_formula_find.CompileWith(d.GreenFormula, &env),
+		}
+		d.compiled = p
+	}
+	return &p.r, &p.b, &p.g
 }
 
 func (d *Drawer) Convert(c color.Color) color.Color {
@@ -36,17 +91,16 @@ func (d *Drawer) At(x, y int) (c color.Color) {
 			c = color.RGBA{0, 0, 0, 255}
 		}
 	}()
-	sx := float64(x)
-	sy := float64(y)
-	if d.Width > 0 && d.Height > 0 {
-		sx = (float64(x)/float64(d.Width))*20.0 - 10.0
-		sy = (float64(y)/float64(d.Height))*20.0 - 10.0
-	}
+	sx, sy := d.Viewport.Transform(d.Width, d.Height).Apply(float64(x), float64(y))
 
-	rr, _, _ := d.RedFormula.Evaluate(sx, sy, 0)
-	br, _, _ := d.BlueFormula.Evaluate(sx, sy, 0)
-	gr, _, _ := d.GreenFormula.Evaluate(sx, sy, 0)
+	rp, bp, gp := d.Compile()
+	rr := rp.Evaluate(sx, sy, d.T)
+	br := bp.Evaluate(sx, sy, d.T)
+	gr := gp.Evaluate(sx, sy, d.T)
 
+	if d.Policy == image_formula_find.Poison && !(image_formula_find.Valid(rr) && image_formula_find.Valid(gr) && image_formula_find.Valid(br)) {
+		return color.RGBA{}
+	}
 	return color.RGBA{
 		R: uint8(rr),
 		G: uint8(gr),
@@ -55,74 +109,236 @@ func (d *Drawer) At(x, y int) (c color.Color) {
 	}
 }
 
+// Diagnostics describes the numeric health of a render.
+type Diagnostics struct {
+	// Pixels is the number of pixels rendered.
+	Pixels int
+	// Invalid counts the pixels with a channel that is NaN or infinite.
+	// There are none under the Protected policy.
+	Invalid int
+	// Channels counts the NaN and infinite values of each channel formula,
+	// red, green and blue.
+	Channels [3]int
+}
+
 // Render draws the formula to the destination image in parallel.
-// It assumes the destination bounds map 1:1 to the Drawer's coordinate space (0,0 to Width,Height).
+// It assumes the destination bounds map 1:1 to the Drawer's coordinate space (0,0 to Width,Height),
+// which the Viewport maps to formula coordinates.
+//
+// The image is split into square tiles which are bounded with interval
+// arithmetic and subdivided quadtree style. Channels that are shown to be a
+// single value over a tile are filled without evaluating any pixels, the rest
+// are evaluated a row at a time. The result is identical to evaluating every
+// pixel.
 func (d *Drawer) Render(dst draw.Image) {
+	d.RenderDiagnostics(dst)
+}
+
+// RenderDiagnostics is Render, also counting the invalid pixels.
+func (d *Drawer) RenderDiagnostics(dst draw.Image) Diagnostics {
 	bounds := dst.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	minX := bounds.Min.X
-	minY := bounds.Min.Y
+
+	rp, bp, gp := d.Compile()
+	view := d.Viewport.Transform(d.Width, d.Height)
+	r := &renderer{
+		env:      d.env(),
+		view:     view,
+		bounded:  d.Policy != image_formula_find.Protected,
+		dst:      dst,
+		min:      bounds.Min,
+		formulas: [3]*image_formula_find.Function{d.RedFormula, d.GreenFormula, d.BlueFormula},
+		programs: [3]*image_formula_find.Program{rp, gp, bp},
+	}
+	r.rgba, _ = dst.(*image.RGBA)
+	// Calculate the scaled coordinates once, they are the same for every tile
+	r.xs, r.ys = view.axes(width, height)
+
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	tiles := int64(tilesX * tilesY)
 
 	numWorkers := runtime.NumCPU()
 	if numWorkers < 1 {
 		numWorkers = 1
 	}
 
-	// We split the work into horizontal bands
-	rowsPerWorker := (height + numWorkers - 1) / numWorkers
-
+	var next int64
+	var mu sync.Mutex
+	diagnostics := Diagnostics{Pixels: width * height}
 	var wg sync.WaitGroup
 	wg.Add(numWorkers)
-
 	for i := 0; i < numWorkers; i++ {
-		startY := i * rowsPerWorker
-		endY := (i + 1) * rowsPerWorker
-		if endY > height {
-			endY = height
-		}
-		// If startY >= height (can happen if more workers than rows), skip
-		if startY >= height {
-			wg.Done()
-			continue
-		}
-
-		go func(y0, y1 int) {
+		go func() {
 			defer wg.Done()
 			defer func() {
 				if r := recover(); r != nil {
 					log.Println("Recovered in Render worker:", r)
 				}
 			}()
-			for y := y0; y < y1; y++ {
-				// Calculate sy (scaled Y)
-				sy := float64(y)
-				if d.Height > 0 {
-					sy = (float64(y) / float64(d.Height)) * 20.0 - 10.0
+			var rows [3][]float64
+			for c := range rows {
+				rows[c] = make([]float64, tileSize)
+			}
+			var counts Diagnostics
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				diagnostics.Invalid += counts.Invalid
+				for c := range counts.Channels {
+					diagnostics.Channels[c] += counts.Channels[c]
 				}
+			}()
+			for {
+				t := atomic.AddInt64(&next, 1) - 1
+				if t >= tiles {
+					return
+				}
+				x0 := int(t%int64(tilesX)) * tileSize
+				y0 := int(t/int64(tilesX)) * tileSize
+				r.tile(x0, y0, min(x0+tileSize, width), min(y0+tileSize, height), [3]bool{}, [3]uint8{}, &rows, &counts)
+			}
+		}()
+	}
+	wg.Wait()
+	return diagnostics
+}
 
-				for x := 0; x < width; x++ {
-					// Calculate sx (scaled X)
-					sx := float64(x)
-					if d.Width > 0 {
-						sx = (float64(x) / float64(d.Width)) * 20.0 - 10.0
-					}
+const (
+	// tileSize is the size of the tiles Render hands to its workers.
+	tileSize = 128
+	// leafSize is the size below which tiles are no longer subdivided.
+	leafSize = 16
+)
+
+// renderer holds the state Render shares between its workers. Channels are
+// ordered red, green, blue.
+type renderer struct {
+	env  image_formula_find.State
+	view Transform
+	// bounded is set when the interval bounds of the formulas hold, which
+	// they do not for the protected operators.
+	bounded  bool
+	dst      draw.Image
+	rgba     *image.RGBA
+	min      image.Point
+	formulas [3]*image_formula_find.Function
+	programs [3]*image_formula_find.Program
+	// xs and ys are the formula coordinates of the columns and rows, when
+	// the view is not rotated.
+	xs, ys []float64
+}
+
+// tile renders the pixels [x0, x1) × [y0, y1), adding the invalid pixels to
+// counts. flat marks the channels already known to be the value in fill over
+// the whole tile.
+func (r *renderer) tile(x0, y0, x1, y1 int, flat [3]bool, fill [3]uint8, rows *[3][]float64, counts *Diagnostics) {
+	if x0 >= x1 || y0 >= y1 {
+		return
+	}
+	X, Y := r.view.Bounds(float64(x0), float64(y0), float64(x1-1), float64(y1-1))
+	state := &image_formula_find.IntervalState{
+		X: X,
+		Y: Y,
+		T: image_formula_find.Point(r.env.T),
 
-					// Evaluate formulas
-					// Note: Evaluate is assumed thread-safe (pure function)
-					rr, _, _ := d.RedFormula.Evaluate(sx, sy, 0)
-					br, _, _ := d.BlueFormula.Evaluate(sx, sy, 0)
-					gr, _, _ := d.GreenFormula.Evaluate(sx, sy, 0)
-
-					dst.Set(minX+x, minY+y, color.RGBA{
-						R: uint8(rr),
-						G: uint8(gr),
-						B: uint8(br),
-						A: 255,
-					})
+		Width:  r.env.Width,
+		Height: r.env.Height,
+		Frame:  r.env.Frame,
+		Params: r.env.Params,
+	}
+	// Subdividing roughly halves the width of a bound, so only bother when
+	// the bounds are narrow enough to become a single value before reaching
+	// leafSize.
+	size := max(x1-x0, y1-y0)
+	done, promising := true, size > leafSize && r.bounded
+	for c := range flat {
+		if !flat[c] && r.bounded {
+			b := Bound(r.formulas[c], state)
+			fill[c], flat[c] = Uniform(b)
+			promising = promising && (flat[c] || b.Hi-b.Lo < float64(2*size/leafSize))
+		}
+		done = done && flat[c]
+	}
+	if !done && promising {
+		mx, my := (x0+x1+1)/2, (y0+y1+1)/2
+		r.tile(x0, y0, mx, my, flat, fill, rows, counts)
+		r.tile(mx, y0, x1, my, flat, fill, rows, counts)
+		r.tile(x0, my, mx, y1, flat, fill, rows, counts)
+		r.tile(mx, my, x1, y1, flat, fill, rows, counts)
+		return
+	}
+	n := x1 - x0
+	for y := y0; y < y1; y++ {
+		// Evaluate the remaining channels a row at a time
+		// Note: Programs are immutable and safe to share between workers
+		for c := range flat {
+			if !flat[c] {
+				evaluateRow(r.programs[c], r.view, r.xs, r.ys, x0, y, r.env.T, rows[c][:n])
+			}
+		}
+		for i := 0; i < n; i++ {
+			var v [3]uint8
+			valid := true
+			for c := range v {
+				v[c] = fill[c]
+				if !flat[c] {
+					v[c] = uint8(rows[c][i])
+					if !image_formula_find.Valid(rows[c][i]) {
+						counts.Channels[c]++
+						valid = false
+					}
 				}
 			}
-		}(startY, endY)
+			c := color.RGBA{
+				R: v[0],
+				G: v[1],
+				B: v[2],
+				A: 255,
+			}
+			if !valid {
+				counts.Invalid++
+				if r.env.Policy == image_formula_find.Poison {
+					c = color.RGBA{}
+				}
+			}
+			if r.rgba != nil {
+				r.rgba.SetRGBA(r.min.X+x0+i, r.min.Y+y, c)
+			} else {
+				r.dst.Set(r.min.X+x0+i, r.min.Y+y, c)
+			}
+		}
 	}
-	wg.Wait()
+}
+
+// Bound returns a guaranteed range for the values of a channel formula over
+// the ranges in state. Missing formulas render as 0.
+func Bound(f *image_formula_find.Function, state *image_formula_find.IntervalState) image_formula_find.Interval {
+	if f == nil || f.Equals == nil {
+		return image_formula_find.Point(0)
+	}
+	i, _ := f.EvaluateInterval(state)
+	return i
+}
+
+// Uniform returns the channel value every value in the bound converts to, if
+// there is a single one.
+func Uniform(i image_formula_find.Interval) (uint8, bool) {
+	if i.NaN || i.Empty() || i.Lo < 0 || i.Hi >= 256 || uint8(i.Lo) != uint8(i.Hi) {
+		return 0, false
+	}
+	return uint8(i.Lo), true
+}
+
+// Degenerate reports whether a channel formula is of no use over the whole
+// of an image of the given size drawn through view, at T 0. It is constant
+// when every pixel gets the same value and saturated when no pixel gets a
+// value in the 0 to 255 range, leaving the colour to the float to uint8
+// conversion. An image of no size is taken as the default view.
+func Degenerate(f *image_formula_find.Function, view Viewport, width, height int) (constant, saturated bool) {
+	i := Bound(f, view.whole(width, height))
+	_, constant = Uniform(i)
+	saturated = !i.Empty() && (i.Hi <= -1 || i.Lo >= 256) || i.Empty() && i.NaN
+	return
 }
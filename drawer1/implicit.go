@@ -0,0 +1,243 @@
+package drawer1
+
+import (
+	"image"
+	image_formula_find "image-formula-find"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Implicit draws a formula as the equation it is rather than as a channel:
+// the curve where its two sides are equal, the zero set of RHS - LHS, or the
+// shape where the left side is less than the right. A formula without a left
+// side is read as RHS = 0. The equation is drawn black on white with
+// antialiased edges.
+//
+// The distance of a pixel from the curve is estimated as |f| / |∇f|, where f
+// is RHS - LHS, in pixels. The gradient is taken from the symbolic
+// derivatives of the formula or, for formulas with no known derivative, by
+// central differences half a pixel either side along the rows and columns.
+type Implicit struct {
+	Formula       *image_formula_find.Function
+	Width, Height int
+	// Params are the values of the named parameters in the formula, by upper
+	// case name.
+	Params map[string]float64
+	// T is the time the formula is drawn at.
+	T float64
+	// Policy is the numeric policy the formula is evaluated with. Pixels
+	// where it is NaN or infinite are drawn white, or transparent black
+	// under Poison.
+	Policy image_formula_find.Policy
+	// Thickness is the width of the curve in pixels, 1 when not positive.
+	Thickness float64
+	// Fill draws the shape where the left side is less than the right, with
+	// the curve as its edge, instead of the curve.
+	Fill bool
+	// Viewport is the window of formula coordinates the image shows, the
+	// default view when zero.
+	Viewport Viewport
+}
+
+// implicitRenderer holds the state Implicit.Render shares between its
+// workers.
+type implicitRenderer struct {
+	*Implicit
+	dst  draw.Image
+	rgba *image.RGBA
+	min  image.Point
+	f    image_formula_find.Program
+	// dx and dy are the derivatives of the formula, when symbolic is set.
+	dx, dy   image_formula_find.Program
+	symbolic bool
+	view     Transform
+	// dxu and dyu are the steps in formula coordinates of one pixel along a
+	// row, dxv and dyv of one down a column.
+	dxu, dyu, dxv, dyv float64
+	// xs and ys are the formula coordinates of the columns and rows, when
+	// the view is not rotated.
+	xs, ys []float64
+	// left and right are xs moved half a pixel, for central differences.
+	left, right []float64
+}
+
+// Render draws the equation to the destination image in parallel, returning
+// the number of pixels where the formula is NaN or infinite. Like
+// Drawer.Render it assumes the destination bounds map 1:1 to the
+// coordinate space (0,0 to Width,Height).
+func (im *Implicit) Render(dst draw.Image) (invalid int) {
+	bounds := dst.Bounds()
+	env := image_formula_find.State{T: im.T, Width: im.Width, Height: im.Height, Frame: im.Viewport.frame(im.Width, im.Height), Params: im.Params, Policy: im.Policy}
+	r := &implicitRenderer{
+		Implicit: im,
+		dst:      dst,
+		min:      bounds.Min,
+		f:        image_formula_find.CompileWith(im.Formula, &env),
+		view:     im.Viewport.Transform(im.Width, im.Height),
+	}
+	r.rgba, _ = dst.(*image.RGBA)
+	r.dxu, r.dyu, r.dxv, r.dyv = r.view.Pixel()
+	r.xs, r.ys = r.view.axes(bounds.Dx(), bounds.Dy())
+	if im.Formula != nil && im.Formula.Equals != nil {
+		dx, errX := im.Formula.Derive("X")
+		dy, errY := im.Formula.Derive("Y")
+		if errX == nil && errY == nil {
+			r.dx = image_formula_find.CompileWith(dx, &env)
+			r.dy = image_formula_find.CompileWith(dy, &env)
+			r.symbolic = true
+		}
+	}
+	if !r.symbolic && !r.view.Rotated() {
+		r.left = make([]float64, len(r.xs))
+		r.right = make([]float64, len(r.xs))
+		for i, x := range r.xs {
+			r.left[i], r.right[i] = x-r.dxu/2, x+r.dxu/2
+		}
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	numWorkers := max(runtime.NumCPU(), 1)
+	var next, total int64
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("Recovered in Implicit.Render worker:", r)
+				}
+			}()
+			rows := make([][]float64, 5)
+			for c := range rows {
+				rows[c] = make([]float64, width)
+			}
+			for {
+				y := atomic.AddInt64(&next, 1) - 1
+				if y >= int64(height) {
+					return
+				}
+				atomic.AddInt64(&total, int64(r.row(int(y), rows)))
+			}
+		}()
+	}
+	wg.Wait()
+	return int(total)
+}
+
+// row draws row y, returning the number of invalid pixels in it. rows are
+// scratch space for the formula and its gradient.
+func (r *implicitRenderer) row(y int, rows [][]float64) (invalid int) {
+	f, gx, gy := rows[0], rows[1], rows[2]
+	evaluateRow(&r.f, r.view, r.xs, r.ys, 0, y, r.T, f)
+	if r.symbolic {
+		r.derivatives(y, rows)
+	} else {
+		r.differences(y, rows)
+	}
+	thickness := r.Thickness
+	if thickness <= 0 {
+		thickness = 1
+	}
+	for i := range f {
+		c := color.RGBA{A: 255}
+		if !image_formula_find.Valid(f[i]) {
+			invalid++
+			c = color.RGBA{255, 255, 255, 255}
+			if r.Policy == image_formula_find.Poison {
+				c = color.RGBA{}
+			}
+			r.set(i, y, c)
+			continue
+		}
+		// The distance in pixels to the curve, positive on the side where
+		// the left side is less than the right. Without a usable gradient
+		// only the pixels exactly on the curve are on it.
+		gradient := math.Hypot(gx[i], gy[i])
+		distance := f[i] / gradient
+		if !image_formula_find.Valid(gradient) || gradient == 0 {
+			distance = 0
+			if f[i] != 0 {
+				distance = math.Copysign(math.Inf(1), f[i])
+			}
+		}
+		coverage := thickness/2 + 0.5 - math.Abs(distance)
+		if r.Fill {
+			coverage = 0.5 + distance
+		}
+		v := uint8(math.Round(255 * (1 - min(max(coverage, 0), 1))))
+		c.R, c.G, c.B = v, v, v
+		r.set(i, y, c)
+	}
+	return invalid
+}
+
+// derivatives sets the gradient rows, the change of the formula over one
+// pixel along the row and down the column, from its symbolic derivatives.
+func (r *implicitRenderer) derivatives(y int, rows [][]float64) {
+	gx, gy := rows[1], rows[2]
+	evaluateRow(&r.dx, r.view, r.xs, r.ys, 0, y, r.T, gx)
+	evaluateRow(&r.dy, r.view, r.xs, r.ys, 0, y, r.T, gy)
+	rotated := r.view.Rotated()
+	for i := range gx {
+		fx, fy := gx[i], gy[i]
+		gx[i], gy[i] = fx*r.dxu, fy*r.dyv
+		if rotated {
+			gx[i] += fy * r.dyu
+			gy[i] += fx * r.dxv
+		}
+	}
+}
+
+// differences sets the gradient rows from central differences of the
+// formula, for formulas with no known derivative.
+func (r *implicitRenderer) differences(y int, rows [][]float64) {
+	gx, gy, lo, hi := rows[1], rows[2], rows[3], rows[4]
+	if r.view.Rotated() {
+		at := func(px, py float64) float64 {
+			X, Y := r.view.Apply(px, py)
+			return r.f.Evaluate(X, Y, r.T)
+		}
+		for i := range gx {
+			px, py := float64(i), float64(y)
+			gx[i] = at(px+0.5, py) - at(px-0.5, py)
+			gy[i] = at(px, py+0.5) - at(px, py-0.5)
+		}
+		return
+	}
+	r.f.EvaluateRow(r.left, r.ys[y], r.T, lo)
+	r.f.EvaluateRow(r.right, r.ys[y], r.T, hi)
+	for i := range r.xs {
+		gx[i] = hi[i] - lo[i]
+	}
+	r.f.EvaluateRow(r.xs, r.ys[y]-r.dyv/2, r.T, lo)
+	r.f.EvaluateRow(r.xs, r.ys[y]+r.dyv/2, r.T, hi)
+	for i := range r.xs {
+		gy[i] = hi[i] - lo[i]
+	}
+}
+
+func (r *implicitRenderer) set(x, y int, c color.RGBA) {
+	if r.rgba != nil {
+		r.rgba.SetRGBA(r.min.X+x, r.min.Y+y, c)
+	} else {
+		r.dst.Set(r.min.X+x, r.min.Y+y, c)
+	}
+}
+
+// Featureless reports whether an equation provably has no solution over the
+// whole of an image of the given size drawn through view, at T 0, so
+// Implicit draws it as a blank image or, filled, a solid one. An image of no
+// size is taken as the default view.
+func Featureless(f *image_formula_find.Function, view Viewport, width, height int) bool {
+	i := Bound(f, view.whole(width, height))
+	if i.Empty() {
+		return i.NaN
+	}
+	return !i.NaN && (i.Lo > 0 || i.Hi < 0)
+}
@@ -0,0 +1,130 @@
+package drawer1
+
+import (
+	"image"
+	"image-formula-find"
+	"image/color"
+	"testing"
+)
+
+func renderImplicit(t *testing.T, im *Implicit, formula string) (*image.RGBA, int) {
+	t.Helper()
+	f, err := image_formula_find.ParseFunction(formula)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", formula, err)
+	}
+	im.Formula = f
+	dst := image.NewRGBA(image.Rect(0, 0, im.Width, im.Height))
+	return dst, im.Render(dst)
+}
+
+// ink counts the darkness of an image in pixels.
+func ink(img *image.RGBA) float64 {
+	total := 0.0
+	for i := 0; i < len(img.Pix); i += 4 {
+		total += 1 - float64(img.Pix[i])/255
+	}
+	return total
+}
+
+func TestImplicitCurve(t *testing.T) {
+	// A circle of radius 5 is a quarter of the width across, 25 pixels.
+	img, invalid := renderImplicit(t, &Implicit{Width: 100, Height: 100}, "x^2 + y^2 = 25")
+	if invalid != 0 {
+		t.Errorf("%d invalid pixels", invalid)
+	}
+	for _, p := range []image.Point{{75, 50}, {25, 50}, {50, 75}, {50, 25}} {
+		if c := img.RGBAAt(p.X, p.Y); c != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("%v on the curve is %v", p, c)
+		}
+	}
+	for _, p := range []image.Point{{50, 50}, {0, 0}, {72, 50}, {78, 50}} {
+		if c := img.RGBAAt(p.X, p.Y); c != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("%v off the curve is %v", p, c)
+		}
+	}
+	// A curve one pixel wide and 2π25 long.
+	if got := ink(img); got < 150 || got > 165 {
+		t.Errorf("Curve of %v pixels, want about 157", got)
+	}
+	thick, _ := renderImplicit(t, &Implicit{Width: 100, Height: 100, Thickness: 3}, "x^2 + y^2 = 25")
+	if got := ink(thick); got < 450 || got > 495 {
+		t.Errorf("Thick curve of %v pixels, want about 471", got)
+	}
+}
+
+func TestImplicitFill(t *testing.T) {
+	img, _ := renderImplicit(t, &Implicit{Width: 100, Height: 100, Fill: true}, "x^2 + y^2 = 25")
+	if c := img.RGBAAt(50, 50); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("Centre is %v", c)
+	}
+	if c := img.RGBAAt(10, 10); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Corner is %v", c)
+	}
+	// π25² pixels.
+	if got := ink(img); got < 1950 || got > 1980 {
+		t.Errorf("Disc of %v pixels, want about 1963", got)
+	}
+}
+
+func TestImplicitDifferences(t *testing.T) {
+	// px has no known derivative, so the gradient is estimated.
+	for _, fill := range []bool{false, true} {
+		want, _ := renderImplicit(t, &Implicit{Width: 60, Height: 40, Fill: fill, Thickness: 2}, "x^2 * 0.5 + y^2 = 20 + sin(x)")
+		got, _ := renderImplicit(t, &Implicit{Width: 60, Height: 40, Fill: fill, Thickness: 2}, "x^2 * 0.5 + y^2 = 20 + sin(x) + 0 * px")
+		for i := range want.Pix {
+			if d := int(want.Pix[i]) - int(got.Pix[i]); d < -3 || d > 3 {
+				t.Fatalf("Fill %v: byte %d is %d by differences, %d by derivatives", fill, i, got.Pix[i], want.Pix[i])
+			}
+		}
+	}
+}
+
+func TestImplicitInvalid(t *testing.T) {
+	// Log of zero or a negative X leaves the left half and the middle column
+	// invalid.
+	img, invalid := renderImplicit(t, &Implicit{Width: 10, Height: 10, Policy: image_formula_find.Poison}, "0 = log(x)")
+	if invalid != 60 {
+		t.Errorf("%d invalid pixels, want 60", invalid)
+	}
+	if c := img.RGBAAt(0, 0); c != (color.RGBA{}) {
+		t.Errorf("Invalid pixel is %v", c)
+	}
+	img, invalid = renderImplicit(t, &Implicit{Width: 10, Height: 10}, "0 = log(x)")
+	if c := img.RGBAAt(0, 0); invalid != 60 || c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("%d invalid pixels, drawn %v", invalid, c)
+	}
+}
+
+func TestFeatureless(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    bool
+	}{
+		{"x^2 + y^2 = 25", false},
+		{"x^2 + y^2 + 1 = 0", true},
+		{"y = x + 30", true},
+		{"y = x + 5", false},
+		{"0 = log(-1)", true},
+	}
+	for _, test := range tests {
+		f, err := image_formula_find.ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		if got := Featureless(f, Viewport{}, 100, 100); got != test.want {
+			t.Errorf("Featureless(%s) = %v, want %v", test.formula, got, test.want)
+		}
+	}
+	// The line is out of the default view, in one moved up to it, and in
+	// one turned and zoomed out to take it in.
+	f, err := image_formula_find.ParseFunction("y = x + 30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, view := range []Viewport{{CenterY: 30}, {Scale: 100, Rotation: 0.5, Aspect: Fit}} {
+		if Featureless(f, view, 100, 50) {
+			t.Errorf("Featureless(%s) in %v", f, view)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package drawer1
+
+import (
+	"image"
+	"image-formula-find"
+	"image/color"
+	"testing"
+)
+
+func TestRenderMatchesFormulaEvaluate(t *testing.T) {
+	formulas := []string{
+		"y = x * x + 2",
+		"y / 4 = sin(x) * 100 + 50",
+		"x = atan2(x, y) * 40",
+		"0 = hypot(x, y) * 12 - abs(x)",
+		"0 = r * 20 + a * 30",
+		"0 = px * gain + py",
+		"0 = (r < 2 * gain) * 200 + u * 50 - v",
+		"0 = sin(x + t) * 100 + floor(t * y)",
+	}
+	params := map[string]float64{"GAIN": 3}
+	for _, each := range formulas {
+		f, err := image_formula_find.ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		d := &Drawer{
+			RedFormula:   f,
+			GreenFormula: f,
+			BlueFormula:  f,
+			Width:        37,
+			Height:       23,
+			Params:       params,
+			T:            1.25,
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+		d.Render(dst)
+		for y := 0; y < d.Height; y++ {
+			sy := (float64(y)/float64(d.Height))*20.0 - 10.0
+			for x := 0; x < d.Width; x++ {
+				sx := (float64(x)/float64(d.Width))*20.0 - 10.0
+				w := f.Equals.Evaluate(&image_formula_find.State{X: sx, Y: sy, T: d.T, Width: d.Width, Height: d.Height, Params: params})
+				want := color.RGBA{R: uint8(w), G: uint8(w), B: uint8(w), A: 255}
+				if got := dst.RGBAAt(x, y); got != want {
+					t.Fatalf("%s at (%d, %d): got %v, want %v", each, x, y, got, want)
+				}
+				if got := d.At(x, y); got != want {
+					t.Fatalf("%s At(%d, %d): got %v, want %v", each, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderFlatRegions(t *testing.T) {
+	formulas := []string{
+		"0 = floor(x) * 20 + 100",
+		"0 = max(x, 0) * 30",
+		"0 = min(hypot(x, y) * 40, 200)",
+		"0 = 7",
+		"0 = sqrt(x)",
+	}
+	for _, each := range formulas {
+		f, err := image_formula_find.ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		d := &Drawer{
+			RedFormula:   f,
+			GreenFormula: f,
+			BlueFormula:  f,
+			Width:        300,
+			Height:       200,
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+		d.Render(dst)
+		for y := 0; y < d.Height; y++ {
+			for x := 0; x < d.Width; x++ {
+				if got, want := dst.RGBAAt(x, y), d.At(x, y); got != want {
+					t.Fatalf("%s at (%d, %d): got %v, want %v", each, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderPolicy(t *testing.T) {
+	// Log is NaN left of the Y axis and sqrt below the X axis; Divide
+	// evaluates its RHS over its LHS.
+	f, err := image_formula_find.ParseFunction("0 = log(x) * 40 + 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := image_formula_find.ParseFunction("0 = sqrt(y) * 30 + (x / 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []image_formula_find.Policy{image_formula_find.IEEE, image_formula_find.Protected, image_formula_find.Poison} {
+		d := &Drawer{RedFormula: f, GreenFormula: g, BlueFormula: f, Width: 150, Height: 100, Policy: policy}
+		dst := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+		diagnostics := d.RenderDiagnostics(dst)
+		var want Diagnostics
+		for y := 0; y < d.Height; y++ {
+			sy := (float64(y)/float64(d.Height))*20.0 - 10.0
+			for x := 0; x < d.Width; x++ {
+				sx := (float64(x)/float64(d.Width))*20.0 - 10.0
+				state := &image_formula_find.State{X: sx, Y: sy, Policy: policy}
+				w := [3]float64{f.Equals.Evaluate(state), g.Equals.Evaluate(state), f.Equals.Evaluate(state)}
+				c := color.RGBA{R: uint8(w[0]), G: uint8(w[1]), B: uint8(w[2]), A: 255}
+				valid := true
+				for i, v := range w {
+					if !image_formula_find.Valid(v) {
+						want.Channels[i]++
+						valid = false
+					}
+				}
+				if !valid {
+					want.Invalid++
+					if policy == image_formula_find.Poison {
+						c = color.RGBA{}
+					}
+				}
+				if got := dst.RGBAAt(x, y); got != c {
+					t.Fatalf("%v at (%d, %d): got %v, want %v", policy, x, y, got, c)
+				}
+				if got := d.At(x, y); got != c {
+					t.Fatalf("%v At(%d, %d): got %v, want %v", policy, x, y, got, c)
+				}
+			}
+		}
+		want.Pixels = d.Width * d.Height
+		if diagnostics != want {
+			t.Errorf("%v: got %+v, want %+v", policy, diagnostics, want)
+		}
+		if (policy == image_formula_find.Protected) != (diagnostics.Invalid == 0) {
+			t.Errorf("%v: %d invalid pixels", policy, diagnostics.Invalid)
+		}
+	}
+}
+
+func TestDegenerate(t *testing.T) {
+	tests := []struct {
+		formula             string
+		constant, saturated bool
+	}{
+		{"0 = x + y", false, false},
+		{"0 = 7", true, false},
+		{"0 = sin(x) * 0.1 + 3.5", true, false},
+		{"0 = x + 1000", false, true},
+		{"5 = x * 0", false, true},
+	}
+	for _, test := range tests {
+		f, err := image_formula_find.ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		if constant, saturated := Degenerate(f, Viewport{}, 100, 100); constant != test.constant || saturated != test.saturated {
+			t.Errorf("%s: got constant %v saturated %v", test.formula, constant, saturated)
+		}
+	}
+	// Saturated from -10 to 10, the formula is in range around X = 50.
+	f, err := image_formula_find.ParseFunction("0 = x * 10 + -500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, saturated := Degenerate(f, Viewport{}, 100, 100); !saturated {
+		t.Errorf("%s is not saturated in the default view", f)
+	}
+	if _, saturated := Degenerate(f, Viewport{CenterX: 50}, 100, 100); saturated {
+		t.Errorf("%s is saturated around X = 50", f)
+	}
+}
@@ -0,0 +1,292 @@
+package drawer1
+
+import (
+	"fmt"
+	image_formula_find "image-formula-find"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultScale is the span of formula coordinates across the image of the
+// zero Viewport, X and Y from -10 to 10.
+const DefaultScale = 20
+
+// Aspect is how a Viewport fits its view to an image that is not square.
+type Aspect int
+
+const (
+	// Stretch spans Scale across both the width and the height, stretching
+	// the formulas over an image that is not square.
+	Stretch Aspect = iota
+	// Fit spans Scale across the shorter side with square pixels, so the
+	// whole of the view shows and more of it along the longer side.
+	Fit
+	// Fill spans Scale across the longer side with square pixels, cropping
+	// the view along the shorter side.
+	Fill
+)
+
+// aspects are the names of the Aspects in ParseAspect and String.
+var aspects = []string{"stretch", "fit", "fill"}
+
+func (a Aspect) String() string {
+	if a < 0 || int(a) >= len(aspects) {
+		return fmt.Sprintf("Aspect(%d)", int(a))
+	}
+	return aspects[a]
+}
+
+// ParseAspect returns the Aspect of a name: stretch, fit or fill.
+func ParseAspect(name string) (Aspect, error) {
+	for i, each := range aspects {
+		if strings.EqualFold(name, each) {
+			return Aspect(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown aspect %q, expected one of %s", name, strings.Join(aspects, ", "))
+}
+
+// Viewport is the window of formula coordinates an image shows. The zero
+// value is the default view, X and Y from -10 to 10 across the image
+// whatever its shape.
+//
+// The derived variables U, V, PX and PY measure the image whatever the view,
+// see Transform.Frame, so U runs from 0 to 1 across the image however it is
+// moved, scaled or turned.
+type Viewport struct {
+	// CenterX and CenterY are the formula coordinates at the centre of the
+	// image.
+	CenterX, CenterY float64
+	// Scale is the span of formula coordinates across the image, measured
+	// as Aspect says. DefaultScale when not positive.
+	Scale float64
+	// Rotation turns the view by this many radians, from the X axis
+	// towards the Y axis.
+	Rotation float64
+	Aspect   Aspect
+}
+
+// viewportFields are the names of the fields of a Viewport in ParseViewport
+// and String.
+var viewportFields = []string{"x", "y", "scale", "rotation", "aspect"}
+
+// ParseViewport reads a viewport from a comma separated list of name=value
+// settings, such as "x=2,y=-1,scale=5,aspect=fit". The names are x and y for
+// the centre, scale, rotation in radians and aspect. An empty string is the
+// default view.
+func ParseViewport(text string) (Viewport, error) {
+	var v Viewport
+	for _, each := range strings.Split(text, ",") {
+		if strings.TrimSpace(each) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(each, "=")
+		if !ok {
+			return Viewport{}, fmt.Errorf("invalid viewport setting %q, expected name=value", each)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "x":
+			v.CenterX, err = strconv.ParseFloat(value, 64)
+		case "y":
+			v.CenterY, err = strconv.ParseFloat(value, 64)
+		case "scale":
+			v.Scale, err = strconv.ParseFloat(value, 64)
+		case "rotation":
+			v.Rotation, err = strconv.ParseFloat(value, 64)
+		case "aspect":
+			v.Aspect, err = ParseAspect(value)
+		default:
+			return Viewport{}, fmt.Errorf("unknown viewport setting %q, expected one of %s", name, strings.Join(viewportFields, ", "))
+		}
+		if err != nil {
+			return Viewport{}, fmt.Errorf("invalid viewport %s: %w", name, err)
+		}
+	}
+	return v, nil
+}
+
+// String returns the settings that differ from the default view as
+// ParseViewport reads them, so the default view is the empty string.
+func (v Viewport) String() string {
+	values := []string{
+		strconv.FormatFloat(v.CenterX, 'g', -1, 64),
+		strconv.FormatFloat(v.CenterY, 'g', -1, 64),
+		strconv.FormatFloat(v.Scale, 'g', -1, 64),
+		strconv.FormatFloat(v.Rotation, 'g', -1, 64),
+		v.Aspect.String(),
+	}
+	var settings []string
+	for i, each := range values {
+		if each != "0" && each != Stretch.String() {
+			settings = append(settings, viewportFields[i]+"="+each)
+		}
+	}
+	return strings.Join(settings, ",")
+}
+
+// Transform returns the map from the pixels of an image of the given size to
+// formula coordinates.
+//
+// It does not fail: when width or height is not positive the viewport is
+// ignored and the identity is returned, so the pixels of an image of no size
+// are the formula coordinates. Drawer and Implicit draw without a Width or
+// Height that way; callers with a size to check should check it first.
+func (v Viewport) Transform(width, height int) Transform {
+	if width <= 0 || height <= 0 {
+		return Transform{Width: 1, Height: 1, XU: 1, YV: 1}
+	}
+	scale := v.Scale
+	if scale <= 0 {
+		scale = DefaultScale
+	}
+	// The spans of formula coordinates across the width and the height.
+	w, h := float64(width), float64(height)
+	spanX, spanY := scale, scale
+	switch v.Aspect {
+	case Fit:
+		spanX, spanY = scale*w/min(w, h), scale*h/min(w, h)
+	case Fill:
+		spanX, spanY = scale*w/max(w, h), scale*h/max(w, h)
+	}
+	sin, cos := math.Sincos(v.Rotation)
+	t := Transform{
+		Width:  w,
+		Height: h,
+		XU:     cos * spanX,
+		XV:     -sin * spanY,
+		YU:     sin * spanX,
+		YV:     cos * spanY,
+	}
+	t.X0 = v.CenterX - t.XU/2 - t.XV/2
+	t.Y0 = v.CenterY - t.YU/2 - t.YV/2
+	return t
+}
+
+// Transform is an affine map from pixel to formula coordinates. Pixel
+// coordinates are divided by Width and Height into U and V, which run from 0
+// to 1 across the image, then
+//
+//	X = XU*U + XV*V + X0
+//	Y = YU*U + YV*V + Y0
+//
+// so the default view maps pixels exactly as X = U*20 - 10.
+type Transform struct {
+	Width, Height float64
+	XU, XV, X0    float64
+	YU, YV, Y0    float64
+}
+
+// Apply returns the formula coordinates of the pixel coordinates px, py.
+func (t Transform) Apply(px, py float64) (x, y float64) {
+	// The conversions stop products being fused into multiply-adds, so
+	// generated code can map pixels exactly the same way, see codegen.
+	u, v := px/t.Width, py/t.Height
+	return float64(t.XU*u) + float64(t.XV*v) + t.X0, float64(t.YU*u) + float64(t.YV*v) + t.Y0
+}
+
+// Frame returns the view as image_formula_find.Frame, which U, V, PX and PY
+// are measured through.
+func (t Transform) Frame() image_formula_find.Frame {
+	return image_formula_find.Frame{XU: t.XU, XV: t.XV, X0: t.X0, YU: t.YU, YV: t.YV, Y0: t.Y0}
+}
+
+// frame returns the Frame of the view of an image of the given size, the
+// default one when it has no size, as for the identity Transform U and V
+// would not run from 0 to 1.
+func (v Viewport) frame(width, height int) image_formula_find.Frame {
+	if width <= 0 || height <= 0 {
+		return image_formula_find.Frame{}
+	}
+	return v.Transform(width, height).Frame()
+}
+
+// whole returns the bounds of the formula coordinates over an image of the
+// given size drawn through the view, at T 0. An image of no size is taken as
+// the default view, X and Y from -10 to 10.
+func (v Viewport) whole(width, height int) *image_formula_find.IntervalState {
+	if width <= 0 || height <= 0 {
+		return &image_formula_find.IntervalState{
+			X: image_formula_find.Span(-10, 10),
+			Y: image_formula_find.Span(-10, 10),
+			T: image_formula_find.Point(0),
+		}
+	}
+	x, y := v.Transform(width, height).Bounds(0, 0, float64(width-1), float64(height-1))
+	return &image_formula_find.IntervalState{
+		X:      x,
+		Y:      y,
+		T:      image_formula_find.Point(0),
+		Width:  width,
+		Height: height,
+		Frame:  v.frame(width, height),
+	}
+}
+
+// Rotated reports whether X depends on the pixel row or Y on the pixel
+// column, so the pixels of a row do not share a Y.
+func (t Transform) Rotated() bool {
+	return t.XV != 0 || t.YU != 0
+}
+
+// Pixel returns the steps in formula coordinates of one pixel along a row,
+// dxu and dyu, and down a column, dxv and dyv.
+func (t Transform) Pixel() (dxu, dyu, dxv, dyv float64) {
+	return t.XU / t.Width, t.YU / t.Width, t.XV / t.Height, t.YV / t.Height
+}
+
+// Bounds returns the ranges of formula coordinates over the pixels from
+// px0, py0 to px1, py1 inclusive, the corners of which an affine map takes
+// to the extremes. Unless the view is rotated the bounds are the very
+// coordinates Apply gives the corners, otherwise they are widened by the
+// rounding Apply may do in between.
+func (t Transform) Bounds(px0, py0, px1, py1 float64) (x, y image_formula_find.Interval) {
+	xs, ys := make([]float64, 0, 4), make([]float64, 0, 4)
+	for _, c := range [][2]float64{{px0, py0}, {px1, py0}, {px0, py1}, {px1, py1}} {
+		cx, cy := t.Apply(c[0], c[1])
+		xs, ys = append(xs, cx), append(ys, cy)
+	}
+	x = image_formula_find.Span(min(xs[0], xs[1], xs[2], xs[3]), max(xs[0], xs[1], xs[2], xs[3]))
+	y = image_formula_find.Span(min(ys[0], ys[1], ys[2], ys[3]), max(ys[0], ys[1], ys[2], ys[3]))
+	if t.Rotated() {
+		x, y = widen(x), widen(y)
+	}
+	return x, y
+}
+
+// widen grows an interval by a few units in the last place at either end.
+func widen(i image_formula_find.Interval) image_formula_find.Interval {
+	const ulps = 4
+	for range ulps {
+		i.Lo, i.Hi = math.Nextafter(i.Lo, math.Inf(-1)), math.Nextafter(i.Hi, math.Inf(1))
+	}
+	return i
+}
+
+// evaluateRow evaluates p at the pixels x0 to x0+len(out) of row y, the
+// formula coordinates of which are xs and ys unless the view is rotated.
+func evaluateRow(p *image_formula_find.Program, t Transform, xs, ys []float64, x0, y int, T float64, out []float64) {
+	if !t.Rotated() {
+		p.EvaluateRow(xs[x0:x0+len(out)], ys[y], T, out)
+		return
+	}
+	for i := range out {
+		X, Y := t.Apply(float64(x0+i), float64(y))
+		out[i] = p.Evaluate(X, Y, T)
+	}
+}
+
+// axes returns the formula coordinates of the columns and rows of an image
+// of the given size, for views that are not rotated.
+func (t Transform) axes(width, height int) (xs, ys []float64) {
+	xs, ys = make([]float64, width), make([]float64, height)
+	for x := range xs {
+		xs[x], _ = t.Apply(float64(x), 0)
+	}
+	for y := range ys {
+		_, ys[y] = t.Apply(0, float64(y))
+	}
+	return xs, ys
+}
@@ -0,0 +1,177 @@
+package drawer1
+
+import (
+	"image"
+	"image-formula-find"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestViewportDefault(t *testing.T) {
+	for _, size := range []image.Point{{37, 23}, {100, 100}, {3, 300}} {
+		view := Viewport{}.Transform(size.X, size.Y)
+		if view.Rotated() {
+			t.Errorf("Default view of %v is rotated", size)
+		}
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				gx, gy := view.Apply(float64(x), float64(y))
+				wx := (float64(x)/float64(size.X))*20.0 - 10.0
+				wy := (float64(y)/float64(size.Y))*20.0 - 10.0
+				if gx != wx || gy != wy {
+					t.Fatalf("Pixel (%d, %d) of %v maps to (%v, %v), want (%v, %v)", x, y, size, gx, gy, wx, wy)
+				}
+			}
+		}
+	}
+	if x, y := (Viewport{Scale: 5}).Transform(0, 10).Apply(3, 4); x != 3 || y != 4 {
+		t.Errorf("Pixel (3, 4) without a size maps to (%v, %v)", x, y)
+	}
+}
+
+func TestViewportTransform(t *testing.T) {
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	tests := []struct {
+		view   Viewport
+		px, py float64
+		x, y   float64
+	}{
+		{Viewport{Aspect: Fit}, 0, 0, -20, -10},
+		{Viewport{Aspect: Fit}, 200, 100, 20, 10},
+		{Viewport{Aspect: Fill}, 0, 0, -10, -5},
+		{Viewport{Aspect: Fill}, 200, 100, 10, 5},
+		{Viewport{CenterX: 3, CenterY: -1, Scale: 2}, 100, 50, 3, -1},
+		{Viewport{CenterX: 3, CenterY: -1, Scale: 2}, 0, 0, 2, -2},
+		// A quarter turn takes the right edge of the image to positive Y.
+		{Viewport{Rotation: math.Pi / 2}, 200, 50, 0, 10},
+		{Viewport{Rotation: math.Pi / 2}, 100, 100, -10, 0},
+	}
+	for _, test := range tests {
+		x, y := test.view.Transform(200, 100).Apply(test.px, test.py)
+		if !near(x, test.x) || !near(y, test.y) {
+			t.Errorf("%v maps (%v, %v) to (%v, %v), want (%v, %v)", test.view, test.px, test.py, x, y, test.x, test.y)
+		}
+	}
+	dxu, dyu, dxv, dyv := Viewport{Aspect: Fit, Rotation: 0.3}.Transform(200, 100).Pixel()
+	if !near(math.Hypot(dxu, dyu), 0.2) || !near(math.Hypot(dxv, dyv), 0.2) || !near(dxu*dxv+dyu*dyv, 0) {
+		t.Errorf("Pixels of a fitted view are not square: (%v, %v), (%v, %v)", dxu, dyu, dxv, dyv)
+	}
+}
+
+func TestParseViewport(t *testing.T) {
+	v, err := ParseViewport("x=2, y=-1.5,Scale=5,rotation=0.25,aspect=fit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Viewport{CenterX: 2, CenterY: -1.5, Scale: 5, Rotation: 0.25, Aspect: Fit}
+	if v != want {
+		t.Errorf("ParseViewport = %+v, want %+v", v, want)
+	}
+	if s := v.String(); s != "x=2,y=-1.5,scale=5,rotation=0.25,aspect=fit" {
+		t.Errorf("String() = %q", s)
+	}
+	if again, err := ParseViewport(v.String()); err != nil || again != v {
+		t.Errorf("%q reads back as %+v, %v", v, again, err)
+	}
+	if v, err := ParseViewport(""); err != nil || v != (Viewport{}) || v.String() != "" {
+		t.Errorf("ParseViewport(\"\") = %+v, %v", v, err)
+	}
+	for _, text := range []string{"x", "zoom=2", "scale=big", "aspect=square"} {
+		if _, err := ParseViewport(text); err == nil {
+			t.Errorf("ParseViewport(%q) did not fail", text)
+		}
+	}
+}
+
+func TestRenderViewport(t *testing.T) {
+	formulas := []string{
+		"0 = floor(x) * 20 + 100",
+		"0 = min(hypot(x, y) * 40, 200)",
+		"0 = sin(x) * 100 + y * 5",
+		"0 = px * 3 + py",
+	}
+	views := []Viewport{
+		{Aspect: Fit},
+		{Aspect: Fill, CenterX: 4, Scale: 7},
+		{Rotation: 0.7, CenterY: 2},
+		{Rotation: math.Pi, Aspect: Fit, Scale: 30},
+	}
+	for _, each := range formulas {
+		f, err := image_formula_find.ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		for _, view := range views {
+			d := &Drawer{RedFormula: f, GreenFormula: f, BlueFormula: f, Width: 300, Height: 140, Viewport: view}
+			transform := view.Transform(d.Width, d.Height)
+			dst := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+			d.Render(dst)
+			for y := 0; y < d.Height; y++ {
+				for x := 0; x < d.Width; x++ {
+					sx, sy := transform.Apply(float64(x), float64(y))
+					w := f.Equals.Evaluate(&image_formula_find.State{X: sx, Y: sy, Width: d.Width, Height: d.Height, Frame: transform.Frame()})
+					want := color.RGBA{R: uint8(w), G: uint8(w), B: uint8(w), A: 255}
+					if got := dst.RGBAAt(x, y); got != want {
+						t.Fatalf("%s in %v at (%d, %d): got %v, want %v", each, view, x, y, got, want)
+					}
+					if got := d.At(x, y); got != want {
+						t.Fatalf("%s in %v At(%d, %d): got %v, want %v", each, view, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestViewportPixels(t *testing.T) {
+	px, err := image_formula_find.ParseFunction("0 = px")
+	if err != nil {
+		t.Fatal(err)
+	}
+	py, err := image_formula_find.ParseFunction("0 = py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PX and PY are the pixel whatever the view, give or take rounding.
+	for _, view := range []Viewport{{}, {Aspect: Fill, CenterX: 4, Scale: 7}, {Rotation: 2, CenterY: 30}} {
+		d := &Drawer{RedFormula: px, GreenFormula: py, BlueFormula: px, Width: 200, Height: 140, Viewport: view}
+		dst := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+		d.Render(dst)
+		for y := 0; y < d.Height; y++ {
+			for x := 0; x < d.Width; x++ {
+				c := dst.RGBAAt(x, y)
+				if math.Abs(float64(c.R)-float64(x)) > 1 || math.Abs(float64(c.G)-float64(y)) > 1 {
+					t.Fatalf("%v: pixel (%d, %d) drawn as PX %d, PY %d", view, x, y, c.R, c.G)
+				}
+			}
+		}
+	}
+}
+
+func TestImplicitViewport(t *testing.T) {
+	// Fitted to a wide image, a circle of radius 5 stays round, 25 pixels
+	// across in both directions.
+	img, _ := renderImplicit(t, &Implicit{Width: 200, Height: 100, Viewport: Viewport{Aspect: Fit}}, "x^2 + y^2 = 25")
+	for _, p := range []image.Point{{125, 50}, {75, 50}, {100, 75}, {100, 25}} {
+		if c := img.RGBAAt(p.X, p.Y); c != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("%v on the circle is %v", p, c)
+		}
+	}
+	if got := ink(img); got < 150 || got > 165 {
+		t.Errorf("Circle of %v pixels, want about 157", got)
+	}
+	// A quarter turn draws the Y axis across the middle row, one pixel
+	// wide, whether the gradient is symbolic or estimated.
+	for _, formula := range []string{"x = 0", "x = 0 * px"} {
+		img, _ = renderImplicit(t, &Implicit{Width: 40, Height: 40, Viewport: Viewport{Rotation: math.Pi / 2}}, formula)
+		for x := 0; x < 40; x++ {
+			if c := img.RGBAAt(x, 20); c != (color.RGBA{0, 0, 0, 255}) {
+				t.Fatalf("%s: (%d, 20) is %v", formula, x, c)
+			}
+			if c := img.RGBAAt(x, 18); c != (color.RGBA{255, 255, 255, 255}) {
+				t.Fatalf("%s: (%d, 18) is %v", formula, x, c)
+			}
+		}
+	}
+}
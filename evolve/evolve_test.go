@@ -0,0 +1,186 @@
+package evolve
+
+import (
+	"image"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"math"
+	"testing"
+)
+
+// individual returns an individual of the red, blue and green formulas.
+func individual(t *testing.T, formulas ...string) *Individual {
+	t.Helper()
+	var fs [3]*image_formula_find.Function
+	for c, each := range formulas {
+		f, err := image_formula_find.ParseFunction(each)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", each, err)
+		}
+		fs[c] = f
+	}
+	return &Individual{DNA: formulas[0], Rf: fs[0], Bf: fs[1], Gf: fs[2]}
+}
+
+func TestCalculateNumerics(t *testing.T) {
+	// The red channel is NaN over the top half.
+	formulas := []string{"0 = sqrt(y)", "0 = x * 10", "0 = y * 10"}
+	req := &BasicRequired{
+		R: image.Rect(0, 0, 10, 10),
+		I: image.NewRGBA(image.Rect(0, 0, 10, 10)),
+	}
+	ieee := individual(t, formulas...)
+	ieee.Calculate(req)
+	if ieee.Invalid != 50 {
+		t.Fatalf("%s: %d invalid pixels, want 50", ieee.Rf, ieee.Invalid)
+	}
+
+	req.Penalty = 2
+	penalised := individual(t, formulas...)
+	penalised.Calculate(req)
+	if want := ieee.Score + 2*50; penalised.Score != want {
+		t.Errorf("Penalised score %v, want %v", penalised.Score, want)
+	}
+
+	req.Policy = image_formula_find.Protected
+	protected := individual(t, formulas...)
+	protected.Calculate(req)
+	if protected.Invalid != 0 {
+		t.Errorf("Protected: %d invalid pixels", protected.Invalid)
+	}
+
+	req.Policy, req.Penalty = image_formula_find.Poison, -1
+	valid := individual(t, "0 = x * 10", "0 = y * 10", "0 = 100")
+	ranked := Rank(req, []*Individual{individual(t, formulas...), valid})
+	if len(ranked) != 1 || ranked[0] != valid {
+		t.Errorf("Ranked %d individuals, want only %s", len(ranked), valid.Rf)
+	}
+}
+
+func TestCalculateSilhouette(t *testing.T) {
+	// The red formula is filled where it is above zero, the bottom half.
+	target := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			target.Pix[target.PixOffset(x, y)+3] = 255
+			if y < 6 {
+				copy(target.Pix[target.PixOffset(x, y):], []byte{255, 255, 255})
+			}
+		}
+	}
+	req := &BasicRequired{R: target.Bounds(), I: target, Silhouette: true}
+	// The green and blue formulas, saturated and not drawn, do not count.
+	i := individual(t, "0 = sqrt(y)", "0 = 1000", "0 = 1000")
+	i.Calculate(req)
+	// Row 5 is on the edge, drawn half covered.
+	if i.Score < 4.9 || i.Score > 5.1 {
+		t.Errorf("%s: score %v, want about 5", i.Rf, i.Score)
+	}
+	if i.Invalid != 50 {
+		t.Errorf("%s: %d invalid pixels, want 50", i.Rf, i.Invalid)
+	}
+	if i.Degenerate(req) || !i.Degenerate(&BasicRequired{}) {
+		t.Errorf("%s: degenerate %v drawn as a silhouette, %v in colour", i.Rf, i.Degenerate(req), i.Degenerate(&BasicRequired{}))
+	}
+}
+
+func TestDegenerate(t *testing.T) {
+	for _, test := range []struct {
+		formulas   []string
+		degenerate bool
+	}{
+		{[]string{"0 = x * 10", "0 = y * 10", "0 = 100"}, false},
+		{[]string{"0 = x * 10", "0 = 1000", "0 = 100"}, true},
+		{[]string{"0 = 50", "0 = 100", "0 = 150"}, true},
+		{[]string{"0 = 50", "0 = 100", "0 = sin(x) * 100"}, false},
+	} {
+		i := individual(t, test.formulas...)
+		if got := Degenerate(i.Rf, i.Bf, i.Gf, drawer1.Viewport{}, 10, 10); got != test.degenerate {
+			t.Errorf("%v: degenerate %v", test.formulas, got)
+		}
+	}
+
+	// The blue channel is saturated in the default view, in range around
+	// X = 50.
+	i := individual(t, "0 = y * 10", "0 = x * 10 + -500", "0 = y * 5")
+	moved := drawer1.Viewport{CenterX: 50}
+	req := &BasicRequired{R: image.Rect(0, 0, 10, 10)}
+	if !i.Degenerate(req) {
+		t.Errorf("%s is not degenerate in the default view", i.Bf)
+	}
+	req.Viewport = moved
+	if i.Degenerate(req) {
+		t.Errorf("%s is degenerate in %v", i.Bf, moved)
+	}
+	// Evolving views, the individual is drawn through its own.
+	req.Viewport, req.EvolveViewport = drawer1.Viewport{}, true
+	if i.Viewport = moved; i.Degenerate(req) {
+		t.Errorf("%s is degenerate in its own view %v", i.Bf, moved)
+	}
+	// So is a silhouette, here a line out of the default view.
+	line := individual(t, "y = x + -50", "0 = 0", "0 = 0")
+	req = &BasicRequired{R: image.Rect(0, 0, 10, 10), Silhouette: true}
+	if !line.Degenerate(req) {
+		t.Errorf("%s is not degenerate in the default view", line.Rf)
+	}
+	req.Viewport = moved
+	if line.Degenerate(req) {
+		t.Errorf("%s is degenerate in %v", line.Rf, moved)
+	}
+}
+
+func TestBloatControl(t *testing.T) {
+	target := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	formulas := []string{"0 = sqrt(y) * 10", "0 = x + y", "0 = 5"}
+	plain := individual(t, formulas...)
+	plain.Calculate(&BasicRequired{R: target.Bounds(), I: target})
+	// The equations count, and their zero sides.
+	if plain.Size != 11 || plain.Depth != 4 {
+		t.Fatalf("%s measured %d nodes %d deep", plain.Rf, plain.Size, plain.Depth)
+	}
+	control := image_formula_find.Bloat{SizePenalty: 10, MaxDepth: plain.Depth - 1, Tournament: 3}
+	penalised := individual(t, formulas...)
+	penalised.Calculate(&BasicRequired{R: target.Bounds(), I: target, Bloat: control})
+	if want := plain.Score + 10*float64(plain.Size); math.Abs(penalised.Score-want) > 1e-9 {
+		t.Errorf("Penalised score %v, want %v", penalised.Score, want)
+	}
+
+	old, young := individual(t, formulas...), individual(t, formulas...)
+	young.FirstGeneration = 1
+	if kept := ControlBloat(control, []*Individual{old, young}, 1); len(kept) != 1 || kept[0] != old {
+		t.Errorf("Kept %d individuals, want only the old one", len(kept))
+	}
+
+	generation := []*Individual{{Score: 1, Size: 5}, {Score: 2, Size: 5}, {Score: 3, Size: 5}}
+	if got := MeanSize(generation); got != 5 {
+		t.Errorf("Mean size %v, want 5", got)
+	}
+	wins := 0
+	for i := 0; i < 1000; i++ {
+		if Choose(control, generation) == generation[0] {
+			wins++
+		}
+	}
+	// The best wins unless it is never drawn, (2/3)³ of the time.
+	if wins < 650 || wins > 830 {
+		t.Errorf("Best won %d of 1000 tournaments, want about 704", wins)
+	}
+}
+
+func TestCalculateViewport(t *testing.T) {
+	target := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	view := drawer1.Viewport{CenterX: 3, Scale: 5, Rotation: 0.5, Aspect: drawer1.Fill}
+	i := individual(t, "0 = x * 20", "0 = y * 20", "0 = hypot(x, y) * 10")
+	i.Calculate(&BasicRequired{R: target.Bounds(), I: target, Viewport: view})
+	if i.Viewport != view {
+		t.Errorf("Drawn through %v, want %v", i.Viewport, view)
+	}
+	if row := i.CsvRow(); row[0] != i.DNA || row[len(row)-1] != view.String() {
+		t.Errorf("Recorded as %q, want the DNA and viewport %q", row, view.String())
+	}
+	want := image.NewRGBA(target.Bounds())
+	(&drawer1.Drawer{RedFormula: i.Rf, BlueFormula: i.Bf, GreenFormula: i.Gf, Width: 12, Height: 8, Viewport: view}).Render(want)
+	if string(want.Pix) != string(i.Image().(*image.RGBA).Pix) {
+		t.Errorf("%s not drawn through the viewport", i.Rf)
+	}
+}
@@ -0,0 +1,99 @@
+package evolve
+
+import (
+	"image-formula-find"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Member is an individual of an encoding, which embeds Individual and so has
+// its methods, Calculate possibly overridden.
+type Member interface {
+	Base() *Individual
+	Calculate(required Required)
+}
+
+// Sorter sorts individuals best first.
+type Sorter[T Member] struct {
+	Children []T
+	// Bloat ranks individuals with nearly equal scores by size when it
+	// asks for lexicographic parsimony pressure.
+	Bloat image_formula_find.Bloat
+}
+
+func (s *Sorter[T]) Len() int {
+	return len(s.Children)
+}
+
+func (s *Sorter[T]) Less(i, j int) bool {
+	a, b := s.Children[i].Base(), s.Children[j].Base()
+	return s.Bloat.Less(a.Score, a.Size, b.Score, b.Size)
+}
+
+func (s *Sorter[T]) Swap(i, j int) {
+	s.Children[i], s.Children[j] = s.Children[j], s.Children[i]
+}
+
+// Rank calculates the children concurrently and returns them sorted best
+// first, without those with invalid pixels when required discards them, see
+// Numerics.
+func Rank[T Member](required Required, children []T) []T {
+	wg := sync.WaitGroup{}
+	for _, child := range children {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child.Calculate(required)
+		}()
+	}
+	wg.Wait()
+	if _, penalty := NumericsOf(required); penalty < 0 {
+		children = slices.DeleteFunc(children, func(child T) bool {
+			return child.Base().Invalid > 0
+		})
+	}
+	sort.Sort(&Sorter[T]{Children: children, Bloat: BloatOf(required)})
+	return children
+}
+
+// ControlBloat drops the individuals new in this generation that the bloat
+// control rejects before they are scored: those deeper than its MaxDepth and,
+// by the Tarpeian method, some of those larger than the mean. Individuals
+// carried over from the last generation are kept.
+func ControlBloat[T Member](control image_formula_find.Bloat, children []T, generation int) []T {
+	for _, child := range children {
+		child.Base().Measure()
+	}
+	mean := MeanSize(children)
+	return slices.DeleteFunc(children, func(child T) bool {
+		i := child.Base()
+		return i.FirstGeneration == generation && (control.TooDeep(i.Depth) || control.Doomed(i.Size, mean))
+	})
+}
+
+// Choose returns a parent to breed, the best of a tournament when the bloat
+// control asks for one and one at random otherwise.
+func Choose[T Member](control image_formula_find.Bloat, generation []T) T {
+	best := generation[rand.Intn(len(generation))]
+	for i := 1; i < control.Tournament; i++ {
+		each := generation[rand.Intn(len(generation))]
+		if e, b := each.Base(), best.Base(); control.Less(e.Score, e.Size, b.Score, b.Size) {
+			best = each
+		}
+	}
+	return best
+}
+
+// MeanSize returns the mean Size of the individuals of a generation.
+func MeanSize[T Member](generation []T) float64 {
+	if len(generation) == 0 {
+		return 0
+	}
+	total := 0
+	for _, each := range generation {
+		total += each.Base().Size
+	}
+	return float64(total) / float64(len(generation))
+}
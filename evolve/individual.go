@@ -0,0 +1,155 @@
+package evolve
+
+import (
+	"fmt"
+	"image"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"image-formula-find/imageutil"
+	"image/draw"
+)
+
+// Individual is what the encodings share of an individual: its formulas and
+// how they drew and scored. An encoding embeds it, decoding the genome into
+// Rf, Bf and Gf once when it makes the individual.
+type Individual struct {
+	// DNA is the genome in the form of the encoding, which identifies the
+	// individual.
+	DNA     string
+	Lineage string
+	Score   float64
+	Rf      *image_formula_find.Function
+	Bf      *image_formula_find.Function
+	Gf      *image_formula_find.Function
+	i       draw.Image
+	d       *drawer1.Drawer
+	// FirstGeneration is the generation the individual was bred in.
+	FirstGeneration int
+	// Invalid counts the pixels with a channel that is NaN or infinite.
+	Invalid int
+	// Size is the number of nodes of the formulas and Depth the depth of
+	// the deepest, see image_formula_find.FormulaSize.
+	Size, Depth int
+	// Viewport is the view the formulas are drawn through, that of the
+	// Required unless the encoding evolves it, see ViewGenes.
+	Viewport drawer1.Viewport
+}
+
+// Base returns the individual, which an encoding's individual embedding it
+// returns too, see Member.
+func (i *Individual) Base() *Individual {
+	return i
+}
+
+// Measure sets the Size and Depth of the formulas.
+func (i *Individual) Measure() {
+	i.Size, i.Depth = image_formula_find.FormulaSize(i.Rf, i.Bf, i.Gf)
+}
+
+// Calculate draws and scores the individual through the view of required.
+func (i *Individual) Calculate(required Required) {
+	i.Viewport = ViewOf(required)
+	i.Draw(required)
+}
+
+// Draw renders the formulas through the Viewport of the individual and
+// scores them against the source image of required, adding the penalties for
+// invalid pixels and bloat it asks for.
+func (i *Individual) Draw(required Required) {
+	i.Measure()
+	rect := required.PlotSize()
+	policy, penalty := NumericsOf(required)
+	i.i = image.NewRGBA(rect.Bounds())
+	if FitsSilhouette(required) {
+		im := &drawer1.Implicit{
+			Formula:  i.Rf,
+			Width:    rect.Dx(),
+			Height:   rect.Dy(),
+			Policy:   policy,
+			Fill:     true,
+			Viewport: i.Viewport,
+		}
+		i.Invalid = im.Render(i.i)
+		i.Score = imageutil.SilhouetteDistance(required.SourceImage(), i.i)
+	} else {
+		i.render(required, policy)
+	}
+	if penalty > 0 {
+		i.Score += penalty * float64(i.Invalid)
+	}
+	i.Score += BloatOf(required).Penalty(i.Size, i.Depth)
+}
+
+// render draws the formulas as the red, green and blue channels and scores
+// them against the source image.
+func (i *Individual) render(required Required, policy image_formula_find.Policy) {
+	rect := required.PlotSize()
+	i.d = &drawer1.Drawer{
+		RedFormula:   i.Rf,
+		BlueFormula:  i.Bf,
+		GreenFormula: i.Gf,
+		Width:        rect.Dx(),
+		Height:       rect.Dy(),
+		Policy:       policy,
+		Viewport:     i.Viewport,
+	}
+	i.Invalid = i.d.RenderDiagnostics(i.i).Invalid
+	i.Score = imageutil.CalculateDistance(required.SourceImage(), i.i)
+}
+
+// Degenerate reports whether the formulas are not worth rendering on an image
+// of the given size through view, either because a channel never lands in
+// the 0 to 255 range or because every channel is constant and the image is a
+// flat colour. A single constant channel is kept as it can still match the
+// source image.
+func Degenerate(rf, bf, gf *image_formula_find.Function, view drawer1.Viewport, width, height int) bool {
+	flat := true
+	for _, f := range []*image_formula_find.Function{rf, bf, gf} {
+		constant, saturated := drawer1.Degenerate(f, view, width, height)
+		if saturated {
+			return true
+		}
+		flat = flat && constant
+	}
+	return flat
+}
+
+// Degenerate is Degenerate of the formulas of the individual at the size of
+// required, through the view it will be drawn through: its own Viewport when
+// required evolves views, that of required otherwise. When fitting a
+// silhouette only the red formula is drawn and it only needs an edge in view.
+func (i *Individual) Degenerate(required Required) bool {
+	view, rect := ViewOf(required), required.PlotSize()
+	if EvolvesView(required) {
+		view = i.Viewport
+	}
+	if !FitsSilhouette(required) {
+		return Degenerate(i.Rf, i.Bf, i.Gf, view, rect.Dx(), rect.Dy())
+	}
+	return drawer1.Featureless(i.Rf, view, rect.Dx(), rect.Dy())
+}
+
+// Image returns the image the individual was last drawn as.
+func (i *Individual) Image() image.Image {
+	return i.i
+}
+
+// CsvRow returns the DNA, the red, blue and green formulas, the score and the
+// viewport, which is empty for the default view, of the individual.
+func (i *Individual) CsvRow() []string {
+	return i.CsvRowWith(image_formula_find.Text)
+}
+
+// CsvRowWith is CsvRow with the formulas printed by print, one of
+// image_formula_find.Notations.
+func (i *Individual) CsvRowWith(print func(image_formula_find.Expression) string) []string {
+	return i.Row(i.DNA, print)
+}
+
+// Row is CsvRowWith with genome in the DNA column, for encodings storing
+// more than the DNA.
+func (i *Individual) Row(genome string, print func(image_formula_find.Expression) string) []string {
+	return []string{
+		genome, i.Rf.Simplify().Format(print), i.Bf.Simplify().Format(print), i.Gf.Simplify().Format(print), fmt.Sprintf("%0.2f", i.Score), i.Viewport.String(),
+	}
+}
@@ -0,0 +1,152 @@
+// Package evolve holds what the encodings, dna1, dna3, dna4, dna5 and gp,
+// have in common. An encoding decodes its genome into the red, blue and green
+// formulas and breeds it; drawing and scoring the formulas, the settings of
+// a run and the ranking, selection and bloat control of a generation are
+// done here, the same for all of them.
+//
+// Each encoding embeds Individual in an Individual of its own and calls the
+// generic functions of this package with its individuals.
+package evolve
+
+import (
+	"image"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+)
+
+// Required is what a run is fitting: the size to draw individuals at and the
+// image to score them against. The optional interfaces below add settings
+// the zero values of which are the defaults.
+type Required interface {
+	PlotSize() image.Rectangle
+	SourceImage() image.Image
+}
+
+// Numerics is implemented by a Required that evaluates the formulas with a
+// numeric policy other than IEEE arithmetic or penalises individuals with
+// invalid pixels, ones with a channel that is NaN or infinite.
+type Numerics interface {
+	NumericPolicy() image_formula_find.Policy
+	// InvalidPenalty is added to the score of an individual for each of its
+	// invalid pixels. A negative penalty discards individuals with any.
+	InvalidPenalty() float64
+}
+
+// Silhouettes is implemented by a Required fitting a silhouette, a black on
+// white mask or logo, instead of a colour image. The red formula is then read
+// as an equation and drawn as the shape where its left side is less than its
+// right, see drawer1.Implicit, and scored against the source image made
+// black and white with imageutil.SilhouetteDistance. The green and blue
+// formulas are not drawn.
+type Silhouettes interface {
+	FitSilhouette() bool
+}
+
+// Parsimony is implemented by a Required controlling bloat, see
+// image_formula_find.Bloat.
+type Parsimony interface {
+	BloatControl() image_formula_find.Bloat
+}
+
+// Views is implemented by a Required drawing the formulas through a view
+// other than the default one, X and Y from -10 to 10.
+type Views interface {
+	View() drawer1.Viewport
+}
+
+// ViewGenes is implemented by a Required evolving the viewport of each
+// individual along with its formulas, starting from the view of Views. Only
+// encodings that breed viewports, gp so far, act on it; the others draw every
+// individual through the view of Views.
+type ViewGenes interface {
+	EvolveView() bool
+}
+
+// BasicRequired is a Required implementing all the optional interfaces with
+// its fields.
+type BasicRequired struct {
+	R image.Rectangle
+	I image.Image
+	// Policy and Penalty implement Numerics.
+	Policy  image_formula_find.Policy
+	Penalty float64
+	// Silhouette implements Silhouettes.
+	Silhouette bool
+	// Bloat implements Parsimony.
+	Bloat image_formula_find.Bloat
+	// Viewport implements Views.
+	Viewport drawer1.Viewport
+	// EvolveViewport implements ViewGenes.
+	EvolveViewport bool
+}
+
+func (b *BasicRequired) PlotSize() image.Rectangle {
+	return b.R
+}
+
+func (b *BasicRequired) SourceImage() image.Image {
+	return b.I
+}
+
+func (b *BasicRequired) NumericPolicy() image_formula_find.Policy {
+	return b.Policy
+}
+
+func (b *BasicRequired) InvalidPenalty() float64 {
+	return b.Penalty
+}
+
+func (b *BasicRequired) FitSilhouette() bool {
+	return b.Silhouette
+}
+
+func (b *BasicRequired) BloatControl() image_formula_find.Bloat {
+	return b.Bloat
+}
+
+func (b *BasicRequired) View() drawer1.Viewport {
+	return b.Viewport
+}
+
+func (b *BasicRequired) EvolveView() bool {
+	return b.EvolveViewport
+}
+
+// NumericsOf returns the policy and invalid pixel penalty of required, IEEE
+// arithmetic and none when it does not implement Numerics.
+func NumericsOf(required Required) (image_formula_find.Policy, float64) {
+	if n, ok := required.(Numerics); ok {
+		return n.NumericPolicy(), n.InvalidPenalty()
+	}
+	return image_formula_find.IEEE, 0
+}
+
+// BloatOf returns the bloat control of required, none when it does not
+// implement Parsimony.
+func BloatOf(required Required) image_formula_find.Bloat {
+	if p, ok := required.(Parsimony); ok {
+		return p.BloatControl()
+	}
+	return image_formula_find.Bloat{}
+}
+
+// ViewOf returns the view of required, the default one when it does not
+// implement Views.
+func ViewOf(required Required) drawer1.Viewport {
+	if v, ok := required.(Views); ok {
+		return v.View()
+	}
+	return drawer1.Viewport{}
+}
+
+// FitsSilhouette reports whether required fits a silhouette, see Silhouettes.
+func FitsSilhouette(required Required) bool {
+	s, ok := required.(Silhouettes)
+	return ok && s.FitSilhouette()
+}
+
+// EvolvesView reports whether required evolves the viewport, see ViewGenes.
+func EvolvesView(required Required) bool {
+	e, ok := required.(ViewGenes)
+	return ok && e.EvolveView()
+}
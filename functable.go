@@ -0,0 +1,96 @@
+package image_formula_find
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FunctionTableVersion is the newest function table.
+const FunctionTableVersion = 3
+
+// functionTables hold, by version, the names of the functions in each table
+// in the order genes index them. A released table never changes, offering
+// different functions takes a new version, so DNA stored with a table
+// version decodes to the same formula for good.
+var functionTables = [][]string{
+	// Version 0 is the single list dna1 used to index for genes of either
+	// arity, in name order. A gene naming a function of the other arity
+	// calls an unknown function. Before tables existed the list was in a
+	// random order in every process, so DNA stored back then cannot be
+	// decoded reliably with any table.
+	{
+		"Abs", "Acos", "Acosh", "Asin", "Asinh", "Atan", "Atan2", "Atanh", "Cbrt", "Ceil",
+		"Copysign", "Cos", "Cosh", "Dim", "Erf", "Erfc", "Erfcinv", "Erfinv", "Exp", "Exp2",
+		"Expm1", "Floor", "Gamma", "Hypot", "Ilogb", "Inf", "J0", "J1", "Jn", "Ldexp",
+		"Log", "Log10", "Log1p", "Log2", "Logb", "Max", "Min", "Mod", "Nextafter", "Pow",
+		"Pow10", "Remainder", "Round", "RoundToEven", "Sin", "Sinh", "Sqrt", "Tan", "Tanh", "Trunc",
+		"Y0", "Y1", "Yn",
+	},
+	// Version 1 holds the evolvable functions, split by arity.
+	{
+		"Abs", "Acos", "Acosh", "Asin", "Asinh", "Atan", "Atanh", "Cbrt", "Ceil", "Cos",
+		"Cosh", "Erf", "Erfc", "Erfcinv", "Erfinv", "Exp", "Exp2", "Expm1", "Floor", "Gamma",
+		"J0", "J1", "Log", "Log10", "Log1p", "Log2", "Logb", "Round", "RoundToEven", "Sin",
+		"Sinh", "Sqrt", "Tan", "Tanh", "Trunc", "Y0", "Y1",
+		"Atan2", "Copysign", "Dim", "Hypot", "Max", "Min", "Mod", "Pow", "Remainder",
+	},
+	// Version 2 adds Step and the functions of three arguments.
+	{
+		"Abs", "Acos", "Acosh", "Asin", "Asinh", "Atan", "Atanh", "Cbrt", "Ceil", "Cos",
+		"Cosh", "Erf", "Erfc", "Erfcinv", "Erfinv", "Exp", "Exp2", "Expm1", "Floor", "Gamma",
+		"J0", "J1", "Log", "Log10", "Log1p", "Log2", "Logb", "Round", "RoundToEven", "Sin",
+		"Sinh", "Sqrt", "Tan", "Tanh", "Trunc", "Y0", "Y1",
+		"Atan2", "Copysign", "Dim", "Hypot", "Max", "Min", "Mod", "Pow", "Remainder", "Step",
+		"Clamp", "If", "Lerp", "Smoothstep",
+	},
+	// Version 3 adds the noise functions.
+	{
+		"Abs", "Acos", "Acosh", "Asin", "Asinh", "Atan", "Atanh", "Cbrt", "Ceil", "Cos",
+		"Cosh", "Erf", "Erfc", "Erfcinv", "Erfinv", "Exp", "Exp2", "Expm1", "Floor", "Gamma",
+		"J0", "J1", "Log", "Log10", "Log1p", "Log2", "Logb", "Round", "RoundToEven", "Sin",
+		"Sinh", "Sqrt", "Tan", "Tanh", "Trunc", "Y0", "Y1",
+		"Atan2", "Copysign", "Dim", "Hypot", "Max", "Min", "Mod", "Perlin", "Pow", "Remainder",
+		"Simplex", "Step", "ValueNoise", "Worley",
+		"Clamp", "Fbm", "If", "Lerp", "Perlin3", "Simplex3", "Smoothstep", "Turbulence", "ValueNoise3", "Worley3",
+	},
+}
+
+// FunctionTable returns the function set of a table version.
+func FunctionTable(version int) (*FunctionSet, error) {
+	if version < 0 || version >= len(functionTables) {
+		return nil, fmt.Errorf("unknown function table version %d", version)
+	}
+	s, err := NewFunctionSet(functionTables[version]...)
+	if err != nil {
+		return nil, fmt.Errorf("function table version %d: %w", version, err)
+	}
+	if version == 0 {
+		all := append(append([]*FunctionDef{}, s.Single...), s.Double...)
+		byName := map[string]*FunctionDef{}
+		for _, def := range all {
+			byName[def.Name] = def
+		}
+		s.Single, s.Double = make([]*FunctionDef, len(all)), make([]*FunctionDef, len(all))
+		for i, name := range functionTables[0] {
+			s.Single[i], s.Double[i] = byName[name], byName[name]
+		}
+	}
+	s.Table = "v" + strconv.Itoa(version)
+	return s, nil
+}
+
+// Version returns the version of a numbered function table, reporting false
+// for other sets.
+func (s *FunctionSet) Version() (int, bool) {
+	return tableVersion(s.Table)
+}
+
+// tableVersion returns the version of a table header such as "v1".
+func tableVersion(header string) (int, bool) {
+	if !strings.HasPrefix(header, "v") {
+		return 0, false
+	}
+	v, err := strconv.Atoi(header[1:])
+	return v, err == nil
+}
@@ -0,0 +1,52 @@
+package image_formula_find
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFunctionTables(t *testing.T) {
+	// Changing the evolvable functions needs a new table version, the
+	// released tables must not change.
+	newest, err := FunctionTable(FunctionTableVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := newest.Names(), EvolvableFunctions().Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Table v%d has %v, evolvable functions are %v", FunctionTableVersion, got, want)
+	}
+	for v := range functionTables {
+		if _, err := FunctionTable(v); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := FunctionTable(len(functionTables)); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+
+	v0, err := ParseFunctionSet("v0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v0.Single) != 53 || len(v0.Double) != 53 {
+		t.Fatalf("Table v0 has %d single and %d double functions", len(v0.Single), len(v0.Double))
+	}
+	if v0.SingleName(6) != "Atan2" || v0.DoubleName(53+44) != "Sin" || v0.String() != "v0" {
+		t.Errorf("Unexpected table v0 %s: %s %s", v0, v0.SingleName(6), v0.DoubleName(53+44))
+	}
+	if _, err := ParseFunctionSet("v99"); err == nil {
+		t.Error("Expected an error for an unknown table")
+	}
+
+	custom, err := ParseFunctionSet("Sin,Atan2,Cos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseFunctionSet(custom.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(custom.Names(), again.Names()) {
+		t.Errorf("%s decoded to %s", custom, again)
+	}
+}
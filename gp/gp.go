@@ -0,0 +1,434 @@
+// Package gp evolves formulas as expression trees, the way tree based
+// genetic programming does, rather than as strings decoded to formulas like
+// the dna packages. Variation works on the trees themselves, so a mutation
+// changes the part of a formula it touches and nothing else.
+package gp
+
+import (
+	"fmt"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"image-formula-find/evolve"
+	"math"
+	"math/rand"
+)
+
+// Functions is the set function nodes draw from, by default the newest
+// function table. Commands replace it before a run to offer the evolution a
+// different selection.
+var Functions = functionTable(image_formula_find.FunctionTableVersion)
+
+func functionTable(version int) *image_formula_find.FunctionSet {
+	s, err := image_formula_find.FunctionTable(version)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Vars are the variables terminals draw from. T is left out as images are
+// drawn at a single time.
+var Vars = []string{"X", "Y", "R", "A"}
+
+const (
+	// MinDepth and MaxInitialDepth are the range of depths ramped half and
+	// half initialisation ramps over.
+	MinDepth        = 2
+	MaxInitialDepth = 6
+	// MaxDepth is the deepest a tree may become through variation, deeper
+	// offspring are dropped.
+	MaxDepth = 17
+	// MutationDepth is the depth of the subtrees subtree mutation grows.
+	MutationDepth = 4
+	// Sigma is the relative standard deviation of Gaussian constant
+	// perturbation.
+	Sigma = 0.1
+	// internalBias is the chance crossover picks a function node rather than
+	// a terminal, when there are any.
+	internalBias = 0.9
+)
+
+// DNA writes the formulas as a script, which ParseDNA reads back.
+func DNA(rf, bf, gf *image_formula_find.Function) string {
+	return fmt.Sprintf("r = %s; g = %s; b = %s", rf.Equals.RHS, gf.Equals.RHS, bf.Equals.RHS)
+}
+
+// ParseDNA reads the red, blue and green formulas back from a script written
+// by DNA, or any other script, with the brackets the text needed removed.
+// Channels missing from the script, or all of them for text that is not a
+// script, are 0.
+func ParseDNA(dna string) (*image_formula_find.Function, *image_formula_find.Function, *image_formula_find.Function) {
+	channels := [3]*image_formula_find.Function{}
+	if s, err := image_formula_find.ParseScript(dna); err == nil {
+		channels = [3]*image_formula_find.Function{s.Red, s.Blue, s.Green}
+	}
+	for c, f := range channels {
+		e := image_formula_find.Expression(&image_formula_find.Const{})
+		if f != nil && f.Equals != nil {
+			e = image_formula_find.Rewrite(f.Equals.RHS, func(e image_formula_find.Expression) image_formula_find.Expression {
+				if b, ok := e.(*image_formula_find.Brackets); ok {
+					return b.Expr
+				}
+				return e
+			})
+		}
+		channels[c] = function(e)
+	}
+	return channels[0], channels[1], channels[2]
+}
+
+// function wraps an expression as a channel formula.
+func function(e image_formula_find.Expression) *image_formula_find.Function {
+	return &image_formula_find.Function{Equals: &image_formula_find.Equals{RHS: e}}
+}
+
+// Constant returns a random constant, on the scale of the coordinates half
+// the time and of a channel otherwise.
+func Constant() image_formula_find.Expression {
+	if rand.Intn(2) == 0 {
+		return &image_formula_find.Const{Value: math.Round(rand.Float64()*200-100) / 10}
+	}
+	return &image_formula_find.Const{Value: math.Round(rand.Float64()*510 - 255)}
+}
+
+// Terminal returns a random variable or, a third of the time, a constant.
+func Terminal() image_formula_find.Expression {
+	if rand.Intn(3) == 0 {
+		return Constant()
+	}
+	return &image_formula_find.Var{Var: Vars[rand.Intn(len(Vars))]}
+}
+
+// operator returns a random node taking the operands, of which there are 1
+// to 3: arithmetic half the time and a function from Functions otherwise.
+// Without functions of three arguments the third operand is dropped.
+func operator(a ...image_formula_find.Expression) image_formula_find.Expression {
+	switch len(a) {
+	case 1:
+		if rand.Intn(2) == 0 || len(Functions.Single) == 0 {
+			return &image_formula_find.Negate{Expr: a[0]}
+		}
+		return image_formula_find.NewSingleFunction(Functions.SingleName(rand.Intn(len(Functions.Single))), a[0])
+	case 3:
+		if len(Functions.Triple) > 0 {
+			return image_formula_find.NewTripleFunction(Functions.TripleName(rand.Intn(len(Functions.Triple))), a[0], a[1], a[2])
+		}
+	}
+	if rand.Intn(2) == 0 || len(Functions.Double) == 0 {
+		switch rand.Intn(6) {
+		case 0:
+			return &image_formula_find.Plus{LHS: a[0], RHS: a[1]}
+		case 1:
+			return &image_formula_find.Subtract{LHS: a[0], RHS: a[1]}
+		case 2:
+			return &image_formula_find.Multiply{LHS: a[0], RHS: a[1]}
+		case 3:
+			return &image_formula_find.Divide{LHS: a[0], RHS: a[1]}
+		case 4:
+			return &image_formula_find.Power{LHS: a[0], RHS: a[1]}
+		default:
+			return &image_formula_find.Modulus{LHS: a[0], RHS: a[1]}
+		}
+	}
+	return image_formula_find.NewDoubleFunction(Functions.DoubleName(rand.Intn(len(Functions.Double))), a[0], a[1], false)
+}
+
+// arity returns a random arity for a new function node, mostly 2 like the
+// arithmetic operators.
+func arity() int {
+	switch n := rand.Intn(8); {
+	case n < 2:
+		return 1
+	case n < 7 || len(Functions.Triple) == 0:
+		return 2
+	}
+	return 3
+}
+
+// Grow returns a random tree at most depth deep whose branches stop at a
+// terminal at random.
+func Grow(depth int) image_formula_find.Expression {
+	if depth <= 1 || rand.Intn(10) < 3 {
+		return Terminal()
+	}
+	return node(depth, Grow)
+}
+
+// Full returns a random tree whose branches are all depth deep.
+func Full(depth int) image_formula_find.Expression {
+	if depth <= 1 {
+		return Terminal()
+	}
+	return node(depth, Full)
+}
+
+func node(depth int, grow func(int) image_formula_find.Expression) image_formula_find.Expression {
+	operands := make([]image_formula_find.Expression, arity())
+	for i := range operands {
+		operands[i] = grow(depth - 1)
+	}
+	return operator(operands...)
+}
+
+// RampedHalfAndHalf returns n random trees with depths ramped evenly from
+// MinDepth to MaxInitialDepth, half grown and half full at each depth.
+func RampedHalfAndHalf(n int) []image_formula_find.Expression {
+	trees := make([]image_formula_find.Expression, n)
+	for i := range trees {
+		depth := MinDepth + (i/2)%(MaxInitialDepth-MinDepth+1)
+		if i%2 == 0 {
+			trees[i] = Grow(depth)
+		} else {
+			trees[i] = Full(depth)
+		}
+	}
+	return trees
+}
+
+// Population returns n new individuals, whose trees taken together are
+// ramped half and half.
+func Population(n int) []*Individual {
+	trees := RampedHalfAndHalf(3 * n)
+	rand.Shuffle(len(trees), func(i, j int) { trees[i], trees[j] = trees[j], trees[i] })
+	individuals := make([]*Individual, n)
+	for i := range individuals {
+		individuals[i] = NewIndividual(function(trees[3*i]), function(trees[3*i+1]), function(trees[3*i+2]))
+	}
+	return individuals
+}
+
+// Nodes returns the subtrees of e in postorder, operands before the node
+// holding them. Positions in this order address subtrees for Replace.
+func Nodes(e image_formula_find.Expression) []image_formula_find.Expression {
+	var nodes []image_formula_find.Expression
+	var walk func(image_formula_find.Expression)
+	walk = func(e image_formula_find.Expression) {
+		for _, each := range image_formula_find.Operands(e) {
+			walk(each)
+		}
+		nodes = append(nodes, e)
+	}
+	walk(e)
+	return nodes
+}
+
+// Size returns the number of nodes of e.
+func Size(e image_formula_find.Expression) int {
+	return len(Nodes(e))
+}
+
+// Replace returns e with the subtree at position i of Nodes replaced by sub.
+// e is not modified.
+func Replace(e image_formula_find.Expression, i int, sub image_formula_find.Expression) image_formula_find.Expression {
+	n := -1
+	return image_formula_find.Rewrite(e, func(e image_formula_find.Expression) image_formula_find.Expression {
+		if n++; n == i {
+			return sub
+		}
+		return e
+	})
+}
+
+// pick returns the position of a random node, a function node rather than a
+// terminal with probability bias when there are any.
+func pick(nodes []image_formula_find.Expression, bias float64) int {
+	var internal []int
+	for i, each := range nodes {
+		if len(image_formula_find.Operands(each)) > 0 {
+			internal = append(internal, i)
+		}
+	}
+	if len(internal) > 0 && rand.Float64() < bias {
+		return internal[rand.Intn(len(internal))]
+	}
+	return rand.Intn(len(nodes))
+}
+
+// SubtreeMutate replaces a random subtree with a grown one.
+func SubtreeMutate(e image_formula_find.Expression) image_formula_find.Expression {
+	return Replace(e, rand.Intn(Size(e)), Grow(MutationDepth))
+}
+
+// PointMutate replaces a random node with another of the same arity, keeping
+// its operands, or a terminal with another terminal.
+func PointMutate(e image_formula_find.Expression) image_formula_find.Expression {
+	nodes := Nodes(e)
+	i := rand.Intn(len(nodes))
+	operands := image_formula_find.Operands(nodes[i])
+	if len(operands) == 0 {
+		return Replace(e, i, Terminal())
+	}
+	return Replace(e, i, operator(operands...))
+}
+
+// HoistMutate returns a random subtree of e, which becomes the whole tree.
+func HoistMutate(e image_formula_find.Expression) image_formula_find.Expression {
+	nodes := Nodes(e)
+	return nodes[pick(nodes, internalBias)]
+}
+
+// ShrinkMutate replaces a random function node with a terminal. Trees
+// without one are returned as they are.
+func ShrinkMutate(e image_formula_find.Expression) image_formula_find.Expression {
+	nodes := Nodes(e)
+	i := pick(nodes, 1)
+	if len(image_formula_find.Operands(nodes[i])) == 0 {
+		return e
+	}
+	return Replace(e, i, Terminal())
+}
+
+// PerturbConstants adds Gaussian noise to every constant, with a standard
+// deviation of Sigma times its magnitude, or of Sigma for those under 1.
+func PerturbConstants(e image_formula_find.Expression) image_formula_find.Expression {
+	return image_formula_find.Rewrite(e, func(e image_formula_find.Expression) image_formula_find.Expression {
+		if c, ok := e.(*image_formula_find.Const); ok {
+			return &image_formula_find.Const{Value: c.Value + rand.NormFloat64()*Sigma*math.Max(1, math.Abs(c.Value))}
+		}
+		return e
+	})
+}
+
+// Crossover returns a with a random subtree replaced by a random subtree of
+// b, both function nodes with probability 0.9.
+func Crossover(a, b image_formula_find.Expression) image_formula_find.Expression {
+	nodes := Nodes(b)
+	return Replace(a, pick(Nodes(a), internalBias), nodes[pick(nodes, internalBias)])
+}
+
+// Mutations are the mutation operators Mutate chooses from.
+var Mutations = []func(image_formula_find.Expression) image_formula_find.Expression{
+	SubtreeMutate, PointMutate, HoistMutate, ShrinkMutate, PerturbConstants,
+}
+
+// Mutate returns the individual with one channel changed by a random
+// mutation operator, keeping its viewport.
+func Mutate(p *Individual) *Individual {
+	trees := channels(p)
+	c := rand.Intn(len(trees))
+	trees[c] = Mutations[rand.Intn(len(Mutations))](trees[c])
+	child := NewIndividual(function(trees[0]), function(trees[1]), function(trees[2]))
+	child.Viewport = p.Viewport
+	return child
+}
+
+// Breed returns the first individual with a subtree of one channel crossed
+// over from the same channel of the second, keeping the viewport of the
+// first.
+func Breed(p1, p2 *Individual) *Individual {
+	trees := channels(p1)
+	c := rand.Intn(len(trees))
+	trees[c] = Crossover(trees[c], channels(p2)[c])
+	child := NewIndividual(function(trees[0]), function(trees[1]), function(trees[2]))
+	child.Viewport = p1.Viewport
+	return child
+}
+
+// MutateView returns the individual with the same formulas and a random
+// change to its viewport: the centre moved, the scale or the rotation
+// perturbed, by Sigma of the scale, Sigma relatively or Sigma radians.
+func MutateView(p *Individual) *Individual {
+	v := p.Viewport
+	scale := v.Scale
+	if scale <= 0 {
+		scale = drawer1.DefaultScale
+	}
+	switch rand.Intn(3) {
+	case 0:
+		v.CenterX += rand.NormFloat64() * Sigma * scale
+		v.CenterY += rand.NormFloat64() * Sigma * scale
+	case 1:
+		v.Scale = scale * math.Exp(rand.NormFloat64()*Sigma)
+	case 2:
+		v.Rotation += rand.NormFloat64() * Sigma
+	}
+	child := &Individual{Individual: evolve.Individual{DNA: p.DNA, Rf: p.Rf, Bf: p.Bf, Gf: p.Gf}}
+	child.Viewport = v
+	return child
+}
+
+// channels returns the red, blue and green trees of an individual.
+func channels(i *Individual) []image_formula_find.Expression {
+	return []image_formula_find.Expression{i.Rf.Equals.RHS, i.Bf.Equals.RHS, i.Gf.Equals.RHS}
+}
+
+// Valid reports whether every tree of the individual is within MaxDepth.
+func Valid(i *Individual) bool {
+	for _, each := range channels(i) {
+		if each.Depth() > MaxDepth {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	childrenCount = 10
+)
+
+// GenerationProcess breeds the next generation from the last, like the
+// GenerationProcess of the dna packages. The parents carry over, each is
+// mutated several times, pairs of them are crossed over and the rest are new
+// ramped half and half individuals. Individuals that render the same as
+// another are only scored once.
+func GenerationProcess(worker Required, lastGeneration []*Individual, generation int) []*Individual {
+	const mutations = 8
+	control := evolve.BloatOf(worker)
+	children := make([]*Individual, 0, len(lastGeneration)*(mutations+2)+len(lastGeneration)+childrenCount)
+
+	// Individuals with the same formulas drawn through different viewports
+	// render differently.
+	phenotypes := map[drawer1.Viewport]*image_formula_find.Phenotypes{}
+	fresh := func(i *Individual) bool {
+		if phenotypes[i.Viewport] == nil {
+			phenotypes[i.Viewport] = &image_formula_find.Phenotypes{}
+		}
+		return phenotypes[i.Viewport].Add(i.Rf, i.Bf, i.Gf)
+	}
+	add := func(child *Individual, parents ...*Individual) {
+		if !Valid(child) || child.Degenerate(worker) || !fresh(child) {
+			return
+		}
+		child.Parent = parents
+		child.FirstGeneration = generation
+		child.Lineage = child.DNA
+		if len(parents) == 1 {
+			child.Lineage = parents[0].DNA
+		}
+		children = append(children, child)
+	}
+
+	for _, p := range lastGeneration {
+		if fresh(p) {
+			children = append(children, p)
+		}
+	}
+	for _, p := range lastGeneration {
+		for i := 0; i < mutations; i++ {
+			add(Mutate(p), p)
+		}
+		if evolve.EvolvesView(worker) {
+			add(MutateView(p), p)
+		}
+	}
+	if len(lastGeneration) > 1 {
+		for range lastGeneration {
+			p1 := evolve.Choose(control, lastGeneration)
+			p2 := evolve.Choose(control, lastGeneration)
+			if p1 != p2 {
+				add(Breed(p1, p2), p1, p2)
+			}
+		}
+	}
+	for tries := 0; len(children) < childrenCount+len(lastGeneration) && tries < 100; tries++ {
+		for _, each := range Population(childrenCount) {
+			each.Viewport = evolve.ViewOf(worker)
+			add(each)
+		}
+	}
+
+	children = evolve.ControlBloat(control, children, generation)
+
+	children = evolve.Rank(worker, children)
+	return children[:min(childrenCount, len(children))]
+}
@@ -0,0 +1,229 @@
+package gp
+
+import (
+	"image"
+	"image-formula-find"
+	"image-formula-find/drawer1"
+	"image-formula-find/evolve"
+	"image/color"
+	"testing"
+)
+
+func parse(t *testing.T, formula string) image_formula_find.Expression {
+	t.Helper()
+	rf, _, _ := ParseDNA("r = " + formula)
+	return rf.Equals.RHS
+}
+
+func TestRampedHalfAndHalf(t *testing.T) {
+	trees := RampedHalfAndHalf(40)
+	depths := map[int]bool{}
+	for i, each := range trees {
+		depth := each.Depth()
+		if depth < 1 || depth > MaxInitialDepth {
+			t.Errorf("%s is %d deep", each, depth)
+		}
+		if want := MinDepth + (i/2)%(MaxInitialDepth-MinDepth+1); i%2 == 1 && depth != want {
+			t.Errorf("Full tree %s is %d deep, want %d", each, depth, want)
+		}
+		depths[depth] = true
+	}
+	for depth := MinDepth; depth <= MaxInitialDepth; depth++ {
+		if !depths[depth] {
+			t.Errorf("No tree %d deep", depth)
+		}
+	}
+}
+
+func TestNodesAndReplace(t *testing.T) {
+	e := parse(t, "x + y * 2")
+	var got []string
+	for _, each := range Nodes(e) {
+		got = append(got, each.String())
+	}
+	want := []string{"x", "y", "2", "y * 2", "x + y * 2"}
+	if len(got) != len(want) {
+		t.Fatalf("Nodes() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Nodes()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if r := Replace(e, 1, &image_formula_find.Var{Var: "R"}); r.String() != "x + R * 2" {
+		t.Errorf("Replace(1) = %s", r)
+	}
+	if r := Replace(e, 4, &image_formula_find.Var{Var: "A"}); r.String() != "A" {
+		t.Errorf("Replace(4) = %s", r)
+	}
+	if e.String() != "x + y * 2" {
+		t.Errorf("Replace modified the tree: %s", e)
+	}
+}
+
+func TestParseDNA(t *testing.T) {
+	for _, each := range Population(20) {
+		rf, bf, gf := ParseDNA(each.DNA)
+		if dna := DNA(rf, bf, gf); dna != each.DNA {
+			t.Errorf("ParseDNA(%q) writes back as %q", each.DNA, dna)
+		}
+	}
+	rf, bf, gf := ParseDNA("not a script")
+	for _, f := range []*image_formula_find.Function{rf, bf, gf} {
+		if f.String() != "0" {
+			t.Errorf("Channel of invalid DNA is %s", f)
+		}
+	}
+}
+
+func TestMutations(t *testing.T) {
+	for _, e := range RampedHalfAndHalf(200) {
+		before := e.String()
+		size := Size(e)
+		for _, mutate := range Mutations {
+			m := mutate(e)
+			if m == nil {
+				t.Fatalf("Mutating %s gave nil", before)
+			}
+			if e.String() != before {
+				t.Fatalf("Mutating %s modified it to %s", before, e)
+			}
+			if rf, _, _ := ParseDNA("r = " + m.String()); rf.Equals.RHS.String() != m.String() {
+				t.Fatalf("%s does not parse back, got %s", m, rf.Equals.RHS)
+			}
+		}
+		if h := HoistMutate(e); Size(h) > size {
+			t.Errorf("Hoisting %s gave the larger %s", before, h)
+		}
+		if s := ShrinkMutate(e); size > 1 && Size(s) >= size {
+			t.Errorf("Shrinking %s gave %s", before, s)
+		}
+	}
+}
+
+func TestPerturbConstants(t *testing.T) {
+	e := parse(t, "x * 100 + sin(y) - 0.5")
+	p := PerturbConstants(e)
+	nodes, perturbed := Nodes(e), Nodes(p)
+	changed := 0
+	for i := range nodes {
+		c, ok := nodes[i].(*image_formula_find.Const)
+		if !ok {
+			if nodes[i].String() == perturbed[i].String() {
+				continue
+			}
+			if _, ok := perturbed[i].(*image_formula_find.Const); !ok && len(image_formula_find.Operands(nodes[i])) == 0 {
+				t.Errorf("%s perturbed to %s", nodes[i], perturbed[i])
+			}
+			continue
+		}
+		v := perturbed[i].(*image_formula_find.Const).Value
+		if v != c.Value {
+			changed++
+		}
+		if d := v - c.Value; d > 10*Sigma*max(1, c.Value) || d < -10*Sigma*max(1, c.Value) {
+			t.Errorf("%v perturbed to %v", c.Value, v)
+		}
+	}
+	if changed != 2 {
+		t.Errorf("%d of 2 constants perturbed: %s", changed, p)
+	}
+}
+
+func TestCrossover(t *testing.T) {
+	a, b := parse(t, "x + y"), parse(t, "sin(r) * cos(a)")
+	seen := map[string]bool{}
+	for i := 0; i < 2000; i++ {
+		seen[Crossover(a, b).String()] = true
+	}
+	for _, want := range []string{"sin(r) * cos(a)", "sin(r) + y", "x + cos(a)"} {
+		if !seen[want] {
+			t.Errorf("Crossover never gave %s, got %v", want, seen)
+		}
+	}
+	if a.String() != "x + y" || b.String() != "sin(r) * cos(a)" {
+		t.Errorf("Crossover modified its parents: %s, %s", a, b)
+	}
+}
+
+func TestGenerationProcess(t *testing.T) {
+	target := image.NewRGBA(image.Rect(0, 0, 12, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			target.SetRGBA(x, y, color.RGBA{uint8(x * 20), uint8(y * 20), 128, 255})
+		}
+	}
+	req := &BasicRequired{R: target.Bounds(), I: target}
+	var generation []*Individual
+	best := 0.0
+	for g := 0; g < 5; g++ {
+		generation = GenerationProcess(req, generation, g)
+		if len(generation) == 0 || len(generation) > childrenCount {
+			t.Fatalf("Generation %d of %d individuals", g, len(generation))
+		}
+		for i, each := range generation {
+			if i > 0 && each.Score < generation[i-1].Score {
+				t.Errorf("Generation %d is not sorted", g)
+			}
+			if !Valid(each) {
+				t.Errorf("%s is deeper than %d", each.DNA, MaxDepth)
+			}
+		}
+		if g > 0 && generation[0].Score > best {
+			t.Errorf("Best score rose from %v to %v", best, generation[0].Score)
+		}
+		best = generation[0].Score
+	}
+}
+
+func TestBloatControl(t *testing.T) {
+	control := image_formula_find.Bloat{MaxDepth: 2, Tarpeian: 1}
+	shallow, deep := NewIndividual(ParseDNA("r = x + y")), NewIndividual(ParseDNA("r = sin(x + y)"))
+	large := NewIndividual(ParseDNA("r = x + y; g = x * y"))
+	kept := evolve.ControlBloat(control, []*Individual{shallow, deep, large}, 0)
+	if len(kept) != 1 || kept[0] != shallow {
+		t.Errorf("Kept %d individuals, want only %s", len(kept), shallow.DNA)
+	}
+	// The missing green and blue count as a constant each.
+	if shallow.Size != 5 || shallow.Depth != 2 {
+		t.Errorf("%s measured %d nodes %d deep", shallow.DNA, shallow.Size, shallow.Depth)
+	}
+}
+
+func TestMutateView(t *testing.T) {
+	p := NewIndividual(ParseDNA("r = x; g = y; b = 0"))
+	p.Viewport = drawer1.Viewport{Scale: 10, Aspect: drawer1.Fit}
+	for i := 0; i < 100; i++ {
+		m := MutateView(p)
+		if m.DNA != p.DNA || m.Rf != p.Rf {
+			t.Fatalf("MutateView changed the formulas to %s", m.DNA)
+		}
+		if m.Viewport == p.Viewport || m.Viewport.Scale <= 0 || m.Viewport.Aspect != drawer1.Fit {
+			t.Fatalf("MutateView(%v) gave %v", p.Viewport, m.Viewport)
+		}
+	}
+	if p.Viewport != (drawer1.Viewport{Scale: 10, Aspect: drawer1.Fit}) {
+		t.Errorf("MutateView modified its parent to %v", p.Viewport)
+	}
+}
+
+func TestGenerationProcessViewport(t *testing.T) {
+	target := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	view := drawer1.Viewport{CenterX: 5, Aspect: drawer1.Fit}
+	for _, evolves := range []bool{false, true} {
+		req := &BasicRequired{R: target.Bounds(), I: target, Viewport: view, EvolveViewport: evolves}
+		var generation []*Individual
+		for g := 0; g < 3; g++ {
+			generation = GenerationProcess(req, generation, g)
+		}
+		for _, each := range generation {
+			if !evolves && each.Viewport != view {
+				t.Errorf("%s drawn through %v, want %v", each.DNA, each.Viewport, view)
+			}
+			row := each.CsvRow()
+			if v, err := drawer1.ParseViewport(row[len(row)-1]); err != nil || v != each.Viewport {
+				t.Errorf("Viewport %v recorded as %q", each.Viewport, row[len(row)-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package gp
+
+import (
+	"image-formula-find"
+	"image-formula-find/evolve"
+)
+
+// Required, BasicRequired and Sorter are those of evolve, named here for the
+// callers of GenerationProcess.
+type (
+	Required      = evolve.Required
+	BasicRequired = evolve.BasicRequired
+	Sorter        = evolve.Sorter[*Individual]
+)
+
+// Individual is a genome of three expression trees, the red, blue and green
+// formulas, which evolve as trees rather than as strings. Its DNA is the
+// genome written as a script, see DNA, which ParseDNA reads back, and its
+// viewport is a gene of its own when the Required evolves it, see
+// evolve.ViewGenes.
+type Individual struct {
+	evolve.Individual
+	Parent []*Individual
+}
+
+// NewIndividual returns the individual with the formulas as its genome.
+func NewIndividual(rf, bf, gf *image_formula_find.Function) *Individual {
+	return &Individual{Individual: evolve.Individual{DNA: DNA(rf, bf, gf), Rf: rf, Bf: bf, Gf: gf}}
+}
+
+// Calculate renders and scores the individual. Unless required evolves the
+// viewport the individual is drawn through the view of required.
+func (i *Individual) Calculate(required Required) {
+	if !evolve.EvolvesView(required) {
+		i.Viewport = evolve.ViewOf(required)
+	}
+	i.Draw(required)
+}
@@ -0,0 +1,301 @@
+package image_formula_find
+
+import (
+	"math"
+	"strings"
+)
+
+// Structural hashing and equality treat an expression as the tree it
+// evaluates: Brackets are looked through, function and variable names are
+// case insensitive, constants compare by their bits and value and pointer
+// nodes are interchangeable. Two expressions that are Equal always have the
+// same Hash.
+
+const (
+	hashOffset = 14695981039346656037
+	hashPrime  = 1099511628211
+)
+
+// tagOther marks expression types outside this package in a hash. The other
+// node tags are those of the binary codec.
+const tagOther byte = 0xff
+
+// hasher is 64 bit FNV-1a.
+type hasher uint64
+
+func (h *hasher) byte(b byte) {
+	*h = (*h ^ hasher(b)) * hashPrime
+}
+
+func (h *hasher) uint64(v uint64) {
+	for i := 0; i < 8; i++ {
+		h.byte(byte(v >> (8 * i)))
+	}
+}
+
+func (h *hasher) string(s string) {
+	s = strings.ToUpper(s)
+	for i := 0; i < len(s); i++ {
+		h.byte(s[i])
+	}
+	h.byte(0)
+}
+
+// node returns e as a pointer node with any Brackets removed.
+func node(e Expression) Expression {
+	for {
+		if p := pointerTo(e); p != nil {
+			e = p
+		}
+		b, ok := e.(*Brackets)
+		if !ok {
+			return e
+		}
+		e = b.Expr
+	}
+}
+
+// nodeHash hashes a pointer node given the hashes of its children.
+func nodeHash(e Expression, c []uint64) uint64 {
+	h := hasher(hashOffset)
+	switch e := e.(type) {
+	case nil:
+		h.byte(tagNone)
+	case *Equals:
+		h.byte(tagEquals)
+		h.byte(byte(len(c)))
+	case *Var:
+		h.byte(tagVar)
+		h.string(e.Var)
+	case *Const:
+		h.byte(tagConst)
+		h.uint64(math.Float64bits(e.Value))
+	case *Plus:
+		h.byte(tagPlus)
+	case *Subtract:
+		h.byte(tagSubtract)
+	case *Multiply:
+		h.byte(tagMultiply)
+	case *Divide:
+		h.byte(tagDivide)
+	case *Power:
+		h.byte(tagPower)
+	case *Modulus:
+		h.byte(tagModulus)
+	case *Negate:
+		h.byte(tagNegate)
+	case *SingleFunction:
+		h.byte(tagSingleFunction)
+		h.string(e.Name)
+	case *DoubleFunction:
+		h.byte(tagDoubleFunction)
+		h.string(e.Name)
+	case *TripleFunction:
+		h.byte(tagTripleFunction)
+		h.string(e.Name)
+	case *Compare:
+		h.byte(tagCompare)
+		h.string(e.Op)
+	default:
+		h.byte(tagOther)
+		h.string(e.String())
+	}
+	for _, each := range c {
+		h.uint64(each)
+	}
+	return uint64(h)
+}
+
+// Hash returns a structural hash of the expression. Expression types outside
+// this package are hashed by their String.
+func Hash(e Expression) uint64 {
+	e = node(e)
+	c, _ := children(e)
+	hashes := make([]uint64, len(c))
+	for i, each := range c {
+		hashes[i] = Hash(each)
+	}
+	return nodeHash(e, hashes)
+}
+
+// HashFunctions returns a structural hash of several formulas together, such
+// as the channels of an individual. Nil formulas hash alike.
+func HashFunctions(fs ...*Function) uint64 {
+	h := hasher(hashOffset)
+	for _, f := range fs {
+		h.uint64(Hash(equation(f)))
+	}
+	return uint64(h)
+}
+
+// Equal reports whether a and b are the same tree. Expression types outside
+// this package are never equal.
+func Equal(a, b Expression) bool {
+	return equal(a, b)
+}
+
+// Phenotypes is a set of formulas taken together, such as the channels of
+// individuals. Formulas are looked up by HashFunctions and told apart with
+// Equal, so ones whose hashes collide are not mistaken for each other. The
+// zero value is an empty set.
+type Phenotypes struct {
+	byHash map[uint64][][]*Function
+}
+
+// Add adds the formulas to the set, reporting false when Equal ones are in
+// it already.
+func (p *Phenotypes) Add(fs ...*Function) bool {
+	h := HashFunctions(fs...)
+	for _, each := range p.byHash[h] {
+		if equalFunctions(each, fs) {
+			return false
+		}
+	}
+	if p.byHash == nil {
+		p.byHash = map[uint64][][]*Function{}
+	}
+	p.byHash[h] = append(p.byHash[h], fs)
+	return true
+}
+
+// equalFunctions reports whether two lists of formulas are Equal formula by
+// formula, nil formulas being alike as they are for HashFunctions.
+func equalFunctions(a, b []*Function) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(equation(a[i]), equation(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// equation returns the equation of a formula, nil for a nil formula.
+func equation(f *Function) Expression {
+	if f == nil || f.Equals == nil {
+		return nil
+	}
+	return f.Equals
+}
+
+// sameNode reports whether two pointer nodes have the same type and contents
+// and the very same children, which is all CSE needs to compare once the
+// children have been merged.
+func sameNode(a, b Expression) bool {
+	switch a := a.(type) {
+	case *Var:
+		b, ok := b.(*Var)
+		return ok && strings.EqualFold(a.Var, b.Var)
+	case *Const:
+		b, ok := b.(*Const)
+		return ok && math.Float64bits(a.Value) == math.Float64bits(b.Value)
+	case *SingleFunction:
+		b, ok := b.(*SingleFunction)
+		if !ok || !strings.EqualFold(a.Name, b.Name) {
+			return false
+		}
+	case *DoubleFunction:
+		b, ok := b.(*DoubleFunction)
+		if !ok || !strings.EqualFold(a.Name, b.Name) {
+			return false
+		}
+	case *TripleFunction:
+		b, ok := b.(*TripleFunction)
+		if !ok || !strings.EqualFold(a.Name, b.Name) {
+			return false
+		}
+	case *Compare:
+		b, ok := b.(*Compare)
+		if !ok || a.Op != b.Op {
+			return false
+		}
+	}
+	ca, ok := children(a)
+	if !ok {
+		return false
+	}
+	cb, ok := children(b)
+	if !ok || len(ca) != len(cb) || nodeTag(a) != nodeTag(b) {
+		return false
+	}
+	for i := range ca {
+		if !interned(ca[i]) || ca[i] != cb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeTag(e Expression) uint64 {
+	return nodeHash(e, nil)
+}
+
+// interned reports whether e is a node CSE can merge, that is a pointer node
+// of this package other than Brackets.
+func interned(e Expression) bool {
+	if _, ok := e.(*Brackets); ok {
+		return false
+	}
+	_, ok := children(e)
+	return ok
+}
+
+// dag merges structurally equal subtrees into a single node. After count,
+// refs holds the number of parents of each node.
+type dag struct {
+	nodes map[uint64][]Expression
+	refs  map[Expression]int
+}
+
+func newDAG() *dag {
+	return &dag{nodes: map[uint64][]Expression{}, refs: map[Expression]int{}}
+}
+
+// intern returns the node of the DAG equal to e, adding it if needed, and
+// its hash.
+func (d *dag) intern(e Expression) (Expression, uint64) {
+	e = node(e)
+	c, ok := children(e)
+	if !ok {
+		return e, Hash(e)
+	}
+	hashes := make([]uint64, len(c))
+	merged := make([]Expression, len(c))
+	for i, each := range c {
+		merged[i], hashes[i] = d.intern(each)
+	}
+	n := withChildren(e, merged)
+	h := nodeHash(n, hashes)
+	for _, each := range d.nodes[h] {
+		if sameNode(each, n) {
+			return each, h
+		}
+	}
+	d.nodes[h] = append(d.nodes[h], n)
+	return n, h
+}
+
+// count adds the parents of the nodes below e to refs.
+func (d *dag) count(e Expression) {
+	c, _ := children(e)
+	for _, each := range c {
+		if !interned(each) {
+			continue
+		}
+		d.refs[each]++
+		if d.refs[each] == 1 {
+			d.count(each)
+		}
+	}
+}
+
+// CSE returns e with structurally equal subtrees replaced by one shared node,
+// turning the tree into a DAG. The result evaluates to the same value as e
+// and is made of pointer nodes without Brackets. Compile applies the same
+// pass so that a shared subtree is evaluated once per pixel.
+func CSE(e Expression) Expression {
+	n, _ := newDAG().intern(e)
+	return n
+}
@@ -0,0 +1,163 @@
+package image_formula_find
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHashAndEqual(t *testing.T) {
+	r := rand.New(rand.NewSource(10))
+	hashes := map[uint64]*Function{}
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 5)
+		// The parsed copy has pointer nodes and brackets where the original
+		// may have value nodes and none.
+		g, err := ParseFunction(f.String())
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if !Equal(f.Equals, g.Equals) {
+			t.Fatalf("%s: not equal to its parsed copy", f)
+		}
+		h := Hash(f.Equals)
+		if h != Hash(g.Equals) || HashFunctions(f) != HashFunctions(g) {
+			t.Fatalf("%s: hash differs from its parsed copy", f)
+		}
+		if other, ok := hashes[h]; ok && !Equal(other.Equals, f.Equals) {
+			t.Fatalf("%s and %s: same hash", other, f)
+		}
+		hashes[h] = f
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"y = x + 1", "Y = (X) + 1", true},
+		{"0 = sin(x)", "0 = SIN((x))", true},
+		{"y = x + 1", "y = 1 + x", false},
+		{"y = x + 1", "x + 1", false},
+		{"0 = sin(x)", "0 = cos(x)", false},
+		{"0 = x * 0", "0 = x * -0", false},
+		{"0 = atan2(x, y)", "0 = x atan2 y", true},
+	} {
+		a, err := ParseFunction(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseFunction(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Equal(a.Equals, b.Equals); got != test.equal {
+			t.Errorf("Equal(%q, %q) = %v", test.a, test.b, got)
+		}
+		if test.equal && HashFunctions(a) != HashFunctions(b) {
+			t.Errorf("%q and %q: hashes differ", test.a, test.b)
+		}
+	}
+	if HashFunctions(nil, &Function{}) != HashFunctions(&Function{}, nil) {
+		t.Error("Nil formulas should hash alike")
+	}
+}
+
+func TestPhenotypes(t *testing.T) {
+	parse := func(s string) *Function {
+		f, err := ParseFunction(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	a, b := parse("y = x + 1"), parse("0 = sin(x)")
+	var p Phenotypes
+	if !p.Add(a, b, nil) || !p.Add(b, a, nil) {
+		t.Error("Distinct formulas were in the set")
+	}
+	if p.Add(parse("Y = (X) + 1"), b, &Function{}) {
+		t.Error("Equal formulas were added twice")
+	}
+	// Formulas whose hash collides with others in the set are added all the
+	// same.
+	collided := Phenotypes{byHash: map[uint64][][]*Function{HashFunctions(b): {{a}}}}
+	if !collided.Add(b) {
+		t.Errorf("%s was mistaken for %s", b, a)
+	}
+	if collided.Add(b) {
+		t.Errorf("%s was added twice", b)
+	}
+}
+
+// repeated returns a formula built from several structurally equal but
+// separately allocated copies of the same random subtree.
+func repeated(r *rand.Rand) *Function {
+	seed := r.Int63()
+	subtree := func() Expression {
+		return randomExpression(rand.New(rand.NewSource(seed)), 4)
+	}
+	return &Function{Equals: &Equals{
+		LHS: &SingleFunction{Name: "Sin", Expr: subtree()},
+		RHS: &Plus{LHS: subtree(), RHS: &Multiply{LHS: subtree(), RHS: randomExpression(r, 3)}},
+	}}
+}
+
+func TestCSE(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	points := [][3]float64{{0, 0, 0}, {1.5, -2, 1}, {-10, 10, 3}}
+	for i := 0; i < 500; i++ {
+		f := repeated(r)
+		dag := &Function{Equals: CSE(f.Equals).(*Equals)}
+		if !Equal(f.Equals, dag.Equals) {
+			t.Fatalf("%s: CSE changed the tree to %s", f, dag)
+		}
+		if dag.Equals.LHS.(*SingleFunction).Expr != dag.Equals.RHS.(*Plus).LHS {
+			t.Fatalf("%s: repeated subtree not shared", f)
+		}
+		p := Compile(f)
+		for _, pt := range points {
+			want, _, err := f.Evaluate(pt[0], pt[1], pt[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _, _ := dag.Evaluate(pt[0], pt[1], pt[2]); !sameFloat(got, want) {
+				t.Fatalf("%s at %v: DAG %v, tree %v", f, pt, got, want)
+			}
+			if got := p.Evaluate(pt[0], pt[1], pt[2]); !sameFloat(got, want) {
+				t.Fatalf("%s at %v: compiled %v, tree %v", f, pt, got, want)
+			}
+		}
+		xs := []float64{-10, -1, 0, 3.5}
+		out := make([]float64, len(xs))
+		p.EvaluateRow(xs, 2, 1, out)
+		for j, x := range xs {
+			if want, _, _ := f.Evaluate(x, 2, 1); !sameFloat(out[j], want) {
+				t.Fatalf("%s at (%v, 2): row %v, tree %v", f, x, out[j], want)
+			}
+		}
+	}
+}
+
+func TestCompileSharesSubtrees(t *testing.T) {
+	f, err := ParseFunction("y = sin(x * y) + sin(x * y) * (sin(x*y) - 2 ^ 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Compile(f)
+	calls, stores := 0, 0
+	for _, in := range p.Code {
+		switch in.Op {
+		case OpCall1:
+			calls++
+		case OpStore:
+			stores++
+		}
+	}
+	if calls != 1 || stores != 1 || p.Registers != 1 {
+		t.Errorf("Expected sin evaluated once in one register, got %d calls, %d stores, %d registers: %+v", calls, stores, p.Registers, p.Code)
+	}
+	if got, _, _ := f.Evaluate(1, 2, 0); p.Evaluate(1, 2, 0) != got {
+		t.Errorf("Compiled %v, evaluated %v", p.Evaluate(1, 2, 0), got)
+	}
+}
@@ -41,3 +41,38 @@ func (cow *CopyOnRead) At(x, y int) color.Color {
 	cow.Copy.Set(x, y, c)
 	return c
 }
+
+// SilhouetteDistance compares an image with a silhouette, a black on white
+// mask or logo, both read as if over white. Each pixel of the image covers
+// the silhouette by its darkness, so black is inside and white, or
+// transparent, outside. The silhouette itself is made black and white
+// first, see Inside. The distance is the sum over the pixels of how far the
+// image is from the silhouette, the number of mismatched pixels for a black
+// and white image.
+func SilhouetteDistance(silhouette image.Image, i image.Image) float64 {
+	r := 0.0
+	xmax := min(silhouette.Bounds().Dx(), i.Bounds().Dx())
+	ymax := min(silhouette.Bounds().Dy(), i.Bounds().Dy())
+	for x := 0; x < xmax; x++ {
+		for y := 0; y < ymax; y++ {
+			r += math.Abs(Inside(silhouette.At(x, y)) - Darkness(i.At(x, y)))
+		}
+	}
+	return r
+}
+
+// Darkness returns how dark a colour is over white, from 0 for white or
+// transparent to 1 for black.
+func Darkness(c color.Color) float64 {
+	_, _, _, a := c.RGBA()
+	return float64(a-uint32(color.Gray16Model.Convert(c).(color.Gray16).Y)) / 0xffff
+}
+
+// Inside returns 1 for a colour inside a silhouette, darker than mid grey,
+// and 0 otherwise.
+func Inside(c color.Color) float64 {
+	if Darkness(c) > 0.5 {
+		return 1
+	}
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	_ "embed"
 	"image"
+	"image/color"
 	_ "image/png"
 	"io"
 	"log"
@@ -48,3 +49,21 @@ func TestCalculateDistance(t *testing.T) {
 		})
 	}
 }
+
+func TestSilhouetteDistance(t *testing.T) {
+	silhouette := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	// Inside, outside, transparent and so outside, and dark so inside.
+	for x, c := range []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {}, {64, 64, 64, 255}} {
+		silhouette.SetRGBA(x, 0, c)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	for x, c := range []color.RGBA{{0, 0, 0, 255}, {0, 0, 0, 255}, {255, 255, 255, 255}, {128, 128, 128, 255}} {
+		img.SetRGBA(x, 0, c)
+	}
+	if got, want := SilhouetteDistance(silhouette, silhouette), 64.0/255; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Silhouette against itself = %v, want %v for the grey pixel", got, want)
+	}
+	if got, want := SilhouetteDistance(silhouette, img), 1+128.0/255; math.Abs(got-want) > 1e-9 {
+		t.Errorf("SilhouetteDistance() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,673 @@
+package image_formula_find
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Interval is a guaranteed range for the value of a formula. NaN is set when
+// the formula may also evaluate to NaN. An interval with Lo > Hi holds no
+// numbers, which together with NaN means the formula is always NaN.
+//
+// The bounds hold for the floating point results Evaluate produces, not just
+// for the exact real values. Arithmetic rounds monotonically so evaluating at
+// the ends of the operand ranges is enough, while library functions such as
+// Sin are widened slightly to cover their implementation error.
+type Interval struct {
+	Lo, Hi float64
+	NaN    bool
+}
+
+// IntervalState holds the ranges of the variables.
+type IntervalState struct {
+	X, Y, T Interval
+	// Width, Height, Frame and Params are as in State.
+	Width, Height int
+	Frame         Frame
+	Params        map[string]float64
+}
+
+// Point returns the interval holding only v.
+func Point(v float64) Interval {
+	if math.IsNaN(v) {
+		return nothing(true)
+	}
+	return Interval{Lo: v, Hi: v}
+}
+
+// Span returns the interval holding lo through hi.
+func Span(lo, hi float64) Interval {
+	return Interval{Lo: lo, Hi: hi}
+}
+
+// Unbounded is the result when nothing is known about a value.
+func Unbounded() Interval {
+	return Interval{Lo: math.Inf(-1), Hi: math.Inf(1), NaN: true}
+}
+
+func nothing(nan bool) Interval {
+	return Interval{Lo: math.Inf(1), Hi: math.Inf(-1), NaN: nan}
+}
+
+// Empty reports whether the interval holds no numbers.
+func (i Interval) Empty() bool {
+	return i.Lo > i.Hi
+}
+
+// Contains reports whether v lies in the interval.
+func (i Interval) Contains(v float64) bool {
+	if math.IsNaN(v) {
+		return i.NaN
+	}
+	return i.Lo <= v && v <= i.Hi
+}
+
+// Finite reports whether every value in the interval is a finite number.
+func (i Interval) Finite() bool {
+	return !i.NaN && !i.Empty() && !math.IsInf(i.Lo, 0) && !math.IsInf(i.Hi, 0)
+}
+
+func (i Interval) hasInf() bool {
+	return !i.Empty() && (math.IsInf(i.Lo, 0) || math.IsInf(i.Hi, 0))
+}
+
+func (i Interval) hasZero() bool {
+	return i.Lo <= 0 && i.Hi >= 0
+}
+
+// include extends the interval to hold v.
+func (i *Interval) include(v float64) {
+	switch {
+	case math.IsNaN(v):
+		i.NaN = true
+	case i.Empty():
+		i.Lo, i.Hi = v, v
+	default:
+		i.Lo, i.Hi = math.Min(i.Lo, v), math.Max(i.Hi, v)
+	}
+}
+
+// widen moves both ends outwards by n ulps.
+func (i Interval) widen(n int) Interval {
+	if i.Empty() {
+		return i
+	}
+	for ; n > 0; n-- {
+		i.Lo = math.Nextafter(i.Lo, math.Inf(-1))
+		i.Hi = math.Nextafter(i.Hi, math.Inf(1))
+	}
+	return i
+}
+
+// loosen widens the interval to cover the error of the math package
+// functions, which is a few ulps for most of them but grows for Pow with
+// large results.
+func (i Interval) loosen() Interval {
+	if i.Empty() {
+		return i
+	}
+	if !math.IsInf(i.Lo, 0) {
+		i.Lo -= math.Abs(i.Lo) * 1e-12
+	}
+	if !math.IsInf(i.Hi, 0) {
+		i.Hi += math.Abs(i.Hi) * 1e-12
+	}
+	return i.widen(2)
+}
+
+// corners evaluates f at the ends of a and b, which bounds any f that is
+// monotonic in each argument.
+func corners(a, b Interval, f func(x, y float64) float64) Interval {
+	r := nothing(a.NaN || b.NaN)
+	if a.Empty() || b.Empty() {
+		return r
+	}
+	for _, x := range [2]float64{a.Lo, a.Hi} {
+		for _, y := range [2]float64{b.Lo, b.Hi} {
+			r.include(f(x, y))
+		}
+	}
+	return r
+}
+
+// magnitude returns the range of |u|.
+func magnitude(u Interval) Interval {
+	switch {
+	case u.Empty():
+		return u
+	case u.Lo >= 0:
+		return Interval{Lo: u.Lo, Hi: u.Hi, NaN: u.NaN}
+	case u.Hi <= 0:
+		return Interval{Lo: -u.Hi, Hi: -u.Lo, NaN: u.NaN}
+	}
+	return Interval{Lo: 0, Hi: math.Max(-u.Lo, u.Hi), NaN: u.NaN}
+}
+
+// monotonic bounds f over u when f is monotonic on the domain [lo, hi] and
+// NaN outside it. loose results are widened with loosen.
+func monotonic(u Interval, f func(float64) float64, lo, hi float64, loose bool) Interval {
+	r := nothing(u.NaN || u.Lo < lo || u.Hi > hi)
+	if u.Empty() {
+		return r
+	}
+	u.Lo, u.Hi = math.Max(u.Lo, lo), math.Min(u.Hi, hi)
+	if u.Empty() {
+		return r
+	}
+	r.include(f(u.Lo))
+	r.include(f(u.Hi))
+	if loose {
+		r = r.loosen()
+	}
+	return r
+}
+
+// library is for math package functions that are monotonic on the domain
+// [lo, hi] and NaN outside it.
+func library(f func(float64) float64, lo, hi float64) func(Interval) Interval {
+	return func(u Interval) Interval {
+		return monotonic(u, f, lo, hi, true)
+	}
+}
+
+// exact is for monotonic functions that are correctly rounded, so need no
+// widening.
+func exact(f func(float64) float64, lo, hi float64) func(Interval) Interval {
+	return func(u Interval) Interval {
+		return monotonic(u, f, lo, hi, false)
+	}
+}
+
+// even is for library functions of |u| that increase with it.
+func even(f func(float64) float64) func(Interval) Interval {
+	return func(u Interval) Interval {
+		return monotonic(magnitude(u), f, 0, math.Inf(1), true)
+	}
+}
+
+// bounded is for functions known to stay within [lo, hi] for any arguments
+// that are not NaN.
+func bounded(lo, hi float64) func(Interval) Interval {
+	return func(u Interval) Interval {
+		return boundedPair(lo, hi)(u, u)
+	}
+}
+
+func boundedPair(lo, hi float64) func(a, b Interval) Interval {
+	return func(a, b Interval) Interval {
+		return Interval{Lo: lo, Hi: hi, NaN: a.NaN || b.NaN}
+	}
+}
+
+// sinusoid bounds Sin and Cos, where peak is the phase of the maximum.
+func sinusoid(f func(float64) float64, peak float64) func(Interval) Interval {
+	return func(u Interval) Interval {
+		const slack = 1e-9
+		if u.Empty() || u.hasInf() || math.Max(-u.Lo, u.Hi) > 1<<29 || u.Hi-u.Lo >= 2*math.Pi {
+			// Infinite arguments give NaN.
+			return Interval{Lo: -1, Hi: 1, NaN: u.NaN || u.hasInf()}
+		}
+		r := monotonic(u, f, math.Inf(-1), math.Inf(1), true)
+		hits := func(phase float64) bool {
+			k := math.Ceil((u.Lo - slack - phase) / (2 * math.Pi))
+			return phase+2*math.Pi*k <= u.Hi+slack
+		}
+		if hits(peak) {
+			r.Hi = 1
+		}
+		if hits(peak + math.Pi) {
+			r.Lo = -1
+		}
+		r.Lo, r.Hi = math.Max(r.Lo, -1), math.Min(r.Hi, 1)
+		return r
+	}
+}
+
+func tangent(u Interval) Interval {
+	const slack = 1e-6
+	switch {
+	case u.Empty():
+		return u
+	case u.hasInf() || math.Max(-u.Lo, u.Hi) > 1<<29 || u.Hi-u.Lo >= math.Pi:
+		return Unbounded()
+	}
+	k := math.Ceil((u.Lo - slack - math.Pi/2) / math.Pi)
+	if math.Pi/2+math.Pi*k <= u.Hi+slack {
+		return Interval{Lo: math.Inf(-1), Hi: math.Inf(1), NaN: u.NaN}
+	}
+	return monotonic(u, math.Tan, math.Inf(-1), math.Inf(1), true)
+}
+
+// erfcinv follows math.Erfcinv, which is Erfinv(1 - u) including the
+// rounding of the subtraction.
+func erfcinv(u Interval) Interval {
+	return library(math.Erfinv, -1, 1)(subtractInterval(Point(1), u))
+}
+
+func negateInterval(u Interval) Interval {
+	if u.Empty() {
+		return u
+	}
+	return Interval{Lo: -u.Hi, Hi: -u.Lo, NaN: u.NaN}
+}
+
+func addInterval(a, b Interval) Interval {
+	return corners(a, b, func(x, y float64) float64 { return x + y })
+}
+
+func subtractInterval(a, b Interval) Interval {
+	return corners(a, b, func(x, y float64) float64 { return x - y })
+}
+
+// multiplyInterval bounds a * b. Zero times infinity is NaN, which can hide
+// the zero products of the finite values in between the corners.
+func multiplyInterval(a, b Interval) Interval {
+	r := corners(a, b, func(x, y float64) float64 { return x * y })
+	if a.hasZero() && b.hasInf() || b.hasZero() && a.hasInf() {
+		r.NaN = true
+		r.include(0)
+	}
+	return r
+}
+
+func divideInterval(a, b Interval) Interval {
+	if !b.Empty() && b.hasZero() {
+		r := nothing(a.NaN || b.NaN || a.hasZero() || a.hasInf() && b.hasInf())
+		if !a.Empty() {
+			r.Lo, r.Hi = math.Inf(-1), math.Inf(1)
+		}
+		return r
+	}
+	r := corners(a, b, func(x, y float64) float64 { return x / y })
+	if a.hasInf() && b.hasInf() {
+		// Infinity over infinity is NaN but finite values in between give 0.
+		r.include(0)
+	}
+	return r
+}
+
+// modInterval bounds math.Mod, whose result has the sign of a and is smaller
+// in magnitude than both a and b.
+func modInterval(a, b Interval) Interval {
+	r := nothing(a.NaN || b.NaN || a.hasInf() || !b.Empty() && b.hasZero())
+	if a.Empty() || b.Empty() {
+		return r
+	}
+	m := magnitude(b).Hi
+	r.Lo, r.Hi = math.Max(a.Lo, -m), math.Min(a.Hi, m)
+	r.Lo, r.Hi = math.Min(r.Lo, 0), math.Max(r.Hi, 0)
+	if a.Lo >= 0 {
+		r.Lo = 0
+	}
+	if a.Hi <= 0 {
+		r.Hi = 0
+	}
+	return r
+}
+
+// powInterval bounds math.Pow for positive bases and for integer constant
+// exponents, which covers the common x ^ 2 style formulas.
+func powInterval(a, b Interval) Interval {
+	r := nothing(a.NaN || b.NaN)
+	switch n := b.Lo; {
+	case a.Empty() || b.Empty():
+	case a.Lo > 0:
+		r = corners(a, b, math.Pow).loosen()
+	case n != b.Hi || n != math.Trunc(n) || math.IsInf(n, 0):
+		return Unbounded()
+	case n < 0 && a.hasZero():
+		r.Lo, r.Hi = math.Inf(-1), math.Inf(1)
+	default:
+		r = corners(a, b, math.Pow)
+		if a.hasZero() && n > 0 {
+			r.include(0)
+		}
+		r = r.loosen()
+	}
+	// Pow(x, 0) and Pow(1, y) are 1 even when x or y is NaN.
+	if a.NaN && b.Contains(0) || b.NaN && a.Contains(1) {
+		r.include(1)
+	}
+	return r
+}
+
+func copysignInterval(a, b Interval) Interval {
+	m := magnitude(a)
+	r := nothing(a.NaN)
+	if m.Empty() || b.Empty() && !b.NaN {
+		return r
+	}
+	if b.NaN || b.Hi >= 0 {
+		r.include(m.Lo)
+		r.include(m.Hi)
+	}
+	if b.NaN || b.Lo <= 0 {
+		r.include(-m.Lo)
+		r.include(-m.Hi)
+	}
+	return r
+}
+
+func dimInterval(a, b Interval) Interval {
+	r := subtractInterval(a, b)
+	if !r.Empty() {
+		r.Lo, r.Hi = math.Max(r.Lo, 0), math.Max(r.Hi, 0)
+	}
+	return r
+}
+
+// hypotInterval bounds math.Hypot, which is infinite when either argument is
+// even if the other is NaN.
+func hypotInterval(a, b Interval) Interval {
+	r := corners(magnitude(a), magnitude(b), math.Hypot).loosen()
+	if a.NaN && b.hasInf() || b.NaN && a.hasInf() {
+		r.include(math.Inf(1))
+	}
+	return r
+}
+
+// maxInterval bounds math.Max, which is +Inf when either argument is even if
+// the other is NaN.
+func maxInterval(a, b Interval) Interval {
+	r := corners(a, b, math.Max)
+	if a.NaN && b.Hi == math.Inf(1) || b.NaN && a.Hi == math.Inf(1) {
+		r.include(math.Inf(1))
+	}
+	return r
+}
+
+// minInterval is the mirror image of maxInterval.
+func minInterval(a, b Interval) Interval {
+	r := corners(a, b, math.Min)
+	if a.NaN && b.Lo == math.Inf(-1) || b.NaN && a.Lo == math.Inf(-1) {
+		r.include(math.Inf(-1))
+	}
+	return r
+}
+
+func remainderInterval(a, b Interval) Interval {
+	r := nothing(a.NaN || b.NaN || a.hasInf() || !b.Empty() && b.hasZero())
+	if a.Empty() || b.Empty() {
+		return r
+	}
+	m := math.Min(magnitude(a).Hi, magnitude(b).Hi/2)
+	r.Lo, r.Hi = -m, m
+	return r
+}
+
+func ldexpInterval(a, b Interval) Interval {
+	// Converting exponents beyond the range of int gives arbitrary results.
+	if b.NaN || magnitude(b).Hi >= 1<<62 {
+		return Unbounded()
+	}
+	return corners(a, b, func(x, y float64) float64 { return math.Ldexp(x, int(y)) })
+}
+
+func nextafterInterval(a, b Interval) Interval {
+	r := Interval{Lo: a.Lo, Hi: a.Hi, NaN: a.NaN || b.NaN}
+	return r.widen(1)
+}
+
+// indicator returns the interval of a result that is 0 or 1.
+func indicator(canFalse, canTrue bool) Interval {
+	r := nothing(false)
+	if canFalse {
+		r.include(0)
+	}
+	if canTrue {
+		r.include(1)
+	}
+	return r
+}
+
+// compareInterval bounds a Compare. NaN operands make it false.
+func compareInterval(op string, a, b Interval) Interval {
+	nan := a.NaN || b.NaN
+	if a.Empty() || b.Empty() {
+		return indicator(true, false)
+	}
+	switch op {
+	case "<":
+		return indicator(nan || a.Hi >= b.Lo, a.Lo < b.Hi)
+	case "<=":
+		return indicator(nan || a.Hi > b.Lo, a.Lo <= b.Hi)
+	case ">":
+		return indicator(nan || b.Hi >= a.Lo, b.Lo < a.Hi)
+	case ">=":
+		return indicator(nan || b.Hi > a.Lo, b.Lo <= a.Hi)
+	case "==":
+		point := a.Lo == a.Hi && b.Lo == b.Hi && a.Lo == b.Lo
+		return indicator(nan || !point, a.Lo <= b.Hi && b.Lo <= a.Hi)
+	}
+	return Point(0)
+}
+
+// stepInterval bounds heaviside, which is 1 when x is NaN.
+func stepInterval(edge, x Interval) Interval {
+	if edge.Empty() || x.Empty() {
+		return indicator(false, true)
+	}
+	return indicator(x.Lo < edge.Hi, edge.NaN || x.NaN || x.Hi >= edge.Lo)
+}
+
+// ifInterval joins the intervals of the branches c can select.
+func ifInterval(c, a, b Interval) Interval {
+	r := nothing(false)
+	join := func(i Interval) {
+		r.NaN = r.NaN || i.NaN
+		if !i.Empty() {
+			r.include(i.Lo)
+			r.include(i.Hi)
+		}
+	}
+	if c.NaN || !c.Empty() && (c.Lo < 0 || c.Hi > 0) {
+		join(a)
+	}
+	if !c.Empty() && c.hasZero() {
+		join(b)
+	}
+	return r
+}
+
+func clampInterval(x, lo, hi Interval) Interval {
+	return minInterval(maxInterval(x, lo), hi)
+}
+
+func lerpInterval(a, b, t Interval) Interval {
+	return addInterval(a, multiplyInterval(subtractInterval(b, a), t))
+}
+
+// smoothstepInterval bounds smoothstep, which stays within [0, 1] but is NaN
+// when its arguments are or when both edges and x can be equal.
+func smoothstepInterval(e0, e1, x Interval) Interval {
+	r := Span(0, 1)
+	width := subtractInterval(e1, e0)
+	r.NaN = divideInterval(subtractInterval(x, e0), width).NaN
+	return r
+}
+
+var singleIntervals = map[string]func(u Interval) Interval{
+	"ABS":         magnitude,
+	"ACOS":        library(math.Acos, -1, 1),
+	"ACOSH":       library(math.Acosh, 1, math.Inf(1)),
+	"ASIN":        library(math.Asin, -1, 1),
+	"ASINH":       library(math.Asinh, math.Inf(-1), math.Inf(1)),
+	"ATAN":        library(math.Atan, math.Inf(-1), math.Inf(1)),
+	"ATANH":       library(math.Atanh, -1, 1),
+	"CBRT":        library(math.Cbrt, math.Inf(-1), math.Inf(1)),
+	"CEIL":        exact(math.Ceil, math.Inf(-1), math.Inf(1)),
+	"COS":         sinusoid(math.Cos, 0),
+	"COSH":        even(math.Cosh),
+	"ERF":         library(math.Erf, math.Inf(-1), math.Inf(1)),
+	"ERFC":        library(math.Erfc, math.Inf(-1), math.Inf(1)),
+	"ERFCINV":     erfcinv,
+	"ERFINV":      library(math.Erfinv, -1, 1),
+	"EXP":         library(math.Exp, math.Inf(-1), math.Inf(1)),
+	"EXP2":        library(math.Exp2, math.Inf(-1), math.Inf(1)),
+	"EXPM1":       library(math.Expm1, math.Inf(-1), math.Inf(1)),
+	"FLOOR":       exact(math.Floor, math.Inf(-1), math.Inf(1)),
+	"J0":          bounded(-1, 1),
+	"J1":          bounded(-1, 1),
+	"LOG":         library(math.Log, 0, math.Inf(1)),
+	"LOG10":       library(math.Log10, 0, math.Inf(1)),
+	"LOG1P":       library(math.Log1p, -1, math.Inf(1)),
+	"LOG2":        library(math.Log2, 0, math.Inf(1)),
+	"ROUND":       exact(math.Round, math.Inf(-1), math.Inf(1)),
+	"ROUNDTOEVEN": exact(math.RoundToEven, math.Inf(-1), math.Inf(1)),
+	"SIN":         sinusoid(math.Sin, math.Pi/2),
+	"SINH":        library(math.Sinh, math.Inf(-1), math.Inf(1)),
+	"SQRT":        exact(math.Sqrt, 0, math.Inf(1)),
+	"TAN":         tangent,
+	"TANH":        library(math.Tanh, math.Inf(-1), math.Inf(1)),
+	"TRUNC":       exact(math.Trunc, math.Inf(-1), math.Inf(1)),
+}
+
+var doubleIntervals = map[string]func(a, b Interval) Interval{
+	"ATAN2":      boundedPair(-math.Pi, math.Pi),
+	"COPYSIGN":   copysignInterval,
+	"DIM":        dimInterval,
+	"HYPOT":      hypotInterval,
+	"JN":         boundedPair(-1, 1),
+	"LDEXP":      ldexpInterval,
+	"MAX":        maxInterval,
+	"MIN":        minInterval,
+	"MOD":        modInterval,
+	"NEXTAFTER":  nextafterInterval,
+	"PERLIN":     noiseInterval2(-2, 2),
+	"POW":        powInterval,
+	"REMAINDER":  remainderInterval,
+	"STEP":       stepInterval,
+	"VALUENOISE": noiseInterval2(-1, 1),
+	"WORLEY":     noiseInterval2(0, math.Sqrt2),
+}
+
+var tripleIntervals = map[string]func(a, b, c Interval) Interval{
+	"CLAMP":       clampInterval,
+	"FBM":         noiseInterval3(-2, 2),
+	"IF":          ifInterval,
+	"LERP":        lerpInterval,
+	"PERLIN3":     noiseInterval3(-2, 2),
+	"SMOOTHSTEP":  smoothstepInterval,
+	"TURBULENCE":  noiseInterval3(0, 2),
+	"VALUENOISE3": noiseInterval3(-1, 1),
+	"WORLEY3":     noiseInterval3(0, math.Sqrt(3)),
+}
+
+// EvaluateInterval bounds the formula over the ranges in state.
+func (v Function) EvaluateInterval(state *IntervalState) (Interval, error) {
+	if v.Equals == nil {
+		return Interval{}, errors.New("no such formula")
+	}
+	return v.Equals.EvaluateInterval(state), nil
+}
+
+func (v Equals) EvaluateInterval(state *IntervalState) Interval {
+	if v.LHS == nil {
+		return v.RHS.EvaluateInterval(state)
+	}
+	return subtractInterval(v.RHS.EvaluateInterval(state), v.LHS.EvaluateInterval(state))
+}
+
+func (v Var) EvaluateInterval(state *IntervalState) Interval {
+	name := strings.ToUpper(v.Var)
+	switch name {
+	case "X":
+		return state.X
+	case "Y":
+		return state.Y
+	case "T":
+		return state.T
+	case "PX", "PY":
+		if state.Width == 0 || state.Height == 0 {
+			// Without an image size the pixels could be any size.
+			return Unbounded()
+		}
+	}
+	if d := derivation(name, state.Width, state.Height, state.Frame); d != nil {
+		return d.EvaluateInterval(state)
+	}
+	return Point(parameter(state.Params, name))
+}
+
+func (c Const) EvaluateInterval(state *IntervalState) Interval {
+	return Point(c.Value)
+}
+
+func (v Plus) EvaluateInterval(state *IntervalState) Interval {
+	return addInterval(v.RHS.EvaluateInterval(state), v.LHS.EvaluateInterval(state))
+}
+
+func (v Subtract) EvaluateInterval(state *IntervalState) Interval {
+	return subtractInterval(v.RHS.EvaluateInterval(state), v.LHS.EvaluateInterval(state))
+}
+
+func (v Multiply) EvaluateInterval(state *IntervalState) Interval {
+	return multiplyInterval(v.RHS.EvaluateInterval(state), v.LHS.EvaluateInterval(state))
+}
+
+func (v Divide) EvaluateInterval(state *IntervalState) Interval {
+	return divideInterval(v.RHS.EvaluateInterval(state), v.LHS.EvaluateInterval(state))
+}
+
+func (v Power) EvaluateInterval(state *IntervalState) Interval {
+	return powInterval(v.LHS.EvaluateInterval(state), v.RHS.EvaluateInterval(state))
+}
+
+func (v Modulus) EvaluateInterval(state *IntervalState) Interval {
+	return modInterval(v.LHS.EvaluateInterval(state), v.RHS.EvaluateInterval(state))
+}
+
+func (v Negate) EvaluateInterval(state *IntervalState) Interval {
+	return negateInterval(v.Expr.EvaluateInterval(state))
+}
+
+func (v Brackets) EvaluateInterval(state *IntervalState) Interval {
+	return v.Expr.EvaluateInterval(state)
+}
+
+func (v SingleFunction) EvaluateInterval(state *IntervalState) Interval {
+	u := v.Expr.EvaluateInterval(state)
+	def := lookupArity(v.Name, 1)
+	if v.Fn == nil && def == nil {
+		// Unknown functions evaluate as the identity.
+		return u
+	}
+	if def != nil && def.Interval1 != nil {
+		return def.Interval1(u)
+	}
+	return Unbounded()
+}
+
+func (v DoubleFunction) EvaluateInterval(state *IntervalState) Interval {
+	a := v.Expr1.EvaluateInterval(state)
+	b := v.Expr2.EvaluateInterval(state)
+	def := lookupArity(v.Name, 2)
+	if v.Fn == nil && def == nil {
+		// Unknown functions evaluate to their first argument.
+		return a
+	}
+	if def != nil && def.Interval2 != nil {
+		return def.Interval2(a, b)
+	}
+	return Unbounded()
+}
+
+func (v Compare) EvaluateInterval(state *IntervalState) Interval {
+	return compareInterval(v.Op, v.LHS.EvaluateInterval(state), v.RHS.EvaluateInterval(state))
+}
+
+func (v TripleFunction) EvaluateInterval(state *IntervalState) Interval {
+	a := v.Expr1.EvaluateInterval(state)
+	b := v.Expr2.EvaluateInterval(state)
+	c := v.Expr3.EvaluateInterval(state)
+	def := lookupArity(v.Name, 3)
+	if v.Fn == nil && def == nil {
+		// Unknown functions evaluate to their first argument.
+		return a
+	}
+	if def != nil && def.Interval3 != nil {
+		return def.Interval3(a, b, c)
+	}
+	return Unbounded()
+}
@@ -0,0 +1,79 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEvaluateIntervalContainsSamples(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 3000; i++ {
+		f := randomFunction(r, 5)
+		x0, y0 := r.Float64()*20-10, r.Float64()*20-10
+		x1, y1 := x0+r.Float64()*4, y0+r.Float64()*4
+		T := float64(r.Intn(3))
+		bound, err := f.EvaluateInterval(&IntervalState{X: Span(x0, x1), Y: Span(y0, y1), T: Point(T)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for s := 0; s < 25; s++ {
+			x, y := x0+(x1-x0)*float64(s%5)/4, y0+(y1-y0)*float64(s/5)/4
+			if v, _, _ := f.Evaluate(x, y, T); !bound.Contains(v) {
+				t.Fatalf("%s at (%v, %v): %v outside %+v", f, x, y, v, bound)
+			}
+		}
+	}
+}
+
+func TestEvaluateInterval(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    Interval
+	}{
+		{"0 = x + 1", Span(-9, 11)},
+		{"0 = x * x", Span(-100, 100)},
+		{"0 = x ^ 2", Span(0, 100)},
+		{"0 = abs(y) + 3", Span(3, 13)},
+		{"0 = floor(x * 0.01)", Span(-1, 0)},
+		{"0 = 5 % x", Interval{Lo: 0, Hi: 5, NaN: true}},
+		{"0 = max(x, 2)", Span(2, 10)},
+		{"0 = sin(x * 0.1)", Span(math.Sin(-1), math.Sin(1))},
+		{"0 = x < 20", Span(1, 1)},
+		{"0 = step(0, x) + clamp(y, -1, 2)", Span(-1, 3)},
+		{"0 = if(x > 20, 1, y)", Span(-10, 10)},
+		{"0 = worley(x, y) + valuenoise(y, x)", Span(-1, 1+math.Sqrt2)},
+	}
+	state := &IntervalState{X: Span(-10, 10), Y: Span(-10, 10)}
+	for _, test := range tests {
+		f, err := ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		got, err := f.EvaluateInterval(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Library functions are widened by a few ulps.
+		if got.NaN != test.want.NaN || got.Lo > test.want.Lo || got.Hi < test.want.Hi ||
+			test.want.Lo-got.Lo > 1e-9 || got.Hi-test.want.Hi > 1e-9 {
+			t.Errorf("%s: got %+v, want %+v", test.formula, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateIntervalNaN(t *testing.T) {
+	state := &IntervalState{X: Span(-10, 10), Y: Span(-10, 10)}
+	for _, each := range []string{"0 = sqrt(x)", "0 = log(x)", "0 = tan(x)", "0 = gamma(x)"} {
+		f, err := ParseFunction(each)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := f.EvaluateInterval(state); !got.NaN && !math.IsInf(got.Hi, 1) {
+			t.Errorf("%s: expected NaN or unbounded result, got %+v", each, got)
+		}
+	}
+	if _, err := (Function{}).EvaluateInterval(state); err == nil {
+		t.Error("Expected error for empty formula")
+	}
+}
@@ -1,87 +1,461 @@
 package image_formula_find
 
 import (
-	"errors"
-	"log"
-	"regexp"
+	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-var (
-	calcLexerRegex *regexp.Regexp
-)
+// operators are the tokens of the operator characters, with × and ÷ standing
+// for * and /.
+var operators = map[rune]int{
+	'+': '+', '-': '-', '*': '*', '/': '/', '%': '%', '^': '^', '=': '=',
+	',': ',', '(': '(', ')': ')', '<': '<', '>': '>', ';': ';',
+	'×': '*', '÷': '/', '√': ROOT,
+}
 
-func init() {
-	var err error
-	calcLexerRegex, err = regexp.Compile(`^(?:(\s)|([+%=,*^/()-])|(\d+(?:\.\d+)?)|([XxYyTt]\b)|(\w+))`)
-	if err != nil {
-		log.Panic("Regex compile issue", err)
-	}
+// namedConstants are the values of the constants that may be written by name,
+// by upper case name. π is pi.
+var namedConstants = map[string]float64{
+	"PI":  math.Pi,
+	"E":   math.E,
+	"PHI": math.Phi,
+	"TAU": 2 * math.Pi,
 }
 
+// comparisonTokens are the tokens of the operators followed by "=".
+var comparisonTokens = map[byte]int{'<': LE, '>': GE, '=': EQ}
+
+// CalcLexer lexes a formula or script for one parse, which leaves its result
+// on the lexer, so separate parses share nothing.
 type CalcLexer struct {
-	input string
-	err   error
+	// src is the whole text and input the part not yet lexed.
+	src, input string
+	// err is the first error found.
+	err *SyntaxError
+	// result is the formula parsed and statements the script.
+	result     *Function
+	statements []Statement
+	// tokens are the tokens returned so far, kept to locate errors and find
+	// the tokens that were expected.
+	tokens []token
+	// operand is set when the next token must start an operand, where a
+	// minus directly followed by a number is part of the number.
+	operand bool
+	// lexeme is the text of the token just lexed.
+	lexeme string
+	// script is set when lexing a script rather than a single formula. The
+	// lexer then starts with SCRIPT, ends lines and the input with ';' and
+	// skips comments running from '#' to the end of the line.
+	script bool
+	// started and ended record the SCRIPT and final ';' tokens of a script.
+	started, ended bool
+	// replaying is set for a lexer returning recorded tokens, used to find
+	// the tokens the parser accepts after them.
+	replaying bool
+	// next is the index of the next recorded token to return and errorAt
+	// the index of the token the replayed parse failed at, -1 if none.
+	next, errorAt int
+}
+
+// token is a token returned by the lexer, along with its value, which holds
+// its offset, and its text.
+type token struct {
+	id   int
+	lval yySymType
+	text string
 }
 
 func NewCalcLexer(input string) yyLexer {
 	return &CalcLexer{
-		input: input,
+		src:     input,
+		input:   input,
+		operand: true,
+	}
+}
+
+// NewScriptLexer returns a lexer for a script of let bindings and channel
+// assignments.
+func NewScriptLexer(input string) yyLexer {
+	return &CalcLexer{
+		src:     input,
+		input:   input,
+		operand: true,
+		script:  true,
 	}
 }
 
 func (lex *CalcLexer) Lex(lval *yySymType) int {
+	if lex.replaying {
+		lex.next++
+		if lex.next > len(lex.tokens) {
+			return 0
+		}
+		*lval = lex.tokens[lex.next-1].lval
+		return lex.tokens[lex.next-1].id
+	}
+	r := lex.lex(lval)
+	lval.pos = len(lex.src) - len(lex.input) - len(lex.lexeme)
+	lex.tokens = append(lex.tokens, token{id: r, lval: *lval, text: lex.lexeme})
+	return r
+}
+
+// lex returns the next token, leaving its text in lexeme.
+func (lex *CalcLexer) lex(lval *yySymType) int {
+	lex.lexeme = ""
+	if lex.script && !lex.started {
+		lex.started = true
+		return SCRIPT
+	}
 	for {
+		if lex.script {
+			lex.skipComment()
+		}
 		if len(lex.input) == 0 {
+			if lex.script && !lex.ended {
+				lex.ended = true
+				lex.operand = true
+				return ';'
+			}
 			return 0
 		}
 		r := lex.subLex(lval)
 		if r == -1 {
 			continue
 		}
+		lex.operand = r != FLOAT && r != VAR && r != ')'
 		return r
 	}
 }
 
 func (lex *CalcLexer) subLex(lval *yySymType) int {
-	rResult := calcLexerRegex.FindStringSubmatch(lex.input)
-	defer func() {
-		if len(rResult) <= 1 || len(rResult[0]) == 0 {
-			return
+	c, size := utf8.DecodeRuneInString(lex.input)
+	switch {
+	case isSpace(c):
+		lex.consume(size)
+		if lex.script && c == '\n' {
+			return ';'
 		}
-		lex.input = lex.input[len(rResult[0]):]
-	}()
-	if len(rResult) <= 1 || len(rResult[0]) == 0 {
+		return -1
+	case c == 'π':
+		lex.consume(size)
+		lval.float = math.Pi
+		return FLOAT
+	case c == '-' && lex.operand:
+		// A minus directly followed by a number, NaN or Inf is part of the
+		// constant.
+		rest := lex.input[1:]
+		if n := scanNumber(rest); n > 0 {
+			lex.consume(1 + n)
+			return lex.constant(lval, lex.lexeme)
+		}
+		if n := scanWord(rest); n > 0 && lex.isConstName(rest[:n], 1+n) {
+			lex.consume(1 + n)
+			return lex.constant(lval, lex.lexeme)
+		}
+	}
+	if token, ok := operators[c]; ok {
+		if token, ok := comparisonTokens[byte(c)]; ok && strings.HasPrefix(lex.input[1:], "=") {
+			// The comparisons <=, >= and ==.
+			lex.consume(2)
+			return token
+		}
+		lex.consume(size)
+		return token
+	}
+	if n := scanNumber(lex.input); n > 0 {
+		lex.consume(n)
+		return lex.constant(lval, lex.lexeme)
+	}
+	n := scanWord(lex.input)
+	if n == 0 {
+		lex.fail(len(lex.src)-len(lex.input), string(c), fmt.Sprintf("unexpected character %q", c))
 		return 1
 	}
-	if len(rResult[1]) > 0 {
-		return -1
+	word := lex.input[:n]
+	if isBuiltinVar(word) {
+		lex.consume(n)
+		lval.s = word
+		return VAR
 	}
-	if len(rResult[2]) > 0 {
-		return int(rune(rResult[2][0]))
+	if lex.operand && lex.isConstName(word, n) {
+		lex.consume(n)
+		return lex.constant(lval, word)
 	}
-	if len(rResult[3]) > 0 {
-		var err error
-		lval.float, err = strconv.ParseFloat(rResult[3], 64)
-		if err != nil {
-			lex.err = err
-			return 1
-		}
+	if v, ok := namedConstants[strings.ToUpper(word)]; ok && lex.operand && !lex.call(n) {
+		lex.consume(n)
+		lval.float = v
 		return FLOAT
 	}
-	if len(rResult[4]) > 0 {
-		lval.s = rResult[4]
+	lex.consume(n)
+	lval.s = word
+	if lex.script && lex.operand && strings.EqualFold(word, "let") {
+		return LET
+	}
+	if lex.operand && !lex.call(0) {
+		// A name in place of an operand that is not called is a parameter.
 		return VAR
 	}
-	if len(rResult[5]) > 0 {
-		lval.s = rResult[5]
-		return FUNCNAME
+	if _, ok := LookupFunction(word); !ok {
+		lex.fail(len(lex.src)-len(lex.input)-n, word, "unknown function "+word)
 	}
-	return 1
+	return FUNCNAME
+}
+
+// consume moves the next n bytes of input to lexeme.
+func (lex *CalcLexer) consume(n int) {
+	lex.lexeme = lex.input[:n]
+	lex.input = lex.input[n:]
+}
+
+// isSpace reports whether c is white space.
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// scanNumber returns the length of the number at the start of s, 0 if there
+// is none. Numbers are digits with an optional fraction, or a fraction alone
+// such as .5, followed by an optional exponent.
+func scanNumber(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	if i < len(s) && s[i] == '.' && i+1 < len(s) && isDigit(s[i+1]) {
+		i += 2
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	if i == 0 {
+		return 0
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			for i = j; i < len(s) && isDigit(s[i]); i++ {
+			}
+		}
+	}
+	return i
+}
+
+// scanWord returns the length of the word at the start of s, 0 if there is
+// none.
+func scanWord(s string) int {
+	i := 0
+	for i < len(s) && isWordByte(s[i]) {
+		i++
+	}
+	return i
 }
 
+// isBuiltinVar reports whether a word names a built in variable.
+func isBuiltinVar(word string) bool {
+	switch strings.ToUpper(word) {
+	case "X", "Y", "T", "R", "A", "U", "V", "PX", "PY":
+		return true
+	}
+	return false
+}
+
+// skipComment drops a comment at the start of the input, leaving the end of
+// the line.
+func (lex *CalcLexer) skipComment() {
+	if !strings.HasPrefix(lex.input, "#") {
+		return
+	}
+	if i := strings.IndexByte(lex.input, '\n'); i >= 0 {
+		lex.input = lex.input[i:]
+	} else {
+		lex.input = ""
+	}
+}
+
+func (lex *CalcLexer) constant(lval *yySymType, s string) int {
+	var err error
+	lval.float, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		lex.fail(len(lex.src)-len(lex.input), s, "invalid number "+s)
+		return 1
+	}
+	return FLOAT
+}
+
+// isConstName reports whether the word at the start of the input, ending at
+// offset end, is NaN or Inf as printed for a constant rather than a call to
+// the Inf function.
+func (lex *CalcLexer) isConstName(word string, end int) bool {
+	if word != "NaN" && word != "Inf" {
+		return false
+	}
+	return !lex.call(end)
+}
+
+// call reports whether the input from offset end opens an argument list.
+func (lex *CalcLexer) call(end int) bool {
+	for _, c := range lex.input[end:] {
+		if !isSpace(c) {
+			return c == '('
+		}
+	}
+	return false
+}
+
+// Error records a syntax error at the last token, which the parser could
+// not accept.
 func (lex *CalcLexer) Error(s string) {
-	lex.err = errors.New(s)
+	if lex.replaying {
+		if lex.err == nil {
+			lex.err = &SyntaxError{}
+			lex.errorAt = lex.next - 1
+		}
+		return
+	}
+	if lex.err != nil || len(lex.tokens) == 0 {
+		return
+	}
+	at := len(lex.tokens) - 1
+	t := lex.tokens[at]
+	lex.fail(t.lval.pos, t.text, "unexpected "+describe(t))
+	lex.err.Expected = lex.expected(at)
+}
+
+// syntaxError returns the error of a failed parse.
+func (lex *CalcLexer) syntaxError() *SyntaxError {
+	if lex.err == nil {
+		// The parser reports every failure through Error.
+		lex.fail(len(lex.src)-len(lex.input), "", "syntax error")
+	}
+	return lex.err
+}
+
+// fail records an error at offset pos unless one was found already.
+func (lex *CalcLexer) fail(pos int, text, message string) {
+	if lex.err != nil || lex.replaying {
+		return
+	}
+	line := 1 + strings.Count(lex.src[:pos], "\n")
+	start := strings.LastIndexByte(lex.src[:pos], '\n') + 1
+	lex.err = &SyntaxError{
+		Input:   lex.src,
+		Line:    line,
+		Column:  1 + utf8.RuneCountInString(lex.src[start:pos]),
+		Token:   text,
+		Message: message,
+	}
+}
+
+// arity records an error for a call of the function name, at offset pos,
+// with the wrong number of arguments.
+func (lex *CalcLexer) arity(name string, pos, args int) {
+	def, ok := LookupFunction(name)
+	if !ok || def.Arity == args {
+		return
+	}
+	plural := "s"
+	if def.Arity == 1 {
+		plural = ""
+	}
+	lex.fail(pos, name, fmt.Sprintf("%s takes %d argument%s, not %d", def.Name, def.Arity, plural, args))
+}
+
+// candidateTokens are the tokens tried in place of an unexpected token.
+var candidateTokens = []int{FLOAT, VAR, FUNCNAME, LET, ROOT, '(', ')', '+', '-', '*', '/', '%', '^', '<', '>', LE, GE, EQ, '=', ',', ';', 0}
+
+// expected returns the names of the tokens the parser would accept in place
+// of token at, found by parsing the tokens before it followed by each
+// candidate in turn.
+func (lex *CalcLexer) expected(at int) []string {
+	var names []string
+	for _, c := range candidateTokens {
+		tokens := append(append([]token{}, lex.tokens[:at]...), token{id: c})
+		replay := &CalcLexer{tokens: tokens, replaying: true, errorAt: -1}
+		yyParse(replay)
+		if replay.errorAt != at {
+			names = append(names, tokenName(c))
+		}
+	}
+	return names
+}
+
+// describe names a token as written for an error message.
+func describe(t token) string {
+	switch {
+	case t.id == 0 || t.id == ';' && t.text == "":
+		return "end of input"
+	case t.id == ';' && t.text == "\n":
+		return "end of line"
+	}
+	return strconv.Quote(t.text)
+}
+
+// tokenName names a kind of token for an error message.
+func tokenName(id int) string {
+	switch id {
+	case 0:
+		return "end of input"
+	case FLOAT:
+		return "number"
+	case VAR:
+		return "variable"
+	case FUNCNAME:
+		return "function"
+	case LET:
+		return `"let"`
+	case ROOT:
+		return `"√"`
+	case LE:
+		return `"<="`
+	case GE:
+		return `">="`
+	case EQ:
+		return `"=="`
+	}
+	return strconv.Quote(string(rune(id)))
+}
+
+// SyntaxError is a formula or script that does not parse, giving where and
+// why.
+type SyntaxError struct {
+	// Input is the text parsed.
+	Input string
+	// Line and Column locate the offending token, counting from 1, with the
+	// column in characters.
+	Line, Column int
+	// Token is the offending token as written, empty at the end of the
+	// input.
+	Token string
+	// Expected are the tokens that could have taken its place, empty for a
+	// token that is out of place for other reasons, such as the name of an
+	// unknown function.
+	Expected []string
+	// Message says what is wrong.
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	s := fmt.Sprintf("column %d: %s", e.Column, e.Message)
+	if e.Line > 1 {
+		s = fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+	}
+	if len(e.Expected) > 0 {
+		s += ", expected " + strings.Join(e.Expected, ", ")
+	}
+	return s
 }
 
 func yyToknameByString(s string) int {
@@ -21,6 +21,16 @@ func TestLexer(t *testing.T) {
 			Input:  "1 + 2 * (3 / 4) = Y",
 			Output: []int{FLOAT, int(rune('+')), FLOAT, int(rune('*')), int(rune('(')), FLOAT, int(rune('/')), FLOAT, int(rune(')')), int(rune('=')), VAR, yyToknameByString("$end")},
 		},
+		{
+			Name:   "Variables and parameters",
+			Input:  "px * r mod scale + sin(a)",
+			Output: []int{VAR, int(rune('*')), VAR, FUNCNAME, VAR, int(rune('+')), FUNCNAME, int(rune('(')), VAR, int(rune(')')), yyToknameByString("$end")},
+		},
+		{
+			Name:   "Unicode and constants",
+			Input:  "π × √x ÷ .5e1 - pi",
+			Output: []int{FLOAT, int(rune('*')), ROOT, VAR, int(rune('/')), FLOAT, int(rune('-')), FLOAT, yyToknameByString("$end")},
+		},
 	} {
 		t.Run(fmt.Sprintf("Test %d", eachI), func(t *testing.T) {
 			yyLexer := NewCalcLexer(each.Input)
@@ -35,3 +45,34 @@ func TestLexer(t *testing.T) {
 		})
 	}
 }
+
+func TestUnicodeOperators(t *testing.T) {
+	for unicode, ascii := range map[string]string{
+		"y = 2 ÷ 4 × x": "y = 2 / 4 * x",
+		"y = √x + √(y)": "y = Sqrt(x) + Sqrt((y))",
+		"y = π * r ^ 2": "y = 3.141592653589793 * r ^ 2",
+		"y = x*1e-07":   "y = x * 0.0000001",
+		"y=-.25+tau":    "y = -0.25 + 6.283185307179586",
+	} {
+		u, err := ParseFunction(unicode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := ParseFunction(ascii)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() != a.String() {
+			t.Errorf("%q parsed as %s, want %s", unicode, u, a)
+		}
+	}
+}
+
+func BenchmarkParseFunction(b *testing.B) {
+	const formula = "y / 4 = sin(x * 1.5e-3) + cos(y) ^ 2 - atan2(x, y) * -0.25 + clamp(x, 0, 1) + π"
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseFunction(formula); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -11,16 +11,30 @@ import (
 var (
 	SingleFunctions map[string]SingleFunctionDef
 	DoubleFunctions map[string]DoubleFunctionDef
+	TripleFunctions map[string]TripleFunctionDef
 	FunctionNames   []string
 )
 
 type SingleFunctionDef func(float64) float64
 type DoubleFunctionDef func(float64, float64) float64
+type TripleFunctionDef func(float64, float64, float64) float64
 
 type State struct {
-	X, Y                            float64
-	T                               int
+	// X and Y run from -10 to 10 across the image and T is the time, see
+	// Variables.
+	X, Y, T                         float64
 	AccessedX, AccessedY, AccessedT bool
+	// Width and Height are the size in pixels of the image X and Y span,
+	// which PX and PY are measured in.
+	Width, Height int
+	// Frame is the view of the image, which U, V, PX and PY are measured
+	// through. The default view when zero.
+	Frame Frame
+	// Params holds the values of named parameters by upper case name. A
+	// parameter without a value is NaN, see Unbound.
+	Params map[string]float64
+	// Policy is the numeric policy to evaluate with.
+	Policy Policy
 }
 
 func (rs *State) CurX() float64 {
@@ -33,7 +47,7 @@ func (rs *State) CurY() float64 {
 	return rs.Y
 }
 
-func (rs *State) CurT() int {
+func (rs *State) CurT() float64 {
 	rs.AccessedT = true
 	return rs.T
 }
@@ -44,6 +58,8 @@ type Expression interface {
 	Depth() int
 	Simplify() Expression
 	HasVar(vs string) bool
+	Derive(vs string) (Expression, error)
+	EvaluateInterval(state *IntervalState) Interval
 }
 
 type Function struct {
@@ -56,18 +72,13 @@ var statePool = sync.Pool{
 	},
 }
 
-func (v Function) Evaluate(X, Y float64, T int) (weight float64, TUsed bool, err error) {
+func (v Function) Evaluate(X, Y, T float64) (weight float64, TUsed bool, err error) {
 	if v.Equals == nil {
 		return 0, false, errors.New("no such formula")
 	}
 
 	state := statePool.Get().(*State)
-	state.X = X
-	state.Y = Y
-	state.T = T
-	state.AccessedX = false
-	state.AccessedY = false
-	state.AccessedT = false
+	*state = State{X: X, Y: Y, T: T}
 
 	weight = v.Equals.Evaluate(state)
 	TUsed = state.AccessedT
@@ -96,9 +107,9 @@ type Equals struct {
 
 func (v Equals) Evaluate(state *State) float64 {
 	if v.LHS == nil {
-		return v.RHS.Evaluate(state)
+		return protectResult(state.Policy, v.RHS.Evaluate(state))
 	}
-	return v.RHS.Evaluate(state) - v.LHS.Evaluate(state)
+	return protectResult(state.Policy, v.RHS.Evaluate(state)-v.LHS.Evaluate(state))
 }
 
 func (v Equals) Depth() int {
@@ -116,13 +127,16 @@ func (v Equals) String() string {
 	if v.LHS == nil {
 		return v.RHS.String()
 	}
-	return fmt.Sprintf("%s = %s", v.LHS.String(), v.RHS.String())
+	return operand(v.LHS, precCompare) + " = " + operand(v.RHS, precCompare)
 }
 
 func (v Equals) Simplify() Expression {
 	v.RHS = removeBrackets(v.RHS.Simplify())
 	if v.LHS != nil {
 		v.LHS = removeBrackets(v.LHS.Simplify())
+		if isConst(v.LHS, 0) {
+			v.LHS = nil
+		}
 	}
 	return &v
 }
@@ -146,8 +160,17 @@ type Var struct {
 	Var string
 }
 
+// HasVar reports whether v is vs or a variable derived from it.
 func (v Var) HasVar(vs string) bool {
-	return v.Var == vs
+	if v.Var == vs {
+		return true
+	}
+	for _, each := range dependencies[strings.ToUpper(v.Var)] {
+		if each == vs {
+			return true
+		}
+	}
+	return false
 }
 
 func (v Var) Evaluate(state *State) float64 {
@@ -162,15 +185,31 @@ func (v Var) Evaluate(state *State) float64 {
 			return float64(state.CurT())
 		}
 	}
-	switch strings.ToUpper(v.Var) {
+	switch name := strings.ToUpper(v.Var); name {
 	case "X":
 		return float64(state.CurX())
 	case "Y":
 		return float64(state.CurY())
 	case "T":
 		return float64(state.CurT())
+	case "R":
+		return math.Hypot(state.CurX(), state.CurY())
+	case "A":
+		return math.Atan2(state.CurY(), state.CurX())
+	case "U":
+		u, _ := state.Frame.unit(state.CurX(), state.CurY())
+		return u
+	case "V":
+		_, v := state.Frame.unit(state.CurX(), state.CurY())
+		return v
+	case "PX":
+		u, _ := state.Frame.unit(state.CurX(), state.CurY())
+		return u * float64(state.Width)
+	case "PY":
+		_, v := state.Frame.unit(state.CurX(), state.CurY())
+		return v * float64(state.Height)
 	default:
-		return 0
+		return parameter(state.Params, name)
 	}
 }
 
@@ -199,7 +238,7 @@ func (c Const) Evaluate(state *State) float64 {
 }
 
 func (v Const) String() string {
-	return fmt.Sprintf("%g", v.Value)
+	return formatConst(v.Value)
 }
 
 func (v Const) Simplify() Expression {
@@ -224,13 +263,13 @@ func (v Plus) Evaluate(state *State) float64 {
 }
 
 func (v Plus) String() string {
-	return fmt.Sprintf("%s + %s", v.LHS.String(), v.RHS.String())
+	return infix(v.LHS, "+", v.RHS, precSum, false)
 }
 
 func (v Plus) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifyPlus(&v)
 }
 
 func (v Plus) Depth() int {
@@ -241,6 +280,8 @@ func (v Plus) Depth() int {
 	return r + 1
 }
 
+// Subtract is RHS - LHS. The parser reads a - b into RHS a and LHS b and
+// String prints it back in that order.
 type Subtract struct {
 	LHS Expression
 	RHS Expression
@@ -255,13 +296,13 @@ func (v Subtract) Evaluate(state *State) float64 {
 }
 
 func (v Subtract) String() string {
-	return fmt.Sprintf("%s - %s", v.LHS.String(), v.RHS.String())
+	return infix(v.RHS, "-", v.LHS, precSum, false)
 }
 
 func (v Subtract) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifySubtract(&v)
 }
 
 func (v Subtract) Depth() int {
@@ -286,13 +327,13 @@ func (v Multiply) Evaluate(state *State) float64 {
 }
 
 func (v Multiply) String() string {
-	return fmt.Sprintf("%s * %s", v.LHS.String(), v.RHS.String())
+	return infix(v.LHS, "*", v.RHS, precProduct, false)
 }
 
 func (v Multiply) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifyMultiply(&v)
 }
 
 func (v Multiply) Depth() int {
@@ -303,6 +344,7 @@ func (v Multiply) Depth() int {
 	return r + 1
 }
 
+// Divide is RHS / LHS, read and printed like Subtract.
 type Divide struct {
 	LHS Expression
 	RHS Expression
@@ -313,17 +355,20 @@ func (v Divide) HasVar(vs string) bool {
 }
 
 func (v Divide) Evaluate(state *State) float64 {
+	if state.Policy == Protected {
+		return protectedDivide(v.RHS.Evaluate(state), v.LHS.Evaluate(state))
+	}
 	return v.RHS.Evaluate(state) / v.LHS.Evaluate(state)
 }
 
 func (v Divide) String() string {
-	return fmt.Sprintf("%s / %s", v.LHS.String(), v.RHS.String())
+	return infix(v.RHS, "/", v.LHS, precProduct, false)
 }
 
 func (v Divide) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifyDivide(&v)
 }
 
 func (v Divide) Depth() int {
@@ -344,17 +389,20 @@ func (v Power) HasVar(vs string) bool {
 }
 
 func (v Power) Evaluate(state *State) float64 {
+	if state.Policy == Protected {
+		return protect2(math.Pow, v.LHS.Evaluate(state), v.RHS.Evaluate(state))
+	}
 	return math.Pow(v.LHS.Evaluate(state), v.RHS.Evaluate(state))
 }
 
 func (v Power) String() string {
-	return fmt.Sprintf("%s ^ %s", v.LHS.String(), v.RHS.String())
+	return infix(v.LHS, "^", v.RHS, precPower, true)
 }
 
 func (v Power) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifyPower(&v)
 }
 
 func (v Power) Depth() int {
@@ -375,17 +423,20 @@ func (v Modulus) HasVar(vs string) bool {
 }
 
 func (v Modulus) Evaluate(state *State) float64 {
+	if state.Policy == Protected {
+		return protect2(math.Mod, v.LHS.Evaluate(state), v.RHS.Evaluate(state))
+	}
 	return math.Mod(v.LHS.Evaluate(state), v.RHS.Evaluate(state))
 }
 
 func (v Modulus) String() string {
-	return fmt.Sprintf("%s %% %s", v.LHS.String(), v.RHS.String())
+	return infix(v.LHS, "%", v.RHS, precProduct, false)
 }
 
 func (v Modulus) Simplify() Expression {
 	v.RHS = v.RHS.Simplify()
 	v.LHS = v.LHS.Simplify()
-	return &v
+	return simplifyModulus(&v)
 }
 
 func (v Modulus) Depth() int {
@@ -409,23 +460,17 @@ func (v Negate) Evaluate(state *State) float64 {
 }
 
 func (v Negate) String() string {
-	return fmt.Sprintf("-%s", v.Expr.String())
+	switch v.Expr.(type) {
+	case *Const, Const:
+		// Without parentheses the lexer would read a negative constant.
+		return "-(" + v.Expr.String() + ")"
+	}
+	return "-" + operand(v.Expr, precUnary)
 }
 
 func (v Negate) Simplify() Expression {
-	switch child := v.Expr.(type) {
-	case *Negate:
-		return child.Expr.Simplify()
-	case *Brackets:
-		switch childChild := child.Expr.(type) {
-		case *Negate:
-			return childChild.Expr.Simplify()
-		case *Const, *Var:
-			v.Expr = childChild
-		}
-	}
 	v.Expr = v.Expr.Simplify()
-	return &v
+	return simplifyNegate(&v)
 }
 
 func (v Negate) Depth() int {
@@ -449,15 +494,17 @@ func (v Brackets) String() string {
 }
 
 func (v Brackets) Simplify() Expression {
-	switch next := v.Expr.(type) {
-	case *Brackets:
-		return next.Expr.Simplify()
-	case *Const:
-		return next.Simplify()
-	case *Var:
-		return next.Simplify()
+	switch next := v.Expr.Simplify().(type) {
+	case *Brackets, *Const, *Var, *SingleFunction, *TripleFunction:
+		return next
+	case *DoubleFunction:
+		if !next.Infix {
+			return next
+		}
+		v.Expr = next
+	default:
+		v.Expr = next
 	}
-	v.Expr = v.Expr.Simplify()
 	return &v
 }
 
@@ -482,9 +529,15 @@ func (v SingleFunction) HasVar(vs string) bool {
 
 func (v SingleFunction) Evaluate(state *State) float64 {
 	var r = v.Expr.Evaluate(state)
-	if v.Fn != nil {
-		r = v.Fn(r)
-	} else if f, ok := SingleFunctions[strings.ToUpper(v.Name)]; ok {
+	f := v.Fn
+	if f == nil {
+		f = SingleFunctions[strings.ToUpper(v.Name)]
+	}
+	switch {
+	case f == nil:
+	case state.Policy == Protected:
+		r = protect1(f, r)
+	default:
 		r = f(r)
 	}
 	return r
@@ -496,7 +549,7 @@ func (v SingleFunction) String() string {
 
 func (v SingleFunction) Simplify() Expression {
 	v.Expr = v.Expr.Simplify()
-	return &v
+	return simplifySingleFunction(&v)
 }
 
 func (v SingleFunction) Depth() int {
@@ -523,9 +576,15 @@ func (v DoubleFunction) HasVar(vs string) bool {
 func (v DoubleFunction) Evaluate(state *State) float64 {
 	var r1 = v.Expr1.Evaluate(state)
 	var r2 = v.Expr2.Evaluate(state)
-	if v.Fn != nil {
-		r1 = v.Fn(r1, r2)
-	} else if f, ok := DoubleFunctions[strings.ToUpper(v.Name)]; ok {
+	f := v.Fn
+	if f == nil {
+		f = DoubleFunctions[strings.ToUpper(v.Name)]
+	}
+	switch {
+	case f == nil:
+	case state.Policy == Protected:
+		r1 = protect2(f, r1, r2)
+	default:
 		r1 = f(r1, r2)
 	}
 	return r1
@@ -533,7 +592,7 @@ func (v DoubleFunction) Evaluate(state *State) float64 {
 
 func (v DoubleFunction) String() string {
 	if v.Infix {
-		return fmt.Sprintf("%s %s %s", v.Expr1.String(), v.Name, v.Expr2.String())
+		return infix(v.Expr1, v.Name, v.Expr2, precInfix, true)
 	} else {
 		return fmt.Sprintf("%s(%s, %s)", v.Name, v.Expr1.String(), v.Expr2.String())
 	}
@@ -542,7 +601,7 @@ func (v DoubleFunction) String() string {
 func (v DoubleFunction) Simplify() Expression {
 	v.Expr1 = v.Expr1.Simplify()
 	v.Expr2 = v.Expr2.Simplify()
-	return &v
+	return simplifyDoubleFunction(&v)
 }
 
 func (v DoubleFunction) Depth() int {
@@ -553,110 +612,177 @@ func (v DoubleFunction) Depth() int {
 	return r + 1
 }
 
-func init() {
-	SingleFunctions = map[string]SingleFunctionDef{}
-	DoubleFunctions = map[string]DoubleFunctionDef{}
-	FunctionNames = []string{}
-	for name, f := range map[string]interface{}{
-		"Abs":             math.Abs,
-		"Acos":            math.Acos,
-		"Acosh":           math.Acosh,
-		"Asin":            math.Asin,
-		"Asinh":           math.Asinh,
-		"Atan":            math.Atan,
-		"Atan2":           math.Atan2,
-		"Atanh":           math.Atanh,
-		"Cbrt":            math.Cbrt,
-		"Ceil":            math.Ceil,
-		"Copysign":        math.Copysign,
-		"Cos":             math.Cos,
-		"Cosh":            math.Cosh,
-		"Dim":             math.Dim,
-		"Erf":             math.Erf,
-		"Erfc":            math.Erfc,
-		"Erfcinv":         math.Erfcinv,
-		"Erfinv":          math.Erfinv,
-		"Exp":             math.Exp,
-		"Exp2":            math.Exp2,
-		"Expm1":           math.Expm1,
-		"Float32bits":     math.Float32bits,
-		"Float32frombits": math.Float32frombits,
-		"Float64bits":     math.Float64bits,
-		"Float64frombits": math.Float64frombits,
-		"Floor":           math.Floor,
-		"Frexp":           math.Frexp,
-		"Gamma":           math.Gamma,
-		"Hypot":           math.Hypot,
-		"Ilogb":           math.Ilogb,
-		"Inf":             math.Inf,
-		"IsInf":           math.IsInf,
-		"IsNaN":           math.IsNaN,
-		"J0":              math.J0,
-		"J1":              math.J1,
-		"Jn":              math.Jn,
-		"Ldexp":           math.Ldexp,
-		"Lgamma":          math.Lgamma,
-		"Log":             math.Log,
-		"Log10":           math.Log10,
-		"Log1p":           math.Log1p,
-		"Log2":            math.Log2,
-		"Logb":            math.Logb,
-		"Max":             math.Max,
-		"Min":             math.Min,
-		"Mod":             math.Mod,
-		"Modf":            math.Modf,
-		"NaN":             math.NaN,
-		"Nextafter":       math.Nextafter,
-		"Nextafter32":     math.Nextafter32,
-		"Pow":             math.Pow,
-		"Pow10":           math.Pow10,
-		"Remainder":       math.Remainder,
-		"Round":           math.Round,
-		"RoundToEven":     math.RoundToEven,
-		"Signbit":         math.Signbit,
-		"Sin":             math.Sin,
-		"Sincos":          math.Sincos,
-		"Sinh":            math.Sinh,
-		"Sqrt":            math.Sqrt,
-		"Tan":             math.Tan,
-		"Tanh":            math.Tanh,
-		"Trunc":           math.Trunc,
-		"Y0":              math.Y0,
-		"Y1":              math.Y1,
-		"Yn":              math.Yn,
-	} {
-		switch f := f.(type) {
-		case func(float64) float64:
-			SingleFunctions[strings.ToUpper(name)] = f
-		case func(float64, float64) float64:
-			DoubleFunctions[strings.ToUpper(name)] = f
-		case func(int, float64) float64:
-			DoubleFunctions[strings.ToUpper(name)] = func(f1 float64, f2 float64) float64 {
-				return f(int(f1), f2)
-			}
-		case func(float64, int) float64:
-			DoubleFunctions[strings.ToUpper(name)] = func(f1 float64, f2 float64) float64 {
-				return f(f1, int(f2))
-			}
-		case func(int) float64:
-			SingleFunctions[strings.ToUpper(name)] = func(f1 float64) float64 {
-				return f(int(f1))
-			}
-		case func(float64) int:
-			SingleFunctions[strings.ToUpper(name)] = func(f1 float64) float64 {
-				return float64(f(f1))
-			}
-		default:
-			continue
-		}
-		FunctionNames = append(FunctionNames, name)
+// Comparisons are the operators of Compare, in the order the dna packages
+// number them.
+var Comparisons = []string{"<", "<=", ">", ">=", "=="}
+
+// comparison returns the function of a Compare operator, or nil.
+func comparison(op string) DoubleFunctionDef {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return lessEqual
+	case ">":
+		return greater
+	case ">=":
+		return greaterEqual
+	case "==":
+		return equalTo
+	}
+	return nil
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func less(a, b float64) float64         { return truth(a < b) }
+func lessEqual(a, b float64) float64    { return truth(a <= b) }
+func greater(a, b float64) float64      { return truth(a > b) }
+func greaterEqual(a, b float64) float64 { return truth(a >= b) }
+func equalTo(a, b float64) float64      { return truth(a == b) }
+
+// Compare is 1 when LHS Op RHS holds and 0 otherwise, NaN comparing false to
+// everything. Operators outside Comparisons always give 0.
+type Compare struct {
+	Op  string
+	LHS Expression
+	RHS Expression
+}
+
+func (v Compare) HasVar(vs string) bool {
+	return v.LHS.HasVar(vs) || v.RHS.HasVar(vs)
+}
+
+func (v Compare) Evaluate(state *State) float64 {
+	a := v.LHS.Evaluate(state)
+	b := v.RHS.Evaluate(state)
+	if f := comparison(v.Op); f != nil {
+		return f(a, b)
+	}
+	return 0
+}
+
+func (v Compare) String() string {
+	return infix(v.LHS, v.Op, v.RHS, precCompare, false)
+}
+
+func (v Compare) Simplify() Expression {
+	v.LHS = v.LHS.Simplify()
+	v.RHS = v.RHS.Simplify()
+	return simplifyCompare(&v)
+}
+
+func (v Compare) Depth() int {
+	l, r := v.LHS.Depth(), v.RHS.Depth()
+	if l > r {
+		return l + 1
+	}
+	return r + 1
+}
+
+// The shaping functions behind If, Step, Clamp, Lerp and Smoothstep. Products
+// are converted explicitly so the compiler cannot fuse them into
+// multiply-adds, which keeps the results identical to generated Go code.
+
+// ifElse is a when c is non zero or NaN and b when it is zero.
+func ifElse(c, a, b float64) float64 {
+	if c != 0 {
+		return a
+	}
+	return b
+}
+
+// heaviside is 0 when x is below edge and 1 otherwise, as GLSL's step.
+func heaviside(edge, x float64) float64 {
+	if x < edge {
+		return 0
+	}
+	return 1
+}
+
+func clamp(x, lo, hi float64) float64 {
+	return math.Min(math.Max(x, lo), hi)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + float64((b-a)*t)
+}
+
+// smoothstep eases from 0 at e0 to 1 at e1 with a cubic Hermite curve.
+func smoothstep(e0, e1, x float64) float64 {
+	t := clamp((x-e0)/(e1-e0), 0, 1)
+	return float64(t*t) * (3 - float64(2*t))
+}
+
+type TripleFunction struct {
+	Name  string
+	Expr1 Expression
+	Expr2 Expression
+	Expr3 Expression
+	Fn    TripleFunctionDef
+}
+
+func NewTripleFunction(name string, expr1, expr2, expr3 Expression) *TripleFunction {
+	f := TripleFunctions[strings.ToUpper(name)]
+	return &TripleFunction{Name: name, Expr1: expr1, Expr2: expr2, Expr3: expr3, Fn: f}
+}
+
+func (v TripleFunction) HasVar(vs string) bool {
+	return v.Expr1.HasVar(vs) || v.Expr2.HasVar(vs) || v.Expr3.HasVar(vs)
+}
+
+func (v TripleFunction) Evaluate(state *State) float64 {
+	var r1 = v.Expr1.Evaluate(state)
+	var r2 = v.Expr2.Evaluate(state)
+	var r3 = v.Expr3.Evaluate(state)
+	f := v.Fn
+	if f == nil {
+		f = TripleFunctions[strings.ToUpper(v.Name)]
+	}
+	switch {
+	case f == nil:
+	case state.Policy == Protected:
+		r1 = protect3(f, r1, r2, r3)
+	default:
+		r1 = f(r1, r2, r3)
+	}
+	return r1
+}
+
+func (v TripleFunction) String() string {
+	return fmt.Sprintf("%s(%s, %s, %s)", v.Name, v.Expr1.String(), v.Expr2.String(), v.Expr3.String())
+}
+
+func (v TripleFunction) Simplify() Expression {
+	v.Expr1 = v.Expr1.Simplify()
+	v.Expr2 = v.Expr2.Simplify()
+	v.Expr3 = v.Expr3.Simplify()
+	return simplifyTripleFunction(&v)
+}
+
+func (v TripleFunction) Depth() int {
+	d := v.Expr1.Depth()
+	if d2 := v.Expr2.Depth(); d2 > d {
+		d = d2
+	}
+	if d3 := v.Expr3.Depth(); d3 > d {
+		d = d3
 	}
+	return d + 1
 }
 
+// ParseFunction parses a formula. It may be called concurrently. A formula
+// that does not parse, calls an unknown function or calls a function with
+// the wrong number of arguments gives an error wrapping a *SyntaxError.
 func ParseFunction(arg string) (*Function, error) {
-	yyResult = nil
-	if r := yyParse(NewCalcLexer(arg)); r != 0 {
-		return nil, fmt.Errorf("invalid formula: %s", arg)
+	lex := NewCalcLexer(arg).(*CalcLexer)
+	if r := yyParse(lex); r != 0 || lex.err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %w", arg, lex.syntaxError())
 	}
-	return yyResult, nil
+	return lex.result, nil
 }
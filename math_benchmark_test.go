@@ -18,6 +18,6 @@ func BenchmarkEvaluate(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _, _ = f.Evaluate(float64(i), float64(i), i)
+		_, _, _ = f.Evaluate(float64(i), float64(i), float64(i))
 	}
 }
@@ -1,6 +1,11 @@
 package image_formula_find
 
 import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -30,6 +35,135 @@ func TestEvaluateCorrectness(t *testing.T) {
 	}
 }
  
+func TestConditionals(t *testing.T) {
+	tests := []struct {
+		formula string
+		x, y    float64
+		want    float64
+	}{
+		{"0 = x < y", 1, 2, 1},
+		{"0 = x < y", 2, 2, 0},
+		{"0 = x <= y", 2, 2, 1},
+		{"0 = x > y", 3, 2, 1},
+		{"0 = x >= y", 1, 2, 0},
+		{"0 = x == y", 2, 2, 1},
+		{"0 = (x == 1) + (y == 1)", 1, 1, 2},
+		{"0 = if(x, 3, 4)", 0, 0, 4},
+		{"0 = if(x - 1, 3, 4)", 0, 0, 3},
+		{"0 = step(1, x)", 0.5, 0, 0},
+		{"0 = step(1, x)", 1, 0, 1},
+		{"0 = clamp(x, 0, 1)", 5, 0, 1},
+		{"0 = clamp(x, 0, 1)", -5, 0, 0},
+		{"0 = lerp(2, 4, x)", 0.25, 0, 2.5},
+		{"0 = smoothstep(0, 2, x)", 1, 0, 0.5},
+		{"0 = smoothstep(0, 2, x)", 3, 0, 1},
+	}
+	for _, test := range tests {
+		f, err := ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		if got, _, _ := f.Evaluate(test.x, test.y, 0); got != test.want {
+			t.Errorf("%s at %v, %v: got %v, want %v", test.formula, test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	state := &State{X: 3, Y: -4, Width: 200, Height: 100, Params: map[string]float64{"SCALE": 2.5}}
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"0 = r", 5},
+		{"0 = a", math.Atan2(-4, 3)},
+		{"0 = u", 0.65},
+		{"0 = v", 0.3},
+		{"0 = px", 130},
+		{"0 = py", 30},
+		{"0 = scale * x", 7.5},
+		{"0 = missing", math.NaN()},
+	}
+	same := func(got, want float64) bool {
+		return math.Abs(got-want) <= 1e-12 || math.IsNaN(got) && math.IsNaN(want)
+	}
+	for _, test := range tests {
+		f, err := ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		if got := f.Equals.Evaluate(state); !same(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.formula, got, test.want)
+		}
+		p := CompileWith(f, state)
+		if got, want := p.Evaluate(state.X, state.Y, 0), f.Equals.Evaluate(state); got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Errorf("%s: compiled to %v, want %v", test.formula, got, want)
+		}
+		i, _ := f.EvaluateInterval(&IntervalState{X: Point(3), Y: Point(-4), Width: 200, Height: 100, Params: state.Params})
+		if want := f.Equals.Evaluate(state); !i.Contains(want) {
+			t.Errorf("%s: interval %+v does not hold %v", test.formula, i, want)
+		}
+	}
+
+	f, err := ParseFunction("0 = r + x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.HasVar("X") || !f.HasVar("Y") || f.HasVar("T") {
+		t.Errorf("%s: unexpected dependencies", f)
+	}
+	d, err := f.Derive("X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := d.Evaluate(3, -4, 0); math.Abs(got-(0.6+1)) > 1e-12 {
+		t.Errorf("Derivative %s evaluated to %v, want 1.6", d, got)
+	}
+	// The position across the image depends on the view.
+	for _, name := range []string{"U", "V", "PX", "PY"} {
+		if _, err := (Var{Var: name}).Derive("X"); !errors.Is(err, ErrNoDerivative) {
+			t.Errorf("Expected ErrNoDerivative for %s, got %v", name, err)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	// A quarter turn and a half, centred on (3, -1), 8 wide.
+	sin, cos := math.Sincos(2)
+	frame := Frame{XU: 8 * cos, XV: -8 * sin, YU: 8 * sin, YV: 8 * cos}
+	frame.X0 = 3 - frame.XU/2 - frame.XV/2
+	frame.Y0 = -1 - frame.YU/2 - frame.YV/2
+	for _, formula := range []string{"0 = u", "0 = v", "0 = px", "0 = py"} {
+		f, err := ParseFunction(formula)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := &State{Width: 200, Height: 100, Frame: frame}
+		p := CompileWith(f, state)
+		for _, uv := range [][2]float64{{0, 0}, {1, 0}, {0.5, 0.5}, {0.25, 0.9}} {
+			state.X, state.Y = frame.at(uv[0], uv[1])
+			want := map[string]float64{"0 = u": uv[0], "0 = v": uv[1], "0 = px": uv[0] * 200, "0 = py": uv[1] * 100}[formula]
+			got := f.Equals.Evaluate(state)
+			if math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s at %v: got %v, want %v", formula, uv, got, want)
+			}
+			if compiled := p.Evaluate(state.X, state.Y, 0); compiled != got {
+				t.Errorf("%s at %v: compiled to %v, want %v", formula, uv, compiled, got)
+			}
+			i, _ := f.EvaluateInterval(&IntervalState{X: Point(state.X), Y: Point(state.Y), Width: 200, Height: 100, Frame: frame})
+			if !i.Contains(got) {
+				t.Errorf("%s at %v: interval %+v does not hold %v", formula, uv, i, got)
+			}
+		}
+	}
+	// The zero Frame is the default view, exactly.
+	for _, x := range []float64{-10, -3.7, 0.1, 9.99} {
+		if u, v := (Frame{}).unit(x, x); u != (x+10)/20 || v != (x+10)/20 {
+			t.Errorf("Default view at %v: (%v, %v)", x, u, v)
+		}
+	}
+}
+
 func TestParseFunction_InvalidInput(t *testing.T) {
 	cases := []string{
 		"x =",
@@ -46,6 +180,68 @@ func TestParseFunction_InvalidInput(t *testing.T) {
 	}
 }
 
+func TestParseFunction_SyntaxError(t *testing.T) {
+	cases := []struct {
+		input    string
+		column   int
+		token    string
+		message  string
+		expected string
+	}{
+		{"y = (x +)", 9, ")", `unexpected ")"`, `number variable function "√" "(" "+" "-"`},
+		{"x = y +", 8, "", "unexpected end of input", `number variable function "√" "(" "+" "-"`},
+		{"y = x x", 7, "x", `unexpected "x"`, `function "+" "-" "*" "/" "%" "^" "<" ">" "<=" ">=" "==" end of input`},
+		{"y = x # 2", 7, "#", "unexpected character '#'", ""},
+		{"y = 2 * nosuch(x)", 9, "nosuch", "unknown function nosuch", ""},
+		{"y = sin(x, y)", 5, "sin", "Sin takes 1 argument, not 2", ""},
+		{"y = x + max(x)", 9, "max", "Max takes 2 arguments, not 1", ""},
+		{"y = x mod", 10, "", "unexpected end of input", `number variable function "√" "(" "+" "-"`},
+	}
+	for _, c := range cases {
+		_, err := ParseFunction(c.input)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%q: expected a SyntaxError, got %v", c.input, err)
+			continue
+		}
+		if syntax.Line != 1 || syntax.Column != c.column || syntax.Token != c.token || syntax.Message != c.message {
+			t.Errorf("%q: got line %d column %d token %q message %q", c.input, syntax.Line, syntax.Column, syntax.Token, syntax.Message)
+		}
+		if got := strings.Join(syntax.Expected, " "); got != c.expected {
+			t.Errorf("%q: expected %s, got %s", c.input, c.expected, got)
+		}
+	}
+
+	_, err := ParseScript("let a = 1\nr = a +\ng = a")
+	var syntax *SyntaxError
+	if !errors.As(err, &syntax) || syntax.Line != 2 || syntax.Column != 8 || syntax.Message != "unexpected end of line" {
+		t.Errorf("Unexpected script error %v", err)
+	}
+}
+
+func TestParseFunction_Concurrent(t *testing.T) {
+	formulas := []string{"y = x * 2", "y = sin(x) + cos(y)", "y = (x +)", "x = max(x, y) ^ 2"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				each := formulas[(i+j)%len(formulas)]
+				f, err := ParseFunction(each)
+				if each == "y = (x +)" {
+					if err == nil {
+						t.Errorf("Expected an error for %q", each)
+					}
+				} else if err != nil || f.String() != each {
+					t.Errorf("Parsed %q as %v, %v", each, f, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestParseFunction_ValidInput(t *testing.T) {
 	f, err := ParseFunction("x = y + 1")
 	if err != nil {
@@ -55,3 +251,21 @@ func TestParseFunction_ValidInput(t *testing.T) {
 		t.Error("Expected function, got nil")
 	}
 }
+
+func TestUnbound(t *testing.T) {
+	f, err := ParseFunction("y = gain * sin(x + phase) + r + gain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ParseFunction("0 = Offset * t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Unbound(map[string]float64{"PHASE": 1}, f, nil, g)
+	if want := []string{"GAIN", "OFFSET"}; !slices.Equal(got, want) {
+		t.Errorf("Unbound = %q, want %q", got, want)
+	}
+	if got := Unbound(map[string]float64{"GAIN": 2, "PHASE": 1, "OFFSET": 0}, f, g); len(got) != 0 {
+		t.Errorf("Unbound = %q with every parameter bound", got)
+	}
+}
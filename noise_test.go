@@ -0,0 +1,88 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// noises are the noise functions with their ranges, taking the octaves of
+// the fractals as the third argument.
+var noises = []struct {
+	name   string
+	f      func(x, y, z float64) float64
+	lo, hi float64
+}{
+	{"Perlin", func(x, y, _ float64) float64 { return perlin(x, y) }, -1, 1},
+	{"Perlin3", perlin3, -1, 1},
+	{"Simplex", func(x, y, _ float64) float64 { return simplex(x, y) }, -1, 1},
+	{"Simplex3", simplex3, -1, 1},
+	{"ValueNoise", func(x, y, _ float64) float64 { return valueNoise(x, y) }, -1, 1},
+	{"ValueNoise3", valueNoise3, -1, 1},
+	{"Worley", func(x, y, _ float64) float64 { return worley(x, y) }, 0, math.Sqrt2},
+	{"Worley3", worley3, 0, math.Sqrt(3)},
+	{"Fbm", fbm, -1, 1},
+	{"Turbulence", turbulence, 0, 1},
+}
+
+func TestNoiseRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range noises {
+		varies := false
+		first := n.f(0.5, 0.5, 3)
+		for i := 0; i < 5000; i++ {
+			x, y, z := r.Float64()*200-100, r.Float64()*200-100, r.Float64()*8
+			v := n.f(x, y, z)
+			if !(v >= n.lo && v <= n.hi) {
+				t.Fatalf("%s(%v, %v, %v) = %v outside [%v, %v]", n.name, x, y, z, v, n.lo, n.hi)
+			}
+			if v != n.f(x, y, z) {
+				t.Fatalf("%s(%v, %v, %v) is not deterministic", n.name, x, y, z)
+			}
+			varies = varies || v != first
+		}
+		if !varies {
+			t.Errorf("%s is constant", n.name)
+		}
+	}
+}
+
+func TestNoiseUndefined(t *testing.T) {
+	for _, n := range noises {
+		for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), noiseLimit, -1e300} {
+			if got := n.f(v, 0.5, 2); !math.IsNaN(got) {
+				t.Errorf("%s(%v, 0.5, 2) = %v, want NaN", n.name, v, got)
+			}
+		}
+	}
+	if got := fbm(1.5, 2.5, math.NaN()); !math.IsNaN(got) {
+		t.Errorf("Fbm with NaN octaves = %v, want NaN", got)
+	}
+}
+
+func TestNoiseSeed(t *testing.T) {
+	defer func(seed uint64) { NoiseSeed = seed }(NoiseSeed)
+	if got := perlin(3, -7); got != 0 {
+		t.Errorf("Perlin at a lattice point = %v, want 0", got)
+	}
+	NoiseSeed = 0
+	before := perlin(0.3, 0.7)
+	NoiseSeed = 42
+	if after := perlin(0.3, 0.7); after == before {
+		t.Errorf("Perlin does not depend on the seed: %v", after)
+	}
+}
+
+func TestNoiseOctaves(t *testing.T) {
+	// Octaves round into 1 to maxOctaves, the first octave being Perlin
+	// noise.
+	if got, want := fbm(1.3, 2.7, -5), perlin(1.3, 2.7); got != want {
+		t.Errorf("Fbm with one octave = %v, want %v", got, want)
+	}
+	if got, want := turbulence(1.3, 2.7, 0.7), math.Abs(perlin(1.3, 2.7)); got != want {
+		t.Errorf("Turbulence with one octave = %v, want %v", got, want)
+	}
+	if got, want := fbm(1.3, 2.7, 100), fbm(1.3, 2.7, maxOctaves); got != want {
+		t.Errorf("Fbm with 100 octaves = %v, want %v", got, want)
+	}
+}
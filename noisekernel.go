@@ -0,0 +1,348 @@
+package image_formula_find
+
+import "math"
+
+// This file holds the noise functions themselves, which NoiseSource embeds
+// for codegen to write into generated code. It declares nothing but
+// functions, and constants and variables one name at a time, using only the
+// math package and NoiseSeed.
+//
+// The noise functions round every product on its own, so the generated code
+// matches them bit for bit on platforms that fuse multiply-adds.
+
+// noiseLimit bounds the coordinates noise is defined for. Noise of NaN,
+// infinite or larger coordinates is NaN.
+const noiseLimit = 1 << 52
+
+// maxOctaves caps the octaves of Fbm and Turbulence.
+const maxOctaves = 8
+
+// noiseMix scrambles the bits of h.
+func noiseMix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xFF51AFD7ED558CCD
+	h ^= h >> 33
+	h *= 0xC4CEB9FE1A85EC53
+	h ^= h >> 33
+	return h
+}
+
+// noiseHash returns the random bits of a lattice point.
+func noiseHash(i, j, k int64) uint64 {
+	return noiseMix(NoiseSeed ^ uint64(i)*0x9E3779B97F4A7C15 ^ uint64(j)*0xC2B2AE3D27D4EB4F ^ uint64(k)*0x165667B19E3779F9)
+}
+
+// noiseUnit maps random bits onto [0, 1).
+func noiseUnit(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
+
+// lattice returns the cell holding v and the offset of v in it, reporting
+// false where noise is not defined.
+func lattice(v float64) (int64, float64, bool) {
+	if !(math.Abs(v) < noiseLimit) {
+		return 0, 0, false
+	}
+	f := math.Floor(v)
+	return int64(f), v - f, true
+}
+
+// fade is Perlin's smoother step, 6t^5 - 15t^4 + 10t^3.
+func fade(t float64) float64 {
+	return float64(float64(t*t)*t) * (float64(t*(float64(t*6)-15)) + 10)
+}
+
+func mix(a, b, t float64) float64 {
+	return a + float64(t*(b-a))
+}
+
+// grad2 is the dot product of the offset with one of eight gradients.
+func grad2(h uint64, x, y float64) float64 {
+	switch h & 7 {
+	case 0:
+		return x + y
+	case 1:
+		return y - x
+	case 2:
+		return x - y
+	case 3:
+		return -x - y
+	case 4:
+		return x
+	case 5:
+		return -x
+	case 6:
+		return y
+	}
+	return -y
+}
+
+// grad3 is the dot product of the offset with one of the twelve gradients
+// pointing to the middles of the edges of a cube.
+func grad3(h uint64, x, y, z float64) float64 {
+	switch h % 12 {
+	case 0:
+		return x + y
+	case 1:
+		return y - x
+	case 2:
+		return x - y
+	case 3:
+		return -x - y
+	case 4:
+		return x + z
+	case 5:
+		return z - x
+	case 6:
+		return x - z
+	case 7:
+		return -x - z
+	case 8:
+		return y + z
+	case 9:
+		return z - y
+	case 10:
+		return y - z
+	}
+	return -y - z
+}
+
+// perlin is Perlin gradient noise in the plane, roughly within [-1, 1] and 0
+// at the lattice points.
+func perlin(x, y float64) float64 {
+	return perlinLayer(x, y, 0)
+}
+
+// perlinLayer is Perlin noise with the lattice of layer k, so the octaves of
+// Fbm do not line up.
+func perlinLayer(x, y float64, k int64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	if !okx || !oky {
+		return math.NaN()
+	}
+	u, v := fade(fx), fade(fy)
+	return mix(
+		mix(grad2(noiseHash(i, j, k), fx, fy), grad2(noiseHash(i+1, j, k), fx-1, fy), u),
+		mix(grad2(noiseHash(i, j+1, k), fx, fy-1), grad2(noiseHash(i+1, j+1, k), fx-1, fy-1), u),
+		v)
+}
+
+// perlin3 is Perlin gradient noise in space.
+func perlin3(x, y, z float64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	k, fz, okz := lattice(z)
+	if !okx || !oky || !okz {
+		return math.NaN()
+	}
+	u, v, w := fade(fx), fade(fy), fade(fz)
+	layer := func(k int64, fz float64) float64 {
+		return mix(
+			mix(grad3(noiseHash(i, j, k), fx, fy, fz), grad3(noiseHash(i+1, j, k), fx-1, fy, fz), u),
+			mix(grad3(noiseHash(i, j+1, k), fx, fy-1, fz), grad3(noiseHash(i+1, j+1, k), fx-1, fy-1, fz), u),
+			v)
+	}
+	return mix(layer(k, fz), layer(k+1, fz-1), w)
+}
+
+// Skew factors of simplex noise.
+var (
+	skew2   = (math.Sqrt(3) - 1) / 2
+	unskew2 = (3 - math.Sqrt(3)) / 6
+)
+
+const (
+	skew3   = 1.0 / 3
+	unskew3 = 1.0 / 6
+)
+
+// simplex is simplex noise in the plane, roughly within [-1, 1].
+func simplex(x, y float64) float64 {
+	s := float64((x + y) * skew2)
+	i, _, oki := lattice(x + s)
+	j, _, okj := lattice(y + s)
+	if !oki || !okj {
+		return math.NaN()
+	}
+	t := float64(float64(i+j) * unskew2)
+	x0, y0 := x-(float64(i)-t), y-(float64(j)-t)
+	var i1, j1 int64 = 0, 1
+	if x0 > y0 {
+		i1, j1 = 1, 0
+	}
+	x1, y1 := x0-float64(i1)+unskew2, y0-float64(j1)+unskew2
+	x2, y2 := x0-1+float64(2*unskew2), y0-1+float64(2*unskew2)
+	corner := func(h uint64, x, y float64) float64 {
+		t := 0.5 - float64(x*x) - float64(y*y)
+		if t < 0 {
+			return 0
+		}
+		t = float64(t * t)
+		return float64(t*t) * grad2(h, x, y)
+	}
+	n := corner(noiseHash(i, j, 0), x0, y0) + corner(noiseHash(i+i1, j+j1, 0), x1, y1) + corner(noiseHash(i+1, j+1, 0), x2, y2)
+	return 70 * n
+}
+
+// simplex3 is simplex noise in space, roughly within [-1, 1].
+func simplex3(x, y, z float64) float64 {
+	s := float64((x + y + z) * skew3)
+	i, _, oki := lattice(x + s)
+	j, _, okj := lattice(y + s)
+	k, _, okk := lattice(z + s)
+	if !oki || !okj || !okk {
+		return math.NaN()
+	}
+	t := float64(float64(i+j+k) * unskew3)
+	x0, y0, z0 := x-(float64(i)-t), y-(float64(j)-t), z-(float64(k)-t)
+	// The second and third corners step along the axes in order of the
+	// offsets, largest first.
+	var i1, j1, k1, i2, j2, k2 int64
+	switch {
+	case x0 >= y0 && y0 >= z0:
+		i1, i2, j2 = 1, 1, 1
+	case x0 >= y0 && x0 >= z0:
+		i1, i2, k2 = 1, 1, 1
+	case x0 >= y0:
+		k1, i2, k2 = 1, 1, 1
+	case y0 < z0:
+		k1, j2, k2 = 1, 1, 1
+	case x0 < z0:
+		j1, j2, k2 = 1, 1, 1
+	default:
+		j1, i2, j2 = 1, 1, 1
+	}
+	corner := func(h uint64, x, y, z float64) float64 {
+		t := 0.6 - float64(x*x) - float64(y*y) - float64(z*z)
+		if t < 0 {
+			return 0
+		}
+		t = float64(t * t)
+		return float64(t*t) * grad3(h, x, y, z)
+	}
+	n := corner(noiseHash(i, j, k), x0, y0, z0) +
+		corner(noiseHash(i+i1, j+j1, k+k1), x0-float64(i1)+unskew3, y0-float64(j1)+unskew3, z0-float64(k1)+unskew3) +
+		corner(noiseHash(i+i2, j+j2, k+k2), x0-float64(i2)+2*unskew3, y0-float64(j2)+2*unskew3, z0-float64(k2)+2*unskew3) +
+		corner(noiseHash(i+1, j+1, k+1), x0-1+3*unskew3, y0-1+3*unskew3, z0-1+3*unskew3)
+	return 32 * n
+}
+
+// noiseValue is the random value of a lattice point, within [-1, 1).
+func noiseValue(h uint64) float64 {
+	return noiseUnit(h)*2 - 1
+}
+
+// valueNoise interpolates random values at the lattice points of the plane,
+// within [-1, 1].
+func valueNoise(x, y float64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	if !okx || !oky {
+		return math.NaN()
+	}
+	u, v := fade(fx), fade(fy)
+	return mix(
+		mix(noiseValue(noiseHash(i, j, 0)), noiseValue(noiseHash(i+1, j, 0)), u),
+		mix(noiseValue(noiseHash(i, j+1, 0)), noiseValue(noiseHash(i+1, j+1, 0)), u),
+		v)
+}
+
+// valueNoise3 is value noise in space.
+func valueNoise3(x, y, z float64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	k, fz, okz := lattice(z)
+	if !okx || !oky || !okz {
+		return math.NaN()
+	}
+	u, v, w := fade(fx), fade(fy), fade(fz)
+	layer := func(k int64) float64 {
+		return mix(
+			mix(noiseValue(noiseHash(i, j, k)), noiseValue(noiseHash(i+1, j, k)), u),
+			mix(noiseValue(noiseHash(i, j+1, k)), noiseValue(noiseHash(i+1, j+1, k)), u),
+			v)
+	}
+	return mix(layer(k), layer(k+1), w)
+}
+
+// worley is cellular noise in the plane: the distance to the nearest of one
+// random point in every lattice cell, within [0, Sqrt(2)].
+func worley(x, y float64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	if !okx || !oky {
+		return math.NaN()
+	}
+	nearest := math.Inf(1)
+	for di := int64(-1); di <= 1; di++ {
+		for dj := int64(-1); dj <= 1; dj++ {
+			h := noiseHash(i+di, j+dj, 0)
+			px := float64(di) + noiseUnit(h) - fx
+			py := float64(dj) + noiseUnit(noiseMix(h)) - fy
+			nearest = math.Min(nearest, float64(px*px)+float64(py*py))
+		}
+	}
+	return math.Sqrt(nearest)
+}
+
+// worley3 is cellular noise in space, within [0, Sqrt(3)].
+func worley3(x, y, z float64) float64 {
+	i, fx, okx := lattice(x)
+	j, fy, oky := lattice(y)
+	k, fz, okz := lattice(z)
+	if !okx || !oky || !okz {
+		return math.NaN()
+	}
+	nearest := math.Inf(1)
+	for di := int64(-1); di <= 1; di++ {
+		for dj := int64(-1); dj <= 1; dj++ {
+			for dk := int64(-1); dk <= 1; dk++ {
+				h := noiseHash(i+di, j+dj, k+dk)
+				px := float64(di) + noiseUnit(h) - fx
+				py := float64(dj) + noiseUnit(noiseMix(h)) - fy
+				pz := float64(dk) + noiseUnit(noiseMix(noiseMix(h))) - fz
+				nearest = math.Min(nearest, float64(px*px)+float64(py*py)+float64(pz*pz))
+			}
+		}
+	}
+	return math.Sqrt(nearest)
+}
+
+// octaves rounds an octave count into 1 to maxOctaves, reporting false for
+// NaN.
+func octaves(n float64) (int, bool) {
+	if math.IsNaN(n) {
+		return 0, false
+	}
+	return int(math.Round(math.Min(math.Max(n, 1), maxOctaves))), true
+}
+
+// fbm is fractal Brownian motion: Perlin noise summed over octaves, each of
+// twice the frequency and half the amplitude of the one before, scaled back
+// into [-1, 1].
+func fbm(x, y, n float64) float64 {
+	return fractal(x, y, n, perlinLayer)
+}
+
+// turbulence is fbm of the magnitude of the noise, within [0, 1].
+func turbulence(x, y, n float64) float64 {
+	return fractal(x, y, n, func(x, y float64, k int64) float64 {
+		return math.Abs(perlinLayer(x, y, k))
+	})
+}
+
+func fractal(x, y, n float64, noise func(x, y float64, k int64) float64) float64 {
+	count, ok := octaves(n)
+	if !ok {
+		return math.NaN()
+	}
+	total, norm, amplitude, frequency := 0.0, 0.0, 1.0, 1.0
+	for k := 0; k < count; k++ {
+		total += float64(noise(float64(x*frequency), float64(y*frequency), int64(k)) * amplitude)
+		norm += amplitude
+		amplitude /= 2
+		frequency *= 2
+	}
+	return total / norm
+}
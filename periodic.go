@@ -0,0 +1,56 @@
+package image_formula_find
+
+import "math"
+
+// periodSamples is the number of times within a period, and of points along
+// each axis of the image, Periodic compares the formula at.
+const periodSamples = 9
+
+// Periodic reports whether the formula repeats in T with the given period
+// from start on, so an animation of T from start to start plus the period
+// loops without a jump. Formulas that do not use T are periodic.
+//
+// The formula is compared at T and T plus the period for a grid of points
+// across the image and several times within the period. Values within one
+// part in a million of each other, or both NaN or infinite, are taken as
+// equal, so Sin(T) is periodic with a period of 2π despite rounding. The
+// check finds the formulas that do not repeat rather than proving that the
+// rest do.
+func (v Function) Periodic(start, period float64) bool {
+	return v.PeriodicWith(start, period, nil)
+}
+
+// PeriodicWith is Periodic for an image of the size, view, parameters and
+// numeric policy of env, see CompileWith, so the grid spans the image
+// through its Frame rather than the default view.
+func (v Function) PeriodicWith(start, period float64, env *State) bool {
+	if env == nil {
+		env = &State{}
+	}
+	p := CompileWith(&v, env)
+	if !p.UsesT {
+		return true
+	}
+	frame := env.Frame.resolve()
+	for k := 0; k < periodSamples; k++ {
+		t := start + period*float64(k)/periodSamples
+		for i := 0; i < periodSamples; i++ {
+			u := float64(i) / (periodSamples - 1)
+			for j := 0; j < periodSamples; j++ {
+				x, y := frame.at(u, float64(j)/(periodSamples-1))
+				if !closeTo(p.Evaluate(x, y, t), p.Evaluate(x, y, t+period)) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// closeTo reports whether a and b are equal for Periodic.
+func closeTo(a, b float64) bool {
+	if !Valid(a) || !Valid(b) {
+		return !Valid(a) && !Valid(b)
+	}
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
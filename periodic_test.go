@@ -0,0 +1,66 @@
+package image_formula_find
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPeriodic(t *testing.T) {
+	tests := []struct {
+		formula       string
+		start, period float64
+		want          bool
+	}{
+		{"0 = x * y", 0, 1, true},
+		{"0 = sin(x + t) * 100", 0, 2 * math.Pi, true},
+		{"0 = sin(x + t) * 100", 3, 2 * math.Pi, true},
+		{"0 = cos(t * pi) + y", -1, 2, true},
+		{"0 = sin(x + t) * 100", 0, math.Pi, false},
+		{"0 = x + t", 0, 1, false},
+		{"0 = floor(t) * 0 + mod(t, 1.5) * x", 0, 1.5, true},
+		{"0 = log(-1 * (t * t + 1))", 0, 1, true},
+	}
+	for _, test := range tests {
+		f, err := ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		if got := f.Periodic(test.start, test.period); got != test.want {
+			t.Errorf("%s from %v with period %v: got %v, want %v", test.formula, test.start, test.period, got, test.want)
+		}
+	}
+}
+
+func TestPeriodicWith(t *testing.T) {
+	// T only shows for X over 20, out of the default view.
+	f, err := ParseFunction("0 = max(x + -20, 0) * t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.PeriodicWith(0, 1, nil) {
+		t.Errorf("%s does not repeat in the default view", f)
+	}
+	moved := &State{Width: 10, Height: 10, Frame: Frame{XU: 20, X0: 90, YV: 20, Y0: -10}}
+	if f.PeriodicWith(0, 1, moved) {
+		t.Errorf("%s repeats in %+v", f, moved.Frame)
+	}
+}
+
+func TestEvaluateFractionalT(t *testing.T) {
+	f, err := ParseFunction("0 = t * 4 + x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, used, _ := f.Evaluate(1, 0, 0.25); got != 2 || !used {
+		t.Errorf("Evaluate at T 0.25: got %v, %v", got, used)
+	}
+	p := Compile(f)
+	if got := p.Evaluate(1, 0, 0.25); got != 2 {
+		t.Errorf("Compiled at T 0.25: got %v", got)
+	}
+	out := make([]float64, 2)
+	p.EvaluateRow([]float64{1, 2}, 0, 0.5, out)
+	if out[0] != 3 || out[1] != 4 {
+		t.Errorf("Row at T 0.5: got %v", out)
+	}
+}
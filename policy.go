@@ -0,0 +1,124 @@
+package image_formula_find
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Policy decides what becomes of the NaN and infinite values formulas
+// produce, from Log of a negative, division by zero, Gamma overflowing and
+// so on. It is chosen per evaluation, with State.Policy, and compiled into a
+// Program by CompileWith.
+type Policy int
+
+const (
+	// IEEE passes NaN and infinities through as IEEE 754 arithmetic
+	// produces them.
+	IEEE Policy = iota
+	// Protected evaluates with the protected operators of genetic
+	// programming, so every formula yields a number. Division by zero is 1.
+	// An operator or function whose result is not finite although its
+	// arguments are is evaluated again with its first argument replaced by
+	// the magnitude, which makes Log and Sqrt of a negative those of its
+	// absolute value, and is 0 when that does not help either. A result still
+	// not finite, say from an overflowing sum, is 0.
+	Protected
+	// Poison evaluates like IEEE, leaving renderers to mark the pixels with a
+	// channel that is not finite as invalid. See drawer1.Diagnostics.
+	Poison
+)
+
+// Policies are the names of the policies, in order.
+var Policies = []string{"ieee", "protected", "poison"}
+
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(Policies) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return Policies[p]
+}
+
+// ParsePolicy returns the policy of a name in Policies, in any case.
+func ParsePolicy(name string) (Policy, error) {
+	for i, each := range Policies {
+		if strings.EqualFold(name, each) {
+			return Policy(i), nil
+		}
+	}
+	return IEEE, fmt.Errorf("unknown numeric policy %q, expected one of %s", name, strings.Join(Policies, ", "))
+}
+
+// Valid reports whether v is a value every policy keeps, neither NaN nor
+// infinite.
+func Valid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// protectResult is the final value of a formula under policy p.
+func protectResult(p Policy, v float64) float64 {
+	if p == Protected && !Valid(v) {
+		return 0
+	}
+	return v
+}
+
+// protectedDivide is a / b under the Protected policy.
+func protectedDivide(a, b float64) float64 {
+	if b == 0 {
+		return 1
+	}
+	return protect2(divide, a, b)
+}
+
+func divide(a, b float64) float64 {
+	return a / b
+}
+
+// protect1, protect2 and protect3 evaluate f under the Protected policy.
+func protect1(f SingleFunctionDef, a float64) float64 {
+	r := f(a)
+	if Valid(r) || !Valid(a) {
+		return r
+	}
+	if r = f(math.Abs(a)); Valid(r) {
+		return r
+	}
+	return 0
+}
+
+func protect2(f DoubleFunctionDef, a, b float64) float64 {
+	r := f(a, b)
+	if Valid(r) || !Valid(a) || !Valid(b) {
+		return r
+	}
+	if r = f(math.Abs(a), b); Valid(r) {
+		return r
+	}
+	return 0
+}
+
+func protect3(f TripleFunctionDef, a, b, c float64) float64 {
+	r := f(a, b, c)
+	if Valid(r) || !Valid(a) || !Valid(b) || !Valid(c) {
+		return r
+	}
+	if r = f(math.Abs(a), b, c); Valid(r) {
+		return r
+	}
+	return 0
+}
+
+// protected1, protected2 and protected3 wrap f for a Program compiled with
+// the Protected policy.
+func protected1(f SingleFunctionDef) SingleFunctionDef {
+	return func(a float64) float64 { return protect1(f, a) }
+}
+
+func protected2(f DoubleFunctionDef) DoubleFunctionDef {
+	return func(a, b float64) float64 { return protect2(f, a, b) }
+}
+
+func protected3(f TripleFunctionDef) TripleFunctionDef {
+	return func(a, b, c float64) float64 { return protect3(f, a, b, c) }
+}
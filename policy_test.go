@@ -0,0 +1,92 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestProtected(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    float64
+	}{
+		{"0 = 1 / x", 1},
+		{"0 = y / x + 2", 3},
+		{"0 = log(4) - log(y)", 0},
+		{"0 = log(x) + 5", 5},
+		{"0 = sqrt(y)", 2},
+		{"0 = y ^ 0.5", 2},
+		{"0 = pow(y, 0.5)", 2},
+		{"0 = y % x + 1", 1},
+		{"0 = gamma(200) + 1", 1},
+		{"0 = 1e300 * 1e300", 0},
+		{"0 = sin(x) + 3", 3},
+	}
+	for _, test := range tests {
+		f, err := ParseFunction(test.formula)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.formula, err)
+		}
+		got := f.Equals.Evaluate(&State{X: 0, Y: -4, Policy: Protected})
+		if math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("%s: got %v, want %v", test.formula, got, test.want)
+		}
+		p := CompileWith(f, &State{Policy: Protected})
+		if compiled := p.Evaluate(0, -4, 0); !sameFloat(compiled, got) {
+			t.Errorf("%s: compiled %v, evaluated %v", test.formula, compiled, got)
+		}
+	}
+	f, err := ParseFunction("0 = 1 / x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []Policy{IEEE, Poison} {
+		if got := f.Equals.Evaluate(&State{Policy: policy}); !math.IsInf(got, 1) {
+			t.Errorf("%v: 1 / 0 = %v, want +Inf", policy, got)
+		}
+		if p := CompileWith(f, &State{Policy: policy}); !math.IsInf(p.Evaluate(0, 0, 0), 1) {
+			t.Errorf("%v: compiled 1 / 0 = %v, want +Inf", policy, p.Evaluate(0, 0, 0))
+		}
+	}
+}
+
+func TestProtectedIsValid(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	xs := []float64{-10, -1, 0, 0.5, 7}
+	out := make([]float64, len(xs))
+	for i := 0; i < 2000; i++ {
+		f := randomFunction(r, 6)
+		p := CompileWith(f, &State{Policy: Protected})
+		for _, y := range []float64{-10, 0, 3.5} {
+			p.EvaluateRow(xs, y, 1, out)
+			for j, x := range xs {
+				want := f.Equals.Evaluate(&State{X: x, Y: y, T: 1, Policy: Protected})
+				if !Valid(want) {
+					t.Fatalf("%s at (%v, %v): %v", f, x, y, want)
+				}
+				if got := p.Evaluate(x, y, 1); !sameFloat(got, want) {
+					t.Fatalf("%s at (%v, %v): compiled %v, evaluated %v", f, x, y, got, want)
+				}
+				if !sameFloat(out[j], want) {
+					t.Fatalf("%s at (%v, %v): row %v, evaluated %v", f, x, y, out[j], want)
+				}
+			}
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for i := range Policies {
+		p, err := ParsePolicy(Policy(i).String())
+		if err != nil || p != Policy(i) {
+			t.Errorf("ParsePolicy(%q) = %v, %v", Policy(i), p, err)
+		}
+	}
+	if p, err := ParsePolicy("Protected"); err != nil || p != Protected {
+		t.Errorf("ParsePolicy(Protected) = %v, %v", p, err)
+	}
+	if _, err := ParsePolicy("lenient"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,405 @@
+package image_formula_find
+
+import (
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The printers here are for reading formulas rather than parsing them back.
+// They drop Brackets nodes and only put in the parentheses the precedence
+// needs. Text keeps the syntax of String, so its output still parses to the
+// same tree, while LaTeX and MathML use the usual mathematical layout:
+// fractions for Divide, superscripts for Power and calls for infix double
+// functions. All of them print the operands in the order String does, which
+// for Subtract and Divide is RHS first, as they evaluate.
+
+// Notations maps the names accepted by the -notation command line flags to
+// printers.
+var Notations = map[string]func(Expression) string{
+	"text":   Text,
+	"latex":  LaTeX,
+	"mathml": MathML,
+}
+
+// Format prints the formula with one of the printers, returning "" for an
+// empty formula.
+func (v Function) Format(print func(Expression) string) string {
+	if v.Equals == nil {
+		return ""
+	}
+	return print(v.Equals)
+}
+
+func (v Function) Text() string {
+	return v.Format(Text)
+}
+
+func (v Function) LaTeX() string {
+	return v.Format(LaTeX)
+}
+
+func (v Function) MathML() string {
+	return v.Format(MathML)
+}
+
+// unwrap strips brackets and turns value nodes into pointer nodes so the
+// printers only need one case per node type.
+func unwrap(e Expression) Expression {
+	for {
+		if p := pointerTo(e); p != nil {
+			e = p
+		}
+		b, ok := e.(*Brackets)
+		if !ok {
+			return e
+		}
+		e = b.Expr
+	}
+}
+
+// Text prints e like String but without the parentheses of Brackets nodes
+// that the precedence makes redundant.
+func Text(e Expression) string {
+	s, _ := text(e)
+	return s
+}
+
+func text(e Expression) (string, int) {
+	group := func(e Expression, min int) string {
+		s, p := text(e)
+		if p < min {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	pair := func(lhs Expression, op string, rhs Expression, prec int, rightAssoc bool) string {
+		l, r := prec, prec+1
+		if rightAssoc {
+			l, r = prec+1, prec
+		}
+		return group(lhs, l) + " " + op + " " + group(rhs, r)
+	}
+	switch e := unwrap(e).(type) {
+	case *Equals:
+		if e.LHS == nil {
+			return text(e.RHS)
+		}
+		return group(e.LHS, precCompare) + " = " + group(e.RHS, precCompare), precEquals
+	case *Plus:
+		return pair(e.LHS, "+", e.RHS, precSum, false), precSum
+	case *Subtract:
+		return pair(e.RHS, "-", e.LHS, precSum, false), precSum
+	case *Multiply:
+		return pair(e.LHS, "*", e.RHS, precProduct, false), precProduct
+	case *Divide:
+		return pair(e.RHS, "/", e.LHS, precProduct, false), precProduct
+	case *Modulus:
+		return pair(e.LHS, "%", e.RHS, precProduct, false), precProduct
+	case *Power:
+		return pair(e.LHS, "^", e.RHS, precPower, true), precPower
+	case *Compare:
+		return pair(e.LHS, e.Op, e.RHS, precCompare, false), precCompare
+	case *Negate:
+		if c, ok := unwrap(e.Expr).(*Const); ok {
+			return "-(" + c.String() + ")", precUnary
+		}
+		return "-" + group(e.Expr, precUnary), precUnary
+	case *SingleFunction:
+		return e.Name + "(" + Text(e.Expr) + ")", precAtom
+	case *DoubleFunction:
+		if e.Infix {
+			return pair(e.Expr1, e.Name, e.Expr2, precInfix, true), precInfix
+		}
+		return e.Name + "(" + Text(e.Expr1) + ", " + Text(e.Expr2) + ")", precAtom
+	case *TripleFunction:
+		return e.Name + "(" + Text(e.Expr1) + ", " + Text(e.Expr2) + ", " + Text(e.Expr3) + ")", precAtom
+	default:
+		return e.String(), precedence(e)
+	}
+}
+
+// A notation lays out the pieces of a formula for render.
+type notation interface {
+	number(v float64) string
+	variable(name string) string
+	// binary joins two operands with one of "=", "+", "-", "*", "mod" or
+	// the Comparisons.
+	binary(lhs, op, rhs string) string
+	negate(s string) string
+	paren(s string) string
+	fraction(num, den string) string
+	power(base, exp string) string
+	call(name string, args ...string) string
+}
+
+// render lays out e in n. The precedences follow the usual conventions, so
+// unary minus groups like subtraction and fractions like powers.
+func render(n notation, e Expression) (string, int) {
+	group := func(e Expression, min int) string {
+		s, p := render(n, e)
+		if p < min {
+			return n.paren(s)
+		}
+		return s
+	}
+	switch e := unwrap(e).(type) {
+	case *Equals:
+		if e.LHS == nil {
+			return render(n, e.RHS)
+		}
+		return n.binary(group(e.LHS, precCompare), "=", group(e.RHS, precCompare)), precEquals
+	case *Var:
+		return n.variable(e.Var), precAtom
+	case *Const:
+		if math.Signbit(e.Value) && !math.IsNaN(e.Value) {
+			return n.number(e.Value), precSum
+		}
+		return n.number(e.Value), precAtom
+	case *Plus:
+		return n.binary(group(e.LHS, precSum), "+", group(e.RHS, precProduct)), precSum
+	case *Subtract:
+		return n.binary(group(e.RHS, precSum), "-", group(e.LHS, precProduct)), precSum
+	case *Multiply:
+		return n.binary(group(e.LHS, precProduct), "*", group(e.RHS, precPower)), precProduct
+	case *Modulus:
+		return n.binary(group(e.LHS, precProduct), "mod", group(e.RHS, precPower)), precProduct
+	case *Divide:
+		return n.fraction(group(e.RHS, precEquals), group(e.LHS, precEquals)), precPower
+	case *Power:
+		return n.power(group(e.LHS, precAtom), group(e.RHS, precEquals)), precPower
+	case *Compare:
+		return n.binary(group(e.LHS, precCompare), e.Op, group(e.RHS, precSum)), precCompare
+	case *Negate:
+		return n.negate(group(e.Expr, precProduct)), precSum
+	case *SingleFunction:
+		return n.call(e.Name, group(e.Expr, precEquals)), precAtom
+	case *DoubleFunction:
+		return n.call(e.Name, group(e.Expr1, precEquals), group(e.Expr2, precEquals)), precAtom
+	case *TripleFunction:
+		return n.call(e.Name, group(e.Expr1, precEquals), group(e.Expr2, precEquals), group(e.Expr3, precEquals)), precAtom
+	default:
+		return n.variable(e.String()), precEquals
+	}
+}
+
+// numberParts splits the magnitude of v into the mantissa and decimal
+// exponent formatConst would print. The exponent is "" when there is none.
+func numberParts(v float64) (negative bool, mantissa, exponent string) {
+	negative = math.Signbit(v) && !math.IsNaN(v)
+	mantissa, exponent, _ = strings.Cut(formatConst(math.Abs(v)), "e")
+	if exponent != "" {
+		n, _ := strconv.Atoi(exponent)
+		exponent = strconv.Itoa(n)
+	}
+	return
+}
+
+// LaTeX prints e for a LaTeX math environment.
+func LaTeX(e Expression) string {
+	s, _ := render(latex{}, e)
+	return s
+}
+
+type latex struct{}
+
+// latexCommands are the functions LaTeX has a command for, by upper case
+// name.
+var latexCommands = map[string]string{
+	"SIN": `\sin`, "COS": `\cos`, "TAN": `\tan`, "SINH": `\sinh`,
+	"COSH": `\cosh`, "TANH": `\tanh`, "ASIN": `\arcsin`, "ACOS": `\arccos`,
+	"ATAN": `\arctan`, "EXP": `\exp`, "LOG": `\ln`, "LOG10": `\log_{10}`,
+	"LOG2": `\log_{2}`, "MAX": `\max`, "MIN": `\min`,
+}
+
+func (latex) number(v float64) string {
+	negative, mantissa, exponent := numberParts(v)
+	switch mantissa {
+	case "Inf":
+		mantissa = `\infty`
+	case "NaN":
+		mantissa = `\mathrm{NaN}`
+	}
+	if exponent != "" {
+		mantissa += ` \times 10^{` + exponent + `}`
+	}
+	if negative {
+		return "-" + mantissa
+	}
+	return mantissa
+}
+
+func (latex) variable(name string) string {
+	if len(name) == 1 {
+		return name
+	}
+	return `\mathrm{` + latexEscape(name) + `}`
+}
+
+func (latex) binary(lhs, op, rhs string) string {
+	switch op {
+	case "*":
+		op = `\cdot`
+	case "mod":
+		op = `\bmod`
+	case "<=":
+		op = `\le`
+	case ">=":
+		op = `\ge`
+	case "==":
+		op = "="
+	}
+	return lhs + " " + op + " " + rhs
+}
+
+func (latex) negate(s string) string {
+	return "-" + s
+}
+
+func (latex) paren(s string) string {
+	return `\left(` + s + `\right)`
+}
+
+func (latex) fraction(num, den string) string {
+	return `\frac{` + num + `}{` + den + `}`
+}
+
+func (latex) power(base, exp string) string {
+	return base + `^{` + exp + `}`
+}
+
+func (l latex) call(name string, args ...string) string {
+	if len(args) == 1 {
+		switch strings.ToUpper(name) {
+		case "SQRT":
+			return `\sqrt{` + args[0] + `}`
+		case "CBRT":
+			return `\sqrt[3]{` + args[0] + `}`
+		case "ABS":
+			return `\left|` + args[0] + `\right|`
+		case "FLOOR":
+			return `\left\lfloor ` + args[0] + `\right\rfloor`
+		case "CEIL":
+			return `\left\lceil ` + args[0] + `\right\rceil`
+		}
+	}
+	command, ok := latexCommands[strings.ToUpper(name)]
+	if !ok {
+		command = `\operatorname{` + latexEscape(name) + `}`
+	}
+	return command + l.paren(strings.Join(args, ", "))
+}
+
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`, `{`, `\{`, `}`, `\}`, `_`, `\_`, `^`, `\^{}`,
+	`#`, `\#`, `$`, `\$`, `%`, `\%`, `&`, `\&`, `~`, `\~{}`,
+)
+
+func latexEscape(s string) string {
+	return latexEscaper.Replace(s)
+}
+
+// MathML prints e as a presentation MathML <math> element.
+func MathML(e Expression) string {
+	s, _ := render(mathML{}, e)
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML">` + s + `</math>`
+}
+
+type mathML struct{}
+
+// mathMLNames are the conventional names of functions, by upper case name.
+var mathMLNames = map[string]string{
+	"SIN": "sin", "COS": "cos", "TAN": "tan", "SINH": "sinh", "COSH": "cosh",
+	"TANH": "tanh", "ASIN": "arcsin", "ACOS": "arccos", "ATAN": "arctan",
+	"EXP": "exp", "LOG": "ln", "MAX": "max", "MIN": "min",
+}
+
+func (mathML) number(v float64) string {
+	negative, mantissa, exponent := numberParts(v)
+	switch mantissa {
+	case "Inf":
+		mantissa = "<mi>&#x221E;</mi>"
+	case "NaN":
+		mantissa = "<mi>NaN</mi>"
+	default:
+		mantissa = "<mn>" + mantissa + "</mn>"
+	}
+	if exponent != "" {
+		power := "<mn>" + strings.TrimPrefix(exponent, "-") + "</mn>"
+		if strings.HasPrefix(exponent, "-") {
+			power = "<mrow><mo>-</mo>" + power + "</mrow>"
+		}
+		mantissa = "<mrow>" + mantissa + "<mo>&#xD7;</mo><msup><mn>10</mn>" + power + "</msup></mrow>"
+	}
+	if negative {
+		return "<mrow><mo>-</mo>" + mantissa + "</mrow>"
+	}
+	return mantissa
+}
+
+func (mathML) variable(name string) string {
+	return "<mi>" + html.EscapeString(name) + "</mi>"
+}
+
+func (mathML) binary(lhs, op, rhs string) string {
+	switch op {
+	case "*":
+		op = "&#xB7;"
+	case "<":
+		op = "&lt;"
+	case "<=":
+		op = "&#x2264;"
+	case ">":
+		op = "&gt;"
+	case ">=":
+		op = "&#x2265;"
+	case "==":
+		op = "="
+	}
+	return "<mrow>" + lhs + "<mo>" + op + "</mo>" + rhs + "</mrow>"
+}
+
+func (mathML) negate(s string) string {
+	return "<mrow><mo>-</mo>" + s + "</mrow>"
+}
+
+func (mathML) paren(s string) string {
+	return "<mrow><mo>(</mo>" + s + "<mo>)</mo></mrow>"
+}
+
+func (mathML) fraction(num, den string) string {
+	return "<mfrac>" + num + den + "</mfrac>"
+}
+
+func (mathML) power(base, exp string) string {
+	return "<msup>" + base + exp + "</msup>"
+}
+
+func (m mathML) call(name string, args ...string) string {
+	if len(args) == 1 {
+		switch strings.ToUpper(name) {
+		case "SQRT":
+			return "<msqrt>" + args[0] + "</msqrt>"
+		case "CBRT":
+			return "<mroot>" + args[0] + "<mn>3</mn></mroot>"
+		case "ABS":
+			return "<mrow><mo>|</mo>" + args[0] + "<mo>|</mo></mrow>"
+		case "FLOOR":
+			return "<mrow><mo>&#x230A;</mo>" + args[0] + "<mo>&#x230B;</mo></mrow>"
+		case "CEIL":
+			return "<mrow><mo>&#x2308;</mo>" + args[0] + "<mo>&#x2309;</mo></mrow>"
+		}
+	}
+	var fn string
+	switch upper := strings.ToUpper(name); upper {
+	case "LOG10", "LOG2":
+		fn = "<msub><mi>log</mi><mn>" + strings.TrimPrefix(upper, "LOG") + "</mn></msub>"
+	default:
+		if conventional, ok := mathMLNames[upper]; ok {
+			name = conventional
+		}
+		fn = "<mi>" + html.EscapeString(name) + "</mi>"
+	}
+	return "<mrow>" + fn + "<mo>&#x2061;</mo>" + m.paren(strings.Join(args, "<mo>,</mo>")) + "</mrow>"
+}
@@ -0,0 +1,264 @@
+package image_formula_find
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	for i := 0; i < 3000; i++ {
+		f := randomFunction(r, 6)
+		s := f.Text()
+		if strings.Count(s, "(") > strings.Count(f.String(), "(") {
+			t.Fatalf("Text %q has more parentheses than %q", s, f)
+		}
+		g, err := ParseFunction(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !equal(pointerTree(t, f.Equals.RHS), g.Equals.RHS) ||
+			f.Equals.LHS != nil && !equal(pointerTree(t, f.Equals.LHS), g.Equals.LHS) {
+			t.Fatalf("Text %q of %q parsed into a different tree", s, f)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	x, y := &Var{Var: "X"}, &Var{Var: "Y"}
+	tests := []struct {
+		expr     Expression
+		expected string
+	}{
+		{&Brackets{Expr: &Multiply{LHS: x, RHS: &Brackets{Expr: &Power{LHS: x, RHS: y}}}}, "X * X ^ Y"},
+		{&Multiply{LHS: &Brackets{Expr: &Plus{LHS: x, RHS: y}}, RHS: y}, "(X + Y) * Y"},
+		{&Negate{Expr: &Brackets{Expr: &Const{Value: 2}}}, "-(2)"},
+		{Equals{LHS: x, RHS: Brackets{Expr: NewSingleFunction("Sin", &Brackets{Expr: y})}}, "X = Sin(Y)"},
+		{&Divide{LHS: &Const{Value: 2}, RHS: &Plus{LHS: x, RHS: y}}, "(X + Y) / 2"},
+		{&Subtract{LHS: &Brackets{Expr: &Plus{LHS: x, RHS: y}}, RHS: x}, "X - (X + Y)"},
+	}
+	for _, tt := range tests {
+		if got := Text(tt.expr); got != tt.expected {
+			t.Errorf("Got %q want %q", got, tt.expected)
+		}
+	}
+	if (Function{}).Text() != "" {
+		t.Error("Expected empty text for empty formula")
+	}
+}
+
+func TestLaTeX(t *testing.T) {
+	x, y := &Var{Var: "X"}, &Var{Var: "Y"}
+	tests := []struct {
+		expr     Expression
+		expected string
+	}{
+		{&Divide{LHS: &Const{Value: 2}, RHS: &Plus{LHS: x, RHS: y}}, `\frac{X + Y}{2}`},
+		{&Power{LHS: &Plus{LHS: x, RHS: y}, RHS: &Multiply{LHS: x, RHS: y}}, `\left(X + Y\right)^{X \cdot Y}`},
+		{&Power{LHS: &Divide{LHS: y, RHS: x}, RHS: &Const{Value: 2}}, `\left(\frac{X}{Y}\right)^{2}`},
+		{&Multiply{LHS: NewSingleFunction("Sin", x), RHS: NewSingleFunction("Erfinv", y)}, `\sin\left(X\right) \cdot \operatorname{Erfinv}\left(Y\right)`},
+		{NewSingleFunction("Sqrt", &Brackets{Expr: x}), `\sqrt{X}`},
+		{NewDoubleFunction("Atan2", x, y, true), `\operatorname{Atan2}\left(X, Y\right)`},
+		{&Plus{LHS: x, RHS: &Negate{Expr: y}}, `X + \left(-Y\right)`},
+		{&Negate{Expr: &Multiply{LHS: x, RHS: y}}, `-X \cdot Y`},
+		{&Negate{Expr: &Plus{LHS: x, RHS: y}}, `-\left(X + Y\right)`},
+		{&Modulus{LHS: x, RHS: &Const{Value: 1.5e-7}}, `X \bmod 1.5 \times 10^{-7}`},
+		{&Subtract{LHS: &Var{Var: "x_1"}, RHS: &Const{Value: math.Inf(-1)}}, `-\infty - \mathrm{x\_1}`},
+		{&Subtract{LHS: &Plus{LHS: x, RHS: y}, RHS: x}, `X - \left(X + Y\right)`},
+		{&Equals{LHS: y, RHS: &Power{LHS: x, RHS: &Const{Value: -2}}}, `Y = X^{-2}`},
+	}
+	for _, tt := range tests {
+		if got := LaTeX(tt.expr); got != tt.expected {
+			t.Errorf("Got %s\nwant %s", got, tt.expected)
+		}
+	}
+}
+
+func TestMathML(t *testing.T) {
+	x, y := &Var{Var: "X"}, &Var{Var: "Y"}
+	got := MathML(&Equals{LHS: y, RHS: &Divide{LHS: &Power{LHS: x, RHS: &Const{Value: 2}}, RHS: NewSingleFunction("Log10", x)}})
+	want := `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow><mi>Y</mi><mo>=</mo>` +
+		`<mfrac><mrow><msub><mi>log</mi><mn>10</mn></msub><mo>&#x2061;</mo><mrow><mo>(</mo><mi>X</mi><mo>)</mo></mrow></mrow>` +
+		`<msup><mi>X</mi><mn>2</mn></msup></mfrac></mrow></math>`
+	if got != want {
+		t.Errorf("Got %s\nwant %s", got, want)
+	}
+
+	// Every formula must come out as well formed XML.
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < 1000; i++ {
+		f := randomFunction(r, 5)
+		s := f.MathML()
+		d := xml.NewDecoder(strings.NewReader(s))
+		for {
+			_, err := d.Token()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v\n%s", f, err, s)
+			}
+		}
+	}
+}
+
+// arithmetic returns a random sum, difference, product or quotient of small
+// constants, depth deep.
+func arithmetic(r *rand.Rand, depth int) Expression {
+	if depth == 0 || r.Intn(4) == 0 {
+		return &Const{Value: float64(1 + r.Intn(9))}
+	}
+	lhs, rhs := arithmetic(r, depth-1), arithmetic(r, depth-1)
+	switch r.Intn(4) {
+	case 0:
+		return &Plus{LHS: lhs, RHS: rhs}
+	case 1:
+		return &Subtract{LHS: lhs, RHS: rhs}
+	case 2:
+		return &Multiply{LHS: lhs, RHS: rhs}
+	}
+	return &Divide{LHS: lhs, RHS: rhs}
+}
+
+// latexValue evaluates LaTeX made of numbers, +, -, \cdot, \frac and
+// \left( \right) with the usual precedence.
+func latexValue(t *testing.T, s string) float64 {
+	t.Helper()
+	s = strings.NewReplacer(`\left(`, " ( ", `\right)`, " ) ", "{", " { ", "}", " } ").Replace(s)
+	tokens := strings.Fields(s)
+	next := func() string {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token
+	}
+	expect := func(want string) {
+		if got := next(); got != want {
+			t.Fatalf("Got %q in LaTeX, want %q", got, want)
+		}
+	}
+	var sum func() float64
+	factor := func() float64 {
+		switch token := next(); token {
+		case `\frac`:
+			expect("{")
+			num := sum()
+			expect("}")
+			expect("{")
+			den := sum()
+			expect("}")
+			return num / den
+		case "(":
+			v := sum()
+			expect(")")
+			return v
+		default:
+			v, err := strconv.ParseFloat(token, 64)
+			if err != nil {
+				t.Fatalf("Unexpected %q in LaTeX", token)
+			}
+			return v
+		}
+	}
+	product := func() float64 {
+		v := factor()
+		for len(tokens) > 0 && tokens[0] == `\cdot` {
+			next()
+			v *= factor()
+		}
+		return v
+	}
+	sum = func() float64 {
+		v := product()
+		for len(tokens) > 0 && (tokens[0] == "+" || tokens[0] == "-") {
+			if next() == "+" {
+				v += product()
+			} else {
+				v -= product()
+			}
+		}
+		return v
+	}
+	return sum()
+}
+
+// mathMLNode is an element of presentation MathML.
+type mathMLNode struct {
+	XMLName  xml.Name
+	Text     string       `xml:",chardata"`
+	Children []mathMLNode `xml:",any"`
+}
+
+// mathMLValue evaluates MathML made of numbers, operators between two
+// operands in an mrow, mfrac and bracketed mrows.
+func mathMLValue(t *testing.T, n mathMLNode) float64 {
+	t.Helper()
+	switch n.XMLName.Local {
+	case "math":
+		return mathMLValue(t, n.Children[0])
+	case "mn":
+		v, err := strconv.ParseFloat(n.Text, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	case "mfrac":
+		return mathMLValue(t, n.Children[0]) / mathMLValue(t, n.Children[1])
+	case "mrow":
+		if len(n.Children) != 3 {
+			t.Fatalf("mrow of %d elements", len(n.Children))
+		}
+		if n.Children[0].XMLName.Local == "mo" {
+			return mathMLValue(t, n.Children[1])
+		}
+		lhs, rhs := mathMLValue(t, n.Children[0]), mathMLValue(t, n.Children[2])
+		switch n.Children[1].Text {
+		case "+":
+			return lhs + rhs
+		case "-":
+			return lhs - rhs
+		case "·":
+			return lhs * rhs
+		}
+		t.Fatalf("Unknown operator %q", n.Children[1].Text)
+	}
+	t.Fatalf("Unknown element %s", n.XMLName.Local)
+	return 0
+}
+
+func TestRenderedValue(t *testing.T) {
+	r := rand.New(rand.NewSource(10))
+	for i := 0; i < 500; i++ {
+		e := arithmetic(r, 4)
+		want := e.Evaluate(&State{})
+		if math.IsInf(want, 0) || math.IsNaN(want) {
+			continue
+		}
+		near := func(got float64) bool {
+			return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+		}
+		f, err := ParseFunction(Text(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := f.Evaluate(0, 0, 0); !near(got) {
+			t.Errorf("Text %s of %s is %v, want %v", f, e, got, want)
+		}
+		s := LaTeX(e)
+		if got := latexValue(t, s); !near(got) {
+			t.Errorf("LaTeX %s of %s is %v, want %v", s, e, got, want)
+		}
+		var n mathMLNode
+		if err := xml.Unmarshal([]byte(MathML(e)), &n); err != nil {
+			t.Fatal(err)
+		}
+		if got := mathMLValue(t, n); !near(got) {
+			t.Errorf("MathML of %s is %v, want %v", e, got, want)
+		}
+	}
+}
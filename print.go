@@ -0,0 +1,93 @@
+package image_formula_find
+
+import (
+	"math"
+	"strconv"
+)
+
+// Binding strengths used by String, from loosest to tightest. They mirror the
+// precedence declarations in calc.y, so an operand is only put in parentheses
+// when the parser would otherwise group it differently.
+const (
+	precEquals  = iota
+	precCompare // < <= > >= ==
+	precSum     // + -
+	precProduct // * / %
+	precPower   // ^, right associative
+	precInfix   // x atan2 y, right associative
+	precUnary   // -x
+	precAtom    // constants, variables, calls and brackets
+)
+
+// precedence returns how tightly e binds when printed. Expressions from
+// outside this package are always parenthesised.
+func precedence(e Expression) int {
+	if p, ok := e.(interface{ precedence() int }); ok {
+		return p.precedence()
+	}
+	return precEquals
+}
+
+// operand prints e, wrapped in parentheses if it binds looser than min.
+func operand(e Expression, min int) string {
+	if precedence(e) < min {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// infix prints an infix operator of the given precedence. Left associative
+// operators need a tighter right operand and right associative ones a tighter
+// left operand.
+func infix(lhs Expression, op string, rhs Expression, prec int, rightAssoc bool) string {
+	l, r := prec, prec+1
+	if rightAssoc {
+		l, r = prec+1, prec
+	}
+	return operand(lhs, l) + " " + op + " " + operand(rhs, r)
+}
+
+// formatConst prints the shortest text that parses back to exactly v. The
+// lexer reads a leading minus as part of a number wherever an operand is
+// expected, so negative constants need no parentheses.
+func formatConst(v float64) string {
+	if math.IsInf(v, 1) {
+		return "Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (v Equals) precedence() int { return precEquals }
+
+func (v Var) precedence() int { return precAtom }
+
+func (v Const) precedence() int { return precAtom }
+
+func (v Plus) precedence() int { return precSum }
+
+func (v Subtract) precedence() int { return precSum }
+
+func (v Multiply) precedence() int { return precProduct }
+
+func (v Divide) precedence() int { return precProduct }
+
+func (v Modulus) precedence() int { return precProduct }
+
+func (v Power) precedence() int { return precPower }
+
+func (v Negate) precedence() int { return precUnary }
+
+func (v Brackets) precedence() int { return precAtom }
+
+func (v SingleFunction) precedence() int { return precAtom }
+
+func (v DoubleFunction) precedence() int {
+	if v.Infix {
+		return precInfix
+	}
+	return precAtom
+}
+
+func (v Compare) precedence() int { return precCompare }
+
+func (v TripleFunction) precedence() int { return precAtom }
@@ -0,0 +1,133 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// pointerTree rebuilds e from its binary form, so trees built from value
+// nodes compare equal to the parser's pointer nodes.
+func pointerTree(t *testing.T, e Expression) Expression {
+	t.Helper()
+	data, err := MarshalExpressionBinary(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = UnmarshalExpressionBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	points := [][2]float64{{-10, -10}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 5000; i++ {
+		f := randomFunction(r, 6)
+		s := f.String()
+		g, err := ParseFunction(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if g.String() != s {
+			t.Fatalf("Printed %q, parsed back as %q", s, g)
+		}
+		if (f.Equals.LHS == nil) != (g.Equals.LHS == nil) {
+			t.Fatalf("%s: left hand side not kept", s)
+		}
+		if !equal(pointerTree(t, f.Equals.RHS), g.Equals.RHS) ||
+			f.Equals.LHS != nil && !equal(pointerTree(t, f.Equals.LHS), g.Equals.LHS) {
+			t.Fatalf("%s: parsed into a different tree", s)
+		}
+		for _, p := range points {
+			want, _, _ := f.Evaluate(p[0], p[1], 1)
+			got, _, _ := g.Evaluate(p[0], p[1], 1)
+			if !sameFloat(got, want) {
+				t.Fatalf("%s at %v: got %v want %v", s, p, got, want)
+			}
+		}
+	}
+}
+
+func TestStringPrecedence(t *testing.T) {
+	x, y, z := &Var{Var: "X"}, &Var{Var: "Y"}, &Var{Var: "T"}
+	tests := []struct {
+		expr     Expression
+		expected string
+	}{
+		{&Multiply{LHS: &Plus{LHS: x, RHS: y}, RHS: z}, "(X + Y) * T"},
+		{&Plus{LHS: &Multiply{LHS: x, RHS: y}, RHS: z}, "X * Y + T"},
+		{&Subtract{LHS: &Subtract{LHS: z, RHS: y}, RHS: x}, "X - (Y - T)"},
+		{&Subtract{LHS: z, RHS: &Subtract{LHS: y, RHS: x}}, "X - Y - T"},
+		{&Divide{LHS: &Multiply{LHS: y, RHS: z}, RHS: x}, "X / (Y * T)"},
+		{&Power{LHS: &Power{LHS: x, RHS: y}, RHS: z}, "(X ^ Y) ^ T"},
+		{&Power{LHS: x, RHS: &Power{LHS: y, RHS: z}}, "X ^ Y ^ T"},
+		{&Multiply{LHS: x, RHS: &Power{LHS: y, RHS: z}}, "X * Y ^ T"},
+		{&Power{LHS: &Multiply{LHS: x, RHS: y}, RHS: z}, "(X * Y) ^ T"},
+		{&Negate{Expr: &Plus{LHS: x, RHS: y}}, "-(X + Y)"},
+		{&Negate{Expr: &Const{Value: 2}}, "-(2)"},
+		{&Negate{Expr: &Negate{Expr: x}}, "--X"},
+		{&Power{LHS: &Negate{Expr: x}, RHS: y}, "-X ^ Y"},
+		{&Subtract{LHS: &Const{Value: -2}, RHS: x}, "X - -2"},
+		{NewDoubleFunction("Atan2", &Plus{LHS: x, RHS: y}, z, true), "(X + Y) Atan2 T"},
+		{NewDoubleFunction("Atan2", NewDoubleFunction("Max", x, y, true), z, true), "(X Max Y) Atan2 T"},
+		{NewDoubleFunction("Atan2", x, NewDoubleFunction("Max", y, z, true), true), "X Atan2 Y Max T"},
+		{&Multiply{LHS: NewDoubleFunction("Mod", x, y, true), RHS: z}, "X Mod Y * T"},
+		{&Negate{Expr: NewDoubleFunction("Mod", x, y, true)}, "-(X Mod Y)"},
+		{&Equals{RHS: &Plus{LHS: x, RHS: y}}, "X + Y"},
+		{&Plus{LHS: &Const{Value: math.Inf(1)}, RHS: &Const{Value: math.Inf(-1)}}, "Inf + -Inf"},
+		{&Const{Value: 1e21}, "1e+21"},
+		{&Const{Value: math.Nextafter(0.3, 1)}, "0.30000000000000004"},
+	}
+	for _, tt := range tests {
+		if got := tt.expr.String(); got != tt.expected {
+			t.Errorf("Got %q want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestParseConstants(t *testing.T) {
+	tests := []struct {
+		formula  string
+		expected float64
+	}{
+		{"0 = 1e-3", 1e-3},
+		{"0 = 2.5E+2", 250},
+		{"0 = -2 ^ 2", 4},
+		{"0 = 2 ^ -1", 0.5},
+		{"0 = 3 - -1", 4},
+		{"0 = 3-1", 2},
+		{"0 = - 2 ^ 2", 4},
+		{"0 = -(2 ^ 2)", -4},
+		{"0 = 2 ^ 3 ^ 2", 512},
+		{"0 = 2 * 3 ^ 2", 18},
+		{"0 = -Inf", math.Inf(-1)},
+		{"0 = Inf(1)", math.Inf(1)},
+		{"0 = Inf (-1)", math.Inf(-1)},
+		{"0 = NaN", math.NaN()},
+		{"4", 4},
+		{"0 = .5", 0.5},
+		{"0 = -.5e1", -5},
+		{"0 = 1.5e-07", 1.5e-7},
+		{"0 = pi", math.Pi},
+		{"0 = -π", -math.Pi},
+		{"0 = TAU", 2 * math.Pi},
+		{"0 = e ^ 2", math.Pow(math.E, 2)},
+		{"0 = Phi", math.Phi},
+		{"0 = 2 × 3", 6},
+		{"0 = √4 ^ 2", 4},
+		{"0 = √(2 + 7)", 3},
+	}
+	for _, tt := range tests {
+		f, err := ParseFunction(tt.formula)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tt.formula, err)
+			continue
+		}
+		if got, _, _ := f.Evaluate(0, 0, 0); !sameFloat(got, tt.expected) {
+			t.Errorf("%q: got %v want %v", tt.formula, got, tt.expected)
+		}
+	}
+}
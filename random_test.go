@@ -0,0 +1,84 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// randomExpression builds a random tree covering every node type, mixing
+// pointer and value nodes the way the dna packages do.
+func randomExpression(r *rand.Rand, depth int) Expression {
+	if depth <= 0 || r.Intn(4) == 0 {
+		switch r.Intn(5) {
+		case 0:
+			return &Var{Var: "X"}
+		case 1:
+			return Var{Var: "y"}
+		case 2:
+			return &Var{Var: "T"}
+		case 3:
+			return &Var{Var: []string{"R", "a", "U", "v", "PX", "py", "scale"}[r.Intn(7)]}
+		default:
+			return &Const{Value: math.Round(r.NormFloat64()*1000) / 100}
+		}
+	}
+	lhs := func() Expression { return randomExpression(r, depth-1) }
+	switch r.Intn(14) {
+	case 0:
+		return &Plus{LHS: lhs(), RHS: lhs()}
+	case 1:
+		return Subtract{LHS: lhs(), RHS: lhs()}
+	case 2:
+		return &Multiply{LHS: lhs(), RHS: lhs()}
+	case 3:
+		return &Divide{LHS: lhs(), RHS: lhs()}
+	case 4:
+		return &Power{LHS: lhs(), RHS: lhs()}
+	case 5:
+		return Modulus{LHS: lhs(), RHS: lhs()}
+	case 6:
+		return &Negate{Expr: lhs()}
+	case 7:
+		return &Brackets{Expr: lhs()}
+	case 8, 9:
+		return NewSingleFunction(randomName(r, 1), lhs())
+	case 10:
+		return &Compare{Op: Comparisons[r.Intn(len(Comparisons))], LHS: lhs(), RHS: lhs()}
+	case 11:
+		return NewTripleFunction(randomName(r, 3), lhs(), lhs(), lhs())
+	default:
+		return NewDoubleFunction(randomName(r, 2), lhs(), lhs(), r.Intn(2) == 0)
+	}
+}
+
+// randomName picks a function of the arity from the sorted names so a seeded
+// test is repeatable. Jn and Yn are left out as their cost grows with the
+// order argument, and Copysign as it copies the sign of NaN, which compiling
+// does not keep.
+func randomName(r *rand.Rand, arity int) string {
+	names := make([]string, 0, len(FunctionNames))
+	for _, name := range FunctionNames {
+		if name != "Jn" && name != "Yn" && name != "Copysign" && lookupArity(name, arity) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names[r.Intn(len(names))]
+}
+
+func randomFunction(r *rand.Rand, depth int) *Function {
+	f := &Function{Equals: &Equals{RHS: randomExpression(r, depth)}}
+	if r.Intn(2) == 0 {
+		f.Equals.LHS = randomExpression(r, depth)
+	}
+	return f
+}
+
+// sameFloat reports whether a and b are identical, treating all NaNs as equal.
+func sameFloat(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Float64bits(a) == math.Float64bits(b)
+}
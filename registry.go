@@ -0,0 +1,362 @@
+package image_formula_find
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FunctionDef describes a function formulas can call by name. Names are case
+// insensitive, Name is the spelling used when a formula is printed.
+type FunctionDef struct {
+	Name string
+	// Arity is 1 for functions of one argument, which set Single, 2 for
+	// functions of two, which set Double, and 3 for functions of three,
+	// which set Triple.
+	Arity  int
+	Single SingleFunctionDef
+	Double DoubleFunctionDef
+	Triple TripleFunctionDef
+	// Domain holds the range each argument must lie in for a result other
+	// than NaN. Nil means every argument is accepted.
+	Domain []Interval
+	// Evolvable functions are worth offering to the evolution. Functions
+	// that truncate their arguments to integers or return a non finite value
+	// for most of them are not.
+	Evolvable bool
+	// Cost is the evaluation cost relative to a multiplication.
+	Cost float64
+	// PiecewiseConstant functions have a zero derivative almost everywhere.
+	PiecewiseConstant bool
+	// Derivative1 returns f'(u) for a function of one argument, Derivative2
+	// the derivative of f(a, b) given the derivatives da and db of its
+	// arguments and Derivative3 likewise for f(a, b, c). Derive fails on
+	// functions without one.
+	Derivative1 func(u Expression) Expression
+	Derivative2 func(a, b, da, db Expression) Expression
+	Derivative3 func(a, b, c, da, db, dc Expression) Expression
+	// Interval1, Interval2 and Interval3 bound the result given bounds on
+	// the arguments. Without one EvaluateInterval assumes nothing about the
+	// result.
+	Interval1 func(u Interval) Interval
+	Interval2 func(a, b Interval) Interval
+	Interval3 func(a, b, c Interval) Interval
+}
+
+var registry = map[string]*FunctionDef{}
+
+// functionName matches the names the lexer reads as a function.
+var functionName = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// reserved reports whether the lexer reads the name as something other than a
+// function: a built in variable, a named constant or the let keyword.
+func reserved(name string) bool {
+	_, constant := namedConstants[strings.ToUpper(name)]
+	return isBuiltinVar(name) || constant || strings.EqualFold(name, "let")
+}
+
+// Register adds a function formulas can call. It is meant to be called at
+// start up, before any formula is parsed or evaluated, as the function tables
+// are not guarded against concurrent use. The name must not already be
+// registered, nor be a variable, named constant or keyword.
+func Register(def FunctionDef) error {
+	key := strings.ToUpper(def.Name)
+	switch {
+	case !functionName.MatchString(def.Name):
+		return fmt.Errorf("invalid function name %q", def.Name)
+	case reserved(def.Name):
+		return fmt.Errorf("function name %q is reserved", def.Name)
+	case def.Arity == 1 && def.Single == nil, def.Arity == 2 && def.Double == nil, def.Arity == 3 && def.Triple == nil:
+		return fmt.Errorf("function %s has no implementation", def.Name)
+	case def.Arity < 1 || def.Arity > 3:
+		return fmt.Errorf("function %s has arity %d, only 1 to 3 are supported", def.Name, def.Arity)
+	case def.Domain != nil && len(def.Domain) != def.Arity:
+		return fmt.Errorf("function %s has %d domains for %d arguments", def.Name, len(def.Domain), def.Arity)
+	}
+	if _, ok := registry[key]; ok {
+		return fmt.Errorf("function %s is already registered", def.Name)
+	}
+	if def.Cost == 0 {
+		def.Cost = 1
+	}
+	registry[key] = &def
+	switch def.Arity {
+	case 1:
+		SingleFunctions[key] = def.Single
+	case 2:
+		DoubleFunctions[key] = def.Double
+	default:
+		TripleFunctions[key] = def.Triple
+	}
+	FunctionNames = FunctionNames[:0]
+	for _, each := range Functions() {
+		FunctionNames = append(FunctionNames, each.Name)
+	}
+	return nil
+}
+
+// LookupFunction returns the registered function with the name.
+func LookupFunction(name string) (*FunctionDef, bool) {
+	def, ok := registry[strings.ToUpper(name)]
+	return def, ok
+}
+
+// lookupArity returns the registered function with the name and arity, or nil.
+func lookupArity(name string, arity int) *FunctionDef {
+	if def, ok := registry[strings.ToUpper(name)]; ok && def.Arity == arity {
+		return def
+	}
+	return nil
+}
+
+// Functions returns every registered function sorted by name.
+func Functions() []*FunctionDef {
+	defs := make([]*FunctionDef, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return strings.ToUpper(defs[i].Name) < strings.ToUpper(defs[j].Name)
+	})
+	return defs
+}
+
+// FunctionSet is the selection of functions an encoding draws from, split by
+// arity so that a gene for a function of two arguments always names one.
+type FunctionSet struct {
+	Single []*FunctionDef
+	Double []*FunctionDef
+	Triple []*FunctionDef
+	// Table is the header of a numbered function table, such as "v1", and
+	// empty for other sets.
+	Table string
+}
+
+// ErrNoFunctions is returned for an empty function set.
+var ErrNoFunctions = errors.New("no functions in set")
+
+// NewFunctionSet returns the set of the named registered functions, in the
+// order given.
+func NewFunctionSet(names ...string) (*FunctionSet, error) {
+	s := &FunctionSet{}
+	for _, name := range names {
+		def, ok := LookupFunction(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", name)
+		}
+		s.add(def)
+	}
+	if len(s.Single)+len(s.Double)+len(s.Triple) == 0 {
+		return nil, ErrNoFunctions
+	}
+	return s, nil
+}
+
+// ParseFunctionSet returns the set for a comma separated list of function
+// names. The words "evolvable" and "all" stand for EvolvableFunctions and
+// AllFunctions and a table header such as "v1" for that function table.
+func ParseFunctionSet(list string) (*FunctionSet, error) {
+	list = strings.TrimSpace(list)
+	switch strings.ToLower(list) {
+	case "evolvable", "":
+		return EvolvableFunctions(), nil
+	case "all":
+		return AllFunctions(), nil
+	}
+	if v, ok := tableVersion(list); ok {
+		return FunctionTable(v)
+	}
+	return NewFunctionSet(strings.Split(list, ",")...)
+}
+
+// AllFunctions returns the set of every registered function, sorted by name.
+func AllFunctions() *FunctionSet {
+	return functionSet(func(*FunctionDef) bool { return true })
+}
+
+// EvolvableFunctions returns the set of the registered functions marked
+// Evolvable, sorted by name.
+func EvolvableFunctions() *FunctionSet {
+	return functionSet(func(def *FunctionDef) bool { return def.Evolvable })
+}
+
+func functionSet(keep func(*FunctionDef) bool) *FunctionSet {
+	s := &FunctionSet{}
+	for _, def := range Functions() {
+		if keep(def) {
+			s.add(def)
+		}
+	}
+	return s
+}
+
+// add appends a function to the list for its arity.
+func (s *FunctionSet) add(def *FunctionDef) {
+	switch def.Arity {
+	case 1:
+		s.Single = append(s.Single, def)
+	case 2:
+		s.Double = append(s.Double, def)
+	default:
+		s.Triple = append(s.Triple, def)
+	}
+}
+
+// SingleName returns the name of the i-th single argument function, wrapping
+// around, or "" when the set has none.
+func (s *FunctionSet) SingleName(i int) string {
+	if len(s.Single) == 0 {
+		return ""
+	}
+	return s.Single[i%len(s.Single)].Name
+}
+
+// DoubleName returns the name of the i-th two argument function, wrapping
+// around, or "" when the set has none.
+func (s *FunctionSet) DoubleName(i int) string {
+	if len(s.Double) == 0 {
+		return ""
+	}
+	return s.Double[i%len(s.Double)].Name
+}
+
+// TripleName returns the name of the i-th three argument function, wrapping
+// around, or "" when the set has none.
+func (s *FunctionSet) TripleName(i int) string {
+	if len(s.Triple) == 0 {
+		return ""
+	}
+	return s.Triple[i%len(s.Triple)].Name
+}
+
+// Names returns the names of the functions in the set by arity, single
+// argument functions first.
+func (s *FunctionSet) Names() []string {
+	names := make([]string, 0, len(s.Single)+len(s.Double)+len(s.Triple))
+	seen := map[string]bool{}
+	for _, def := range append(append(append([]*FunctionDef{}, s.Single...), s.Double...), s.Triple...) {
+		if !seen[def.Name] {
+			seen[def.Name] = true
+			names = append(names, def.Name)
+		}
+	}
+	return names
+}
+
+// String returns the set as ParseFunctionSet reads it back: the table header
+// for a function table and the names of the functions otherwise.
+func (s *FunctionSet) String() string {
+	if s.Table != "" {
+		return s.Table
+	}
+	return strings.Join(s.Names(), ",")
+}
+
+// Relative costs of the built in functions.
+const (
+	costCheap     = 1
+	costRoot      = 4
+	costLibrary   = 20
+	costExpensive = 60
+)
+
+var (
+	unitRange = []Interval{Span(-1, 1)}
+	positive  = []Interval{Span(0, math.Inf(1))}
+)
+
+// builtins are the functions of the math package and the shaping and noise
+// functions formulas can call.
+var builtins = []FunctionDef{
+	{Name: "Abs", Arity: 1, Single: math.Abs, Cost: costCheap, Evolvable: true},
+	{Name: "Acos", Arity: 1, Single: math.Acos, Cost: costLibrary, Evolvable: true, Domain: unitRange},
+	{Name: "Acosh", Arity: 1, Single: math.Acosh, Cost: costLibrary, Evolvable: true, Domain: []Interval{Span(1, math.Inf(1))}},
+	{Name: "Asin", Arity: 1, Single: math.Asin, Cost: costLibrary, Evolvable: true, Domain: unitRange},
+	{Name: "Asinh", Arity: 1, Single: math.Asinh, Cost: costLibrary, Evolvable: true},
+	{Name: "Atan", Arity: 1, Single: math.Atan, Cost: costLibrary, Evolvable: true},
+	{Name: "Atan2", Arity: 2, Double: math.Atan2, Cost: costLibrary, Evolvable: true},
+	{Name: "Atanh", Arity: 1, Single: math.Atanh, Cost: costLibrary, Evolvable: true, Domain: unitRange},
+	{Name: "Cbrt", Arity: 1, Single: math.Cbrt, Cost: costRoot, Evolvable: true},
+	{Name: "Ceil", Arity: 1, Single: math.Ceil, Cost: costCheap, Evolvable: true},
+	{Name: "Clamp", Arity: 3, Triple: clamp, Cost: costCheap, Evolvable: true},
+	{Name: "Copysign", Arity: 2, Double: math.Copysign, Cost: costCheap, Evolvable: true},
+	{Name: "Cos", Arity: 1, Single: math.Cos, Cost: costLibrary, Evolvable: true},
+	{Name: "Cosh", Arity: 1, Single: math.Cosh, Cost: costLibrary, Evolvable: true},
+	{Name: "Dim", Arity: 2, Double: math.Dim, Cost: costCheap, Evolvable: true},
+	{Name: "Erf", Arity: 1, Single: math.Erf, Cost: costLibrary, Evolvable: true},
+	{Name: "Erfc", Arity: 1, Single: math.Erfc, Cost: costLibrary, Evolvable: true},
+	{Name: "Erfcinv", Arity: 1, Single: math.Erfcinv, Cost: costExpensive, Evolvable: true, Domain: []Interval{Span(0, 2)}},
+	{Name: "Erfinv", Arity: 1, Single: math.Erfinv, Cost: costExpensive, Evolvable: true, Domain: unitRange},
+	{Name: "Exp", Arity: 1, Single: math.Exp, Cost: costLibrary, Evolvable: true},
+	{Name: "Exp2", Arity: 1, Single: math.Exp2, Cost: costLibrary, Evolvable: true},
+	{Name: "Expm1", Arity: 1, Single: math.Expm1, Cost: costLibrary, Evolvable: true},
+	{Name: "Fbm", Arity: 3, Triple: fbm, Cost: 4 * costExpensive, Evolvable: true},
+	{Name: "Floor", Arity: 1, Single: math.Floor, Cost: costCheap, Evolvable: true},
+	{Name: "Gamma", Arity: 1, Single: math.Gamma, Cost: costExpensive, Evolvable: true},
+	{Name: "Hypot", Arity: 2, Double: math.Hypot, Cost: costRoot, Evolvable: true},
+	{Name: "If", Arity: 3, Triple: ifElse, Cost: costCheap, Evolvable: true},
+	{Name: "Ilogb", Arity: 1, Single: func(a float64) float64 { return float64(math.Ilogb(a)) }, Cost: costCheap},
+	{Name: "Inf", Arity: 1, Single: func(a float64) float64 { return math.Inf(int(a)) }, Cost: costCheap},
+	{Name: "J0", Arity: 1, Single: math.J0, Cost: costExpensive, Evolvable: true},
+	{Name: "J1", Arity: 1, Single: math.J1, Cost: costExpensive, Evolvable: true},
+	{Name: "Jn", Arity: 2, Double: func(a, b float64) float64 { return math.Jn(int(a), b) }, Cost: costExpensive},
+	{Name: "Ldexp", Arity: 2, Double: func(a, b float64) float64 { return math.Ldexp(a, int(b)) }, Cost: costCheap},
+	{Name: "Lerp", Arity: 3, Triple: lerp, Cost: costCheap, Evolvable: true},
+	{Name: "Log", Arity: 1, Single: math.Log, Cost: costLibrary, Evolvable: true, Domain: positive},
+	{Name: "Log10", Arity: 1, Single: math.Log10, Cost: costLibrary, Evolvable: true, Domain: positive},
+	{Name: "Log1p", Arity: 1, Single: math.Log1p, Cost: costLibrary, Evolvable: true, Domain: []Interval{Span(-1, math.Inf(1))}},
+	{Name: "Log2", Arity: 1, Single: math.Log2, Cost: costLibrary, Evolvable: true, Domain: positive},
+	{Name: "Logb", Arity: 1, Single: math.Logb, Cost: costCheap, Evolvable: true},
+	{Name: "Max", Arity: 2, Double: math.Max, Cost: costCheap, Evolvable: true},
+	{Name: "Min", Arity: 2, Double: math.Min, Cost: costCheap, Evolvable: true},
+	{Name: "Mod", Arity: 2, Double: math.Mod, Cost: costRoot, Evolvable: true},
+	{Name: "Nextafter", Arity: 2, Double: math.Nextafter, Cost: costCheap},
+	{Name: "Perlin", Arity: 2, Double: perlin, Cost: costExpensive, Evolvable: true},
+	{Name: "Perlin3", Arity: 3, Triple: perlin3, Cost: 2 * costExpensive, Evolvable: true},
+	{Name: "Pow", Arity: 2, Double: math.Pow, Cost: costLibrary, Evolvable: true},
+	{Name: "Pow10", Arity: 1, Single: func(a float64) float64 { return math.Pow10(int(a)) }, Cost: costCheap},
+	{Name: "Remainder", Arity: 2, Double: math.Remainder, Cost: costRoot, Evolvable: true},
+	{Name: "Round", Arity: 1, Single: math.Round, Cost: costCheap, Evolvable: true},
+	{Name: "RoundToEven", Arity: 1, Single: math.RoundToEven, Cost: costCheap, Evolvable: true},
+	{Name: "Simplex", Arity: 2, Double: simplex, Cost: costExpensive, Evolvable: true},
+	{Name: "Simplex3", Arity: 3, Triple: simplex3, Cost: 2 * costExpensive, Evolvable: true},
+	{Name: "Sin", Arity: 1, Single: math.Sin, Cost: costLibrary, Evolvable: true},
+	{Name: "Sinh", Arity: 1, Single: math.Sinh, Cost: costLibrary, Evolvable: true},
+	{Name: "Smoothstep", Arity: 3, Triple: smoothstep, Cost: costRoot, Evolvable: true},
+	{Name: "Sqrt", Arity: 1, Single: math.Sqrt, Cost: costRoot, Evolvable: true, Domain: positive},
+	{Name: "Step", Arity: 2, Double: heaviside, Cost: costCheap, Evolvable: true},
+	{Name: "Tan", Arity: 1, Single: math.Tan, Cost: costLibrary, Evolvable: true},
+	{Name: "Tanh", Arity: 1, Single: math.Tanh, Cost: costLibrary, Evolvable: true},
+	{Name: "Trunc", Arity: 1, Single: math.Trunc, Cost: costCheap, Evolvable: true},
+	{Name: "Turbulence", Arity: 3, Triple: turbulence, Cost: 4 * costExpensive, Evolvable: true},
+	{Name: "ValueNoise", Arity: 2, Double: valueNoise, Cost: costExpensive, Evolvable: true},
+	{Name: "ValueNoise3", Arity: 3, Triple: valueNoise3, Cost: 2 * costExpensive, Evolvable: true},
+	{Name: "Worley", Arity: 2, Double: worley, Cost: 2 * costExpensive, Evolvable: true},
+	{Name: "Worley3", Arity: 3, Triple: worley3, Cost: 4 * costExpensive, Evolvable: true},
+	{Name: "Y0", Arity: 1, Single: math.Y0, Cost: costExpensive, Evolvable: true, Domain: positive},
+	{Name: "Y1", Arity: 1, Single: math.Y1, Cost: costExpensive, Evolvable: true, Domain: positive},
+	{Name: "Yn", Arity: 2, Double: func(a, b float64) float64 { return math.Yn(int(a), b) }, Cost: costExpensive, Domain: []Interval{Unbounded(), Span(0, math.Inf(1))}},
+}
+
+func init() {
+	SingleFunctions = map[string]SingleFunctionDef{}
+	DoubleFunctions = map[string]DoubleFunctionDef{}
+	TripleFunctions = map[string]TripleFunctionDef{}
+	FunctionNames = []string{}
+	for _, def := range builtins {
+		key := strings.ToUpper(def.Name)
+		def.PiecewiseConstant = piecewiseConstant[key]
+		def.Derivative1 = singleDerivatives[key]
+		def.Derivative2 = doubleDerivatives[key]
+		def.Derivative3 = tripleDerivatives[key]
+		def.Interval1 = singleIntervals[key]
+		def.Interval2 = doubleIntervals[key]
+		def.Interval3 = tripleIntervals[key]
+		if err := Register(def); err != nil {
+			panic(err)
+		}
+	}
+}
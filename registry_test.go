@@ -0,0 +1,162 @@
+package image_formula_find
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// unregister removes a function registered by a test so the random formulas
+// of other tests do not pick it up.
+func unregister(name string) {
+	key := strings.ToUpper(name)
+	delete(registry, key)
+	delete(SingleFunctions, key)
+	delete(DoubleFunctions, key)
+	delete(TripleFunctions, key)
+	FunctionNames = FunctionNames[:0]
+	for _, def := range Functions() {
+		FunctionNames = append(FunctionNames, def.Name)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	err := Register(FunctionDef{
+		Name:   "Twice",
+		Arity:  1,
+		Single: func(a float64) float64 { return 2 * a },
+		Derivative1: func(u Expression) Expression {
+			return num(2)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregister("Twice") })
+
+	f, err := ParseFunction("y = twice(x) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := f.Evaluate(3, 0, 0); got != 7 {
+		t.Errorf("Evaluated to %v, want 7", got)
+	}
+	if p := Compile(f); p.Evaluate(3, 0, 0) != 7 {
+		t.Errorf("Compiled to %v, want 7", p.Evaluate(3, 0, 0))
+	}
+	d, err := f.Derive("X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := d.Evaluate(3, 0, 0); got != 2 {
+		t.Errorf("Derivative %s evaluated to %v, want 2", d, got)
+	}
+	if i, _ := f.EvaluateInterval(&IntervalState{X: Span(0, 1)}); i != Unbounded() {
+		t.Errorf("Expected an unbounded interval without a rule, got %+v", i)
+	}
+	def, ok := LookupFunction("TWICE")
+	if !ok || def.Cost != 1 || def.Evolvable {
+		t.Errorf("Unexpected definition %+v", def)
+	}
+
+	for _, bad := range []FunctionDef{
+		{Name: "twice", Arity: 1, Single: math.Abs},
+		{Name: "2x", Arity: 1, Single: math.Abs},
+		{Name: "x", Arity: 1, Single: math.Abs},
+		{Name: "PX", Arity: 1, Single: math.Abs},
+		{Name: "r", Arity: 1, Single: math.Abs},
+		{Name: "Pi", Arity: 1, Single: math.Abs},
+		{Name: "TAU", Arity: 1, Single: math.Abs},
+		{Name: "e", Arity: 2, Double: math.Max},
+		{Name: "Let", Arity: 1, Single: math.Abs},
+		{Name: "Half", Arity: 2, Single: math.Abs},
+		{Name: "Half", Arity: 3, Single: math.Abs},
+		{Name: "Half", Arity: 4, Single: math.Abs},
+		{Name: "Half", Arity: 1, Single: math.Abs, Domain: []Interval{Unbounded(), Unbounded()}},
+	} {
+		if err := Register(bad); err == nil {
+			unregister(bad.Name)
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	if len(FunctionNames) != len(builtins) {
+		t.Fatalf("%d function names for %d built in functions", len(FunctionNames), len(builtins))
+	}
+	for i, def := range Functions() {
+		if FunctionNames[i] != def.Name {
+			t.Errorf("FunctionNames[%d] = %s, want %s", i, FunctionNames[i], def.Name)
+		}
+		if def.Cost <= 0 {
+			t.Errorf("%s: cost %v", def.Name, def.Cost)
+		}
+		if def.Derivative1 == nil && def.Derivative2 == nil && def.Derivative3 == nil && !def.PiecewiseConstant && def.Name != "Gamma" {
+			t.Errorf("%s: no derivative", def.Name)
+		}
+		// Arguments just outside the domain give NaN, the middle of the
+		// domain does not.
+		for arg, domain := range def.Domain {
+			args := []float64{1, 1, 1}
+			for _, outside := range []float64{domain.Lo - 0.5, domain.Hi + 0.5} {
+				if math.IsInf(outside, 0) {
+					continue
+				}
+				args[arg] = outside
+				if v := call2(def, args); !math.IsNaN(v) {
+					t.Errorf("%s%v = %v, want NaN", def.Name, args, v)
+				}
+			}
+			args[arg] = math.Min(math.Max(domain.Lo+0.5, domain.Lo), domain.Hi)
+			if v := call2(def, args); math.IsNaN(v) {
+				t.Errorf("%s%v is NaN", def.Name, args)
+			}
+		}
+	}
+}
+
+func call2(def *FunctionDef, args []float64) float64 {
+	switch def.Arity {
+	case 1:
+		return def.Single(args[0])
+	case 2:
+		return def.Double(args[0], args[1])
+	}
+	return def.Triple(args[0], args[1], args[2])
+}
+
+func TestFunctionSet(t *testing.T) {
+	s, err := ParseFunctionSet("sin, Atan2,cos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.Names(), " "); got != "Sin Cos Atan2" {
+		t.Errorf("Got %q", got)
+	}
+	if s.SingleName(3) != "Cos" || s.DoubleName(5) != "Atan2" {
+		t.Errorf("Unexpected names %s %s", s.SingleName(3), s.DoubleName(5))
+	}
+	if _, err := ParseFunctionSet("sin,nosuch"); err == nil {
+		t.Error("Expected an error for an unknown function")
+	}
+	if _, err := NewFunctionSet(); !errors.Is(err, ErrNoFunctions) {
+		t.Errorf("Expected ErrNoFunctions, got %v", err)
+	}
+	evolvable, err := ParseFunctionSet("evolvable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range evolvable.Names() {
+		if name == "Inf" || name == "Nextafter" {
+			t.Errorf("%s should not be evolvable", name)
+		}
+	}
+	if all := AllFunctions(); len(all.Names()) != len(FunctionNames) {
+		t.Errorf("All functions has %d names, want %d", len(all.Names()), len(FunctionNames))
+	}
+	if s := (&FunctionSet{}); s.SingleName(1) != "" || s.DoubleName(1) != "" {
+		t.Error("Expected no names from an empty set")
+	}
+}
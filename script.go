@@ -0,0 +1,117 @@
+package image_formula_find
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is one line of a script: a let binding naming an expression, or
+// the assignment of an expression to a colour channel.
+type Statement struct {
+	// Let is set for a binding and unset for a channel assignment.
+	Let bool
+	// Name is the bound name or the channel, as written.
+	Name string
+	// Expr is the expression as written, before bindings are substituted.
+	Expr Expression
+}
+
+func (s Statement) String() string {
+	if s.Let {
+		return "let " + s.Name + " = " + s.Expr.String()
+	}
+	return s.Name + " = " + s.Expr.String()
+}
+
+// Script is a small program producing the three colour channels of an image,
+// such as
+//
+//	let a = sin(x * y)
+//	r = a * 255; g = (1 - a) * 128; b = a ^ 2
+//
+// Statements are separated by ";" or new lines and "#" starts a comment
+// running to the end of the line. A let binding names an expression for the
+// statements after it, hiding a variable or parameter of the same name. The
+// channels are r, g and b, or red, green and blue, and each may be assigned
+// once.
+type Script struct {
+	// Statements are the statements as written.
+	Statements []Statement
+	// Red, Green and Blue are the channel formulas with the bindings
+	// substituted, nil for a channel the script does not assign. A binding
+	// used more than once is the same expression in every place, so the
+	// channels share the subexpression.
+	Red, Green, Blue *Function
+	// Lets are the bound expressions, with the earlier bindings substituted,
+	// by upper case name.
+	Lets map[string]Expression
+}
+
+// ParseScript parses and resolves a script. Like ParseFunction it may be
+// called concurrently and reports a *SyntaxError for text that does not
+// parse.
+func ParseScript(text string) (*Script, error) {
+	lex := NewScriptLexer(text).(*CalcLexer)
+	if r := yyParse(lex); r != 0 || lex.err != nil {
+		return nil, fmt.Errorf("invalid script: %w", lex.syntaxError())
+	}
+	s := &Script{Statements: lex.statements, Lets: map[string]Expression{}}
+	for _, each := range s.Statements {
+		e := s.substitute(each.Expr)
+		name := strings.ToUpper(each.Name)
+		if each.Let {
+			s.Lets[name] = e
+			continue
+		}
+		channel := s.channel(name)
+		if channel == nil {
+			return nil, fmt.Errorf("invalid script: unknown channel %s", each.Name)
+		}
+		if *channel != nil {
+			return nil, fmt.Errorf("invalid script: channel %s assigned twice", each.Name)
+		}
+		*channel = &Function{Equals: &Equals{RHS: e}}
+	}
+	return s, nil
+}
+
+// substitute replaces the variables bound so far by their expressions.
+func (s *Script) substitute(e Expression) Expression {
+	return Rewrite(e, func(e Expression) Expression {
+		if v, ok := e.(*Var); ok {
+			if bound, ok := s.Lets[strings.ToUpper(v.Var)]; ok {
+				return bound
+			}
+		}
+		return e
+	})
+}
+
+// channel returns the field holding the channel of an upper case name, nil
+// for other names.
+func (s *Script) channel(name string) **Function {
+	switch name {
+	case "R", "RED":
+		return &s.Red
+	case "G", "GREEN":
+		return &s.Green
+	case "B", "BLUE":
+		return &s.Blue
+	}
+	return nil
+}
+
+// Channels returns the red, green and blue formulas.
+func (s Script) Channels() (*Function, *Function, *Function) {
+	return s.Red, s.Green, s.Blue
+}
+
+// String returns the statements one to a line, which parses back to the same
+// script.
+func (s Script) String() string {
+	lines := make([]string, len(s.Statements))
+	for i, each := range s.Statements {
+		lines[i] = each.String()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,76 @@
+package image_formula_find
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	s, err := ParseScript("let a = sin(x * y)\nr = a * 255; g = (1 - a) * 128 # green\nb = a ^ 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Statements) != 4 || !s.Statements[0].Let || s.Statements[0].Name != "a" {
+		t.Fatalf("Unexpected statements %+v", s.Statements)
+	}
+	x, y := 0.5, 2.0
+	a := math.Sin(x * y)
+	for _, c := range []struct {
+		name string
+		f    *Function
+		want float64
+	}{
+		{"red", s.Red, a * 255},
+		{"green", s.Green, (1 - a) * 128},
+		{"blue", s.Blue, math.Pow(a, 2)},
+	} {
+		if c.f == nil {
+			t.Fatalf("No %s channel", c.name)
+		}
+		if got, _, _ := c.f.Evaluate(x, y, 0); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: %s evaluated to %v, want %v", c.name, c.f, got, c.want)
+		}
+		if p := Compile(c.f); math.Abs(p.Evaluate(x, y, 0)-c.want) > 1e-9 {
+			t.Errorf("%s: compiled to %v, want %v", c.name, p.Evaluate(x, y, 0), c.want)
+		}
+	}
+	if s.Red.Equals.RHS.(*Multiply).LHS != s.Lets["A"] {
+		t.Error("Expected the red channel to share the binding")
+	}
+
+	again, err := ParseScript(s.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != s.String() || again.Blue.String() != s.Blue.String() {
+		t.Errorf("Got %q after printing %q", again, s)
+	}
+}
+
+func TestParseScriptBindings(t *testing.T) {
+	// A binding hides the variable it names, including from later bindings
+	// of the same name.
+	s, err := ParseScript("let x = y * 2; let x = x + 1; red = x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := s.Red.Evaluate(100, 3, 0); got != 7 {
+		t.Errorf("%s evaluated to %v, want 7", s.Red, got)
+	}
+	if s.Green != nil || s.Blue != nil {
+		t.Error("Expected unassigned channels to be nil")
+	}
+
+	for _, bad := range []string{
+		"r = x; r = y",
+		"q = x",
+		"let = x",
+		"r = x = y",
+		"x + 1",
+		"let a = 1 r = a",
+	} {
+		if _, err := ParseScript(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package image_formula_find
+
+import (
+	"math"
+	"strings"
+)
+
+// The simplifier rules below only rewrite a node when the result evaluates to
+// the same value for every input, NaN, infinities and the sign of zero
+// included, so x + 0 is kept as -0 + 0 is +0 and 0 * x as it is -0 for
+// negative x. X, Y and T are assumed to be finite, which they always are when
+// rendering.
+//
+// The rules run bottom up from the Simplify methods, so the operands they see
+// have already been simplified.
+
+// constValue returns the value of e if it is a constant, looking through
+// brackets.
+func constValue(e Expression) (float64, bool) {
+	switch e := removeBrackets(e).(type) {
+	case *Const:
+		return e.Value, true
+	case Const:
+		return e.Value, true
+	}
+	return 0, false
+}
+
+func isConst(e Expression, value float64) bool {
+	v, ok := constValue(e)
+	return ok && v == value
+}
+
+// isNegativeZero reports whether e is the constant -0, which unlike +0 leaves
+// every value it is added to unchanged.
+func isNegativeZero(e Expression) bool {
+	v, ok := constValue(e)
+	return ok && v == 0 && math.Signbit(v)
+}
+
+// fold evaluates a node whose operands are all constant.
+func fold(e Expression) Expression {
+	return &Const{Value: e.Evaluate(&State{})}
+}
+
+// boundedFunctions map finite arguments to finite results.
+var boundedFunctions = map[string]bool{
+	"ABS": true, "ASINH": true, "ATAN": true, "CBRT": true, "CEIL": true,
+	"COS": true, "ERF": true, "ERFC": true, "FLOOR": true, "J0": true,
+	"J1": true, "ROUND": true, "ROUNDTOEVEN": true, "SIN": true, "TANH": true,
+	"TRUNC": true,
+}
+
+// isFinite conservatively reports whether e can never evaluate to NaN or an
+// infinity.
+func isFinite(e Expression) bool {
+	switch e := removeBrackets(e).(type) {
+	case *Const:
+		return !math.IsNaN(e.Value) && !math.IsInf(e.Value, 0)
+	case *Var:
+		return true
+	case *Negate:
+		return isFinite(e.Expr)
+	case *Compare:
+		return true
+	case *SingleFunction:
+		return boundedFunctions[strings.ToUpper(e.Name)] && isFinite(e.Expr)
+	case *DoubleFunction:
+		switch strings.ToUpper(e.Name) {
+		case "ATAN2", "MAX", "MIN":
+			return isFinite(e.Expr1) && isFinite(e.Expr2)
+		case "COPYSIGN":
+			return isFinite(e.Expr1)
+		}
+	}
+	return false
+}
+
+// equal reports whether two expressions have the same structure, see Equal.
+func equal(a, b Expression) bool {
+	a, b = node(a), node(b)
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case *Equals:
+		b, ok := b.(*Equals)
+		return ok && (a.LHS == nil) == (b.LHS == nil) && (a.LHS == nil || equal(a.LHS, b.LHS)) && equal(a.RHS, b.RHS)
+	case *Const:
+		b, ok := b.(*Const)
+		return ok && math.Float64bits(a.Value) == math.Float64bits(b.Value)
+	case *Var:
+		b, ok := b.(*Var)
+		return ok && strings.EqualFold(a.Var, b.Var)
+	case *Plus:
+		b, ok := b.(*Plus)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Subtract:
+		b, ok := b.(*Subtract)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Multiply:
+		b, ok := b.(*Multiply)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Divide:
+		b, ok := b.(*Divide)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Power:
+		b, ok := b.(*Power)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Modulus:
+		b, ok := b.(*Modulus)
+		return ok && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	case *Negate:
+		b, ok := b.(*Negate)
+		return ok && equal(a.Expr, b.Expr)
+	case *SingleFunction:
+		b, ok := b.(*SingleFunction)
+		return ok && strings.EqualFold(a.Name, b.Name) && equal(a.Expr, b.Expr)
+	case *DoubleFunction:
+		b, ok := b.(*DoubleFunction)
+		return ok && strings.EqualFold(a.Name, b.Name) && equal(a.Expr1, b.Expr1) && equal(a.Expr2, b.Expr2)
+	case *TripleFunction:
+		b, ok := b.(*TripleFunction)
+		return ok && strings.EqualFold(a.Name, b.Name) && equal(a.Expr1, b.Expr1) && equal(a.Expr2, b.Expr2) && equal(a.Expr3, b.Expr3)
+	case *Compare:
+		b, ok := b.(*Compare)
+		return ok && a.Op == b.Op && equal(a.LHS, b.LHS) && equal(a.RHS, b.RHS)
+	}
+	return false
+}
+
+// operandRank orders the operands of commutative nodes: variables first, then
+// compound expressions and constants last.
+func operandRank(e Expression) int {
+	switch removeBrackets(e).(type) {
+	case *Var:
+		return 0
+	case *Const:
+		return 2
+	}
+	return 1
+}
+
+// ordered reports whether a belongs before b in canonical order.
+func ordered(a, b Expression) bool {
+	ra, rb := operandRank(a), operandRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	if ra == 2 {
+		return true
+	}
+	return a.String() <= b.String()
+}
+
+// powerOfTwoCoefficient returns c and x when e is c * x with |c| a power of
+// two no smaller than one, or 1 and e otherwise. Multiplying by such c is
+// exact, which is what makes collecting like terms safe.
+func powerOfTwoCoefficient(e Expression) (float64, Expression) {
+	if m, ok := removeBrackets(e).(*Multiply); ok {
+		for _, pair := range [][2]Expression{{m.LHS, m.RHS}, {m.RHS, m.LHS}} {
+			if c, ok := constValue(pair[0]); ok && isPowerOfTwo(c) {
+				return c, pair[1]
+			}
+		}
+	}
+	return 1, e
+}
+
+func isPowerOfTwo(c float64) bool {
+	frac, exp := math.Frexp(math.Abs(c))
+	return frac == 0.5 && exp >= 1
+}
+
+func simplifyPlus(v *Plus) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	switch {
+	case lc && rc:
+		return fold(v)
+	case isNegativeZero(v.LHS):
+		return v.RHS
+	case isNegativeZero(v.RHS):
+		return v.LHS
+	}
+	c1, x1 := powerOfTwoCoefficient(v.LHS)
+	c2, x2 := powerOfTwoCoefficient(v.RHS)
+	if (c1 > 0) == (c2 > 0) && equal(x1, x2) {
+		if sum := c1 + c2; math.Abs(sum) < 1<<53 {
+			return &Multiply{LHS: &Const{Value: sum}, RHS: x1}
+		}
+	}
+	if !ordered(v.LHS, v.RHS) {
+		v.LHS, v.RHS = v.RHS, v.LHS
+	}
+	return v
+}
+
+func simplifySubtract(v *Subtract) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	switch {
+	case lc && rc:
+		return fold(v)
+	case isConst(v.LHS, 0) && !isNegativeZero(v.LHS):
+		return v.RHS
+	case isNegativeZero(v.RHS):
+		return &Negate{Expr: v.LHS}
+	case equal(v.LHS, v.RHS) && isFinite(v.LHS):
+		return &Const{Value: 0}
+	}
+	if n, ok := removeBrackets(v.LHS).(*Negate); ok {
+		return simplifyPlus(&Plus{LHS: v.RHS, RHS: n.Expr})
+	}
+	return v
+}
+
+func simplifyMultiply(v *Multiply) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	if lc && rc {
+		return fold(v)
+	}
+	if !ordered(v.RHS, v.LHS) {
+		v.LHS, v.RHS = v.RHS, v.LHS
+	}
+	// Constants are now on the left.
+	switch c, _ := constValue(v.LHS); {
+	case !lc && !rc:
+	case c == 1:
+		return v.RHS
+	case c == -1:
+		return simplifyNegate(&Negate{Expr: v.RHS})
+	}
+	ln, lok := removeBrackets(v.LHS).(*Negate)
+	rn, rok := removeBrackets(v.RHS).(*Negate)
+	if lok && rok {
+		return simplifyMultiply(&Multiply{LHS: ln.Expr, RHS: rn.Expr})
+	}
+	return v
+}
+
+func simplifyDivide(v *Divide) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	switch {
+	case lc && rc:
+		return fold(v)
+	case isConst(v.LHS, 1):
+		return v.RHS
+	case isConst(v.LHS, -1):
+		return simplifyNegate(&Negate{Expr: v.RHS})
+	}
+	return v
+}
+
+func simplifyPower(v *Power) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	switch {
+	case lc && rc:
+		return fold(v)
+	case isConst(v.RHS, 1):
+		return v.LHS
+	case isConst(v.RHS, 0), isConst(v.LHS, 1):
+		return &Const{Value: 1}
+	}
+	return v
+}
+
+func simplifyModulus(v *Modulus) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	if lc && rc {
+		return fold(v)
+	}
+	return v
+}
+
+func simplifyNegate(v *Negate) Expression {
+	switch child := removeBrackets(v.Expr).(type) {
+	case *Negate:
+		return child.Expr
+	case *Const:
+		return &Const{Value: -child.Value}
+	case *Var:
+		v.Expr = child
+	}
+	return v
+}
+
+func simplifySingleFunction(v *SingleFunction) Expression {
+	if v.Fn == nil && SingleFunctions[strings.ToUpper(v.Name)] == nil {
+		// Unknown functions evaluate as the identity.
+		return v.Expr
+	}
+	if _, ok := constValue(v.Expr); ok {
+		return fold(v)
+	}
+	return v
+}
+
+func simplifyDoubleFunction(v *DoubleFunction) Expression {
+	if v.Fn == nil && DoubleFunctions[strings.ToUpper(v.Name)] == nil {
+		// Unknown functions evaluate to their first argument.
+		return v.Expr1
+	}
+	_, c1 := constValue(v.Expr1)
+	_, c2 := constValue(v.Expr2)
+	if c1 && c2 {
+		return fold(v)
+	}
+	return v
+}
+
+func simplifyTripleFunction(v *TripleFunction) Expression {
+	if v.Fn == nil && TripleFunctions[strings.ToUpper(v.Name)] == nil {
+		// Unknown functions evaluate to their first argument.
+		return v.Expr1
+	}
+	_, c1 := constValue(v.Expr1)
+	_, c2 := constValue(v.Expr2)
+	_, c3 := constValue(v.Expr3)
+	switch {
+	case c1 && c2 && c3:
+		return fold(v)
+	case c1 && strings.EqualFold(v.Name, "If"):
+		// A constant condition always selects the same branch.
+		if isConst(v.Expr1, 0) {
+			return v.Expr3
+		}
+		return v.Expr2
+	}
+	return v
+}
+
+func simplifyCompare(v *Compare) Expression {
+	_, lc := constValue(v.LHS)
+	_, rc := constValue(v.RHS)
+	if lc && rc {
+		return fold(v)
+	}
+	return v
+}
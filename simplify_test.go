@@ -0,0 +1,89 @@
+package image_formula_find
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSimplifyPreservesEvaluation(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	points := [][2]float64{{-10, -10}, {0, 0}, {0.5, -2.25}, {7, 3}}
+	for i := 0; i < 5000; i++ {
+		f := randomFunction(r, 6)
+		s := f.Simplify()
+		for _, p := range points {
+			for _, T := range []float64{0, 1} {
+				want, _, _ := f.Evaluate(p[0], p[1], T)
+				got, _, _ := s.Evaluate(p[0], p[1], T)
+				if !sameFloat(got, want) {
+					t.Fatalf("%s simplified to %s: at %v T=%v got %v want %v", f, s, p, T, got, want)
+				}
+			}
+		}
+	}
+}
+
+var negativeZero = math.Copysign(0, -1)
+
+func TestSimplify(t *testing.T) {
+	x, y := &Var{Var: "X"}, &Var{Var: "Y"}
+	tests := []struct {
+		name string
+		expr Expression
+		want string
+	}{
+		{"fold", &Plus{LHS: &Const{Value: 2}, RHS: &Multiply{LHS: &Const{Value: 3}, RHS: &Const{Value: 4}}}, "14"},
+		{"fold function", NewSingleFunction("Abs", &Negate{Expr: &Const{Value: 3}}), "3"},
+		{"add zero", &Plus{LHS: &Const{Value: 0}, RHS: x}, "X + 0"},
+		{"add negative zero", &Plus{LHS: &Const{Value: negativeZero}, RHS: x}, "X"},
+		{"subtract zero", &Subtract{LHS: x, RHS: &Const{Value: 0}}, "0 - X"},
+		{"subtract negative zero", &Subtract{LHS: x, RHS: &Const{Value: negativeZero}}, "-X"},
+		{"multiply one", &Multiply{LHS: y, RHS: &Const{Value: 1}}, "Y"},
+		{"multiply zero", &Multiply{LHS: NewSingleFunction("Sin", x), RHS: &Const{Value: 0}}, "0 * Sin(X)"},
+		{"multiply zero unsafe", &Multiply{LHS: &Divide{LHS: x, RHS: y}, RHS: &Const{Value: 0}}, "0 * (Y / X)"},
+		{"power one", &Power{LHS: x, RHS: &Const{Value: 1}}, "X"},
+		{"power zero", &Power{LHS: &Divide{LHS: x, RHS: y}, RHS: &Const{Value: 0}}, "1"},
+		{"double negate", &Negate{Expr: &Brackets{Expr: &Negate{Expr: x}}}, "X"},
+		{"self subtract", &Subtract{LHS: x, RHS: x}, "0"},
+		{"like terms", &Plus{LHS: x, RHS: &Brackets{Expr: x}}, "2 * X"},
+		{"like terms coefficient", &Plus{LHS: &Multiply{LHS: y, RHS: &Const{Value: 2}}, RHS: y}, "3 * Y"},
+		{"ordering", &Plus{LHS: &Const{Value: 3}, RHS: y}, "Y + 3"},
+		{"unknown function", NewSingleFunction("Negate", x), "X"},
+		{"brackets", &Brackets{Expr: &Brackets{Expr: &Plus{LHS: x, RHS: y}}}, "(X + Y)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.expr.Simplify().String(); got != test.want {
+				t.Errorf("Simplify(%s) = %q, want %q", test.expr, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSimplifyKeepsNaN(t *testing.T) {
+	nan := &Const{Value: math.NaN()}
+	e := &Multiply{LHS: &Const{Value: 0}, RHS: &Divide{LHS: nan, RHS: &Var{Var: "X"}}}
+	if got := e.Simplify().Evaluate(&State{X: 1}); !math.IsNaN(got) {
+		t.Errorf("Expected NaN, got %v", got)
+	}
+}
+
+func TestSimplifyKeepsSignOfZero(t *testing.T) {
+	x, zero := &Var{Var: "X"}, &Const{Value: 0}
+	for _, e := range []Expression{
+		&Multiply{LHS: zero, RHS: x},
+		&Plus{LHS: zero, RHS: x},
+		&Subtract{LHS: x, RHS: zero},
+		&Subtract{LHS: &Const{Value: negativeZero}, RHS: x},
+	} {
+		// Atan2 tells the zeros apart.
+		f := &Function{Equals: &Equals{RHS: NewDoubleFunction("Atan2", zero, e, false)}}
+		for _, X := range []float64{-2, 0, negativeZero} {
+			want, _, _ := f.Evaluate(X, 0, 0)
+			if got, _, _ := f.Simplify().Evaluate(X, 0, 0); !sameFloat(got, want) {
+				t.Errorf("%s simplified to %s: at X = %v got %v, want %v", f, f.Simplify(), X, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package image_formula_find
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+// Variables are the names of the built in variables. X and Y run from -10
+// to 10 across the image, unless it is drawn through another view, and T is
+// the time. The others are derived from X and Y:
+//
+//	R       the distance from the origin, Hypot(X, Y)
+//	A       the angle from the positive X axis, Atan2(Y, X)
+//	U, V    the position across and down the image, 0 to 1, see Frame
+//	PX, PY  the position in pixels, U and V scaled by the image size in State
+//
+// Any other name is a parameter, which takes its value from State.Params
+// and is NaN when it has none, so a misspelt name shows up as invalid pixels
+// rather than as a different image. Unbound lists those without a value.
+var Variables = []string{"X", "Y", "T", "R", "A", "U", "V", "PX", "PY"}
+
+// dependencies lists the variables each derived variable is computed from.
+// Through a rotated view the position across the image depends on both X and
+// Y.
+var dependencies = map[string][]string{
+	"R":  {"X", "Y"},
+	"A":  {"X", "Y"},
+	"U":  {"X", "Y"},
+	"V":  {"X", "Y"},
+	"PX": {"X", "Y"},
+	"PY": {"X", "Y"},
+}
+
+// parameter returns the value of the parameter name in params, NaN when it
+// has none.
+func parameter(params map[string]float64, name string) float64 {
+	if v, ok := params[name]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+// Unbound returns the parameters of the formulas that params has no value
+// for, by upper case name in the order they first appear.
+func Unbound(params map[string]float64, fs ...*Function) []string {
+	var names []string
+	for _, f := range fs {
+		if f == nil || f.Equals == nil {
+			continue
+		}
+		Walk(f.Equals, func(e Expression) bool {
+			v, ok := e.(*Var)
+			if !ok {
+				return true
+			}
+			name := strings.ToUpper(v.Var)
+			if _, bound := params[name]; !bound && !slices.Contains(Variables, name) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// Frame is the view an image is drawn through, the affine map from U and V,
+// which run from 0 to 1 across and down the image, to X and Y:
+//
+//	X = XU*U + XV*V + X0
+//	Y = YU*U + YV*V + Y0
+//
+// U, V, PX and PY invert it, so they measure the image whatever view it
+// shows. The zero Frame is the default view, X and Y from -10 to 10. See
+// drawer1.Transform, which maps pixels the same way.
+type Frame struct {
+	XU, XV, X0 float64
+	YU, YV, Y0 float64
+}
+
+// defaultFrame is the default view, which the zero Frame stands for.
+var defaultFrame = Frame{XU: 20, X0: -10, YV: 20, Y0: -10}
+
+// resolve returns the frame, the default view for the zero Frame.
+func (f Frame) resolve() Frame {
+	if f == (Frame{}) {
+		return defaultFrame
+	}
+	return f
+}
+
+// rotated reports whether U depends on Y or V on X.
+func (f Frame) rotated() bool {
+	return f.XV != 0 || f.YU != 0
+}
+
+// at returns X and Y at u, v.
+func (f Frame) at(u, v float64) (x, y float64) {
+	return float64(f.XU*u) + float64(f.XV*v) + f.X0, float64(f.YU*u) + float64(f.YV*v) + f.Y0
+}
+
+// unit returns U and V at x, y. Unless the view is rotated they are
+// (x - X0) / XU and (y - Y0) / YV, so (x + 10) / 20 in the default view.
+func (f Frame) unit(x, y float64) (u, v float64) {
+	f = f.resolve()
+	if !f.rotated() {
+		return (x - f.X0) / f.XU, (y - f.Y0) / f.YV
+	}
+	// The conversions stop products being fused into multiply-adds, which
+	// would round differently from the compiled Program.
+	det := float64(f.XU*f.YV) - float64(f.XV*f.YU)
+	dx, dy := x-f.X0, y-f.Y0
+	return (float64(f.YV*dx) - float64(f.XV*dy)) / det, (float64(f.XU*dy) - float64(f.YU*dx)) / det
+}
+
+// derivation returns an expression evaluating exactly as the derived
+// variable name does for an image of the given size drawn through frame, or
+// nil for other names. The compiler, interval arithmetic and, for R and A,
+// derivatives work on it in place of the variable.
+func derivation(name string, width, height int, frame Frame) Expression {
+	x, y := &Var{Var: "X"}, &Var{Var: "Y"}
+	switch name {
+	case "R":
+		return &DoubleFunction{Name: "Hypot", Expr1: x, Expr2: y, Fn: math.Hypot}
+	case "A":
+		return &DoubleFunction{Name: "Atan2", Expr1: y, Expr2: x, Fn: math.Atan2}
+	case "U":
+		u, _ := frame.unitOf()
+		return u
+	case "V":
+		_, v := frame.unitOf()
+		return v
+	case "PX":
+		u, _ := frame.unitOf()
+		return &Multiply{LHS: u, RHS: &Const{Value: float64(width)}}
+	case "PY":
+		_, v := frame.unitOf()
+		return &Multiply{LHS: v, RHS: &Const{Value: float64(height)}}
+	}
+	return nil
+}
+
+// unitOf is unit as expressions of X and Y. Subtract evaluates its RHS minus
+// its LHS and Divide its RHS over its LHS.
+func (f Frame) unitOf() (u, v Expression) {
+	f = f.resolve()
+	c := func(v float64) Expression { return &Const{Value: v} }
+	dx := &Subtract{LHS: c(f.X0), RHS: &Var{Var: "X"}}
+	dy := &Subtract{LHS: c(f.Y0), RHS: &Var{Var: "Y"}}
+	if !f.rotated() {
+		return &Divide{LHS: c(f.XU), RHS: dx}, &Divide{LHS: c(f.YV), RHS: dy}
+	}
+	det := c(float64(f.XU*f.YV) - float64(f.XV*f.YU))
+	u = &Divide{LHS: det, RHS: &Subtract{
+		LHS: &Multiply{LHS: c(f.XV), RHS: dy},
+		RHS: &Multiply{LHS: c(f.YV), RHS: dx},
+	}}
+	v = &Divide{LHS: det, RHS: &Subtract{
+		LHS: &Multiply{LHS: c(f.YU), RHS: dx},
+		RHS: &Multiply{LHS: c(f.XU), RHS: dy},
+	}}
+	return u, v
+}
@@ -0,0 +1,188 @@
+package image_formula_find
+
+import (
+	"sort"
+	"strings"
+)
+
+// children returns the operands of a pointer node in field order, reporting
+// false for expressions of other types. An Equals without a LHS has the RHS
+// as its only child.
+func children(e Expression) ([]Expression, bool) {
+	switch e := e.(type) {
+	case *Equals:
+		if e.LHS == nil {
+			return []Expression{e.RHS}, true
+		}
+		return []Expression{e.LHS, e.RHS}, true
+	case *Var, *Const:
+		return nil, true
+	case *Plus:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Subtract:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Multiply:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Divide:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Power:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Modulus:
+		return []Expression{e.LHS, e.RHS}, true
+	case *Negate:
+		return []Expression{e.Expr}, true
+	case *Brackets:
+		return []Expression{e.Expr}, true
+	case *SingleFunction:
+		return []Expression{e.Expr}, true
+	case *DoubleFunction:
+		return []Expression{e.Expr1, e.Expr2}, true
+	case *TripleFunction:
+		return []Expression{e.Expr1, e.Expr2, e.Expr3}, true
+	case *Compare:
+		return []Expression{e.LHS, e.RHS}, true
+	}
+	return nil, false
+}
+
+// withChildren returns a copy of the pointer node e with its operands
+// replaced, in the order children returns them.
+func withChildren(e Expression, c []Expression) Expression {
+	switch e := e.(type) {
+	case *Equals:
+		if len(c) == 1 {
+			return &Equals{RHS: c[0]}
+		}
+		return &Equals{LHS: c[0], RHS: c[1]}
+	case *Var:
+		v := *e
+		return &v
+	case *Const:
+		v := *e
+		return &v
+	case *Plus:
+		return &Plus{LHS: c[0], RHS: c[1]}
+	case *Subtract:
+		return &Subtract{LHS: c[0], RHS: c[1]}
+	case *Multiply:
+		return &Multiply{LHS: c[0], RHS: c[1]}
+	case *Divide:
+		return &Divide{LHS: c[0], RHS: c[1]}
+	case *Power:
+		return &Power{LHS: c[0], RHS: c[1]}
+	case *Modulus:
+		return &Modulus{LHS: c[0], RHS: c[1]}
+	case *Negate:
+		return &Negate{Expr: c[0]}
+	case *Brackets:
+		return &Brackets{Expr: c[0]}
+	case *SingleFunction:
+		return &SingleFunction{Name: e.Name, Expr: c[0], Fn: e.Fn}
+	case *DoubleFunction:
+		return &DoubleFunction{Name: e.Name, Expr1: c[0], Expr2: c[1], Infix: e.Infix, Fn: e.Fn}
+	case *TripleFunction:
+		return &TripleFunction{Name: e.Name, Expr1: c[0], Expr2: c[1], Expr3: c[2], Fn: e.Fn}
+	case *Compare:
+		return &Compare{Op: e.Op, LHS: c[0], RHS: c[1]}
+	}
+	return e
+}
+
+// Operands returns the operands of a node, value or pointer, in field order:
+// LHS before RHS and Expr1 before Expr2 and Expr3. Leaves, nil and
+// Expression types outside this package have none.
+func Operands(e Expression) []Expression {
+	if p := pointerTo(e); p != nil {
+		e = p
+	}
+	c, _ := children(e)
+	return c
+}
+
+// Walk calls visit for e and, when it returns true, walks each operand of e
+// in turn, so the nodes are visited depth first in prefix order. Brackets
+// are visited like any other node. Nil operands, such as the LHS of an
+// Equals holding a bare expression, are skipped.
+func Walk(e Expression, visit func(Expression) bool) {
+	if e == nil || !visit(e) {
+		return
+	}
+	for _, each := range Operands(e) {
+		Walk(each, visit)
+	}
+}
+
+// Rewrite returns e with every node replaced by the result of rewrite,
+// working bottom up: a node's operands are rewritten first, and rewrite is
+// handed a copy of the node holding the new operands. Returning the node
+// unchanged keeps it. Rewritten nodes are pointer nodes, e is not modified.
+func Rewrite(e Expression, rewrite func(Expression) Expression) Expression {
+	if e == nil {
+		return nil
+	}
+	if p := pointerTo(e); p != nil {
+		e = p
+	}
+	c, ok := children(e)
+	if !ok {
+		return rewrite(e)
+	}
+	operands := make([]Expression, len(c))
+	for i, each := range c {
+		operands[i] = Rewrite(each, rewrite)
+	}
+	return rewrite(withChildren(e, operands))
+}
+
+// Metrics summarises the size and content of an expression.
+type Metrics struct {
+	// Nodes is the number of nodes, not counting Brackets.
+	Nodes int
+	// Consts is the number of constants.
+	Consts int
+	// Functions counts the uses of each function by upper case name.
+	Functions map[string]int
+	// Vars are the upper case names of the variables used, sorted.
+	Vars []string
+}
+
+// Measure returns the metrics of the expression.
+func Measure(e Expression) Metrics {
+	m := Metrics{Functions: map[string]int{}}
+	vars := map[string]bool{}
+	Walk(e, func(e Expression) bool {
+		if p := pointerTo(e); p != nil {
+			e = p
+		}
+		switch e := e.(type) {
+		case *Brackets:
+			return true
+		case *Const:
+			m.Consts++
+		case *Var:
+			vars[strings.ToUpper(e.Var)] = true
+		case *SingleFunction:
+			m.Functions[strings.ToUpper(e.Name)]++
+		case *DoubleFunction:
+			m.Functions[strings.ToUpper(e.Name)]++
+		case *TripleFunction:
+			m.Functions[strings.ToUpper(e.Name)]++
+		}
+		m.Nodes++
+		return true
+	})
+	for v := range vars {
+		m.Vars = append(m.Vars, v)
+	}
+	sort.Strings(m.Vars)
+	return m
+}
+
+// Metrics returns the metrics of the formula, which are empty for a missing
+// formula.
+func (v Function) Metrics() Metrics {
+	if v.Equals == nil {
+		return Metrics{Functions: map[string]int{}}
+	}
+	return Measure(v.Equals)
+}
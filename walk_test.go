@@ -0,0 +1,82 @@
+package image_formula_find
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	f, err := ParseFunction("y = sin(x) + (2 * x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visited []string
+	Walk(f.Equals, func(e Expression) bool {
+		visited = append(visited, strings.TrimPrefix(reflect.TypeOf(e).String(), "*image_formula_find."))
+		// Do not descend into functions.
+		_, isFunction := e.(*SingleFunction)
+		return !isFunction
+	})
+	want := []string{"Equals", "Var", "Plus", "SingleFunction", "Brackets", "Multiply", "Const", "Var"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("Visited %v, want %v", visited, want)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	f, err := ParseFunction("y = sin(x) + x * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := f.String()
+	swapped := Rewrite(f.Equals, func(e Expression) Expression {
+		switch e := e.(type) {
+		case *Var:
+			if strings.EqualFold(e.Var, "X") {
+				return &Var{Var: "T"}
+			}
+		case *SingleFunction:
+			return &SingleFunction{Name: "cos", Expr: e.Expr}
+		}
+		return e
+	})
+	if got, want := swapped.String(), "y = cos(T) + T * 2"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+	if f.String() != before {
+		t.Errorf("Rewrite modified the original: %s", f)
+	}
+}
+
+func TestRewriteIdentity(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	for i := 0; i < 1000; i++ {
+		f := randomFunction(r, 5)
+		g := Rewrite(f.Equals, func(e Expression) Expression { return e })
+		if !Equal(f.Equals, g) || f.String() != g.String() {
+			t.Fatalf("%s: rewrote to %s", f, g)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	f, err := ParseFunction("y = sin(x) * sin(2) + max(x, 1.5) - (t)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := f.Metrics()
+	want := Metrics{
+		Nodes:     13,
+		Consts:    2,
+		Functions: map[string]int{"SIN": 2, "MAX": 1},
+		Vars:      []string{"T", "X", "Y"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Got %+v, want %+v", m, want)
+	}
+	if m := (Function{}).Metrics(); m.Nodes != 0 || len(m.Functions) != 0 {
+		t.Errorf("Expected empty metrics, got %+v", m)
+	}
+}